@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/nspass/nspass-agent/generated/model"
+	"github.com/nspass/nspass-agent/pkg/admin"
+	"github.com/nspass/nspass-agent/pkg/api"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/iptables"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// redactedSecret 替换敏感配置值，既能让运维人员看出该字段"已设置"，又不会把
+// 真实密钥打印到终端或日志采集系统里
+const redactedSecret = "******"
+
+// newValidateCmd `validate`只做配置文件的加载+校验，不接触运行中的Agent，
+// 给CI/CD或systemd的ExecStartPre用
+func newValidateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "校验配置文件",
+		Long:  "解析并校验配置文件，校验失败时以非零状态码退出",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig(configPath)
+			if err != nil {
+				return fmt.Errorf("加载配置文件失败: %w", err)
+			}
+
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("配置校验失败: %w", err)
+			}
+
+			fmt.Println("配置校验通过")
+			return nil
+		},
+	}
+}
+
+// newDumpCmd `dump`打印setDefaults填充后的生效配置，敏感字段做脱敏处理，
+// 用于排查"agent实际用的配置和我以为的不一样"这类问题
+func newDumpCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "dump",
+		Short: "打印生效配置（敏感信息已脱敏）",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig(configPath)
+			if err != nil {
+				return fmt.Errorf("加载配置文件失败: %w", err)
+			}
+
+			redactSecrets(cfg)
+
+			data, err := yaml.Marshal(cfg)
+			if err != nil {
+				return fmt.Errorf("序列化配置失败: %w", err)
+			}
+
+			fmt.Print(string(data))
+			return nil
+		},
+	}
+}
+
+// redactSecrets 将cfg中已知的敏感字段替换为占位符，就地修改
+func redactSecrets(cfg *config.Config) {
+	if cfg.API.Token != "" {
+		cfg.API.Token = redactedSecret
+	}
+	if cfg.API.ChallengeKey != "" {
+		cfg.API.ChallengeKey = redactedSecret
+	}
+	if cfg.Metrics.AuthToken != "" {
+		cfg.Metrics.AuthToken = redactedSecret
+	}
+}
+
+// resolveAdminSocketPath 决定连接本地运维API用的套接字路径：优先使用
+// --admin-socket命令行参数，否则从配置文件里的admin.socket_path读取
+func resolveAdminSocketPath() (string, error) {
+	if adminSocketPath != "" {
+		return adminSocketPath, nil
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return "", fmt.Errorf("加载配置文件失败: %w", err)
+	}
+
+	if cfg.Admin.SocketPath == "" {
+		return "", fmt.Errorf("未配置admin.socket_path，且未指定--admin-socket")
+	}
+
+	return cfg.Admin.SocketPath, nil
+}
+
+// newStatusCmd `status`通过admin套接字查询运行中agent的实时状态，不依赖
+// WebSocket控制面是否可达
+func newStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "查询运行中Agent的状态",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			socketPath, err := resolveAdminSocketPath()
+			if err != nil {
+				return err
+			}
+
+			var result interface{}
+			if err := admin.NewClient(socketPath).Call("GetStatus", nil, &result); err != nil {
+				return fmt.Errorf("查询状态失败: %w", err)
+			}
+
+			data, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return fmt.Errorf("格式化状态失败: %w", err)
+			}
+
+			fmt.Println(string(data))
+			return nil
+		},
+	}
+}
+
+// newReloadCmd `reload`触发一次强制的配置重新拉取，等价于等待下一轮
+// UpdateInterval轮询或服务器推送config_update
+func newReloadCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reload",
+		Short: "触发一次配置重新拉取",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			socketPath, err := resolveAdminSocketPath()
+			if err != nil {
+				return err
+			}
+
+			if err := admin.NewClient(socketPath).Call("Reconcile", nil, nil); err != nil {
+				return fmt.Errorf("触发配置重新拉取失败: %w", err)
+			}
+
+			fmt.Println("已触发配置重新拉取")
+			return nil
+		},
+	}
+}
+
+// newProxyCmd `proxy`目前只有一个`restart`子命令，保留成命令组是为了给后续
+// （例如`proxy status`）留出扩展空间
+func newProxyCmd() *cobra.Command {
+	proxyCmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "代理服务运维操作",
+	}
+
+	proxyCmd.AddCommand(&cobra.Command{
+		Use:   "restart <name>",
+		Short: "重启指定的代理服务",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			socketPath, err := resolveAdminSocketPath()
+			if err != nil {
+				return err
+			}
+
+			params := map[string]string{"name": args[0]}
+			if err := admin.NewClient(socketPath).Call("RestartProxy", params, nil); err != nil {
+				return fmt.Errorf("重启代理%s失败: %w", args[0], err)
+			}
+
+			fmt.Printf("代理%s已重启\n", args[0])
+			return nil
+		},
+	})
+
+	return proxyCmd
+}
+
+// newIPTablesCmd `iptables show|test`都通过Manager.RenderRulesFromProto走
+// 既有的TemplateManager渲染路径生成完整的iptables-restore格式文本，但都不
+// 调用applyRules，不会改变宿主机上实际生效的规则。区别只在于配置来源：show
+// 渲染的是服务器下发的当前配置，test渲染的是本地一份候选配置文件
+func newIPTablesCmd() *cobra.Command {
+	iptablesCmd := &cobra.Command{
+		Use:   "iptables",
+		Short: "iptables规则调试工具",
+	}
+
+	iptablesCmd.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "渲染服务器下发的当前iptables配置，但不应用",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig(configPath)
+			if err != nil {
+				return fmt.Errorf("加载配置文件失败: %w", err)
+			}
+
+			apiClient := api.NewClient(cfg.API, cfg.ServerID)
+			configs, err := apiClient.GetServerIptablesConfigsProto(cfg.ServerID)
+			if err != nil {
+				return fmt.Errorf("获取iptables配置失败: %w", err)
+			}
+
+			return renderIPTablesConfigs(cfg, configs)
+		},
+	})
+
+	iptablesCmd.AddCommand(&cobra.Command{
+		Use:   "test <config.json>",
+		Short: "渲染一份本地候选iptables配置文件，但不应用",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig(configPath)
+			if err != nil {
+				return fmt.Errorf("加载配置文件失败: %w", err)
+			}
+
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("读取候选配置文件失败: %w", err)
+			}
+
+			var configs []*model.IptablesConfig
+			if err := json.Unmarshal(data, &configs); err != nil {
+				return fmt.Errorf("解析候选配置文件失败: %w", err)
+			}
+
+			return renderIPTablesConfigs(cfg, configs)
+		},
+	})
+
+	return iptablesCmd
+}
+
+// renderIPTablesConfigs 用cfg.IPTables构建的Manager渲染configs，仅支持
+// iptables-restore后端（nftables后端不走TemplateManager，模板渲染对它没有
+// 意义）
+func renderIPTablesConfigs(cfg *config.Config, configs []*model.IptablesConfig) error {
+	mgr, ok := iptables.NewManager(cfg.IPTables).(*iptables.Manager)
+	if !ok {
+		return fmt.Errorf("当前iptables.backend=%s不支持规则渲染预览，仅iptables-restore后端支持", cfg.IPTables.Backend)
+	}
+
+	content, err := mgr.RenderRulesFromProto(configs)
+	if err != nil {
+		return fmt.Errorf("渲染规则失败: %w", err)
+	}
+
+	fmt.Print(content)
+	return nil
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/nspass/nspass-agent/pkg/agent"
+	"github.com/nspass/nspass-agent/pkg/api"
 	"github.com/nspass/nspass-agent/pkg/config"
 	"github.com/nspass/nspass-agent/pkg/logger"
 
@@ -15,8 +17,9 @@ import (
 )
 
 var (
-	configPath = "/etc/nspass/config.yaml"
-	logLevel   = "info"
+	configPath      = "/etc/nspass/config.yaml"
+	logLevel        = "info"
+	adminSocketPath string
 
 	// 构建时注入的版本信息
 	Version   = "dev"
@@ -36,6 +39,18 @@ func main() {
 	rootCmd.Flags().StringVarP(&configPath, "config", "c", configPath, "配置文件路径")
 	rootCmd.Flags().StringVarP(&logLevel, "log-level", "l", logLevel, "日志级别 (debug, info, warn, error)")
 
+	// 操作运维子命令：validate/dump在本地解析配置文件即可完成，status/reload/
+	// iptables/proxy需要通过admin.Client连接agent.Service暴露的Unix域套接字
+	rootCmd.PersistentFlags().StringVar(&adminSocketPath, "admin-socket", "", "本地运维API的Unix域套接字路径，默认读取配置文件里的admin.socket_path")
+	rootCmd.AddCommand(newRunCmd())
+	rootCmd.AddCommand(newValidateCmd())
+	rootCmd.AddCommand(newDumpCmd())
+	rootCmd.AddCommand(newStatusCmd())
+	rootCmd.AddCommand(newReloadCmd())
+	rootCmd.AddCommand(newIPTablesCmd())
+	rootCmd.AddCommand(newProxyCmd())
+	rootCmd.AddCommand(newTraceCmd())
+
 	if err := rootCmd.Execute(); err != nil {
 		// 在logger初始化之前，使用基础输出
 		logrus.Fatal(err)
@@ -90,6 +105,19 @@ func runAgent(cmd *cobra.Command, args []string) {
 		systemLogger.WithField("config", cfg.Logger).Info("日志系统已根据配置重新初始化")
 	}
 
+	// 初始化审计事件sink，此后LogAudit/LogStateChange会fan-out到其中启用的sink
+	if err := logger.InitAudit(cfg.ServerID, cfg.API.Token, cfg.Logger.Audit); err != nil {
+		systemLogger.WithError(err).Warn("初始化审计事件sink失败，审计事件仅写入标准日志")
+	}
+
+	// 初始化pkg/api的OpenTelemetry追踪导出，Tracing.Endpoint为空时返回no-op
+	// shutdown，GetServerConfig等方法创建的span不产生导出开销
+	shutdownTracing, err := api.InitTracing(context.Background(), cfg.API.Tracing)
+	if err != nil {
+		systemLogger.WithError(err).Warn("初始化OpenTelemetry追踪失败，本次运行不导出追踪数据")
+		shutdownTracing = func(context.Context) error { return nil }
+	}
+
 	// 记录启动信息
 	logger.LogStartup("nspass-agent", Version, map[string]interface{}{
 		"server_id":       cfg.ServerID,
@@ -119,6 +147,45 @@ func runAgent(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	// 启动本地配置文件热重载监听：SIGHUP或配置文件变化会重新加载、校验并按
+	// 发生变化的子树派发事件。其中只有logger配置会在不重启进程的情况下生效；
+	// 代理启用列表、monitor配置和api块的变化只被检测并记录警告，不会应用到
+	// 运行中的子系统。服务端下发的配置走agent.Reconciler的
+	// EventConfigChanged，与本Watcher是两条独立的触发路径
+	watcherCtx, cancelWatcher := context.WithCancel(context.Background())
+	defer cancelWatcher()
+
+	cfgWatcher := config.NewWatcher(configPath, cfg)
+	cfgWatcher.Subscribe(config.LoggerChanged, func(next *config.Config) {
+		if err := logger.Initialize(next.Logger); err != nil {
+			systemLogger.WithError(err).Warn("热重载日志配置失败，继续使用当前配置")
+			return
+		}
+		if err := logger.InitAudit(next.ServerID, next.API.Token, next.Logger.Audit); err != nil {
+			systemLogger.WithError(err).Warn("热重载审计事件sink失败")
+		}
+		systemLogger.Info("日志配置已热重载")
+	})
+	// proxy.enabled_types/monitor/api块没有对应的"运行中子系统局部更新"
+	// 入口（UpdateProxies只按egress ID应用已下发的配置，不感知本地yaml的类型
+	// 开关；Manager的监控循环不会更换已启动ticker的间隔），这里只记录检测到
+	// 的变化，新值在重启Agent之后才生效
+	cfgWatcher.Subscribe(config.ProxyEnabledTypesChanged, func(next *config.Config) {
+		systemLogger.WithField("enabled_types", next.Proxy.EnabledTypes).
+			Warn("检测到proxy.enabled_types变化，当前版本仍需重启Agent才能生效")
+	})
+	cfgWatcher.Subscribe(config.MonitorChanged, func(next *config.Config) {
+		systemLogger.WithField("monitor", next.Proxy.Monitor).
+			Warn("检测到proxy.monitor变化，当前版本仍需重启Agent才能生效")
+	})
+	cfgWatcher.Subscribe(config.APIChanged, func(next *config.Config) {
+		systemLogger.Warn("检测到api块变化，当前版本仍需重启Agent才能生效")
+	})
+
+	if err := cfgWatcher.Start(watcherCtx); err != nil {
+		systemLogger.WithError(err).Warn("启动配置热重载监听失败，SIGHUP和文件变化将不会生效")
+	}
+
 	systemLogger.WithFields(logrus.Fields{
 		"server_id":        cfg.ServerID,
 		"startup_duration": time.Since(startTime).Milliseconds(),
@@ -129,6 +196,8 @@ func runAgent(cmd *cobra.Command, args []string) {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	receivedSignal := <-sigChan
 
+	cancelWatcher()
+
 	shutdownStart := time.Now()
 	systemLogger.WithField("signal", receivedSignal).Info("NSPass Agent 正在关闭...")
 
@@ -137,6 +206,11 @@ func runAgent(cmd *cobra.Command, args []string) {
 		logger.LogError(err, "停止Agent服务失败", nil)
 	}
 
+	// 关闭追踪导出器，确保关闭前产生的span都已flush给collector
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.LogError(err, "关闭OpenTelemetry追踪失败", nil)
+	}
+
 	shutdownDuration := time.Since(shutdownStart)
 	totalDuration := time.Since(startTime)
 
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nspass/nspass-agent/pkg/agent"
+	"github.com/nspass/nspass-agent/pkg/config"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	roleAgent         = "agent"
+	roleReporter      = "reporter"
+	roleIptablesSync  = "iptables-sync"
+	roleOneshotConfig = "oneshot-config"
+)
+
+// newRunCmd `run --role=...`是runAgent(完整Service)以外几个角色的统一入口，
+// 每个角色只构造自己需要的那部分api.Client调用（见pkg/agent/roles.go），让
+// 运维人员可以把iptables同步丢给cron/systemd timer，或者单独跑一个轻量
+// reporter常驻进程，同时仍然保留`nspass-agent`（无子命令）等价于
+// `run --role=agent`的既有用法
+func newRunCmd() *cobra.Command {
+	var role string
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "以指定角色运行Agent",
+		Long: "以指定角色运行Agent：\n" +
+			"  agent          完整Service，轮询/监听配置并管理proxy、iptables等子系统（默认，等价于不带子命令直接运行）\n" +
+			"  reporter       只周期性上报最小状态（网络地址+时间），不管理proxy/iptables\n" +
+			"  iptables-sync  一次性拉取并应用iptables配置后退出，适合cron/systemd timer\n" +
+			"  oneshot-config 一次性拉取服务器配置并打印到标准输出后退出",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch role {
+			case roleAgent:
+				runAgent(cmd, args)
+				return nil
+			case roleReporter:
+				return runReporterRole()
+			case roleIptablesSync:
+				return runIptablesSyncRole()
+			case roleOneshotConfig:
+				return runOneshotConfigRole()
+			default:
+				return fmt.Errorf("未知角色: %s（支持: %s/%s/%s/%s）", role, roleAgent, roleReporter, roleIptablesSync, roleOneshotConfig)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&role, "role", roleAgent, "运行角色: agent/reporter/iptables-sync/oneshot-config")
+
+	return cmd
+}
+
+// loadConfigForRole 加载并校验配置文件，是reporter/iptables-sync/
+// oneshot-config三个一次性/轻量角色共用的启动前置步骤，与runAgent里完整
+// Service的加载校验逻辑一致
+func loadConfigForRole() (*config.Config, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载配置文件失败: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("配置校验失败: %w", err)
+	}
+	return cfg, nil
+}
+
+// runReporterRole 常驻运行reporter角色，直到收到SIGINT/SIGTERM
+func runReporterRole() error {
+	cfg, err := loadConfigForRole()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+
+	return agent.RunReporter(ctx, cfg, cfg.ServerID)
+}
+
+// runIptablesSyncRole 一次性拉取并应用iptables配置后返回，适合cron/
+// systemd timer
+func runIptablesSyncRole() error {
+	cfg, err := loadConfigForRole()
+	if err != nil {
+		return err
+	}
+
+	if err := agent.RunIptablesSync(cfg, cfg.ServerID); err != nil {
+		return err
+	}
+
+	fmt.Println("iptables配置同步完成")
+	return nil
+}
+
+// runOneshotConfigRole 一次性拉取服务器配置并打印到标准输出后返回
+func runOneshotConfigRole() error {
+	cfg, err := loadConfigForRole()
+	if err != nil {
+		return err
+	}
+
+	serverConfig, err := agent.RunConfigPuller(cfg, cfg.ServerID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(serverConfig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化配置失败: %w", err)
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,63 @@
+//go:build linux && amd64
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nspass/nspass-agent/pkg/admin"
+	"github.com/nspass/nspass-agent/pkg/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// newTraceCmd `trace <component>`通过admin套接字把代理名解析成PID，再在CLI
+// 自身进程里对该PID发起ptrace附加，给operator一个"这个shadowsocks worker在
+// 碰什么不该碰的文件/主机"的调试开关。ptrace的附加方必须是实际发起
+// PtraceAttach的那个进程，所以这里不能像status/reload那样把动作代理给长期
+// 运行的agent daemon执行，只能由CLI进程自己完成，admin API只负责PID查询。
+func newTraceCmd() *cobra.Command {
+	var syscalls []string
+
+	cmd := &cobra.Command{
+		Use:   "trace <component>",
+		Short: "审计指定代理进程的系统调用（connect/bind/execve/openat）",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			socketPath, err := resolveAdminSocketPath()
+			if err != nil {
+				return err
+			}
+
+			var result struct {
+				PID int `json:"pid"`
+			}
+			params := map[string]string{"name": args[0]}
+			if err := admin.NewClient(socketPath).Call("GetProxyPID", params, &result); err != nil {
+				return fmt.Errorf("查询%s的PID失败: %w", args[0], err)
+			}
+
+			fmt.Printf("开始审计%s（pid=%d），按Ctrl+C结束\n", args[0], result.PID)
+
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sigCh
+				utils.RequestAuditStop(result.PID)
+			}()
+
+			return utils.AuditSyscalls(result.PID, syscalls, func(event utils.SyscallEvent) {
+				data, _ := json.Marshal(event)
+				fmt.Println(string(data))
+			})
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&syscalls, "syscall", nil, "只审计指定的系统调用（connect/bind/execve/openat），不指定则全部审计")
+
+	return cmd
+}
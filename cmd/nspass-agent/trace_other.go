@@ -0,0 +1,23 @@
+//go:build !(linux && amd64)
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// newTraceCmd 在非linux/amd64平台上的占位实现：ptrace审计依赖的系统调用
+// 只在linux/amd64下实现，其余平台保留命令名以维持cobra帮助输出一致，但
+// 执行时直接报错
+func newTraceCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "trace <component>",
+		Short: "审计指定代理进程的系统调用（仅支持linux/amd64）",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fmt.Errorf("trace子命令仅在linux/amd64平台上可用")
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Client是admin Server对应的Unix域套接字客户端，供cobra的
+// status/reload/proxy restart子命令调用
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient 创建连接到socketPath的Client
+func NewClient(socketPath string) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Call 向admin Server发起一次RPC调用，result非nil时将Result字段解码进去
+func (c *Client) Call(method string, params interface{}, result interface{}) error {
+	var paramsJSON json.RawMessage
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("编码请求参数失败: %w", err)
+		}
+		paramsJSON = data
+	}
+
+	body, err := json.Marshal(rpcRequest{Method: method, Params: paramsJSON})
+	if err != nil {
+		return fmt.Errorf("编码RPC请求失败: %w", err)
+	}
+
+	// admin套接字只在本机监听，URL的host部分不参与寻址，只是http.Client要求的占位符
+	resp, err := c.httpClient.Post("http://admin/rpc", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("连接本地运维API失败（agent是否在运行？）: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var rpcResp rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return fmt.Errorf("解析RPC响应失败: %w", err)
+	}
+
+	if rpcResp.Error != "" {
+		return fmt.Errorf("%s", rpcResp.Error)
+	}
+
+	if result == nil || rpcResp.Result == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(rpcResp.Result)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,190 @@
+// Package admin为agent.Service提供本地运维API：通过0600权限的Unix域套接字
+// 暴露一套最小的JSON RPC over HTTP接口（GetStatus/Reconcile/RestartProxy/
+// GetProxyPID），让`nspass-agent status|reload|proxy restart|trace`等cobra
+// 子命令在WebSocket控制面不可达时也能完成同样的on-box调试动作，参考
+// kube-proxy旁路工具给operator的kubectl式体验
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// StatusProvider 返回一份可JSON序列化的状态快照，由agent.Service注入
+type StatusProvider func() interface{}
+
+// ReconcileFunc 触发一次强制协调，由agent.Service注入（对应Reconciler.Enqueue
+// 各事件类型）
+type ReconcileFunc func() error
+
+// RestartProxyFunc 重启指定名称的代理服务，由agent.Service注入
+type RestartProxyFunc func(name string) error
+
+// ProxyPIDFunc 返回指定名称代理服务当前持有的PID，由agent.Service注入，
+// 未启动/未接管该代理时ok为false
+type ProxyPIDFunc func(name string) (pid int, ok bool)
+
+// rpcRequest 是/rpc端点接受的请求体，Method决定如何解析Params
+type rpcRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse 是/rpc端点的统一响应体
+type rpcResponse struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type restartProxyParams struct {
+	Name string `json:"name"`
+}
+
+// getProxyPIDParams是"GetProxyPID"方法的请求参数，复用restartProxyParams
+// 同样的{"name": ...}形状
+type getProxyPIDParams struct {
+	Name string `json:"name"`
+}
+
+// Server 是监听Unix域套接字的本地运维API服务
+type Server struct {
+	socketPath   string
+	httpServer   *http.Server
+	listener     net.Listener
+	getStatus    StatusProvider
+	reconcile    ReconcileFunc
+	restartProxy RestartProxyFunc
+	getProxyPID  ProxyPIDFunc
+}
+
+// NewServer 创建admin Server，socketPath为空时返回nil（调用方应视为禁用）
+func NewServer(socketPath string, getStatus StatusProvider, reconcile ReconcileFunc, restartProxy RestartProxyFunc, getProxyPID ProxyPIDFunc) *Server {
+	if socketPath == "" {
+		return nil
+	}
+
+	s := &Server{
+		socketPath:   socketPath,
+		getStatus:    getStatus,
+		reconcile:    reconcile,
+		restartProxy: restartProxy,
+		getProxyPID:  getProxyPID,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rpc", s.handleRPC)
+	s.httpServer = &http.Server{Handler: mux}
+
+	return s
+}
+
+// Start 监听Unix域套接字并以0600权限开放，仅宿主上拥有文件读写权限的用户
+// 可以连接；已存在的同名套接字文件会被当作陈旧句柄清理掉
+func (s *Server) Start() error {
+	log := logger.GetAdminLogger()
+
+	if err := os.RemoveAll(s.socketPath); err != nil {
+		return fmt.Errorf("清理陈旧的admin套接字失败: %w", err)
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("监听admin套接字失败: %w", err)
+	}
+
+	if err := os.Chmod(s.socketPath, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("设置admin套接字权限失败: %w", err)
+	}
+
+	s.listener = listener
+
+	log.WithField("socket_path", s.socketPath).Info("本地运维API已启动")
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.LogError(err, "本地运维API异常退出", nil)
+		}
+	}()
+
+	return nil
+}
+
+// Stop 关闭HTTP服务并清理套接字文件
+func (s *Server) Stop() error {
+	if s.httpServer == nil {
+		return nil
+	}
+
+	if err := s.httpServer.Shutdown(context.Background()); err != nil {
+		return fmt.Errorf("关闭admin套接字服务失败: %w", err)
+	}
+
+	return os.RemoveAll(s.socketPath)
+}
+
+func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRPCError(w, http.StatusBadRequest, fmt.Sprintf("解析请求失败: %v", err))
+		return
+	}
+
+	switch req.Method {
+	case "GetStatus":
+		s.writeResult(w, s.getStatus())
+	case "Reconcile":
+		if err := s.reconcile(); err != nil {
+			writeRPCError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.writeResult(w, map[string]string{"message": "已入队强制协调"})
+	case "RestartProxy":
+		var params restartProxyParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Name == "" {
+			writeRPCError(w, http.StatusBadRequest, "缺少代理名称参数")
+			return
+		}
+		if err := s.restartProxy(params.Name); err != nil {
+			writeRPCError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.writeResult(w, map[string]string{"message": fmt.Sprintf("代理%s已重启", params.Name)})
+	case "GetProxyPID":
+		var params getProxyPIDParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Name == "" {
+			writeRPCError(w, http.StatusBadRequest, "缺少代理名称参数")
+			return
+		}
+		pid, ok := s.getProxyPID(params.Name)
+		if !ok {
+			writeRPCError(w, http.StatusNotFound, fmt.Sprintf("代理%s当前没有运行中的进程", params.Name))
+			return
+		}
+		s.writeResult(w, map[string]int{"pid": pid})
+	default:
+		writeRPCError(w, http.StatusNotFound, fmt.Sprintf("未知方法: %s", req.Method))
+	}
+}
+
+func (s *Server) writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rpcResponse{Result: result})
+}
+
+func writeRPCError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rpcResponse{Error: message})
+}
@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/moooyo/nspass-proto/generated/model"
+	"github.com/nspass/nspass-agent/pkg/admin"
+	"github.com/nspass/nspass-agent/pkg/geoip"
+	"github.com/nspass/nspass-agent/pkg/iptables"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/proxy"
+	"github.com/nspass/nspass-agent/pkg/websocket"
+)
+
+// 以下类型是pkg/component.Component在Service现有子系统上的适配器：各子系统
+// 本身的生命周期方法签名互不相同（有的带ctx，有的不带；有的要求显式Stop，
+// 有的只需要关闭底层句柄），适配器把它们统一成Component接口，使Service可以
+// 通过component.Registry统一编排启动/停止顺序
+
+// proxyComponent 适配proxy.Manager。Manager的监控器在NewManager时已经启动，
+// 这里的OnStart留空，OnStop负责停止监控器
+type proxyComponent struct {
+	manager *proxy.Manager
+}
+
+func (c *proxyComponent) Name() string                      { return "proxy" }
+func (c *proxyComponent) OnInit(ctx context.Context) error  { return nil }
+func (c *proxyComponent) OnStart(ctx context.Context) error { return nil }
+func (c *proxyComponent) OnStop(ctx context.Context) error  { return c.manager.StopMonitor() }
+func (c *proxyComponent) HealthCheck() error                { return nil }
+
+// iptablesComponent 适配iptables.ManagerInterface，规则按需应用，没有独立的
+// 启动/停止动作
+type iptablesComponent struct {
+	manager iptables.ManagerInterface
+}
+
+func (c *iptablesComponent) Name() string                      { return "iptables" }
+func (c *iptablesComponent) OnInit(ctx context.Context) error  { return nil }
+func (c *iptablesComponent) OnStart(ctx context.Context) error { return nil }
+func (c *iptablesComponent) OnStop(ctx context.Context) error  { return nil }
+func (c *iptablesComponent) HealthCheck() error                { return nil }
+
+// websocketComponent 适配websocket.Client，依赖proxy和iptables组件，保证
+// Client引用的任务处理器/监控数据收集器所依赖的子系统已经就绪
+type websocketComponent struct {
+	client *websocket.Client
+	// restartMarkerPath是config.TaskConfig.RestartMarkerPath，OnStart里据此
+	// 检查本次启动是不是紧接在一次restartAgentService自重启之后
+	restartMarkerPath string
+}
+
+func (c *websocketComponent) Name() string { return "websocket" }
+
+func (c *websocketComponent) OnInit(ctx context.Context) error { return nil }
+
+func (c *websocketComponent) OnStart(ctx context.Context) error {
+	if c.client == nil {
+		return nil
+	}
+	if err := c.client.Start(); err != nil {
+		return err
+	}
+	c.sendDeferredRestartResult()
+	return nil
+}
+
+// sendDeferredRestartResult检查restartMarkerPath处是否留有上一次自重启没来得
+// 及发送的TaskResult标记，有的话补发TASK_STATUS_COMPLETED并清除标记。标记不
+// 存在是绝大多数正常启动（不是紧接在一次自重启之后）的情况，不记日志
+func (c *websocketComponent) sendDeferredRestartResult() {
+	if c.restartMarkerPath == "" {
+		return
+	}
+
+	log := logger.GetComponentLogger("websocket-component")
+	marker, ok, err := websocket.ConsumeRestartMarker(c.restartMarkerPath)
+	if err != nil {
+		log.WithError(err).Warn("读取自重启标记失败")
+		return
+	}
+	if !ok {
+		return
+	}
+
+	log.WithField("task_id", marker.TaskID).Info("检测到自重启标记，补发延迟的任务结果")
+	c.client.SendDeferredTaskResult(marker.TaskID, &model.TaskResult{
+		TaskId: marker.TaskID,
+		Status: model.TaskStatus_TASK_STATUS_COMPLETED,
+		Output: "Agent自重启完成",
+	})
+}
+
+func (c *websocketComponent) OnStop(ctx context.Context) error {
+	if c.client == nil {
+		return nil
+	}
+	return c.client.Stop()
+}
+
+func (c *websocketComponent) HealthCheck() error { return nil }
+
+// registryComponent 适配Service自身的注册中心自注册/配置监听逻辑（依赖
+// websocket组件先启动，保持与之前硬编码顺序一致）
+type registryComponent struct {
+	svc *Service
+}
+
+func (c *registryComponent) Name() string { return "registry" }
+
+func (c *registryComponent) OnInit(ctx context.Context) error { return nil }
+
+func (c *registryComponent) OnStart(ctx context.Context) error {
+	c.svc.startRegistryOrPolling(logger.GetComponentLogger("agent-service"))
+	return nil
+}
+
+func (c *registryComponent) OnStop(ctx context.Context) error {
+	if c.svc.registry == nil {
+		return nil
+	}
+	return c.svc.registry.Close()
+}
+
+func (c *registryComponent) HealthCheck() error { return nil }
+
+// geoipComponent 适配geoip.Resolver，数据库本身是懒加载的，OnStart无需动作，
+// OnStop负责释放底层文件句柄
+type geoipComponent struct {
+	resolver *geoip.Resolver
+}
+
+func (c *geoipComponent) Name() string                      { return "geoip" }
+func (c *geoipComponent) OnInit(ctx context.Context) error  { return nil }
+func (c *geoipComponent) OnStart(ctx context.Context) error { return nil }
+
+func (c *geoipComponent) OnStop(ctx context.Context) error {
+	if c.resolver == nil {
+		return nil
+	}
+	return c.resolver.Close()
+}
+
+func (c *geoipComponent) HealthCheck() error { return nil }
+
+// reconcilerComponent 适配Reconciler，依赖proxy/iptables组件先就绪，因为
+// 各事件类型的syncHandler会直接调用它们
+type reconcilerComponent struct {
+	reconciler *Reconciler
+}
+
+func (c *reconcilerComponent) Name() string { return "reconciler" }
+
+func (c *reconcilerComponent) OnInit(ctx context.Context) error { return nil }
+
+func (c *reconcilerComponent) OnStart(ctx context.Context) error {
+	return c.reconciler.Start(ctx)
+}
+
+func (c *reconcilerComponent) OnStop(ctx context.Context) error {
+	return c.reconciler.Stop()
+}
+
+func (c *reconcilerComponent) HealthCheck() error { return nil }
+
+// adminComponent 适配admin.Server，未配置cfg.Admin.SocketPath时server为nil，
+// OnStart/OnStop都是空操作
+type adminComponent struct {
+	server *admin.Server
+}
+
+func (c *adminComponent) Name() string { return "admin" }
+
+func (c *adminComponent) OnInit(ctx context.Context) error { return nil }
+
+func (c *adminComponent) OnStart(ctx context.Context) error {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Start()
+}
+
+func (c *adminComponent) OnStop(ctx context.Context) error {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Stop()
+}
+
+func (c *adminComponent) HealthCheck() error { return nil }
+
+// metricsComponent 适配websocket.MetricsExporter，cfg.Metrics.Enable为false
+// 时NewMetricsExporter返回nil，OnStart/OnStop都是空操作
+type metricsComponent struct {
+	exporter *websocket.MetricsExporter
+}
+
+func (c *metricsComponent) Name() string { return "metrics" }
+
+func (c *metricsComponent) OnInit(ctx context.Context) error { return nil }
+
+func (c *metricsComponent) OnStart(ctx context.Context) error {
+	if c.exporter == nil {
+		return nil
+	}
+	return c.exporter.Start()
+}
+
+func (c *metricsComponent) OnStop(ctx context.Context) error {
+	if c.exporter == nil {
+		return nil
+	}
+	return c.exporter.Stop()
+}
+
+func (c *metricsComponent) HealthCheck() error { return nil }
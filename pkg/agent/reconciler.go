@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// EventType标识Reconciler处理的一类配置变更，类比kube-proxy informer里的
+// 资源种类：WebSocket推送和周期性resync都只负责把对应的EventType入队，真正
+// 的"读取期望状态、和已应用状态对比、只下发变化部分"的逻辑在各自的syncHandler里
+type EventType string
+
+const (
+	EventConfigChanged   EventType = "config"
+	EventIptablesChanged EventType = "iptables"
+	EventProxyChanged    EventType = "proxy"
+	EventRoutesChanged   EventType = "routes"
+)
+
+// reconcileResyncInterval 周期性安全resync的间隔，兜底WebSocket推送或注册
+// 中心配置通知丢失的情况，量级上和pkg/websocket的resyncSafetyInterval一致
+const reconcileResyncInterval = 5 * time.Minute
+
+// reconcileMinInterval 同一事件类型两次成功处理之间的最小间隔，避免
+// reportStatus、WebSocket推送、resync ticker短时间内同时触发时重复全量协调
+const reconcileMinInterval = 2 * time.Second
+
+// reconcileMaxBackoff 同一事件类型连续失败时的最大重试间隔
+const reconcileMaxBackoff = time.Minute
+
+// syncHandler处理一类事件的实际协调逻辑，返回值决定下一次允许处理该事件的
+// 时间点是按reconcileMinInterval还是按失败退避计算
+type syncHandler func() error
+
+// Reconciler是一个按key（EventType）去重的工作队列：每个key由独立的worker
+// goroutine串行处理，保证同一子系统不会被并发协调两次；key在被worker取走
+// 之前重复入队只会触发一次处理，调用方无需自己防抖
+type Reconciler struct {
+	log      *logrus.Entry
+	handlers map[EventType]syncHandler
+	workCh   map[EventType]chan struct{}
+
+	mu           sync.Mutex
+	pending      map[EventType]bool
+	lastSync     map[EventType]time.Time
+	lastErr      map[EventType]string
+	failureCount map[EventType]int
+	nextAllowed  map[EventType]time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewReconciler创建一个空的Reconciler，需要通过RegisterHandler注册完所有
+// 事件类型的处理逻辑之后再调用Start
+func NewReconciler() *Reconciler {
+	return &Reconciler{
+		log:          logger.GetComponentLogger("agent-reconciler"),
+		handlers:     make(map[EventType]syncHandler),
+		workCh:       make(map[EventType]chan struct{}),
+		pending:      make(map[EventType]bool),
+		lastSync:     make(map[EventType]time.Time),
+		lastErr:      make(map[EventType]string),
+		failureCount: make(map[EventType]int),
+		nextAllowed:  make(map[EventType]time.Time),
+	}
+}
+
+// RegisterHandler为事件类型t注册同步逻辑，必须在Start之前调用
+func (r *Reconciler) RegisterHandler(t EventType, handler syncHandler) {
+	r.handlers[t] = handler
+	r.workCh[t] = make(chan struct{}, 1)
+}
+
+// Start为每个已注册的事件类型启动一个独立worker，并启动周期性安全resync
+func (r *Reconciler) Start(ctx context.Context) error {
+	r.ctx, r.cancel = context.WithCancel(ctx)
+
+	for t := range r.handlers {
+		t := t
+		r.wg.Add(1)
+		go r.worker(t)
+	}
+
+	r.wg.Add(1)
+	go r.resyncLoop()
+
+	return nil
+}
+
+// Stop通知所有worker退出并等待其结束
+func (r *Reconciler) Stop() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+// Enqueue把事件t标记为待处理。t未注册处理器时直接忽略
+func (r *Reconciler) Enqueue(t EventType) {
+	if _, ok := r.handlers[t]; !ok {
+		return
+	}
+
+	r.mu.Lock()
+	alreadyPending := r.pending[t]
+	r.pending[t] = true
+	r.mu.Unlock()
+
+	if alreadyPending {
+		return
+	}
+
+	select {
+	case r.workCh[t] <- struct{}{}:
+	default:
+		// worker已经在处理当前这一轮，或已有一次通知在等待，不阻塞调用方
+	}
+}
+
+// worker串行处理事件类型t的所有触发
+func (r *Reconciler) worker(t EventType) {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.workCh[t]:
+			r.waitUntilAllowed(t)
+			r.process(t)
+		}
+	}
+}
+
+// waitUntilAllowed在速率限制/退避窗口内阻塞，直到允许再次处理t或ctx被取消
+func (r *Reconciler) waitUntilAllowed(t EventType) {
+	r.mu.Lock()
+	next := r.nextAllowed[t]
+	r.mu.Unlock()
+
+	if wait := time.Until(next); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-r.ctx.Done():
+		}
+	}
+}
+
+func (r *Reconciler) process(t EventType) {
+	r.mu.Lock()
+	r.pending[t] = false
+	r.mu.Unlock()
+
+	handler := r.handlers[t]
+	if handler == nil {
+		return
+	}
+
+	err := handler()
+
+	r.mu.Lock()
+	r.lastSync[t] = time.Now()
+	if err != nil {
+		r.failureCount[t]++
+		r.lastErr[t] = err.Error()
+		r.nextAllowed[t] = time.Now().Add(backoffDuration(r.failureCount[t]))
+	} else {
+		r.failureCount[t] = 0
+		r.lastErr[t] = ""
+		r.nextAllowed[t] = time.Now().Add(reconcileMinInterval)
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		r.log.WithError(err).WithField("event", t).Warn("协调事件处理失败，已按退避策略安排重试")
+	} else {
+		r.log.WithField("event", t).Debug("协调事件处理完成")
+	}
+}
+
+// backoffDuration按连续失败次数计算下一次重试前的等待时间，1s起按指数增长，
+// 封顶reconcileMaxBackoff
+func backoffDuration(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	d := time.Second << uint(failures-1)
+	if d <= 0 || d > reconcileMaxBackoff {
+		return reconcileMaxBackoff
+	}
+	return d
+}
+
+// resyncLoop周期性地把所有已注册的事件类型重新入队，作为WebSocket推送或
+// 注册中心配置通知丢失时的安全网
+func (r *Reconciler) resyncLoop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(reconcileResyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			for t := range r.handlers {
+				r.Enqueue(t)
+			}
+		}
+	}
+}
+
+// GetStatus返回每个事件类型的队列深度（去重后最多有一个待处理）、最近一次
+// 同步时间和错误，供Service.GetStatus聚合展示
+func (r *Reconciler) GetStatus() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make(map[string]interface{}, len(r.handlers))
+	for t := range r.handlers {
+		entry := map[string]interface{}{
+			"queue_depth": boolToInt(r.pending[t]),
+		}
+		if !r.lastSync[t].IsZero() {
+			entry["last_sync"] = r.lastSync[t].Format(time.RFC3339)
+		}
+		if r.lastErr[t] != "" {
+			entry["last_error"] = r.lastErr[t]
+		}
+		statuses[string(t)] = entry
+	}
+	return statuses
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
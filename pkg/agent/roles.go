@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/api"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/iptables"
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// 本文件实现`nspass-agent run --role=...`里除"agent"（完整Service）以外的
+// 几个轻量角色。它们都只构造自己用得到的那一小块api.Client调用，不创建
+// Service及其proxy/websocket/registry等子系统，适合作为cron/systemd timer
+// 里的一次性任务，或常驻但职责单一的上报进程
+
+// RunConfigPuller 一次性拉取服务器配置并返回给调用方（oneshot-config角色据此
+// 以JSON打印到标准输出），用于bootstrap脚本里先取一份配置做检查，不落地应用
+func RunConfigPuller(cfg *config.Config, serverID string) (*api.ServerConfigData, error) {
+	apiClient := api.NewClient(cfg.API, serverID)
+	defer apiClient.Close()
+
+	serverConfig, err := apiClient.GetServerConfig(serverID)
+	if err != nil {
+		return nil, fmt.Errorf("拉取服务器配置失败: %w", err)
+	}
+
+	return serverConfig, nil
+}
+
+// RunIptablesSync 一次性拉取proto格式的iptables配置并应用，用于在cron/
+// systemd timer里单独跑iptables同步，不需要常驻agent进程
+func RunIptablesSync(cfg *config.Config, serverID string) error {
+	log := logger.GetComponentLogger("agent-iptables-sync")
+
+	apiClient := api.NewClient(cfg.API, serverID)
+	defer apiClient.Close()
+
+	iptablesManager := iptables.NewManager(cfg.IPTables)
+
+	configs, err := apiClient.GetServerIptablesConfigsProto(serverID)
+	if err != nil {
+		return fmt.Errorf("获取iptables配置失败: %w", err)
+	}
+
+	log.WithField("configs_count", len(configs)).Info("获取到iptables配置(proto)，开始应用")
+
+	if err := iptablesManager.UpdateRulesFromProto(configs); err != nil {
+		return fmt.Errorf("应用iptables配置失败: %w", err)
+	}
+
+	log.Info("iptables同步完成")
+	return nil
+}
+
+// RunReporter 按cfg.UpdateInterval周期性上报一份最小状态报告（网络地址+
+// 上报时间，不含CPU/内存/proxy状态，这些需要完整Service管理的子系统才能
+// 采集），直到ctx被取消。用于只需要"让服务器知道这个server_id还活着"的
+// 轻量常驻场景，与完整Service的statusReportLoop相比不拉起proxy/iptables等
+// 子系统
+func RunReporter(ctx context.Context, cfg *config.Config, serverID string) error {
+	log := logger.GetComponentLogger("agent-reporter")
+
+	apiClient := api.NewClient(cfg.API, serverID)
+	defer apiClient.Close()
+
+	interval := time.Duration(cfg.UpdateInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	report := func() {
+		ipv4, ipv6, err := getNetworkAddresses()
+		if err != nil {
+			log.WithError(err).Warn("获取网络地址失败，仍继续上报")
+		}
+
+		status := api.AgentStatusReport{
+			ServerID:    serverID,
+			IPv4Address: ipv4,
+			IPv6Address: ipv6,
+			ReportTime:  time.Now(),
+		}
+
+		if _, err := apiClient.ReportAgentStatus(status); err != nil {
+			log.WithError(err).Warn("上报状态失败")
+			return
+		}
+
+		log.Debug("状态上报完成")
+	}
+
+	log.WithField("interval", interval).Info("reporter角色已启动")
+	report()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("reporter角色已停止")
+			return nil
+		case <-ticker.C:
+			report()
+		}
+	}
+}
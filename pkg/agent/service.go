@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -11,11 +12,21 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nspass/nspass-agent/pkg/admin"
 	"github.com/nspass/nspass-agent/pkg/api"
+	"github.com/nspass/nspass-agent/pkg/component"
 	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/geoip"
 	"github.com/nspass/nspass-agent/pkg/iptables"
+	"github.com/nspass/nspass-agent/pkg/ipvs"
 	"github.com/nspass/nspass-agent/pkg/logger"
 	"github.com/nspass/nspass-agent/pkg/proxy"
+	// 以下blank import仅为触发各代理后端的init()，使其通过proxy.Register把自己
+	// 登记进注册表——pkg/proxy本身不再直接依赖具体后端，避免import cycle
+	_ "github.com/nspass/nspass-agent/pkg/proxy/shadowsocks"
+	_ "github.com/nspass/nspass-agent/pkg/proxy/snell"
+	_ "github.com/nspass/nspass-agent/pkg/proxy/trojan"
+	"github.com/nspass/nspass-agent/pkg/registry"
 	"github.com/nspass/nspass-agent/pkg/websocket"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -29,10 +40,17 @@ type Service struct {
 	apiClient       *api.Client
 	proxyManager    *proxy.Manager
 	iptablesManager iptables.ManagerInterface
+	ipvsManager     ipvs.ManagerInterface
 	wsClient        *websocket.Client
+	registry        registry.Registry
+	geoResolver     *geoip.Resolver
+	components      *component.Registry
+	reconciler      *Reconciler
+	adminServer     *admin.Server
 
 	serverID       string
 	lastConfigHash string
+	pushedConfig   *api.ServerConfigData // 注册中心watch推送的配置，syncConfig优先消费它而不是重新走HTTP拉取
 
 	// 控制相关
 	ctx     context.Context
@@ -57,6 +75,17 @@ func NewService(cfg *config.Config, serverID string) (*Service, error) {
 	// 创建iptables管理器
 	iptablesManager := iptables.NewManager(cfg.IPTables)
 
+	// 创建IPVS管理器，接管负载均衡型出口（单个EgressItem背后对应一组
+	// real server）。cfg.IPVS.Enable为false时返回的是一个空操作实现
+	ipvsManager := ipvs.NewManager(cfg.IPVS)
+	proxyManager.SetIPVSManager(ipvsManager)
+
+	// 创建注册中心客户端，Backend为空或"none"时返回no-op实现
+	reg, err := registry.New(cfg.Registry)
+	if err != nil {
+		return nil, fmt.Errorf("创建注册中心客户端失败: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	service := &Service{
@@ -64,6 +93,9 @@ func NewService(cfg *config.Config, serverID string) (*Service, error) {
 		apiClient:       apiClient,
 		proxyManager:    proxyManager,
 		iptablesManager: iptablesManager,
+		ipvsManager:     ipvsManager,
+		registry:        reg,
+		geoResolver:     geoip.New(cfg.GeoIP),
 		serverID:        serverID,
 		ctx:             ctx,
 		cancel:          cancel,
@@ -73,7 +105,17 @@ func NewService(cfg *config.Config, serverID string) (*Service, error) {
 	taskHandler := websocket.NewDefaultTaskHandler(cfg, proxyManager, iptablesManager)
 
 	// 创建监控数据收集器
-	metricsCollector := websocket.NewDefaultMetricsCollector(proxyManager)
+	metricsCollector := websocket.NewMetricsCollector(proxyManager)
+
+	// 复用geoResolver给CollectConnectionMetrics的TopDestinations附加
+	// 国家/ASN/ISP信息。metricsCollector现在是MetricsCollector接口（具体实现
+	// 可能是DefaultMetricsCollector也可能是内嵌了它的EBPFCollector），两者都
+	// 通过内嵌/直接定义提供了SetGeoResolver，这里用接口断言而不是假设具体类型
+	if setter, ok := metricsCollector.(interface {
+		SetGeoResolver(resolver *geoip.Resolver)
+	}); ok {
+		setter.SetGeoResolver(service.geoResolver)
+	}
 
 	// 创建WebSocket客户端
 	wsClient := websocket.NewClient(cfg, serverID, cfg.API.Token, taskHandler, metricsCollector, iptablesManager, proxyManager)
@@ -81,8 +123,77 @@ func NewService(cfg *config.Config, serverID string) (*Service, error) {
 	// 设置任务统计提供者，用于监控数据收集
 	wsClient.SetTaskStatsProvider()
 
+	// taskHandler依赖wsClient做自重启前的出站队列flush，而wsClient的构造又
+	// 依赖taskHandler——只能在这里反向回填
+	taskHandler.SetOutboundFlusher(wsClient)
+
 	service.wsClient = wsClient
 
+	// 创建事件驱动的配置协调器，取代"清空lastConfigHash、等下一轮轮询"的
+	// 粗粒度做法：ConfigChanged/IptablesChanged/ProxyChanged/RoutesChanged
+	// 各自有独立的同步逻辑和worker，由WebSocket推送和周期性resync共同触发
+	reconciler := NewReconciler()
+	reconciler.RegisterHandler(EventIptablesChanged, service.syncIptables)
+	reconciler.RegisterHandler(EventProxyChanged, service.syncProxies)
+	reconciler.RegisterHandler(EventRoutesChanged, service.syncRoutes)
+	reconciler.RegisterHandler(EventConfigChanged, service.syncConfig)
+	service.reconciler = reconciler
+
+	// WebSocket推送成功应用egress/iptables配置后，同步通知Reconciler记账并
+	// 安排一次协调，使GetStatus里的last_sync能反映推送路径的更新
+	wsClient.SetSyncHook(func(kind string) {
+		switch kind {
+		case "proxy":
+			reconciler.Enqueue(EventProxyChanged)
+		case "iptables":
+			reconciler.Enqueue(EventIptablesChanged)
+		}
+	})
+
+	components := component.NewRegistry()
+	if err := components.RegisterComponent(&proxyComponent{manager: proxyManager}); err != nil {
+		return nil, err
+	}
+	if err := components.RegisterComponent(&iptablesComponent{manager: iptablesManager}); err != nil {
+		return nil, err
+	}
+	if err := components.RegisterComponent(&reconcilerComponent{reconciler: reconciler}, "proxy", "iptables"); err != nil {
+		return nil, err
+	}
+	if err := components.RegisterComponent(&websocketComponent{client: wsClient, restartMarkerPath: cfg.Task.RestartMarkerPath}, "proxy", "iptables"); err != nil {
+		return nil, err
+	}
+	if err := components.RegisterComponent(&registryComponent{svc: service}, "websocket", "reconciler"); err != nil {
+		return nil, err
+	}
+	if err := components.RegisterComponent(&geoipComponent{resolver: service.geoResolver}); err != nil {
+		return nil, err
+	}
+
+	// 本地运维API依赖proxy/iptables/reconciler才能回答GetStatus/Reconcile/
+	// RestartProxy，未配置cfg.Admin.SocketPath时NewServer返回nil，适配器的
+	// OnStart/OnStop据此变成空操作
+	adminServer := admin.NewServer(cfg.Admin.SocketPath, service.buildAdminStatus, service.forceReconcile, proxyManager.RestartProxy, service.getProxyPID)
+	service.adminServer = adminServer
+	if err := components.RegisterComponent(&adminComponent{server: adminServer}, "proxy", "iptables", "reconciler"); err != nil {
+		return nil, err
+	}
+
+	// Prometheus抓取端点复用metricsCollector已有的Collect*Metrics方法。
+	// metricsCollector是MetricsCollector接口，NewMetricsExporter需要多两个
+	// 方法的ExportableMetricsCollector，但NewMetricsCollector的两种实现
+	// （DefaultMetricsCollector本身和内嵌了它的EBPFCollector）都满足，所以
+	// 断言不会失败；cfg.Metrics.Enable为false时NewMetricsExporter返回nil
+	var metricsExporter *websocket.MetricsExporter
+	if exportable, ok := metricsCollector.(websocket.ExportableMetricsCollector); ok {
+		metricsExporter = websocket.NewMetricsExporter(exportable, cfg.Metrics.ListenAddr, cfg.Metrics.AuthToken)
+	}
+	if err := components.RegisterComponent(&metricsComponent{exporter: metricsExporter}); err != nil {
+		return nil, err
+	}
+
+	service.components = components
+
 	logger.LogStartup("agent-service", "1.0", map[string]interface{}{
 		"server_id":         serverID,
 		"update_interval":   cfg.UpdateInterval,
@@ -90,6 +201,7 @@ func NewService(cfg *config.Config, serverID string) (*Service, error) {
 		"proxy_enabled":     len(cfg.Proxy.EnabledTypes) > 0,
 		"iptables_enabled":  cfg.IPTables.Enable,
 		"websocket_enabled": true,
+		"admin_enabled":     adminServer != nil,
 	})
 
 	return service, nil
@@ -107,11 +219,17 @@ func (s *Service) Start() error {
 	log := logger.GetComponentLogger("agent-service")
 	log.Info("启动Agent服务")
 
-	// 启动WebSocket客户端
-	if s.wsClient != nil {
-		if err := s.wsClient.Start(); err != nil {
-			log.WithError(err).Error("启动WebSocket客户端失败")
-		}
+	if err := s.components.Init(s.ctx); err != nil {
+		return fmt.Errorf("初始化组件失败: %w", err)
+	}
+	if err := s.components.Start(s.ctx); err != nil {
+		return fmt.Errorf("启动组件失败: %w", err)
+	}
+
+	// ResyncInterval>0时，周期性把最近一次下发的期望状态重新对账一遍，重试上次
+	// 对账失败的代理（已生效且配置未变的代理会被跳过）
+	if interval := s.config.Proxy.Monitor.ResyncInterval; interval > 0 {
+		s.proxyManager.Resync(s.ctx, time.Duration(interval)*time.Second)
 	}
 
 	s.running = true
@@ -120,6 +238,112 @@ func (s *Service) Start() error {
 	return nil
 }
 
+// RegisterComponent 向Service的组件Registry注册一个额外的子系统，必须在
+// Start之前调用。deps中的名字须引用已注册组件（内置的proxy/iptables/
+// websocket/registry/geoip，或此前注册的其他外部组件）
+func (s *Service) RegisterComponent(c component.Component, deps ...string) error {
+	return s.components.RegisterComponent(c, deps...)
+}
+
+// startRegistryOrPolling 配置了注册中心后端时向其自注册并监听配置下发，
+// 取代基于UpdateInterval的HTTP轮询；未配置（registry.New返回noopRegistry）
+// 时退回既有的reportStatus定时上报路径
+func (s *Service) startRegistryOrPolling(log *logrus.Entry) {
+	if s.config.Registry.Backend == "" || s.config.Registry.Backend == "none" {
+		s.wg.Add(1)
+		go s.statusReportLoop()
+		return
+	}
+
+	ipv4, ipv6, err := getNetworkAddresses()
+	if err != nil {
+		log.WithError(err).Warn("获取网络地址失败，仍继续自注册")
+	}
+
+	info := registry.AgentInfo{
+		ServerID: s.serverID,
+		IPv4:     ipv4,
+		IPv6:     ipv6,
+	}
+
+	if err := s.registry.Start(s.ctx, info); err != nil {
+		log.WithError(err).Error("注册中心自注册失败，退回HTTP轮询上报")
+		s.wg.Add(1)
+		go s.statusReportLoop()
+		return
+	}
+
+	watch := func(ctx context.Context) {
+		if err := s.registry.WatchConfig(ctx, s.onConfigPushed); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warn("监听配置下发失败")
+		}
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		if !s.config.Registry.ElectionEnable {
+			watch(s.ctx)
+			return
+		}
+
+		// 开启了ElectionEnable时，只有选举获胜的副本才watch+apply配置，
+		// 避免HA部署下多个副本读到同一条推送各自并发应用一遍
+		for s.ctx.Err() == nil {
+			if err := s.registry.Campaign(s.ctx, watch); err != nil && s.ctx.Err() == nil {
+				log.WithError(err).Warn("leader选举失败，稍后重试")
+				time.Sleep(5 * time.Second)
+			}
+		}
+	}()
+}
+
+// onConfigPushed是WatchConfig的回调：把注册中心推送的原始字节解码为
+// api.ServerConfigData并直接交给syncConfig使用的pushedConfig，跳过再发一次
+// HTTP GetServerConfig的往返；解码失败时退化为只触发ConfigChanged协调事件，
+// 由syncConfig走既有的HTTP拉取路径兜底
+func (s *Service) onConfigPushed(data []byte) {
+	log := logger.GetComponentLogger("agent-service")
+
+	var cfg api.ServerConfigData
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.WithError(err).Warn("解码推送的配置失败，回退到HTTP拉取")
+		s.reconciler.Enqueue(EventConfigChanged)
+		return
+	}
+
+	log.Info("收到注册中心推送的配置，直接投递到配置协调流水线")
+
+	s.mu.Lock()
+	s.pushedConfig = &cfg
+	s.mu.Unlock()
+
+	s.reconciler.Enqueue(EventConfigChanged)
+}
+
+// statusReportLoop 按UpdateInterval周期性调用reportStatus，是未配置注册中心
+// 时的既有HTTP上报路径
+func (s *Service) statusReportLoop() {
+	defer s.wg.Done()
+
+	interval := time.Duration(s.config.UpdateInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log := logger.GetComponentLogger("agent-service")
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reportStatus(); err != nil {
+				log.WithError(err).Warn("上报状态失败")
+			}
+		}
+	}
+}
+
 // Stop 停止Agent服务
 func (s *Service) Stop() error {
 	s.mu.Lock()
@@ -132,22 +356,18 @@ func (s *Service) Stop() error {
 	log := logger.GetComponentLogger("agent-service")
 	log.Info("停止Agent服务")
 
-	// 停止WebSocket客户端
-	if s.wsClient != nil {
-		if err := s.wsClient.Stop(); err != nil {
-			log.WithError(err).Error("停止WebSocket客户端失败")
-		}
-	}
-
-	// 取消上下文
+	// 取消上下文，通知各组件内部的goroutine退出
 	s.cancel()
 
 	// 等待所有goroutine完成
 	s.wg.Wait()
 
-	// 停止proxy监控器
-	if err := s.proxyManager.StopMonitor(); err != nil {
-		logger.LogError(err, "停止proxy监控器失败", nil)
+	if err := s.components.Stop(s.ctx); err != nil {
+		logger.LogError(err, "停止组件失败", nil)
+	}
+
+	if err := s.apiClient.Close(); err != nil {
+		logger.LogError(err, "关闭API客户端失败", nil)
 	}
 
 	s.running = false
@@ -173,12 +393,111 @@ func (s *Service) updateIPTablesRulesFromProto() error {
 	return s.iptablesManager.UpdateRulesFromProto(iptablesConfigs)
 }
 
+// syncIptables 是EventIptablesChanged的syncHandler，复用既有的proto拉取+
+// UpdateRulesFromProto路径，本身已经是按配置ID做全量diff应用，满足Reconciler
+// 对syncHandler幂等、可重复调用的要求
+func (s *Service) syncIptables() error {
+	return s.updateIPTablesRulesFromProto()
+}
+
+// syncProxies 是EventProxyChanged的syncHandler。当前egress配置只通过
+// WebSocket推送的model.EgressItem全量下发、由wsClient直接调用
+// proxyManager.UpdateProxies应用（参见SetSyncHook），HTTP轮询路径拿到的
+// api.ServerConfigData.Egress是旧版非proto格式，还没有到model.EgressItem的
+// 转换器，这里先不做实际应用，避免凭空拼出一个不完整的转换
+func (s *Service) syncProxies() error {
+	logger.GetComponentLogger("agent-reconciler").Debug("proxy配置协调：HTTP轮询路径暂无egress转换器，等待WebSocket推送覆盖")
+	return nil
+}
+
+// syncRoutes 是EventRoutesChanged的syncHandler。仓库里目前没有独立的路由
+// 管理子系统来消费api.ServerConfigData.Routes，这里先占位记录，等对应的
+// pkg/routes之类的子系统落地后再接入真正的diff-apply
+func (s *Service) syncRoutes() error {
+	logger.GetComponentLogger("agent-reconciler").Debug("routes配置协调：尚无路由管理子系统，暂不做动作")
+	return nil
+}
+
+// syncConfig 是EventConfigChanged的syncHandler：优先消费onConfigPushed经
+// pushedConfig投递的配置（注册中心watch直接推送，省掉一次HTTP往返），
+// 没有待消费的推送时才退回apiClient.GetServerConfig的HTTP拉取；随后和上次
+// 已应用的配置哈希比较，只有发生变化时才扇出到proxy/routes/iptables各自的
+// 协调事件，避免每次触发都重新全量应用
+func (s *Service) syncConfig() error {
+	s.mu.Lock()
+	cfg := s.pushedConfig
+	s.pushedConfig = nil
+	s.mu.Unlock()
+
+	if cfg == nil {
+		var err error
+		cfg, err = s.apiClient.GetServerConfig(s.serverID)
+		if err != nil {
+			return fmt.Errorf("获取服务器配置失败: %w", err)
+		}
+	}
+
+	hash := s.calculateConfigHash(cfg)
+
+	s.mu.Lock()
+	changed := hash != s.lastConfigHash
+	s.lastConfigHash = hash
+	s.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	logger.GetComponentLogger("agent-reconciler").WithField("config_hash", hash).Info("服务器配置发生变化，扇出子系统协调事件")
+
+	s.reconciler.Enqueue(EventProxyChanged)
+	s.reconciler.Enqueue(EventRoutesChanged)
+	s.reconciler.Enqueue(EventIptablesChanged)
+
+	return nil
+}
+
+// forceReconcile 是admin API的Reconcile方法的实现：入队EventConfigChanged，
+// 让下一轮syncConfig重新拉取服务器配置并按需扇出到proxy/routes/iptables，
+// 等价于`nspass-agent reload`的落地效果
+func (s *Service) forceReconcile() error {
+	logger.GetComponentLogger("agent-admin").Info("收到admin API的强制协调请求")
+	s.reconciler.Enqueue(EventConfigChanged)
+	return nil
+}
+
+// getProxyPID 返回指定代理当前持有的PID，供admin API的GetProxyPID方法使用，
+// `nspass-agent trace <component>`靠它把组件名解析成可以ptrace附加的pid
+func (s *Service) getProxyPID(name string) (int, bool) {
+	pid, ok := s.proxyManager.ProxyPids()[name]
+	return pid, ok
+}
+
+// buildAdminStatus 组装admin API的GetStatus响应：组件健康状态、协调器队列
+// 状态和代理状态汇总，供`nspass-agent status`展示
+func (s *Service) buildAdminStatus() interface{} {
+	s.mu.RLock()
+	running := s.running
+	lastConfigHash := s.lastConfigHash
+	s.mu.RUnlock()
+
+	return map[string]interface{}{
+		"server_id":        s.serverID,
+		"running":          running,
+		"last_config_hash": lastConfigHash,
+		"components":       s.components.Status(),
+		"reconciler":       s.reconciler.GetStatus(),
+		"proxy":            s.proxyManager.GetStatus(),
+		"proxy_monitor":    s.proxyManager.GetMonitorSnapshot(),
+	}
+}
+
 // reportStatus 上报状态
 func (s *Service) reportStatus() error {
 	log := logger.GetComponentLogger("agent-service")
 
 	// 获取网络地址
-	ipv4, ipv6, err := s.getNetworkAddresses()
+	ipv4, ipv6, err := getNetworkAddresses()
 	if err != nil {
 		logger.LogError(err, "获取网络地址失败", nil)
 	}
@@ -209,6 +528,8 @@ func (s *Service) reportStatus() error {
 		ReportTime:  time.Now(),
 	}
 
+	s.enrichWithGeoIP(&statusReport)
+
 	// 发送状态报告
 	configUpdate, err := s.apiClient.ReportAgentStatus(statusReport)
 	if err != nil {
@@ -220,15 +541,45 @@ func (s *Service) reportStatus() error {
 		log.WithFields(logrus.Fields{
 			"config_version": configUpdate.ConfigVersion,
 			"update_message": configUpdate.UpdateMessage,
-		}).Info("检测到服务器配置更新，将在下次循环中获取新配置")
+		}).Info("检测到服务器配置更新，入队ConfigChanged协调事件")
 
-		// 清除配置hash以强制在下次循环中更新
-		s.lastConfigHash = ""
+		s.reconciler.Enqueue(EventConfigChanged)
 	}
 
 	return nil
 }
 
+// enrichWithGeoIP 用pkg/geoip解析report中的IP地址，填充地理位置字段。未配置
+// geoip或解析失败时静默跳过，不影响report本身的上报
+func (s *Service) enrichWithGeoIP(report *api.AgentStatusReport) {
+	if s.geoResolver == nil {
+		return
+	}
+
+	ip := report.IPv4Address
+	if ip == "" {
+		ip = report.IPv6Address
+	}
+	if ip == "" {
+		return
+	}
+
+	location, err := s.geoResolver.Lookup(ip)
+	if err != nil {
+		logger.GetComponentLogger("agent-service").WithError(err).Debug("geoip查询失败，跳过地理位置字段")
+		return
+	}
+
+	report.Continent = location.Continent
+	report.Country = location.Country
+	report.Province = location.Province
+	report.City = location.City
+	report.ISP = location.ISP
+	report.Latitude = location.Latitude
+	report.Longitude = location.Longitude
+	report.TimeZone = location.TimeZone
+}
+
 // getPublicIP 通过API获取真实的外网IP地址
 func getPublicIP() (string, error) {
 	// 定义多个IP查询API，按优先级排序
@@ -290,8 +641,9 @@ func queryIPAPI(client *http.Client, apiURL string) (string, error) {
 	return ip, nil
 }
 
-// getNetworkAddresses 获取网络地址
-func (s *Service) getNetworkAddresses() (ipv4, ipv6 string, err error) {
+// getNetworkAddresses 获取网络地址。不依赖Service的任何状态，Service.reportStatus
+// 和roles.go里的轻量角色都直接调用这个包级函数，不需要构建完整Service
+func getNetworkAddresses() (ipv4, ipv6 string, err error) {
 	log := logger.GetComponentLogger("agent-service")
 
 	// 首先尝试通过API获取真实的外网IPv4地址
@@ -461,6 +813,9 @@ func (s *Service) GetStatus() map[string]interface{} {
 		"last_config_hash": s.lastConfigHash,
 		"proxy_status":     s.proxyManager.GetStatus(),
 		"iptables_status":  s.iptablesManager.GetRulesSummary(),
+		"ipvs_status":      s.ipvsManager.GetServicesSummary(),
+		"reconciler":       s.reconciler.GetStatus(),
+		"components":       s.components.Status(),
 		"memory_usage":     m.Alloc / 1024 / 1024, // MB
 		"goroutines":       runtime.NumGoroutine(),
 	}
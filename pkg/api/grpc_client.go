@@ -0,0 +1,372 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nspass/nspass-agent/generated/agent"
+	"github.com/nspass/nspass-agent/generated/model"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcRetryServiceConfig是grpc-go的service config模板，按config.APIConfig.GRPC.MaxRetries
+// 填充重试次数，让连接层而不是调用方来处理瞬时失败的重试，取代REST传输里手写的重试循环
+const grpcRetryServiceConfig = `{
+	"methodConfig": [{
+		"name": [{"service": "nspass.agent.v1.AgentService"}],
+		"retryPolicy": {
+			"maxAttempts": %d,
+			"initialBackoff": "0.5s",
+			"maxBackoff": "5s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE", "DEADLINE_EXCEEDED"]
+		}
+	}]
+}`
+
+// grpcTransport是transport的gRPC/protobuf实现，基于一条持久连接而非REST每次
+// 请求都走独立的HTTP连接，鉴权信息通过gRPC metadata而非HTTP Header传递
+type grpcTransport struct {
+	config   config.APIConfig
+	serverID string
+	conn     *grpc.ClientConn
+	client   agent.AgentServiceClient
+}
+
+// newGRPCTransport 创建新的gRPC传输，建立持久连接
+func newGRPCTransport(cfg config.APIConfig, serverID string) (*grpcTransport, error) {
+	kaTime := time.Duration(cfg.GRPC.KeepaliveTime) * time.Second
+	kaTimeout := time.Duration(cfg.GRPC.KeepaliveTimeout) * time.Second
+
+	conn, err := grpc.NewClient(
+		cfg.BaseURL,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                kaTime,
+			Timeout:             kaTimeout,
+			PermitWithoutStream: true,
+		}),
+		// maxAttempts包含首次调用本身，所以是MaxRetries+1
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(grpcRetryServiceConfig, cfg.GRPC.MaxRetries+1)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("建立gRPC连接失败: %w", err)
+	}
+
+	return &grpcTransport{
+		config:   cfg,
+		serverID: serverID,
+		conn:     conn,
+		client:   agent.NewAgentServiceClient(conn),
+	}, nil
+}
+
+// outgoingContext 把鉴权信息以gRPC metadata的形式附加到ctx上，对应REST传输
+// setAuthHeaders设置的Server-ID/Server-Token Header
+func (c *grpcTransport) outgoingContext(ctx context.Context) context.Context {
+	md := metadata.Pairs(
+		"server-id", c.serverID,
+		"server-token", c.config.Token,
+	)
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// callTimeout 返回单次RPC调用的超时时间，复用REST传输的Timeout配置项
+func (c *grpcTransport) callTimeout() time.Duration {
+	return time.Duration(c.config.Timeout) * time.Second
+}
+
+// GetServerConfig 获取服务器配置
+func (c *grpcTransport) GetServerConfig(serverID string) (*ServerConfigData, error) {
+	startTime := time.Now()
+	log := logger.GetAPILogger()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout())
+	defer cancel()
+	ctx = c.outgoingContext(ctx)
+
+	resp, err := c.client.GetServerConfig(ctx, &agent.GetServerConfigRequest{ServerId: serverID})
+	if err != nil {
+		logger.LogError(err, "gRPC获取服务器配置失败", logrus.Fields{
+			"server_id": serverID,
+		})
+		return nil, fmt.Errorf("gRPC获取服务器配置失败: %w", err)
+	}
+
+	if !resp.Success {
+		err := fmt.Errorf("API返回错误: %s", resp.ErrorMessage)
+		logger.LogError(err, "获取服务器配置API返回错误", logrus.Fields{
+			"server_id": serverID,
+		})
+		return nil, err
+	}
+
+	data := convertServerConfigDataFromProto(resp.Data)
+
+	duration := time.Since(startTime)
+	logger.LogPerformance("api_get_server_config", duration, logrus.Fields{
+		"server_id":     serverID,
+		"routes_count":  len(data.Routes),
+		"egress_count":  len(data.Egress),
+		"forward_rules": len(data.ForwardRules),
+		"transport":     "grpc",
+	})
+
+	log.WithFields(logrus.Fields{
+		"server_id":   serverID,
+		"server_name": data.ServerName,
+		"duration_ms": duration.Milliseconds(),
+	}).Info("成功获取服务器配置(gRPC)")
+
+	return data, nil
+}
+
+// ReportAgentStatus 上报Agent状态。每次调用开启一条独立的客户端流并在发送完
+// 这一次状态后立即CloseAndRecv，这样既满足.proto里client-streaming的定义，
+// 又不需要在Client外部维护一条贯穿整个Agent生命周期的长流
+func (c *grpcTransport) ReportAgentStatus(status AgentStatusReport) (*ServerConfigUpdateInfo, error) {
+	startTime := time.Now()
+	log := logger.GetAPILogger()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout())
+	defer cancel()
+	ctx = c.outgoingContext(ctx)
+
+	stream, err := c.client.ReportAgentStatus(ctx)
+	if err != nil {
+		logger.LogError(err, "创建Agent状态上报流失败", logrus.Fields{
+			"server_id": status.ServerID,
+		})
+		return nil, fmt.Errorf("创建Agent状态上报流失败: %w", err)
+	}
+
+	if err := stream.Send(convertAgentStatusReportToProto(status)); err != nil {
+		logger.LogError(err, "发送Agent状态失败", logrus.Fields{
+			"server_id": status.ServerID,
+		})
+		return nil, fmt.Errorf("发送Agent状态失败: %w", err)
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		logger.LogError(err, "接收Agent状态上报响应失败", logrus.Fields{
+			"server_id": status.ServerID,
+		})
+		return nil, fmt.Errorf("接收Agent状态上报响应失败: %w", err)
+	}
+
+	if !resp.Success {
+		err := fmt.Errorf("API返回错误: %s", resp.ErrorMessage)
+		logger.LogError(err, "上报Agent状态API返回错误", logrus.Fields{
+			"server_id": status.ServerID,
+		})
+		return nil, err
+	}
+
+	var update *ServerConfigUpdateInfo
+	if resp.ConfigUpdate != nil {
+		update = &ServerConfigUpdateInfo{
+			HasUpdate:     resp.ConfigUpdate.HasUpdate,
+			ConfigVersion: resp.ConfigUpdate.ConfigVersion,
+			UpdateMessage: resp.ConfigUpdate.UpdateMessage,
+			UpdateTime:    resp.ConfigUpdate.UpdateTime.AsTime(),
+		}
+	}
+
+	duration := time.Since(startTime)
+	logger.LogPerformance("api_report_agent_status", duration, logrus.Fields{
+		"server_id": status.ServerID,
+		"transport": "grpc",
+	})
+
+	log.WithFields(logrus.Fields{
+		"server_id":      status.ServerID,
+		"acknowledgment": resp.Acknowledgment,
+		"has_update":     update != nil && update.HasUpdate,
+		"duration_ms":    duration.Milliseconds(),
+	}).Info("成功上报Agent状态(gRPC)")
+
+	return update, nil
+}
+
+// GetServerIptablesConfigsProto 获取服务器的iptables配置（proto格式）
+func (c *grpcTransport) GetServerIptablesConfigsProto(serverID string) ([]*model.IptablesConfig, error) {
+	startTime := time.Now()
+	log := logger.GetAPILogger()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout())
+	defer cancel()
+	ctx = c.outgoingContext(ctx)
+
+	resp, err := c.client.GetServerIptablesConfigs(ctx, &agent.GetServerIptablesConfigsRequest{ServerId: serverID})
+	if err != nil {
+		logger.LogError(err, "gRPC获取iptables配置失败", logrus.Fields{
+			"server_id": serverID,
+		})
+		return nil, fmt.Errorf("gRPC获取iptables配置失败: %w", err)
+	}
+
+	if !resp.Success {
+		err := fmt.Errorf("API返回错误: %s", resp.ErrorMessage)
+		logger.LogError(err, "获取iptables配置API返回错误", logrus.Fields{
+			"server_id": serverID,
+		})
+		return nil, err
+	}
+
+	configs := make([]*model.IptablesConfig, 0, len(resp.Data))
+	for _, raw := range resp.Data {
+		cfg := &model.IptablesConfig{}
+		if err := proto.Unmarshal(raw, cfg); err != nil {
+			logger.LogError(err, "反序列化iptables配置失败", logrus.Fields{
+				"server_id": serverID,
+			})
+			return nil, fmt.Errorf("反序列化iptables配置失败: %w", err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	duration := time.Since(startTime)
+	log.WithFields(logrus.Fields{
+		"server_id":     serverID,
+		"configs_count": len(configs),
+		"duration_ms":   duration.Milliseconds(),
+	}).Info("成功获取iptables配置(gRPC)")
+
+	return configs, nil
+}
+
+// Close 关闭底层的gRPC连接
+func (c *grpcTransport) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// convertServerConfigDataFromProto 把gRPC响应里的ServerConfigData转换成
+// pkg/api既有的Go DTO，供REST/gRPC两种传输共用同一套下游类型
+func convertServerConfigDataFromProto(data *agent.ServerConfigData) *ServerConfigData {
+	if data == nil {
+		return &ServerConfigData{}
+	}
+
+	routes := make([]RouteConfig, 0, len(data.Routes))
+	for _, r := range data.Routes {
+		routes = append(routes, RouteConfig{
+			ID:          r.Id,
+			RouteID:     r.RouteId,
+			RouteName:   r.RouteName,
+			EntryPoint:  r.EntryPoint,
+			Port:        r.Port,
+			Protocol:    r.Protocol,
+			Type:        r.Type,
+			Status:      r.Status,
+			ServerID:    r.ServerId,
+			Description: r.Description,
+			Metadata:    r.Metadata,
+			CreatedAt:   r.CreatedAt.AsTime(),
+			UpdatedAt:   r.UpdatedAt.AsTime(),
+		})
+	}
+
+	egress := make([]EgressConfig, 0, len(data.Egress))
+	for _, e := range data.Egress {
+		egress = append(egress, EgressConfig{
+			ID:            e.Id,
+			EgressID:      e.EgressId,
+			ServerID:      e.ServerId,
+			EgressMode:    e.EgressMode,
+			EgressConfig:  e.EgressConfig,
+			TargetAddress: e.TargetAddress,
+			ForwardType:   e.ForwardType,
+			DestAddress:   e.DestAddress,
+			DestPort:      e.DestPort,
+			Password:      e.Password,
+			SupportUDP:    e.SupportUdp,
+		})
+	}
+
+	forwardRules := make([]ForwardRuleConfig, 0, len(data.ForwardRules))
+	for _, f := range data.ForwardRules {
+		forwardRules = append(forwardRules, ForwardRuleConfig{
+			ID:             f.Id,
+			UserID:         f.UserId,
+			Name:           f.Name,
+			ServerID:       f.ServerId,
+			EgressMode:     f.EgressMode,
+			ForwardType:    f.ForwardType,
+			SourcePort:     f.SourcePort,
+			TargetAddress:  f.TargetAddress,
+			TargetPort:     f.TargetPort,
+			Password:       f.Password,
+			SupportUDP:     f.SupportUdp,
+			Status:         f.Status,
+			TrafficUp:      f.TrafficUp,
+			TrafficDown:    f.TrafficDown,
+			LastActiveTime: f.LastActiveTime,
+			CreatedAt:      f.CreatedAt,
+			UpdatedAt:      f.UpdatedAt,
+		})
+	}
+
+	return &ServerConfigData{
+		ServerID:     data.ServerId,
+		ServerName:   data.ServerName,
+		Routes:       routes,
+		Egress:       egress,
+		ForwardRules: forwardRules,
+		Metadata:     data.Metadata,
+		LastUpdated:  data.LastUpdated.AsTime(),
+	}
+}
+
+// convertAgentStatusReportToProto 把pkg/api既有的AgentStatusReport转换成gRPC
+// 请求消息
+func convertAgentStatusReportToProto(status AgentStatusReport) *agent.ReportAgentStatusRequest {
+	services := make([]*agent.ProxyServiceStatus, 0, len(status.Activity.ProxyServices))
+	for _, s := range status.Activity.ProxyServices {
+		services = append(services, &agent.ProxyServiceStatus{
+			ServiceName:     s.ServiceName,
+			ServiceStatus:   s.ServiceStatus,
+			Port:            int32(s.Port),
+			ConnectionCount: int32(s.ConnectionCount),
+			ErrorMessage:    s.ErrorMessage,
+			LastCheck:       timestamppb.New(s.LastCheck),
+		})
+	}
+
+	return &agent.ReportAgentStatusRequest{
+		ServerId:    status.ServerID,
+		Ipv4Address: status.IPv4Address,
+		Ipv6Address: status.IPv6Address,
+		Activity: &agent.AgentActivity{
+			ActiveConnections:  int32(status.Activity.ActiveConnections),
+			TotalBytesSent:     status.Activity.TotalBytesSent,
+			TotalBytesReceived: status.Activity.TotalBytesReceived,
+			ProxyServices:      services,
+			LastActivity:       timestamppb.New(status.Activity.LastActivity),
+			CpuUsage:           float32(status.Activity.CPUUsage),
+			MemoryUsage:        float32(status.Activity.MemoryUsage),
+			DiskUsage:          float32(status.Activity.DiskUsage),
+		},
+		ReportTime: timestamppb.New(status.ReportTime),
+		Continent:  status.Continent,
+		Country:    status.Country,
+		Province:   status.Province,
+		City:       status.City,
+		Isp:        status.ISP,
+		Latitude:   status.Latitude,
+		Longitude:  status.Longitude,
+		TimeZone:   status.TimeZone,
+	}
+}
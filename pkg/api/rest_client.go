@@ -0,0 +1,414 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nspass/nspass-agent/generated/model"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// restTransport是transport的HTTP+JSON实现，沿用API还是JSON-over-REST之前的
+// 既有行为，是config.APIConfig.Transport留空或显式设为"rest"时使用的默认传输
+type restTransport struct {
+	config     config.APIConfig
+	serverID   string
+	httpClient *http.Client
+}
+
+// newRESTTransport 创建新的REST传输
+func newRESTTransport(cfg config.APIConfig, serverID string) *restTransport {
+	return &restTransport{
+		config:   cfg,
+		serverID: serverID,
+		httpClient: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+		},
+	}
+}
+
+// setAuthHeaders 设置鉴权Headers
+func (c *restTransport) setAuthHeaders(req *http.Request) {
+	req.Header.Set("Server-ID", c.serverID)
+	req.Header.Set("Server-Token", c.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+}
+
+// GetServerConfig 获取服务器配置
+func (c *restTransport) GetServerConfig(serverID string) (result *ServerConfigData, err error) {
+	startTime := time.Now()
+	log := logger.GetAPILogger()
+
+	url := fmt.Sprintf("%s/v1/agent/config/%s", c.config.BaseURL, serverID)
+	ctx, span := startAPISpan("api.GetServerConfig", http.MethodGet, url, serverID)
+	defer func() { endSpan(span, err) }()
+
+	log.WithFields(logrus.Fields{
+		"url":       url,
+		"server_id": serverID,
+	}).Debug("开始获取服务器配置")
+
+	req, reqErr := http.NewRequest("GET", url, nil)
+	if reqErr != nil {
+		err = fmt.Errorf("创建请求失败: %w", reqErr)
+		logger.LogError(err, "创建API请求失败", logrus.Fields{
+			"url":       url,
+			"server_id": serverID,
+		})
+		return nil, err
+	}
+
+	c.setAuthHeaders(req)
+	injectTraceContext(ctx, req)
+
+	var resp *http.Response
+	var lastErr error
+
+	// 重试机制，每次尝试是span的一个子span，方便在trace里单独看每次尝试的耗时
+	for i := 0; i < c.config.RetryCount; i++ {
+		_, attemptSpan := startRetrySpan(ctx, i+1)
+		attemptStart := time.Now()
+		resp, lastErr = c.httpClient.Do(req)
+		attemptDuration := time.Since(attemptStart)
+		endSpan(attemptSpan, lastErr)
+
+		if lastErr == nil && resp.StatusCode == http.StatusOK {
+			log.WithFields(logrus.Fields{
+				"attempt":     i + 1,
+				"duration_ms": attemptDuration.Milliseconds(),
+			}).Debug("获取服务器配置成功")
+			break
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if i < c.config.RetryCount-1 {
+			retryDelay := time.Duration(c.config.RetryDelay) * time.Second
+			log.WithFields(logrus.Fields{
+				"attempt":      i + 1,
+				"max_attempts": c.config.RetryCount,
+				"error":        lastErr,
+				"retry_delay":  retryDelay,
+			}).Warn("获取服务器配置失败，准备重试")
+			time.Sleep(retryDelay)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("retry.count", c.config.RetryCount))
+
+	if lastErr != nil {
+		err = fmt.Errorf("获取服务器配置失败: %w", lastErr)
+		logger.LogError(err, "获取服务器配置最终失败", mergeFields(traceLogFields(span), logrus.Fields{
+			"url":            url,
+			"server_id":      serverID,
+			"retry_count":    c.config.RetryCount,
+			"total_duration": time.Since(startTime).Milliseconds(),
+		}))
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("API返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		logger.LogError(err, "API响应错误", logrus.Fields{
+			"status_code": resp.StatusCode,
+			"response":    string(body),
+			"url":         url,
+			"server_id":   serverID,
+		})
+		return nil, err
+	}
+
+	// 解析响应
+	type GetServerConfigResponse struct {
+		Status struct {
+			Success   bool   `json:"success"`
+			Message   string `json:"message,omitempty"`
+			ErrorCode string `json:"error_code,omitempty"`
+		} `json:"status"`
+		Data *ServerConfigData `json:"data,omitempty"`
+	}
+
+	var response GetServerConfigResponse
+	if decodeErr := json.NewDecoder(resp.Body).Decode(&response); decodeErr != nil {
+		err = fmt.Errorf("解析API响应失败: %w", decodeErr)
+		logger.LogError(err, "解析API响应失败", logrus.Fields{
+			"url":       url,
+			"server_id": serverID,
+		})
+		return nil, err
+	}
+
+	if !response.Status.Success {
+		err = fmt.Errorf("API返回错误: %s", response.Status.Message)
+		logger.LogError(err, "获取服务器配置API返回错误", logrus.Fields{
+			"server_id":     serverID,
+			"error_code":    response.Status.ErrorCode,
+			"error_message": response.Status.Message,
+		})
+		return nil, err
+	}
+
+	if response.Data == nil {
+		err = fmt.Errorf("服务器配置数据为空")
+		logger.LogError(err, "服务器配置数据为空", logrus.Fields{
+			"server_id": serverID,
+		})
+		return nil, err
+	}
+
+	duration := time.Since(startTime)
+
+	// 记录性能指标
+	logger.LogPerformance("api_get_server_config", duration, logrus.Fields{
+		"server_id":     serverID,
+		"routes_count":  len(response.Data.Routes),
+		"egress_count":  len(response.Data.Egress),
+		"forward_rules": len(response.Data.ForwardRules),
+	})
+
+	log.WithFields(mergeFields(traceLogFields(span), logrus.Fields{
+		"server_id":     serverID,
+		"server_name":   response.Data.ServerName,
+		"routes_count":  len(response.Data.Routes),
+		"egress_count":  len(response.Data.Egress),
+		"forward_rules": len(response.Data.ForwardRules),
+		"last_updated":  response.Data.LastUpdated,
+		"duration_ms":   duration.Milliseconds(),
+	})).Info("成功获取服务器配置")
+
+	return response.Data, nil
+}
+
+// ReportAgentStatus 上报Agent状态
+func (c *restTransport) ReportAgentStatus(status AgentStatusReport) (result *ServerConfigUpdateInfo, err error) {
+	startTime := time.Now()
+	log := logger.GetAPILogger()
+
+	url := fmt.Sprintf("%s/v1/agent/status", c.config.BaseURL)
+	ctx, span := startAPISpan("api.ReportAgentStatus", http.MethodPost, url, status.ServerID)
+	defer func() { endSpan(span, err) }()
+
+	log.WithFields(logrus.Fields{
+		"url":                url,
+		"server_id":          status.ServerID,
+		"active_connections": status.Activity.ActiveConnections,
+		"proxy_services":     len(status.Activity.ProxyServices),
+	}).Debug("开始上报Agent状态")
+
+	data, marshalErr := json.Marshal(status)
+	if marshalErr != nil {
+		err = fmt.Errorf("序列化状态数据失败: %w", marshalErr)
+		logger.LogError(err, "序列化状态数据失败", logrus.Fields{
+			"server_id": status.ServerID,
+		})
+		return nil, err
+	}
+
+	req, reqErr := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	if reqErr != nil {
+		err = fmt.Errorf("创建请求失败: %w", reqErr)
+		logger.LogError(err, "创建状态报告请求失败", logrus.Fields{
+			"url":       url,
+			"server_id": status.ServerID,
+		})
+		return nil, err
+	}
+
+	c.setAuthHeaders(req)
+	injectTraceContext(ctx, req)
+
+	resp, doErr := c.httpClient.Do(req)
+	if doErr != nil {
+		err = fmt.Errorf("发送状态报告失败: %w", doErr)
+		logger.LogError(err, "发送状态报告失败", logrus.Fields{
+			"url":       url,
+			"server_id": status.ServerID,
+		})
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("状态报告失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		logger.LogError(err, "状态报告响应错误", logrus.Fields{
+			"status_code": resp.StatusCode,
+			"response":    string(body),
+			"url":         url,
+			"server_id":   status.ServerID,
+		})
+		return nil, err
+	}
+
+	// 解析响应
+	type ReportAgentStatusResponse struct {
+		Status struct {
+			Success   bool   `json:"success"`
+			Message   string `json:"message,omitempty"`
+			ErrorCode string `json:"error_code,omitempty"`
+		} `json:"status"`
+		Acknowledgment string                  `json:"acknowledgment,omitempty"`
+		ConfigUpdate   *ServerConfigUpdateInfo `json:"config_update,omitempty"`
+	}
+
+	var response ReportAgentStatusResponse
+	if decodeErr := json.NewDecoder(resp.Body).Decode(&response); decodeErr != nil {
+		err = fmt.Errorf("解析响应失败: %w", decodeErr)
+		logger.LogError(err, "解析状态报告响应失败", logrus.Fields{
+			"url":       url,
+			"server_id": status.ServerID,
+		})
+		return nil, err
+	}
+
+	if !response.Status.Success {
+		err = fmt.Errorf("API返回错误: %s", response.Status.Message)
+		logger.LogError(err, "上报Agent状态API返回错误", logrus.Fields{
+			"server_id":     status.ServerID,
+			"error_code":    response.Status.ErrorCode,
+			"error_message": response.Status.Message,
+		})
+		return nil, err
+	}
+
+	duration := time.Since(startTime)
+
+	// 记录性能指标
+	logger.LogPerformance("api_report_agent_status", duration, logrus.Fields{
+		"server_id":          status.ServerID,
+		"active_connections": status.Activity.ActiveConnections,
+		"proxy_services":     len(status.Activity.ProxyServices),
+	})
+
+	log.WithFields(mergeFields(traceLogFields(span), logrus.Fields{
+		"server_id":      status.ServerID,
+		"acknowledgment": response.Acknowledgment,
+		"has_update":     response.ConfigUpdate != nil && response.ConfigUpdate.HasUpdate,
+		"duration_ms":    duration.Milliseconds(),
+	})).Info("成功上报Agent状态")
+
+	return response.ConfigUpdate, nil
+}
+
+// GetServerIptablesConfigsProto 获取服务器的iptables配置（proto格式）
+func (c *restTransport) GetServerIptablesConfigsProto(serverID string) (result []*model.IptablesConfig, err error) {
+	startTime := time.Now()
+	log := logger.GetAPILogger()
+
+	url := fmt.Sprintf("%s/v1/servers/%s/iptables/configs", c.config.BaseURL, serverID)
+	ctx, span := startAPISpan("api.GetServerIptablesConfigsProto", http.MethodGet, url, serverID)
+	defer func() { endSpan(span, err) }()
+
+	log.WithFields(logrus.Fields{
+		"url":       url,
+		"server_id": serverID,
+	}).Debug("开始获取服务器iptables配置(proto)")
+
+	req, reqErr := http.NewRequest("GET", url, nil)
+	if reqErr != nil {
+		err = fmt.Errorf("创建请求失败: %w", reqErr)
+		logger.LogError(err, "创建iptables配置请求失败", logrus.Fields{
+			"url":       url,
+			"server_id": serverID,
+		})
+		return nil, err
+	}
+
+	c.setAuthHeaders(req)
+	injectTraceContext(ctx, req)
+
+	var resp *http.Response
+	var lastErr error
+
+	// 重试机制，每次尝试是span的一个子span
+	for i := 0; i < c.config.RetryCount; i++ {
+		_, attemptSpan := startRetrySpan(ctx, i+1)
+		resp, lastErr = c.httpClient.Do(req)
+		endSpan(attemptSpan, lastErr)
+		if lastErr == nil && resp.StatusCode < 500 {
+			break
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if i < c.config.RetryCount-1 {
+			retryDelay := time.Duration(c.config.RetryDelay) * time.Second
+			log.WithFields(logrus.Fields{
+				"retry":     i + 1,
+				"max_retry": c.config.RetryCount,
+				"error":     lastErr,
+				"delay":     retryDelay,
+			}).Debug("iptables配置请求失败，准备重试")
+			time.Sleep(retryDelay)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("retry.count", c.config.RetryCount))
+
+	if lastErr != nil {
+		err = fmt.Errorf("iptables配置请求失败: %w", lastErr)
+		logger.LogError(err, "iptables配置请求最终失败", logrus.Fields{
+			"url":         url,
+			"server_id":   serverID,
+			"retry_count": c.config.RetryCount,
+		})
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("iptables配置API返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		logger.LogError(err, "iptables配置API响应错误", logrus.Fields{
+			"status_code": resp.StatusCode,
+			"response":    string(body),
+			"url":         url,
+			"server_id":   serverID,
+		})
+		return nil, err
+	}
+
+	// API返回proto格式的数据结构
+	var response struct {
+		Data []*model.IptablesConfig `json:"data"`
+	}
+
+	if decodeErr := json.NewDecoder(resp.Body).Decode(&response); decodeErr != nil {
+		err = fmt.Errorf("解析响应失败: %w", decodeErr)
+		logger.LogError(err, "解析iptables配置响应失败", logrus.Fields{
+			"url":       url,
+			"server_id": serverID,
+		})
+		return nil, err
+	}
+
+	duration := time.Since(startTime)
+
+	log.WithFields(mergeFields(traceLogFields(span), logrus.Fields{
+		"server_id":     serverID,
+		"configs_count": len(response.Data),
+		"duration_ms":   duration.Milliseconds(),
+	})).Info("成功获取iptables配置(proto)")
+
+	return response.Data, nil
+}
+
+// Close 实现transport接口。REST传输基于net/http的共享连接池，没有需要显式
+// 释放的持久连接
+func (c *restTransport) Close() error {
+	return nil
+}
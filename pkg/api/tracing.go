@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer是pkg/api所有span的来源，Tracing.Endpoint为空时otel.Tracer返回的是
+// 默认的no-op实现，Start/End几乎零开销，不需要额外的开关判断
+var tracer = otel.Tracer("github.com/nspass/nspass-agent/pkg/api")
+
+// InitTracing按cfg.Tracing配置一个OTLP/gRPC导出的全局TracerProvider，并把
+// W3C trace context设为全局propagator，供本包和未来其他包的Inject/Extract共
+// 用。Endpoint为空时直接返回no-op的shutdown，保持otel的默认no-op
+// TracerProvider，GetServerConfig等方法创建的span不会产生任何导出开销
+func InitTracing(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("创建OTLP导出器失败: %w", err)
+	}
+
+	sampleRatio := cfg.SampleRatio
+	if sampleRatio <= 0 {
+		sampleRatio = 1
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String("nspass-agent"),
+	)
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	logger.GetAPILogger().WithFields(logrus.Fields{
+		"endpoint":     cfg.Endpoint,
+		"sample_ratio": sampleRatio,
+	}).Info("已启用pkg/api的OpenTelemetry追踪导出")
+
+	return tp.Shutdown, nil
+}
+
+// startAPISpan为一次API调用开出顶层span，带上请求方法/URL/server_id等
+// 属性，供"每个调用都有span"的追踪要求使用；返回的ctx用于派生后续的重试
+// 子span和向外注入trace context
+func startAPISpan(name, method, url, serverID string) (context.Context, trace.Span) {
+	return tracer.Start(context.Background(), name, trace.WithAttributes(
+		attribute.String("server.id", serverID),
+		attribute.String("http.url", url),
+		attribute.String("http.method", method),
+	))
+}
+
+// startRetrySpan为每次重试尝试开一个子span，使单次尝试的耗时在trace里单独
+// 可见，不再只能看到聚合的logger.LogPerformance指标
+func startRetrySpan(ctx context.Context, attempt int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "api.retry_attempt", trace.WithAttributes(
+		attribute.Int("retry.count", attempt),
+	))
+}
+
+// injectTraceContext把ctx里的W3C trace context注入到出站请求的Header，让
+// 控制面能把自己的处理span和这次调用关联起来
+func injectTraceContext(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// endSpan按err是否为空设置span状态并记录异常，随后结束span；span/trace ID
+// 通过traceLogFields取出供调用方并入logrus字段，与本地的startTime/
+// duration_ms一起记录，让日志能直接按trace_id grep到链路
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+	span.End()
+}
+
+// traceLogFields返回span所属的trace_id/span_id，方便日志按链路关联
+func traceLogFields(span trace.Span) logrus.Fields {
+	sc := span.SpanContext()
+	return logrus.Fields{
+		"trace_id": sc.TraceID().String(),
+		"span_id":  sc.SpanID().String(),
+	}
+}
+
+// mergeFields合并多个logrus.Fields，后面的重复key覆盖前面的
+func mergeFields(sets ...logrus.Fields) logrus.Fields {
+	merged := logrus.Fields{}
+	for _, set := range sets {
+		for k, v := range set {
+			merged[k] = v
+		}
+	}
+	return merged
+}
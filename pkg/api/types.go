@@ -76,6 +76,17 @@ type AgentStatusReport struct {
 	IPv6Address string        `json:"ipv6_address,omitempty"`
 	Activity    AgentActivity `json:"activity"`
 	ReportTime  time.Time     `json:"report_time"`
+
+	// 以下字段由pkg/geoip根据IPv4Address/IPv6Address查询嵌入式IP库得出，
+	// 未配置IP库或查询失败时全部留空，不影响上报本身
+	Continent string  `json:"continent,omitempty"`
+	Country   string  `json:"country,omitempty"`
+	Province  string  `json:"province,omitempty"`
+	City      string  `json:"city,omitempty"`
+	ISP       string  `json:"isp,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	TimeZone  string  `json:"time_zone,omitempty"`
 }
 
 // AgentActivity Agent活动信息
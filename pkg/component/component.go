@@ -0,0 +1,242 @@
+// Package component为agent.Service之类需要管理多个子系统生命周期的宿主提供
+// 一个通用的OnInit/OnStart/OnStop组件模型：每个子系统实现Component接口并声明
+// 依赖的其他组件名，Registry负责按依赖关系排出启动顺序、在启动失败时回滚已
+// 启动的组件、并在停止时按相反顺序收尾。新增一个子系统（Prometheus导出器、
+// 自定义探针等）只需要RegisterComponent，不必改动宿主的Start/Stop逻辑。
+package component
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Component 是可被Registry管理生命周期的子系统的最小接口
+type Component interface {
+	// Name 返回组件名，在同一个Registry中必须唯一，也用作依赖声明中的标识符
+	Name() string
+	// OnInit 在所有组件Start之前按依赖顺序调用一次，用于准备资源但不产生
+	// 外部可见的副作用（监听端口、连接外部服务等留给OnStart）
+	OnInit(ctx context.Context) error
+	// OnStart 启动组件，Registry保证该组件声明的所有依赖已经OnStart成功
+	OnStart(ctx context.Context) error
+	// OnStop 停止组件，应当是幂等的：Registry在回滚场景下可能对同一组件
+	// 重复调用
+	OnStop(ctx context.Context) error
+	// HealthCheck 返回组件当前是否健康，供Registry.Status()聚合展示
+	HealthCheck() error
+}
+
+// Status 是单个组件在某一时刻的状态快照
+type Status struct {
+	Name    string `json:"name"`
+	Started bool   `json:"started"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+type registration struct {
+	component Component
+	deps      []string
+	started   bool
+}
+
+// Registry 管理一组Component的注册、依赖排序和生命周期调度
+type Registry struct {
+	mu    sync.Mutex
+	regs  map[string]*registration
+	order []string // 按依赖关系解析出的启动顺序，Stop按其逆序执行
+	log   *logrus.Entry
+}
+
+// NewRegistry 创建一个空的组件Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		regs: make(map[string]*registration),
+		log:  logger.GetComponentLogger("component-registry"),
+	}
+}
+
+// RegisterComponent 注册一个组件及其依赖的组件名。deps中的名字不要求在注册时
+// 已经存在，但Init/Start前必须全部注册完毕，否则会在排序阶段报错
+func (r *Registry) RegisterComponent(c Component, deps ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := c.Name()
+	if _, exists := r.regs[name]; exists {
+		return fmt.Errorf("组件%s已注册", name)
+	}
+
+	r.regs[name] = &registration{component: c, deps: deps}
+	return nil
+}
+
+// resolveOrder对已注册组件按依赖关系做拓扑排序（Kahn算法），检测出缺失依赖
+// 和循环依赖
+func (r *Registry) resolveOrder() ([]string, error) {
+	inDegree := make(map[string]int, len(r.regs))
+	dependents := make(map[string][]string, len(r.regs))
+
+	for name, reg := range r.regs {
+		if _, ok := inDegree[name]; !ok {
+			inDegree[name] = 0
+		}
+		for _, dep := range reg.deps {
+			if _, ok := r.regs[dep]; !ok {
+				return nil, fmt.Errorf("组件%s依赖的%s未注册", name, dep)
+			}
+			inDegree[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	queue := make([]string, 0, len(inDegree))
+	for name, deg := range inDegree {
+		if deg == 0 {
+			queue = append(queue, name)
+		}
+	}
+
+	order := make([]string, 0, len(r.regs))
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		order = append(order, name)
+
+		for _, dependent := range dependents[name] {
+			inDegree[dependent]--
+			if inDegree[dependent] == 0 {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	if len(order) != len(r.regs) {
+		return nil, fmt.Errorf("组件依赖关系存在循环，无法确定启动顺序")
+	}
+
+	return order, nil
+}
+
+// Init 按依赖顺序对所有组件调用OnInit，任意一个失败立即返回，不做回滚——
+// OnInit约定不产生外部可见副作用
+func (r *Registry) Init(ctx context.Context) error {
+	r.mu.Lock()
+	order, err := r.resolveOrder()
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.order = order
+	r.mu.Unlock()
+
+	for _, name := range order {
+		reg := r.regs[name]
+		r.log.WithField("component", name).Debug("初始化组件")
+		if err := reg.component.OnInit(ctx); err != nil {
+			return fmt.Errorf("初始化组件%s失败: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Start 按依赖顺序启动所有组件。任意组件启动失败时，回滚已经启动成功的组件
+// （按启动的逆序调用OnStop），并返回该组件的启动错误；回滚中的停止失败只
+// 记录日志，不并入返回值
+func (r *Registry) Start(ctx context.Context) error {
+	r.mu.Lock()
+	order := r.order
+	r.mu.Unlock()
+	if order == nil {
+		var err error
+		order, err = r.resolveOrder()
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.order = order
+		r.mu.Unlock()
+	}
+
+	started := make([]string, 0, len(order))
+	for _, name := range order {
+		reg := r.regs[name]
+		r.log.WithField("component", name).Info("启动组件")
+
+		if err := reg.component.OnStart(ctx); err != nil {
+			startErr := fmt.Errorf("启动组件%s失败: %w", name, err)
+			r.log.WithError(startErr).Error("组件启动失败，回滚已启动的组件")
+			r.rollback(ctx, started)
+			return startErr
+		}
+
+		reg.started = true
+		started = append(started, name)
+	}
+
+	return nil
+}
+
+// rollback 按启动的逆序停止已经成功启动的组件，单个组件的停止失败只记录日志，
+// 不影响其余组件的回滚
+func (r *Registry) rollback(ctx context.Context, started []string) {
+	for i := len(started) - 1; i >= 0; i-- {
+		name := started[i]
+		reg := r.regs[name]
+		if err := reg.component.OnStop(ctx); err != nil {
+			r.log.WithField("component", name).WithError(err).Warn("回滚组件时停止失败")
+		}
+		reg.started = false
+	}
+}
+
+// Stop 按启动顺序的逆序停止所有已启动的组件，聚合各组件的停止错误但不会因为
+// 某个组件停止失败而跳过其余组件
+func (r *Registry) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	order := r.order
+	r.mu.Unlock()
+
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		name := order[i]
+		reg := r.regs[name]
+		if !reg.started {
+			continue
+		}
+
+		r.log.WithField("component", name).Info("停止组件")
+		if err := reg.component.OnStop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("停止组件%s失败: %w", name, err))
+			continue
+		}
+		reg.started = false
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("停止组件时发生%d个错误: %v", len(errs), errs)
+	}
+	return nil
+}
+
+// Status 返回所有已注册组件的当前状态快照
+func (r *Registry) Status() map[string]Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make(map[string]Status, len(r.regs))
+	for name, reg := range r.regs {
+		status := Status{Name: name, Started: reg.started}
+		if err := reg.component.HealthCheck(); err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Healthy = true
+		}
+		statuses[name] = status
+	}
+	return statuses
+}
@@ -15,11 +15,161 @@ type Config struct {
 	API            APIConfig      `yaml:"api" json:"api"`
 	Proxy          ProxyConfig    `yaml:"proxy" json:"proxy"`
 	IPTables       IPTablesConfig `yaml:"iptables" json:"iptables"`
+	IPVS           IPVSConfig     `yaml:"ipvs" json:"ipvs"`
+	Registry       RegistryConfig `yaml:"registry" json:"registry"`
+	GeoIP          GeoIPConfig    `yaml:"geoip" json:"geoip"`
+	Admin          AdminConfig    `yaml:"admin" json:"admin"`
+	Metrics        MetricsConfig  `yaml:"metrics" json:"metrics"`
 	Logger         logger.Config  `yaml:"logger" json:"logger"`
+	Task           TaskConfig     `yaml:"task" json:"task"`
 	UpdateInterval int            `yaml:"update_interval" json:"update_interval"` // 秒
 	LogLevel       string         `yaml:"log_level" json:"log_level"`
 }
 
+// TaskConfig 控制pkg/websocket执行WebSocket下发任务时的行为
+type TaskConfig struct {
+	// Exec配置TaskHandlerRegistry里通用"exec"执行器可以运行哪些脚本/二进制，
+	// 任务必须通过Parameters["command"]引用其中一个Name，不在白名单内的一律拒绝
+	Exec TaskExecConfig `yaml:"exec" json:"exec"`
+	// Restart配置TaskManager内RestartSupervisor按任务类型分别使用的失败
+	// 重试策略
+	Restart TaskRestartConfig `yaml:"restart" json:"restart"`
+	// StoreDir是TaskManager持久化任务记录（TaskStore）的目录，留空表示不落盘，
+	// 重启后TaskManager的幂等性记录和待重试任务全部丢失
+	StoreDir string `yaml:"store_dir" json:"store_dir"`
+	// HealthCheck配置health_check任务里system/proxy/iptables三类检查各自的
+	// 阈值与严重级别
+	HealthCheck TaskHealthCheckConfig `yaml:"health_check" json:"health_check"`
+	// RestartMarkerPath是restart任务对agent自身做syscall.Exec自重启前落盘的
+	// "重启待确认"标记文件路径，新进程启动时据此补发自重启前来不及发送的
+	// TASK_STATUS_COMPLETED结果。与StoreDir是否配置无关，总是有默认值
+	RestartMarkerPath string `yaml:"restart_marker_path" json:"restart_marker_path"`
+}
+
+// TaskHealthCheckConfig 控制handleHealthCheck对system/proxy/iptables三类检查
+// 使用的阈值和失败后的严重级别。只有Severity为"critical"的检查失败时，
+// health_check任务才会被判定为TASK_STATUS_FAILED，其余级别的失败仅体现在
+// 结构化结果里供人工查看
+type TaskHealthCheckConfig struct {
+	System   SystemHealthCheckConfig    `yaml:"system" json:"system"`
+	Proxy    ComponentHealthCheckConfig `yaml:"proxy" json:"proxy"`
+	IPTables ComponentHealthCheckConfig `yaml:"iptables" json:"iptables"`
+}
+
+// SystemHealthCheckConfig 系统资源检查的阈值
+type SystemHealthCheckConfig struct {
+	DiskFreePercentMin   float64 `yaml:"disk_free_percent_min" json:"disk_free_percent_min"`
+	LoadAveragePerCPUMax float64 `yaml:"load_average_per_cpu_max" json:"load_average_per_cpu_max"`
+	MemoryFreePercentMin float64 `yaml:"memory_free_percent_min" json:"memory_free_percent_min"`
+	// MinUptimeSeconds是agent自身运行时长的下限，用于发现频繁崩溃重启的情况；
+	// 运行时长本身不是越长越好，所以只设下限不设上限
+	MinUptimeSeconds int    `yaml:"min_uptime_seconds" json:"min_uptime_seconds"`
+	Severity         string `yaml:"severity" json:"severity"` // "critical"或其他
+}
+
+// ComponentHealthCheckConfig 控制proxy/iptables这类布尔性质检查（进程是否
+// 存活、规则是否漂移）失败后的严重级别，没有额外的数值阈值
+type ComponentHealthCheckConfig struct {
+	Severity string `yaml:"severity" json:"severity"`
+}
+
+// TaskRestartConfig 控制RestartSupervisor的重试策略：Default是兜底策略，
+// Policies按model.TaskType.String()返回的名字（如"TASK_TYPE_RESTART"）覆盖
+// 特定任务类型
+type TaskRestartConfig struct {
+	Default  TaskRestartPolicyConfig            `yaml:"default" json:"default"`
+	Policies map[string]TaskRestartPolicyConfig `yaml:"policies" json:"policies"`
+}
+
+// TaskRestartPolicyConfig 是websocket.RestartPolicy的配置化形式，命名和字段
+// 对应Swarmkit restart包的RestartPolicy概念
+type TaskRestartPolicyConfig struct {
+	// Condition："on-failure"（默认，仅失败时重试）/"any"/"none"（禁止自动重试）
+	Condition string `yaml:"condition" json:"condition"`
+	// DelaySeconds是第一次重试前的基础等待时间，0表示使用内置默认值
+	DelaySeconds int `yaml:"delay_seconds" json:"delay_seconds"`
+	// MaxAttempts是Window时间窗口内允许的最大失败次数，超过后判定为永久失败、
+	// 不再自动重试，0表示使用内置默认值
+	MaxAttempts int `yaml:"max_attempts" json:"max_attempts"`
+	// WindowSeconds是统计失败次数的滑动窗口，0表示使用内置默认值
+	WindowSeconds int `yaml:"window_seconds" json:"window_seconds"`
+	// BackoffMultiplier是每多失败一次，等待时间相对上一次的放大倍数，
+	// 0表示使用内置默认值
+	BackoffMultiplier float64 `yaml:"backoff_multiplier" json:"backoff_multiplier"`
+	// MaxBackoffSeconds是退避时长的上限，0表示使用内置默认值
+	MaxBackoffSeconds int `yaml:"max_backoff_seconds" json:"max_backoff_seconds"`
+	// Jitter是退避时长上下浮动的比例（0~1），避免同一批失败任务在退避结束的
+	// 同一时刻集中重试，0表示使用内置默认值
+	Jitter float64 `yaml:"jitter" json:"jitter"`
+}
+
+// TaskExecConfig 通用exec任务执行器的白名单和资源限制
+type TaskExecConfig struct {
+	// Commands是允许执行的命令白名单，按Name索引
+	Commands []TaskExecCommand `yaml:"commands" json:"commands"`
+	// TimeoutSeconds是单次执行的默认超时，命令自身未设置Timeout时使用，
+	// 0表示使用内置默认值
+	TimeoutSeconds int `yaml:"timeout_seconds" json:"timeout_seconds"`
+	// OutputLimitBytes是捕获stdout/stderr各自的上限，超出部分被截断，
+	// 0表示使用内置默认值
+	OutputLimitBytes int `yaml:"output_limit_bytes" json:"output_limit_bytes"`
+}
+
+// TaskExecCommand 白名单里的一条可执行命令
+type TaskExecCommand struct {
+	Name string `yaml:"name" json:"name"` // 任务Parameters["command"]引用的名字
+	Path string `yaml:"path" json:"path"` // 可执行文件的绝对路径
+	// Args是固定参数模板，支持用{{paramName}}引用TaskMessage.Parameters里的值
+	Args []string `yaml:"args" json:"args"`
+	// Env是注入子进程的额外环境变量，同样支持{{paramName}}模板
+	Env            map[string]string `yaml:"env" json:"env"`
+	TimeoutSeconds int               `yaml:"timeout_seconds" json:"timeout_seconds"` // 覆盖TaskExecConfig.TimeoutSeconds
+}
+
+// AdminConfig 控制pkg/admin暴露的本地运维API：通过Unix域套接字提供GetStatus/
+// 强制协调/代理重启，供`nspass-agent status|reload|proxy restart`等cobra子
+// 命令在WebSocket控制面不可达时使用
+type AdminConfig struct {
+	Enable     bool   `yaml:"enable" json:"enable"`           // 默认不启用；为true且未设置socket_path时使用默认套接字路径
+	SocketPath string `yaml:"socket_path" json:"socket_path"` // Unix域套接字路径，Enable为true时默认/var/run/nspass/admin.sock；非空即启动admin API
+}
+
+// MetricsConfig 控制pkg/websocket.MetricsExporter暴露的Prometheus抓取端点：
+// 把DefaultMetricsCollector采集到的同一套system/traffic/connection/
+// performance/error数据以/metrics端点暴露出来，作为WebSocket主动推送之外的
+// 另一条观测路径，供现有的Prometheus/node_exporter式监控接入
+type MetricsConfig struct {
+	Enable     bool   `yaml:"enable" json:"enable"`           // 默认不启用，需要显式开启
+	ListenAddr string `yaml:"listen_addr" json:"listen_addr"` // 监听地址，默认127.0.0.1:9257
+	// AuthToken非空时，/metrics要求Authorization: Bearer <token>请求头，
+	// 留空表示不做鉴权（仅监听在127.0.0.1时可以接受）
+	AuthToken string `yaml:"auth_token" json:"auth_token"`
+}
+
+// GeoIPConfig 控制pkg/geoip用哪种驱动、从哪个文件加载IP地理位置库
+type GeoIPConfig struct {
+	Driver         string `yaml:"driver" json:"driver"`                   // "maxmind"/"ip2region"/"none"
+	DatabasePath   string `yaml:"database_path" json:"database_path"`     // .mmdb或.xdb文件路径
+	ReloadInterval int    `yaml:"reload_interval" json:"reload_interval"` // 检测文件变化的轮询间隔（秒），默认60
+	// CacheSize是Resolver按/24（v4）或/48（v6）网段聚合缓存的查询结果条数上限，
+	// 0表示使用内置默认值；超过上限按LRU淘汰最久未使用的网段
+	CacheSize int `yaml:"cache_size" json:"cache_size"`
+}
+
+// RegistryConfig 控制pkg/registry使用哪种后端做自注册和配置下发监听，
+// Backend为空或"none"时完全不启用，Agent退回到基于UpdateInterval的HTTP轮询上报
+type RegistryConfig struct {
+	Backend   string   `yaml:"backend" json:"backend"`     // "etcd"/"consul"/"none"
+	Endpoints []string `yaml:"endpoints" json:"endpoints"` // 注册中心地址列表
+	Namespace string   `yaml:"namespace" json:"namespace"` // key前缀，默认"/nspass"
+	TTL       int      `yaml:"ttl" json:"ttl"`             // 租约TTL（秒），默认15
+
+	// ElectionEnable在同一server_id有多个Agent副本（HA部署）时开启leader选举，
+	// 只有选举成功的副本会监听并应用配置下发，其余副本继续维持自注册但不参与
+	// 配置应用，避免多副本并发apply同一份iptables/proxy配置
+	ElectionEnable bool `yaml:"election_enable" json:"election_enable"`
+}
+
 // APIConfig API配置
 type APIConfig struct {
 	BaseURL       string `yaml:"base_url" json:"base_url"`
@@ -29,6 +179,38 @@ type APIConfig struct {
 	RetryDelay    int    `yaml:"retry_delay" json:"retry_delay"`
 	TLS           bool   `yaml:"tls" json:"tls"`                         // 是否启用TLS
 	TLSSkipVerify bool   `yaml:"tls_skip_verify" json:"tls_skip_verify"` // 是否跳过TLS证书验证
+	ChallengeKey  string `yaml:"challenge_key" json:"challenge_key"`     // 用于签名挑战握手的预共享密钥
+
+	// Transport选择与控制面通信的传输方式："rest"（默认，HTTP+JSON，向后兼容）
+	// 或"grpc"（generated/agent的gRPC stub，持久连接+keepalive+流式状态上报）
+	Transport string `yaml:"transport" json:"transport"`
+	// GRPC仅在Transport为"grpc"时生效
+	GRPC GRPCConfig `yaml:"grpc" json:"grpc"`
+
+	// Tracing控制pkg/api的OpenTelemetry导出，Endpoint为空时完全不启用，
+	// GetServerConfig/ReportAgentStatus/GetServerIptablesConfigsProto创建的
+	// span都是no-op实现，不记录也不导出任何追踪数据
+	Tracing TracingConfig `yaml:"tracing" json:"tracing"`
+}
+
+// TracingConfig 控制pkg/api的OTLP追踪导出
+type TracingConfig struct {
+	// Endpoint是OTLP/gRPC collector地址（host:port），为空时禁用追踪
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	// SampleRatio是采样率，取值[0,1]，0表示使用默认值1（全采样）
+	SampleRatio float64 `yaml:"sample_ratio" json:"sample_ratio"`
+	// Insecure为true时OTLP导出不使用TLS，适合collector部署在同一内网/sidecar的场景
+	Insecure bool `yaml:"insecure" json:"insecure"`
+}
+
+// GRPCConfig 控制gRPC传输的连接参数
+type GRPCConfig struct {
+	// KeepaliveTime是连接空闲多久后发送一次keepalive ping（秒），0表示使用默认值
+	KeepaliveTime int `yaml:"keepalive_time" json:"keepalive_time"`
+	// KeepaliveTimeout是发出keepalive ping后等待响应的超时（秒），0表示使用默认值
+	KeepaliveTimeout int `yaml:"keepalive_timeout" json:"keepalive_timeout"`
+	// MaxRetries是grpc-go service config里单次RPC的最大重试次数，0表示使用默认值
+	MaxRetries int `yaml:"max_retries" json:"max_retries"`
 }
 
 // ProxyConfig 代理配置
@@ -37,10 +219,87 @@ type ProxyConfig struct {
 	ConfigPath    string   `yaml:"config_path" json:"config_path"`         // 代理配置文件路径
 	EnabledTypes  []string `yaml:"enabled_types" json:"enabled_types"`     // 启用的代理类型
 	AutoStart     bool     `yaml:"auto_start" json:"auto_start"`           // 是否自动启动
-	RestartOnFail bool     `yaml:"restart_on_fail" json:"restart_on_fail"` // 失败时是否重启
+	RestartOnFail bool     `yaml:"restart_on_fail" json:"restart_on_fail"` // 失败时是否重启（RestartPolicy为空时的兼容取值）
+
+	// RestartPolicy 受监管进程的重启策略：always/on_failure/never，为空时按
+	// RestartOnFail换算（true对应on_failure，false对应never），用于兼容旧配置
+	RestartPolicy string `yaml:"restart_policy" json:"restart_policy"`
+
+	// HealthProbe 健康探测配置：即使PID仍存活，探测持续失败也会触发重启
+	HealthProbe HealthProbeConfig `yaml:"health_probe" json:"health_probe"`
+
+	// Resources 资源限制，目前仅systemd生命周期后端会据此渲染unit文件
+	Resources ResourceLimits `yaml:"resources" json:"resources"`
+
+	// PackageManager 控制安装代理软件时使用的包管理器
+	PackageManager PackageManagerConfig `yaml:"package_manager" json:"package_manager"`
 
 	// 进程监控配置
 	Monitor MonitorConfig `yaml:"monitor" json:"monitor"` // 进程监控配置
+
+	// Supervisor 显式指定生命周期后端，为空时由pkg/proxy/lifecycle按
+	// systemd.Available()自动探测并在失败时回退
+	Supervisor SupervisorConfig `yaml:"supervisor" json:"supervisor"`
+
+	// GracefulWait是GracefulStop发出SIGTERM后等待进程自行退出的秒数，超时后
+	// 升级为SIGKILL，0表示使用默认值
+	GracefulWait int `yaml:"graceful_wait" json:"graceful_wait"`
+}
+
+// SupervisorConfig 控制pkg/proxy/lifecycle选用的生命周期后端
+type SupervisorConfig struct {
+	// Backend 为空表示自动探测（有systemd优先用systemd，否则用进程内
+	// supervisor）；"systemd"/"pidfile"/"container"强制使用对应后端，"systemd"
+	// 和"container"在不可用或启动失败时直接报错而不回退，"pidfile"完全跳过
+	// systemd探测
+	Backend string `yaml:"backend" json:"backend"`
+	// UnitDir systemd unit文件写入目录，留空使用/etc/systemd/system
+	UnitDir string `yaml:"unit_dir" json:"unit_dir"`
+
+	// ContainerRuntime是container后端使用的CLI："docker"/"podman"，留空时
+	// 优先探测docker、不存在则尝试podman
+	ContainerRuntime string `yaml:"container_runtime" json:"container_runtime"`
+	// ContainerImage是container后端启动代理进程所用的镜像，必须包含待运行
+	// 的代理二进制；Backend为"container"时必填
+	ContainerImage string `yaml:"container_image" json:"container_image"`
+}
+
+// PackageManagerConfig 控制pkg/pkgmgr解析安装器时的行为
+type PackageManagerConfig struct {
+	// Override 显式指定使用的安装器："apt"/"dnf"/"yum"/"zypper"/"pacman"/"apk"/
+	// "static"，留空表示按探测到的原生包管理器自动选择，找不到时回退到静态二进制
+	Override string `yaml:"override" json:"override"`
+	// DryRun为true时Install只把安装计划写入日志、不实际执行，供操作员审计；
+	// 计划本身由Installer.Plan返回
+	DryRun       bool               `yaml:"dry_run" json:"dry_run"`
+	StaticBinary StaticBinaryConfig `yaml:"static_binary" json:"static_binary"`
+}
+
+// StaticBinaryConfig 静态二进制兜底安装方式的配置
+type StaticBinaryConfig struct {
+	// MirrorURL 下载地址模板，支持{package}和{version}占位符
+	MirrorURL       string `yaml:"mirror_url" json:"mirror_url"`
+	Version         string `yaml:"version" json:"version"`
+	SHA256          string `yaml:"sha256" json:"sha256"`                       // 发布包的SHA256，为空则跳过校验
+	GPGPublicKey    string `yaml:"gpg_public_key" json:"gpg_public_key"`       // 可选，armored GPG公钥路径，配合.asc签名文件校验
+	CosignPublicKey string `yaml:"cosign_public_key" json:"cosign_public_key"` // 可选，cosign公钥路径，配合.sig签名文件校验
+}
+
+// ResourceLimits 对代理进程施加的资源上限，0表示不限制
+type ResourceLimits struct {
+	MemoryMaxMB     int `yaml:"memory_max_mb" json:"memory_max_mb"`         // 内存上限（MB）
+	CPUQuotaPercent int `yaml:"cpu_quota_percent" json:"cpu_quota_percent"` // CPU配额（百分比）
+}
+
+// HealthProbeConfig 进程级健康探测配置，用于supervisor在PID存活但服务实际不可用
+// 时仍能发现问题并触发重启
+type HealthProbeConfig struct {
+	Type     string   `yaml:"type" json:"type"`         // tcp/http/exec，留空表示不启用探测
+	Target   string   `yaml:"target" json:"target"`     // tcp: host:port；http: 完整URL
+	Command  string   `yaml:"command" json:"command"`   // exec: 可执行文件路径
+	Args     []string `yaml:"args" json:"args"`         // exec: 命令行参数
+	Interval int      `yaml:"interval" json:"interval"` // 探测间隔（秒）
+	Timeout  int      `yaml:"timeout" json:"timeout"`   // 探测超时（秒）
 }
 
 // MonitorConfig 进程监控配置
@@ -50,6 +309,34 @@ type MonitorConfig struct {
 	RestartCooldown int  `yaml:"restart_cooldown" json:"restart_cooldown"` // 重启冷却时间（秒）
 	MaxRestarts     int  `yaml:"max_restarts" json:"max_restarts"`         // 最大重启次数（每小时）
 	HealthTimeout   int  `yaml:"health_timeout" json:"health_timeout"`     // 健康检查超时（秒）
+	// StateDir是ProxyMonitor持久化代理状态快照（PID、启动时间、配置哈希、重启
+	// 历史）的目录，留空时不落盘，Agent重启后所有代理都会被当作新进程重新拉起
+	StateDir string `yaml:"state_dir" json:"state_dir"`
+
+	// MaxBackoff是指数退避冷却时间的上限（秒），0表示使用内置默认值
+	MaxBackoff int `yaml:"max_backoff" json:"max_backoff"`
+	// JitterSeconds是每次计算冷却时间后额外叠加的随机抖动上限（秒），避免
+	// 同时崩溃的一批代理在退避结束的同一时刻集中发起重启
+	JitterSeconds int `yaml:"jitter_seconds" json:"jitter_seconds"`
+	// TripThreshold是连续重启失败多少次后熔断（进入circuit_open状态、停止
+	// 尝试重启），0表示使用内置默认值
+	TripThreshold int `yaml:"trip_threshold" json:"trip_threshold"`
+	// OpenDuration是熔断后等待多久才放行一次试探性重启（half_open），单位秒，
+	// 0表示使用内置默认值
+	OpenDuration int `yaml:"open_duration" json:"open_duration"`
+
+	// ResyncInterval是proxy.Manager.Resync周期性把最近一次下发的期望状态重新
+	// 对账一遍的间隔（秒），只会重新应用上次对账失败的代理，配置文件被手工改动
+	// 不会被纠正；0表示不启用周期性Resync，只在UpdateProxies被调用时对账
+	ResyncInterval int `yaml:"resync_interval" json:"resync_interval"`
+
+	// Prober配置一个与instance.HealthCheck内置探测并行的、可插拔的主动健康
+	// 探测（tcp/http/socks5/exec），Type留空表示不启用。与cfg.Proxy.HealthProbe
+	// （供lifecycle/supervisor在PID级别使用）是两层独立的探测，互不影响
+	Prober HealthProbeConfig `yaml:"prober" json:"prober"`
+	// ProbeFailureThreshold是Prober连续探测失败多少次后触发attemptRestart，
+	// 0表示使用内置默认值
+	ProbeFailureThreshold int `yaml:"probe_failure_threshold" json:"probe_failure_threshold"`
 }
 
 // IPTablesConfig iptables配置
@@ -57,6 +344,46 @@ type IPTablesConfig struct {
 	Enable      bool   `yaml:"enable" json:"enable"`
 	ChainPrefix string `yaml:"chain_prefix" json:"chain_prefix"`
 	BackupPath  string `yaml:"backup_path" json:"backup_path"`
+	// Backend 选择规则管理后端："iptables"/"iptables-restore"（默认，基于
+	// iptables-save/restore）、"nftables"（基于netlink的原子替换）、"auto"
+	// （探测内核/工具链能力后自动选择，优先nftables）。留空等价于"iptables"
+	Backend string `yaml:"backend" json:"backend"`
+	// RestoreMode 控制iptables-restore应用规则的范围："noflush"（默认）只
+	// 针对我们实际管理的表，且表内只清空/重写ChainPrefix下的自定义链，user/
+	// 系统既有规则保持不动；"full"是旧行为，整份iptables-save内容原样传回
+	// iptables-restore，涉及的每张表都会被整体重写。留空等价于"noflush"
+	RestoreMode string `yaml:"restore_mode" json:"restore_mode"`
+	// MinSyncIntervalSeconds是两次实际同步之间的最短间隔（秒），短时间内
+	// 连续多次UpdateRulesFromProto调用会被合并成这个间隔到期后的一次同步，
+	// 避免突发的配置更新引起连续的iptables-restore调用和xtables锁竞争。
+	// 默认1秒，0表示使用默认值
+	MinSyncIntervalSeconds int `yaml:"min_sync_interval_seconds" json:"min_sync_interval_seconds"`
+	// MaxSyncIntervalSeconds是即使没有新的Run请求也至少要执行一次同步的最长
+	// 间隔（秒），对齐Kubernetes BoundedFrequencyRunner的语义，保证长期没有
+	// 变化时也会定期核对一次。默认60秒，0表示使用默认值
+	MaxSyncIntervalSeconds int `yaml:"max_sync_interval_seconds" json:"max_sync_interval_seconds"`
+	// UseIPSet为true时，proto配置里的SourceIpSet/DestIpSet（大量IP/网段）
+	// 会被下发成ipset（hash:ip或hash:net）并通过`-m set --match-set`匹配，
+	// 而不是展开成一条一条的-s/-d规则，避免规则数爆炸拖慢iptables-restore。
+	// 宿主机没有ipset命令时自动跳过依赖ipset的配置，不影响其余规则下发。
+	// 默认false
+	UseIPSet bool `yaml:"use_ipset" json:"use_ipset"`
+}
+
+// IPVSConfig 控制pkg/ipvs是否接管负载均衡型出口（单个EgressItem背后对应一组
+// 权重可调的上游real server），与IPTablesConfig平行存在：两者分别管理各自
+// 的内核数据面（IPVS虚拟服务表 vs iptables/nftables规则链）
+type IPVSConfig struct {
+	Enable bool `yaml:"enable" json:"enable"` // 是否启用IPVS负载均衡出口
+
+	// DefaultScheduler是新建虚拟服务未显式指定调度算法时使用的IPVS调度器：
+	// rr（轮询）/wrr（加权轮询）/sh（源地址哈希，保持同一客户端的会话粘性）
+	DefaultScheduler string `yaml:"default_scheduler" json:"default_scheduler"`
+
+	// TuneSysctls为true时，启动阶段会调整conn_reuse_mode/expire_nodest_conn/
+	// expire_quiescent_template等内核参数，避免权重变化或real server下线时
+	// 已有连接conntrack条目造成的连接黏滞
+	TuneSysctls bool `yaml:"tune_sysctls" json:"tune_sysctls"`
 }
 
 // LoadConfig 从文件加载配置
@@ -104,6 +431,26 @@ func setDefaults(config *Config) {
 		config.API.RetryDelay = 5
 	}
 
+	if config.API.Transport == "" {
+		config.API.Transport = "rest"
+	}
+
+	if config.API.Transport == "grpc" {
+		if config.API.GRPC.KeepaliveTime == 0 {
+			config.API.GRPC.KeepaliveTime = 30
+		}
+		if config.API.GRPC.KeepaliveTimeout == 0 {
+			config.API.GRPC.KeepaliveTimeout = 10
+		}
+		if config.API.GRPC.MaxRetries == 0 {
+			config.API.GRPC.MaxRetries = 3
+		}
+	}
+
+	if config.API.Tracing.Endpoint != "" && config.API.Tracing.SampleRatio == 0 {
+		config.API.Tracing.SampleRatio = 1
+	}
+
 	if config.Proxy.BinPath == "" {
 		config.Proxy.BinPath = "/usr/local/bin"
 	}
@@ -132,6 +479,55 @@ func setDefaults(config *Config) {
 		config.Proxy.Monitor.HealthTimeout = 5 // 健康检查5秒超时
 	}
 
+	if config.Proxy.Monitor.StateDir == "" {
+		config.Proxy.Monitor.StateDir = "/var/lib/nspass/state"
+	}
+
+	if config.Proxy.Monitor.MaxBackoff == 0 {
+		config.Proxy.Monitor.MaxBackoff = 900 // 退避封顶15分钟
+	}
+
+	if config.Proxy.Monitor.JitterSeconds == 0 {
+		config.Proxy.Monitor.JitterSeconds = 5
+	}
+
+	if config.Proxy.Monitor.TripThreshold == 0 {
+		config.Proxy.Monitor.TripThreshold = 5 // 连续失败5次后熔断
+	}
+
+	if config.Proxy.Monitor.OpenDuration == 0 {
+		config.Proxy.Monitor.OpenDuration = 300 // 熔断后5分钟才放行一次试探性重启
+	}
+
+	if config.Proxy.Monitor.ProbeFailureThreshold == 0 {
+		config.Proxy.Monitor.ProbeFailureThreshold = 3
+	}
+
+	if config.Proxy.HealthProbe.Type != "" && config.Proxy.HealthProbe.Timeout == 0 {
+		config.Proxy.HealthProbe.Timeout = 5 // 探测5秒超时
+	}
+
+	if config.Proxy.GracefulWait == 0 {
+		config.Proxy.GracefulWait = 10 // 优雅停止默认等待10秒后升级为SIGKILL
+	}
+
+	if config.Registry.Backend != "" && config.Registry.Backend != "none" {
+		if config.Registry.Namespace == "" {
+			config.Registry.Namespace = "/nspass"
+		}
+		if config.Registry.TTL == 0 {
+			config.Registry.TTL = 15 // 租约15秒超时
+		}
+	}
+
+	if config.GeoIP.Driver != "" && config.GeoIP.Driver != "none" && config.GeoIP.ReloadInterval == 0 {
+		config.GeoIP.ReloadInterval = 60
+	}
+
+	if config.GeoIP.Driver != "" && config.GeoIP.Driver != "none" && config.GeoIP.CacheSize == 0 {
+		config.GeoIP.CacheSize = 4096
+	}
+
 	if config.IPTables.BackupPath == "" {
 		config.IPTables.BackupPath = "/etc/nspass/iptables-backup"
 	}
@@ -140,6 +536,91 @@ func setDefaults(config *Config) {
 		config.IPTables.ChainPrefix = "NSPASS_"
 	}
 
+	if config.IPTables.Backend == "" {
+		config.IPTables.Backend = "iptables"
+	}
+
+	if config.IPTables.RestoreMode == "" {
+		config.IPTables.RestoreMode = "noflush"
+	}
+
+	if config.IPTables.MinSyncIntervalSeconds == 0 {
+		config.IPTables.MinSyncIntervalSeconds = 1
+	}
+
+	if config.IPTables.MaxSyncIntervalSeconds == 0 {
+		config.IPTables.MaxSyncIntervalSeconds = 60
+	}
+
+	if config.IPVS.DefaultScheduler == "" {
+		config.IPVS.DefaultScheduler = "wrr"
+	}
+
+	if config.Admin.Enable && config.Admin.SocketPath == "" {
+		config.Admin.SocketPath = "/var/run/nspass/admin.sock"
+	}
+
+	if config.Metrics.Enable && config.Metrics.ListenAddr == "" {
+		config.Metrics.ListenAddr = "127.0.0.1:9257"
+	}
+
+	if config.Task.RestartMarkerPath == "" {
+		config.Task.RestartMarkerPath = "/var/run/nspass/restart_pending.json"
+	}
+
+	if config.Task.Exec.TimeoutSeconds == 0 {
+		config.Task.Exec.TimeoutSeconds = 60
+	}
+
+	if config.Task.Exec.OutputLimitBytes == 0 {
+		config.Task.Exec.OutputLimitBytes = 64 * 1024 // 64KB
+	}
+
+	if config.Task.Restart.Default.Condition == "" {
+		config.Task.Restart.Default.Condition = "on-failure"
+	}
+	if config.Task.Restart.Default.DelaySeconds == 0 {
+		config.Task.Restart.Default.DelaySeconds = 5
+	}
+	if config.Task.Restart.Default.MaxAttempts == 0 {
+		config.Task.Restart.Default.MaxAttempts = 5
+	}
+	if config.Task.Restart.Default.WindowSeconds == 0 {
+		config.Task.Restart.Default.WindowSeconds = 600 // 10分钟
+	}
+	if config.Task.Restart.Default.BackoffMultiplier == 0 {
+		config.Task.Restart.Default.BackoffMultiplier = 2
+	}
+	if config.Task.Restart.Default.MaxBackoffSeconds == 0 {
+		config.Task.Restart.Default.MaxBackoffSeconds = 300 // 5分钟
+	}
+	if config.Task.Restart.Default.Jitter == 0 {
+		config.Task.Restart.Default.Jitter = 0.2
+	}
+
+	if config.Task.HealthCheck.System.DiskFreePercentMin == 0 {
+		config.Task.HealthCheck.System.DiskFreePercentMin = 10
+	}
+	if config.Task.HealthCheck.System.LoadAveragePerCPUMax == 0 {
+		config.Task.HealthCheck.System.LoadAveragePerCPUMax = 2
+	}
+	if config.Task.HealthCheck.System.MemoryFreePercentMin == 0 {
+		config.Task.HealthCheck.System.MemoryFreePercentMin = 10
+	}
+	if config.Task.HealthCheck.System.MinUptimeSeconds == 0 {
+		config.Task.HealthCheck.System.MinUptimeSeconds = 60
+	}
+	if config.Task.HealthCheck.System.Severity == "" {
+		config.Task.HealthCheck.System.Severity = "critical"
+	}
+	if config.Task.HealthCheck.Proxy.Severity == "" {
+		config.Task.HealthCheck.Proxy.Severity = "critical"
+	}
+	if config.Task.HealthCheck.IPTables.Severity == "" {
+		// 规则漂移会被下一次Resync自动纠偏，默认不算critical
+		config.Task.HealthCheck.IPTables.Severity = "warning"
+	}
+
 	// 日志配置默认值
 	if config.Logger.Level == "" {
 		if config.LogLevel != "" {
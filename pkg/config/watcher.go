@@ -0,0 +1,231 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// ChangeKind 标识一次热重载中发生变化的配置子树，Watcher按子树派发给各自的
+// 订阅者，而不是让每个订阅者重新diff整个Config
+type ChangeKind string
+
+const (
+	// LoggerChanged logger块变化，订阅者通常重新调用logger.Initialize
+	LoggerChanged ChangeKind = "logger_changed"
+	// ProxyEnabledTypesChanged proxy.enabled_types变化。Agent对该事件只记录
+	// 警告，新的代理列表在重启Agent之后生效
+	ProxyEnabledTypesChanged ChangeKind = "proxy_enabled_types_changed"
+	// MonitorChanged proxy.monitor块变化。Agent对该事件只记录警告，新的检查
+	// 间隔在重启Agent之后生效
+	MonitorChanged ChangeKind = "monitor_changed"
+	// APIChanged api块变化（除被拒绝的base_url清空外）
+	APIChanged ChangeKind = "api_changed"
+)
+
+// Watcher监听本地YAML配置文件变化（fsnotify）和SIGHUP信号，重新加载、校验
+// 并与当前生效的配置diff，只把真正变化的子树作为ChangeKind事件派发给订阅者，
+// 使logger.Initialize可以不重启进程重新执行；代理增删、monitor轮询间隔的
+// 变化同样会派发，由订阅者决定如何处理。
+// 本Watcher只管本地YAML文件；服务端下发的配置走agent.Reconciler的
+// EventConfigChanged，两者是独立的触发源
+type Watcher struct {
+	path string
+
+	mu         sync.RWMutex
+	current    *Config
+	lastReload time.Time
+	lastErr    error
+
+	subMu       sync.Mutex
+	subscribers map[ChangeKind][]func(*Config)
+}
+
+// NewWatcher创建Watcher，initial是进程启动时LoadConfig加载的配置，作为第一次
+// diff的基准
+func NewWatcher(path string, initial *Config) *Watcher {
+	return &Watcher{
+		path:        path,
+		current:     initial,
+		subscribers: make(map[ChangeKind][]func(*Config)),
+	}
+}
+
+// Subscribe 注册一个回调，当kind对应的子树发生变化时以新配置为参数调用。须
+// 在Start之前完成全部订阅，否则可能错过Start后第一次触发的重载
+func (w *Watcher) Subscribe(kind ChangeKind, fn func(*Config)) {
+	w.subMu.Lock()
+	defer w.subMu.Unlock()
+	w.subscribers[kind] = append(w.subscribers[kind], fn)
+}
+
+// Current 返回当前生效的配置快照
+func (w *Watcher) Current() *Config {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// LastReload 返回最近一次重载尝试的时间和结果（成功则err为nil）。每次
+// 尝试的结果同时由reload写入logger.GetSystemLogger日志
+func (w *Watcher) LastReload() (time.Time, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.lastReload, w.lastErr
+}
+
+// Start 启动fsnotify文件监听和SIGHUP信号处理，直到ctx被取消
+func (w *Watcher) Start(ctx context.Context) error {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("创建文件监听器失败: %w", err)
+	}
+
+	// 大多数编辑器/部署工具以"写临时文件再rename覆盖"的方式更新配置文件，
+	// 直接watch文件本身会在rename后失效，因此watch所在目录、按文件名过滤事件
+	dir := filepath.Dir(w.path)
+	if err := fsWatcher.Add(dir); err != nil {
+		fsWatcher.Close()
+		return fmt.Errorf("监听配置目录%s失败: %w", dir, err)
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	log := logger.GetSystemLogger()
+	log.WithField("path", w.path).Info("配置热重载监听已启动")
+
+	go func() {
+		defer fsWatcher.Close()
+		defer signal.Stop(sighup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sighup:
+				log.WithField("signal", sig.String()).Info("收到SIGHUP，重新加载配置")
+				w.reload()
+			case evt, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(evt.Name) != filepath.Clean(w.path) {
+					continue
+				}
+				if evt.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				log.WithField("event", evt.String()).Info("检测到配置文件变化，重新加载配置")
+				w.reload()
+			case err, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Warn("配置文件监听出错")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reload重新加载、校验并diff配置文件，成功则更新w.current并派发变化的
+// ChangeKind；被拒绝或解析失败都只记录到lastErr和系统日志，不影响当前生效配置
+func (w *Watcher) reload() {
+	log := logger.GetSystemLogger()
+
+	next, err := LoadConfig(w.path)
+	if err != nil {
+		w.recordReload(fmt.Errorf("解析配置失败: %w", err))
+		log.WithError(err).Error("配置热重载失败：解析配置文件出错")
+		return
+	}
+
+	if err := next.Validate(); err != nil {
+		w.recordReload(fmt.Errorf("配置校验失败: %w", err))
+		log.WithError(err).Error("配置热重载失败：校验未通过")
+		return
+	}
+
+	current := w.Current()
+
+	if next.ServerID != current.ServerID {
+		rejectErr := fmt.Errorf("拒绝热重载：server_id不允许变更（%s -> %s）", current.ServerID, next.ServerID)
+		w.recordReload(rejectErr)
+		log.WithError(rejectErr).Error("配置热重载被拒绝")
+		return
+	}
+
+	if next.API.BaseURL == "" {
+		rejectErr := fmt.Errorf("拒绝热重载：api.base_url不能被清空")
+		w.recordReload(rejectErr)
+		log.WithError(rejectErr).Error("配置热重载被拒绝")
+		return
+	}
+
+	kinds := diffConfig(current, next)
+
+	w.mu.Lock()
+	w.current = next
+	w.lastReload = time.Now()
+	w.lastErr = nil
+	w.mu.Unlock()
+
+	if len(kinds) == 0 {
+		log.Debug("配置热重载完成，无实质变化")
+		return
+	}
+
+	log.WithField("changed", kinds).Info("配置热重载完成，派发变更事件")
+	w.dispatch(kinds, next)
+}
+
+// recordReload记录一次重载尝试的结果，之后可通过LastReload查询
+func (w *Watcher) recordReload(err error) {
+	w.mu.Lock()
+	w.lastReload = time.Now()
+	w.lastErr = err
+	w.mu.Unlock()
+}
+
+// diffConfig比较old/next各子树，返回实际发生变化的ChangeKind列表
+func diffConfig(old, next *Config) []ChangeKind {
+	var kinds []ChangeKind
+
+	if !reflect.DeepEqual(old.Logger, next.Logger) {
+		kinds = append(kinds, LoggerChanged)
+	}
+	if !reflect.DeepEqual(old.Proxy.EnabledTypes, next.Proxy.EnabledTypes) {
+		kinds = append(kinds, ProxyEnabledTypesChanged)
+	}
+	if !reflect.DeepEqual(old.Proxy.Monitor, next.Proxy.Monitor) {
+		kinds = append(kinds, MonitorChanged)
+	}
+	if !reflect.DeepEqual(old.API, next.API) {
+		kinds = append(kinds, APIChanged)
+	}
+
+	return kinds
+}
+
+// dispatch把next分发给kinds各自对应的所有订阅者
+func (w *Watcher) dispatch(kinds []ChangeKind, next *Config) {
+	w.subMu.Lock()
+	defer w.subMu.Unlock()
+
+	for _, kind := range kinds {
+		for _, fn := range w.subscribers[kind] {
+			fn(next)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package errorbus维护一个进程内的结构化错误事件环形缓冲区，供
+// pkg/logger的logrus Hook和pkg/proxy等组件发布错误，pkg/websocket的
+// CollectErrorMetrics据此聚合出真实的错误监控数据，不再是写死的模拟值。
+// 包名用errorbus而不是errors，避免和标准库errors包重名。
+package errorbus
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 严重级别取值，和CollectErrorMetrics里Critical/Warning两档分类对应
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// defaultCapacity是环形缓冲区的默认槽位数，超出后最老的事件被覆盖
+const defaultCapacity = 1024
+
+// defaultDedupWindow是同一(Component,Type,Message)事件被合并计数而不是
+// 各自占用一个槽位的时间窗口，避免日志风暴把缓冲区刷满
+const defaultDedupWindow = 10 * time.Second
+
+// dedupSweepInterval控制sweepDedup被调用的频率（按Publish调用次数计），
+// 防止dedupSeen里的过期条目无限堆积
+const dedupSweepInterval = 256
+
+// Event是一条结构化错误事件。Count表示该事件在去重窗口内被合并的次数，
+// 首次发布时为1
+type Event struct {
+	Timestamp time.Time
+	Severity  string
+	Component string
+	Type      string
+	Message   string
+	Count     int
+}
+
+// Bus是错误事件的环形缓冲区：写路径只靠atomic操作分配槽位，不加锁；
+// 去重判断单独用一把锁保护的map，两者互不阻塞彼此
+type Bus struct {
+	capacity uint64
+	events   []atomic.Pointer[Event]
+	cursor   atomic.Uint64
+
+	dedupWindow time.Duration
+	dedupMu     sync.Mutex
+	dedupSeen   map[string]*Event
+	publishSeq  atomic.Uint64
+}
+
+// NewBus创建一个容量为capacity、去重窗口为dedupWindow的Bus。capacity<=0时
+// 使用defaultCapacity，dedupWindow<=0时使用defaultDedupWindow
+func NewBus(capacity int, dedupWindow time.Duration) *Bus {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	if dedupWindow <= 0 {
+		dedupWindow = defaultDedupWindow
+	}
+	return &Bus{
+		capacity:    uint64(capacity),
+		events:      make([]atomic.Pointer[Event], capacity),
+		dedupWindow: dedupWindow,
+		dedupSeen:   make(map[string]*Event),
+	}
+}
+
+var (
+	globalOnce sync.Once
+	globalBus  *Bus
+)
+
+// Global返回进程级别的单例Bus，首次调用时以默认容量/去重窗口初始化
+func Global() *Bus {
+	globalOnce.Do(func() {
+		globalBus = NewBus(defaultCapacity, defaultDedupWindow)
+	})
+	return globalBus
+}
+
+// Publish发布一条错误事件。如果去重窗口内已经有相同(component,errType,message)
+// 的事件，只给已有事件的Count加一并刷新时间戳，不占用新槽位；否则分配一个新
+// 槽位写入
+func (b *Bus) Publish(severity, component, errType, message string) {
+	key := dedupKey(component, errType, message)
+	now := time.Now()
+
+	b.dedupMu.Lock()
+	if existing, ok := b.dedupSeen[key]; ok && now.Sub(existing.Timestamp) < b.dedupWindow {
+		existing.Count++
+		existing.Timestamp = now
+		b.dedupMu.Unlock()
+		return
+	}
+
+	event := &Event{
+		Timestamp: now,
+		Severity:  severity,
+		Component: component,
+		Type:      errType,
+		Message:   message,
+		Count:     1,
+	}
+	b.dedupSeen[key] = event
+	if b.publishSeq.Add(1)%dedupSweepInterval == 0 {
+		b.sweepDedupLocked(now)
+	}
+	b.dedupMu.Unlock()
+
+	idx := b.cursor.Add(1) - 1
+	b.events[idx%b.capacity].Store(event)
+}
+
+// sweepDedupLocked清掉dedupSeen里已经滑出去重窗口的条目，调用方需持有dedupMu
+func (b *Bus) sweepDedupLocked(now time.Time) {
+	for key, event := range b.dedupSeen {
+		if now.Sub(event.Timestamp) >= b.dedupWindow {
+			delete(b.dedupSeen, key)
+		}
+	}
+}
+
+// Snapshot返回当前缓冲区里所有事件的副本，按时间戳升序排列
+func (b *Bus) Snapshot() []Event {
+	events := make([]Event, 0, b.capacity)
+	for i := range b.events {
+		if e := b.events[i].Load(); e != nil {
+			events = append(events, *e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events
+}
+
+// Tail返回按时间戳从新到旧排列的最近n条事件，供新的websocket任务把错误
+// 尾部传回server端排查问题。n<=0时返回nil，缓冲区为空时返回长度为0的切片
+func (b *Bus) Tail(n int) []Event {
+	if n <= 0 {
+		return nil
+	}
+	events := b.Snapshot()
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.After(events[j].Timestamp)
+	})
+	if len(events) > n {
+		events = events[:n]
+	}
+	return events
+}
+
+// dedupKey把(component,errType,message)折叠成一个字符串key，message本身
+// 可能很长甚至包含动态内容，只取其sha256前8字节的hex拼进key，避免map key过大
+func dedupKey(component, errType, message string) string {
+	sum := sha256.Sum256([]byte(message))
+	return component + "|" + errType + "|" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,51 @@
+package errorbus
+
+import "github.com/sirupsen/logrus"
+
+// componentFieldKeys是按优先级依次尝试从logrus.Entry.Data里取Component标签
+// 的字段名。大部分调用点走logger.LogError，它直接用全局logger而不是
+// component专属logger，所以entry.Data里通常没有"component"字段，这里退而
+// 求其次看有没有"proxy_type"这类更具体的上下文字段
+var componentFieldKeys = []string{"component", "proxy_type", "task_type"}
+
+// LogrusHook把logrus在Warn级别及以上产生的日志条目发布到Bus里，作为
+// errorbus除了各组件显式Publish调用之外的另一个事件来源，做到日志里看到
+// 的warn/error基本都能在CollectErrorMetrics里统计到
+type LogrusHook struct {
+	bus *Bus
+}
+
+// NewLogrusHook返回一个把日志事件发布到bus的logrus.Hook
+func NewLogrusHook(bus *Bus) *LogrusHook {
+	return &LogrusHook{bus: bus}
+}
+
+// Levels只关心Warn及以上，Debug/Info级别的日志不构成"错误"
+func (h *LogrusHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel, logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel}
+}
+
+// Fire把entry映射成一条Event并发布，component/error_type取不到时落到
+// "unknown"/"log"，不让整条日志因为缺字段被丢弃
+func (h *LogrusHook) Fire(entry *logrus.Entry) error {
+	severity := SeverityWarning
+	if entry.Level <= logrus.ErrorLevel {
+		severity = SeverityCritical
+	}
+
+	component := "unknown"
+	for _, key := range componentFieldKeys {
+		if v, ok := entry.Data[key].(string); ok && v != "" {
+			component = v
+			break
+		}
+	}
+
+	errType, _ := entry.Data["error_type"].(string)
+	if errType == "" {
+		errType = "log"
+	}
+
+	h.bus.Publish(severity, component, errType, entry.Message)
+	return nil
+}
@@ -0,0 +1,236 @@
+// Package geoip把"根据IP查询地理位置"这件事从上报逻辑中剥离出来：Resolver
+// 包装一个可插拔的Driver（MaxMind GeoLite2 mmdb或ip2region xdb），懒加载数据
+// 库文件，并在文件发生变化时自动热重载。任何一步失败都只返回零值Location，
+// 调用方应据此让上报在未配置或查询失败时优雅降级，而不是报错。
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultCacheSize = 4096
+
+// Location 是一次查询得到的地理位置信息，字段均为zero value表示未知
+type Location struct {
+	Continent string
+	Country   string
+	Province  string
+	City      string
+	ISP       string
+	ASN       string // 形如"AS15169 Google LLC"，字段为空表示数据库未提供ASN信息
+	Latitude  float64
+	Longitude float64
+	TimeZone  string
+}
+
+// Driver 是具体IP库格式的查询实现，mmdbDriver和ip2regionDriver各有一份
+type Driver interface {
+	Lookup(ip net.IP) (Location, error)
+	// Close 释放底层文件句柄/内存映射
+	Close() error
+}
+
+// openDriver按cfg.Driver打开对应格式的数据库文件
+func openDriver(cfg config.GeoIPConfig) (Driver, error) {
+	switch cfg.Driver {
+	case "maxmind":
+		return openMaxMindDriver(cfg.DatabasePath)
+	case "ip2region":
+		return openIP2RegionDriver(cfg.DatabasePath)
+	default:
+		return nil, fmt.Errorf("不支持的geoip驱动: %s", cfg.Driver)
+	}
+}
+
+// Resolver 懒加载cfg指定的IP库，并周期性检查文件mtime以支持热重载（例如运维
+// 热更新了一份更新的GeoLite2库文件）。Driver为"none"或未配置时Resolver仍可
+// 构造，但Lookup总是返回空Location
+type Resolver struct {
+	cfg config.GeoIPConfig
+	log *logrus.Entry
+
+	mu      sync.RWMutex
+	driver  Driver
+	modTime time.Time
+
+	// cache按/24（v4）或/48（v6）网段聚合查询结果，避免同一网段内的连接反复触发
+	// driver查询——对proxy连接这种短时间内大量来自同一运营商/机房网段IP的场景
+	// 尤其有效
+	cache *lruCache
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// New创建一个Resolver。数据库本身在第一次Lookup调用时才会被打开（懒加载），
+// 这里只记录配置并在Driver可用时启动热重载监视协程
+func New(cfg config.GeoIPConfig) *Resolver {
+	cacheSize := cfg.CacheSize
+	if cacheSize <= 0 {
+		cacheSize = defaultCacheSize
+	}
+
+	r := &Resolver{
+		cfg:    cfg,
+		log:    logger.GetComponentLogger("geoip"),
+		cache:  newLRUCache(cacheSize),
+		stopCh: make(chan struct{}),
+	}
+
+	if r.enabled() {
+		go r.watchLoop()
+	}
+
+	return r
+}
+
+func (r *Resolver) enabled() bool {
+	return r.cfg.Driver != "" && r.cfg.Driver != "none" && r.cfg.DatabasePath != ""
+}
+
+// Lookup 查询ipStr对应的地理位置，任何错误（未配置、IP非法、库未加载、驱动
+// 查询失败）都返回(Location{}, err)，调用方应把err当作"跳过这些字段"处理，
+// 而不是让整个上报失败
+func (r *Resolver) Lookup(ipStr string) (Location, error) {
+	if !r.enabled() || ipStr == "" {
+		return Location{}, fmt.Errorf("geoip未启用或IP为空")
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return Location{}, fmt.Errorf("非法IP地址: %s", ipStr)
+	}
+
+	key := cacheKey(ip)
+	if loc, ok := r.cache.get(key); ok {
+		return loc, nil
+	}
+
+	driver, err := r.ensureLoaded()
+	if err != nil {
+		return Location{}, err
+	}
+
+	loc, err := driver.Lookup(ip)
+	if err != nil {
+		return Location{}, err
+	}
+
+	r.cache.put(key, loc)
+	return loc, nil
+}
+
+// cacheKey把ip归一化成它所在网段的起始地址作为缓存键：v4取/24，v6取/48——
+// 同一网段的IP通常属于同一运营商/机房，查询结果趋同，聚合缓存能显著减少
+// driver查询次数
+func cacheKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		mask := net.CIDRMask(24, 32)
+		return v4.Mask(mask).String()
+	}
+	mask := net.CIDRMask(48, 128)
+	return ip.Mask(mask).String()
+}
+
+// ensureLoaded 懒加载数据库文件，已加载时直接返回缓存的driver
+func (r *Resolver) ensureLoaded() (Driver, error) {
+	r.mu.RLock()
+	if r.driver != nil {
+		d := r.driver
+		r.mu.RUnlock()
+		return d, nil
+	}
+	r.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.driver != nil {
+		return r.driver, nil
+	}
+
+	driver, err := openDriver(r.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("加载geoip数据库失败: %w", err)
+	}
+	r.driver = driver
+
+	if info, statErr := os.Stat(r.cfg.DatabasePath); statErr == nil {
+		r.modTime = info.ModTime()
+	}
+
+	return driver, nil
+}
+
+// watchLoop 按ReloadInterval轮询数据库文件的mtime，一旦变化就重新打开，替换
+// 掉旧的driver；旧driver留给垃圾回收，期间仍在途的查询继续使用它不受影响
+func (r *Resolver) watchLoop() {
+	interval := time.Duration(r.cfg.ReloadInterval) * time.Second
+	if interval <= 0 {
+		interval = 60 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			r.reloadIfChanged()
+		}
+	}
+}
+
+func (r *Resolver) reloadIfChanged() {
+	info, err := os.Stat(r.cfg.DatabasePath)
+	if err != nil {
+		return
+	}
+
+	r.mu.RLock()
+	unchanged := r.driver == nil || info.ModTime().Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	driver, err := openDriver(r.cfg)
+	if err != nil {
+		r.log.WithError(err).Warn("热重载geoip数据库失败，继续使用旧数据")
+		return
+	}
+
+	r.mu.Lock()
+	old := r.driver
+	r.driver = driver
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	r.cache.purge()
+	r.log.WithField("path", r.cfg.DatabasePath).Info("geoip数据库已热重载")
+}
+
+// Close 停止热重载协程并释放底层数据库
+func (r *Resolver) Close() error {
+	r.stopOnce.Do(func() { close(r.stopCh) })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.driver != nil {
+		err := r.driver.Close()
+		r.driver = nil
+		return err
+	}
+	return nil
+}
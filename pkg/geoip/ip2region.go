@@ -0,0 +1,51 @@
+package geoip
+
+import (
+	"net"
+	"strings"
+
+	xdb "github.com/lionsoul2014/ip2region/binding/golang/xdb"
+)
+
+// ip2regionDriver 查询ip2region v2格式（.xdb）的离线IP库，常用于中国大陆
+// 网络环境下的部署，避免依赖MaxMind的在线/商业授权
+type ip2regionDriver struct {
+	searcher *xdb.Searcher
+}
+
+func openIP2RegionDriver(path string) (Driver, error) {
+	searcher, err := xdb.NewWithFileOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ip2regionDriver{searcher: searcher}, nil
+}
+
+// Lookup ip2region.xdb的查询结果是"国家|区域|省份|城市|ISP"形式的管道分隔
+// 字符串，这里按固定顺序拆分映射到Location
+func (d *ip2regionDriver) Lookup(ip net.IP) (Location, error) {
+	region, err := d.searcher.SearchByStr(ip.String())
+	if err != nil {
+		return Location{}, err
+	}
+
+	parts := strings.Split(region, "|")
+	get := func(i int) string {
+		if i < len(parts) && parts[i] != "0" {
+			return parts[i]
+		}
+		return ""
+	}
+
+	return Location{
+		Country:  get(0),
+		Province: get(2),
+		City:     get(3),
+		ISP:      get(4),
+	}, nil
+}
+
+func (d *ip2regionDriver) Close() error {
+	d.searcher.Close()
+	return nil
+}
@@ -0,0 +1,83 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// mmdbDriver 查询MaxMind GeoLite2格式（.mmdb）的IP库
+type mmdbDriver struct {
+	reader *maxminddb.Reader
+}
+
+func openMaxMindDriver(path string) (Driver, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &mmdbDriver{reader: reader}, nil
+}
+
+// mmdbRecord 对应GeoLite2-City.mmdb的常见字段结构，只取上报需要的子集
+type mmdbRecord struct {
+	Continent struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"continent"`
+	Country struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+		TimeZone  string  `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+	Traits struct {
+		ISP                          string `maxminddb:"isp"`
+		AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+		AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+	} `maxminddb:"traits"`
+}
+
+func (d *mmdbDriver) Lookup(ip net.IP) (Location, error) {
+	var record mmdbRecord
+	if err := d.reader.Lookup(ip, &record); err != nil {
+		return Location{}, err
+	}
+
+	province := ""
+	if len(record.Subdivisions) > 0 {
+		province = record.Subdivisions[0].Names["en"]
+	}
+
+	asn := ""
+	if record.Traits.AutonomousSystemNumber != 0 {
+		asn = fmt.Sprintf("AS%d", record.Traits.AutonomousSystemNumber)
+		if record.Traits.AutonomousSystemOrganization != "" {
+			asn += " " + record.Traits.AutonomousSystemOrganization
+		}
+	}
+
+	return Location{
+		Continent: record.Continent.Names["en"],
+		Country:   record.Country.Names["en"],
+		Province:  province,
+		City:      record.City.Names["en"],
+		ISP:       record.Traits.ISP,
+		ASN:       asn,
+		Latitude:  record.Location.Latitude,
+		Longitude: record.Location.Longitude,
+		TimeZone:  record.Location.TimeZone,
+	}, nil
+}
+
+func (d *mmdbDriver) Close() error {
+	return d.reader.Close()
+}
@@ -2,6 +2,7 @@ package iptables
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -18,6 +19,110 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// 地址族取值，用在Rule.Family、model.IptablesConfig.IpFamily以及按族选择
+// iptables/ip6tables命令的地方。FamilyBoth的规则会被同时下发到v4和v6两条
+// 流水线，适用于不含具体地址字面量、两个协议族都适用的规则
+const (
+	FamilyV4   = "v4"
+	FamilyV6   = "v6"
+	FamilyBoth = "both"
+)
+
+// iptablesExecutor抽象实际执行iptables/iptables-save/iptables-restore命令
+// 的那一层，生产环境下由realIPTablesExecutor实现、直接fork子进程；单元测试
+// 用fake实现替换掉，既能断言调用次数，也不需要真的有iptables可执行文件
+type iptablesExecutor interface {
+	Save() ([]byte, error)
+	Restore(path string) ([]byte, error)
+	RestoreFull(path string) ([]byte, error)
+	Run(args ...string) ([]byte, error)
+	RestoreAvailable() bool
+}
+
+// realIPTablesExecutor是iptablesExecutor在生产环境下的默认实现，直接调用
+// 宿主机PATH上的iptables/ip6tables系列命令，具体调用哪一族由family决定
+type realIPTablesExecutor struct {
+	family string // FamilyV4 或 FamilyV6
+}
+
+func (e realIPTablesExecutor) saveCommand() string {
+	if e.family == FamilyV6 {
+		return "ip6tables-save"
+	}
+	return "iptables-save"
+}
+
+func (e realIPTablesExecutor) restoreCommand() string {
+	if e.family == FamilyV6 {
+		return "ip6tables-restore"
+	}
+	return "iptables-restore"
+}
+
+func (e realIPTablesExecutor) baseCommand() string {
+	if e.family == FamilyV6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+func (e realIPTablesExecutor) Save() ([]byte, error) {
+	return exec.Command(e.saveCommand()).Output()
+}
+
+func (e realIPTablesExecutor) Restore(path string) ([]byte, error) {
+	// --noflush让restore只按输入内容做增量替换，不在应用前清空涉及的表，
+	// 避免整张表规则在restore瞬间被清空、产生短暂的放行/拦截空窗
+	return exec.Command(e.restoreCommand(), "--noflush", path).CombinedOutput()
+}
+
+func (e realIPTablesExecutor) RestoreFull(path string) ([]byte, error) {
+	return exec.Command(e.restoreCommand(), path).CombinedOutput()
+}
+
+func (e realIPTablesExecutor) Run(args ...string) ([]byte, error) {
+	return exec.Command(e.baseCommand(), args...).CombinedOutput()
+}
+
+func (e realIPTablesExecutor) RestoreAvailable() bool {
+	_, err := exec.LookPath(e.restoreCommand())
+	return err == nil
+}
+
+// ipsetExecutor抽象实际执行ipset命令的那一层，和iptablesExecutor是同样的
+// 设计：生产环境下由realIPSetExecutor实现、直接fork子进程，单元测试可以换
+// 成记录调用次数的替身
+type ipsetExecutor interface {
+	Available() bool
+	Save() ([]byte, error)
+	Restore(content string) ([]byte, error)
+	Destroy(name string) ([]byte, error)
+}
+
+// realIPSetExecutor是ipsetExecutor在生产环境下的默认实现，一个Manager只需
+// 要一份（不像iptablesExecutor那样按v4/v6区分），ipset本身通过create语句里
+// 的`family inet`/`family inet6`区分地址族，不需要切换到另一个可执行文件
+type realIPSetExecutor struct{}
+
+func (realIPSetExecutor) Available() bool {
+	_, err := exec.LookPath("ipset")
+	return err == nil
+}
+
+func (realIPSetExecutor) Save() ([]byte, error) {
+	return exec.Command("ipset", "save").Output()
+}
+
+func (realIPSetExecutor) Restore(content string) ([]byte, error) {
+	cmd := exec.Command("ipset", "restore", "-exist")
+	cmd.Stdin = strings.NewReader(content)
+	return cmd.CombinedOutput()
+}
+
+func (realIPSetExecutor) Destroy(name string) ([]byte, error) {
+	return exec.Command("ipset", "destroy", name).CombinedOutput()
+}
+
 // Rule 表示一条iptables规则
 type Rule struct {
 	ID      string `json:"id"`
@@ -26,6 +131,19 @@ type Rule struct {
 	Rule    string `json:"rule"`   // 完整的规则内容
 	Action  string `json:"action"` // add, insert, delete
 	Enabled bool   `json:"enabled"`
+	// Family是这条规则所属的地址族：FamilyV4/FamilyV6/FamilyBoth，留空按
+	// FamilyV4处理（兼容chunk10-3之前写入的managedRules）
+	Family string `json:"family"`
+
+	// SourceSet/DestSet是这条规则引用的ipset名称（config.IPTablesConfig.
+	// UseIPSet启用且对应的SourceIpSet/DestIpSet非空时才会设置），Rule里已经
+	// 包含了引用它的`-m set --match-set <name> src/dst`子句；
+	// SourceSetMembers/DestSetMembers是这个集合应该包含的IP/网段，供
+	// generateIPSetRestoreContent生成对应的ipset create/add语句
+	SourceSet        string   `json:"source_set,omitempty"`
+	DestSet          string   `json:"dest_set,omitempty"`
+	SourceSetMembers []string `json:"source_set_members,omitempty"`
+	DestSetMembers   []string `json:"dest_set_members,omitempty"`
 }
 
 // RuleSet 规则集合，用于配置对比
@@ -35,23 +153,104 @@ type RuleSet map[string]*Rule // key: 规则的唯一标识符
 type ManagerInterface interface {
 	UpdateRulesFromProto(configs []*model.IptablesConfig) error
 	GetRulesSummary() map[string]interface{}
+
+	// CheckDrift比较内核里当前实际生效的规则和最近一次UpdateRulesFromProto
+	// 应用后记在内存里的managedRules快照，用于health_check任务发现"Agent认为
+	// 规则已下发，但实际被外部工具/人工改动或重启后丢失"这类漂移
+	CheckDrift() (*RuleDriftReport, error)
+}
+
+// RuleDriftReport是CheckDrift的结果：Managed是上一次UpdateRulesFromProto应用
+// 后内存里记录的规则数量，Live是重新从内核读取到的当前实际生效的规则数量，
+// Drifted为true表示两者不一致。v4/v6两族分别探测后汇总，只比较数量、不逐条
+// 比对规则内容，足以发现"规则被整体清空/未能恢复"这类最常见的漂移场景
+type RuleDriftReport struct {
+	Managed int
+	Live    int
+	Drifted bool
 }
 
-// Manager 基于iptables-save/restore的管理器
+// defaultRuleCacheTTL是getCachedTables复用已解析规则状态的最长时间，超过
+// 这个时间即使我们自己没有发起过变更，也重新跑一次iptables-save，以便发现
+// 外部工具/人工改动
+const defaultRuleCacheTTL = 30 * time.Second
+
+// familyRuntime持有某一个地址族（v4或v6）专属的运行时状态：用哪个
+// iptablesExecutor（对应iptables还是ip6tables系列命令）、规则文件写到哪、
+// 备份文件名前缀，以及这个族自己的懒加载系统规则缓存。v4/v6两条流水线各自
+// 独立失败、独立回滚，互不影响
+type familyRuntime struct {
+	exec          iptablesExecutor
+	rulesFilePath string
+	backupPrefix  string
+
+	// cacheMu单独保护下面这份"系统当前实际规则"的懒加载缓存，和Manager.mu
+	// 保护的managedRules是两件不同的事：managedRules是我们自己上一轮下发后
+	// 记住的期望状态，tables是我们认为内核当前实际生效的状态
+	cacheMu  sync.Mutex
+	tables   map[string]*IPTablesTable
+	cachedAt time.Time
+	cacheTTL time.Duration
+}
+
+// Manager 基于iptables-save/restore的管理器，v4/v6两族共享managedRules和
+// 统计信息，各自的执行命令、规则文件、系统状态缓存通过families区分
 type Manager struct {
 	config          config.IPTablesConfig
 	mu              sync.RWMutex
-	rulesFilePath   string
 	backupDir       string
 	templateManager *TemplateManager
+	families        map[string]*familyRuntime // FamilyV4/FamilyV6 -> 对应的运行时状态
 
-	// 当前管理的规则状态
+	// 当前管理的规则状态，v4/v6规则共用同一份ID空间
 	managedRules map[string]*Rule // rule ID -> rule
 	lastUpdate   time.Time
+	stats        ManagerStats // 最近一次应用规则的统计信息
+
+	// desired是UpdateRulesFromProto最近一次转换后的期望规则集合，由runner
+	// 的同步goroutine在syncOnce里读取并实际应用。UpdateRulesFromProto本身
+	// 只负责在这里落子，真正的备份/生成/apply工作交给runner协调频率
+	desired RuleSet
+	runner  *boundedFrequencyRunner
+
+	// ipsetExec/ipsetsFilePath只在cfg.UseIPSet为true时真正用到：
+	// ipsetExec是实际执行ipset命令的那一层，ipsetsFilePath是`ipset save`格式
+	// 的规则文件落盘路径，和families[*].rulesFilePath是同一层级的概念，但
+	// ipset不分v4/v6两个文件
+	ipsetExec      ipsetExecutor
+	ipsetsFilePath string
 }
 
-// NewManager 创建新的iptables管理器
+// NewManager 根据cfg.Backend选择规则管理后端并创建对应的管理器。"auto"会先
+// 探测宿主机上nftables的可用性（参考kube-proxy在iptables/ipvs/nftables代理
+// 模式间的探测方式），其余取值直接生效，未识别的值按"iptables"处理
 func NewManager(cfg config.IPTablesConfig) ManagerInterface {
+	backend := cfg.Backend
+	if backend == "" {
+		backend = "iptables"
+	}
+	if backend == "auto" {
+		backend = detectBackend()
+	}
+
+	log := logger.GetIPTablesLogger()
+	log.WithField("backend", backend).Info("选定iptables规则管理后端")
+
+	if backend == "nftables" {
+		nftMgr, err := newNFTablesManager(cfg)
+		if err != nil {
+			logger.LogError(err, "初始化nftables管理器失败，回退到iptables-restore", nil)
+		} else {
+			return nftMgr
+		}
+	}
+
+	return newIPTablesRestoreManager(cfg)
+}
+
+// newIPTablesRestoreManager 创建基于iptables-save/iptables-restore的管理器，
+// 是"iptables"/"iptables-restore"后端以及nftables初始化失败时的兜底实现
+func newIPTablesRestoreManager(cfg config.IPTablesConfig) ManagerInterface {
 	rulesDir := "/etc/nspass/iptables"
 	if cfg.BackupPath != "" {
 		rulesDir = cfg.BackupPath
@@ -65,12 +264,27 @@ func NewManager(cfg config.IPTablesConfig) ManagerInterface {
 		templateManager = nil
 	}
 
+	backupDir := filepath.Join(rulesDir, "backup")
+
 	manager := &Manager{
 		config:          cfg,
-		rulesFilePath:   filepath.Join(rulesDir, "rules.v4"),
-		backupDir:       filepath.Join(rulesDir, "backup"),
+		backupDir:       backupDir,
 		templateManager: templateManager,
 		managedRules:    make(map[string]*Rule),
+		families: map[string]*familyRuntime{
+			FamilyV4: {
+				exec:          realIPTablesExecutor{family: FamilyV4},
+				rulesFilePath: filepath.Join(rulesDir, "rules.v4"),
+				backupPrefix:  "iptables_backup_",
+				cacheTTL:      defaultRuleCacheTTL,
+			},
+			FamilyV6: {
+				exec:          realIPTablesExecutor{family: FamilyV6},
+				rulesFilePath: filepath.Join(rulesDir, "rules.v6"),
+				backupPrefix:  "ip6tables_backup_",
+				cacheTTL:      defaultRuleCacheTTL,
+			},
+		},
 	}
 
 	// 确保目录存在
@@ -85,176 +299,755 @@ func NewManager(cfg config.IPTablesConfig) ManagerInterface {
 		})
 	}
 
+	manager.ipsetExec = realIPSetExecutor{}
+	manager.ipsetsFilePath = filepath.Join(rulesDir, "ipsets")
+
+	minInterval := time.Duration(cfg.MinSyncIntervalSeconds) * time.Second
+	maxInterval := time.Duration(cfg.MaxSyncIntervalSeconds) * time.Second
+	manager.runner = newBoundedFrequencyRunner("iptables-sync", manager.syncOnce, minInterval, maxInterval)
+	manager.runner.Start()
+
 	logger.LogStartup("iptables-manager", "1.0", map[string]interface{}{
-		"enabled":      cfg.Enable,
-		"chain_prefix": cfg.ChainPrefix,
-		"rules_file":   manager.rulesFilePath,
-		"backup_dir":   manager.backupDir,
+		"enabled":           cfg.Enable,
+		"chain_prefix":      cfg.ChainPrefix,
+		"rules_file_v4":     manager.families[FamilyV4].rulesFilePath,
+		"rules_file_v6":     manager.families[FamilyV6].rulesFilePath,
+		"backup_dir":        manager.backupDir,
+		"min_sync_interval": minInterval,
+		"max_sync_interval": maxInterval,
+		"use_ipset":         cfg.UseIPSet,
 	})
 
 	return manager
 }
 
-// UpdateRulesFromProto 使用proto配置更新iptables规则
-func (m *Manager) UpdateRulesFromProto(configs []*model.IptablesConfig) error {
-	if !m.config.Enable {
-		logger.GetIPTablesLogger().Info("iptables管理已禁用，跳过规则更新")
-		return nil
+// detectFamily决定一条proto配置应该下发到v4还是v6：优先读取配置上显式的
+// IpFamily字段（取值"v4"/"v6"/"both"，大小写不敏感），未设置或无法识别时
+// 退化为检测SourceIp/DestIp里是否包含":"（IPv6地址字面量的明显特征），两者
+// 都判断不出来时默认v4
+func detectFamily(cfg *model.IptablesConfig) string {
+	switch strings.ToLower(cfg.IpFamily) {
+	case FamilyV6:
+		return FamilyV6
+	case FamilyBoth:
+		return FamilyBoth
+	case FamilyV4:
+		return FamilyV4
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	startTime := time.Now()
-	log := logger.GetIPTablesLogger()
-
-	log.WithField("config_count", len(configs)).Info("使用proto配置更新iptables规则")
+	if strings.Contains(cfg.SourceIp, ":") || strings.Contains(cfg.DestIp, ":") {
+		return FamilyV6
+	}
+	return FamilyV4
+}
 
-	// 1. 备份当前规则
-	if err := m.backupCurrentRules(); err != nil {
-		logger.LogError(err, "备份当前规则失败", nil)
+// splitRulesByFamily按Rule.Family把rules拆成v4/v6两个独立子集。Family为
+// FamilyBoth的规则同时出现在两个子集里；留空按v4处理
+func splitRulesByFamily(rules map[string]*Rule) (v4, v6 map[string]*Rule) {
+	v4 = make(map[string]*Rule, len(rules))
+	v6 = make(map[string]*Rule)
+	for id, rule := range rules {
+		switch rule.Family {
+		case FamilyV6:
+			v6[id] = rule
+		case FamilyBoth:
+			v4[id] = rule
+			v6[id] = rule
+		default:
+			v4[id] = rule
+		}
 	}
+	return v4, v6
+}
 
-	// 2. 转换proto配置为内部规则格式
-	newRules := make(map[string]*Rule)
-	enabledCount := 0
-	for _, config := range configs {
-		if !config.IsEnabled {
-			log.WithField("config_id", config.Id).Debug("跳过已禁用的iptables配置")
+// convertProtoConfigs把proto配置转换成内部Rule格式，每条规则的Family按
+// detectFamily的结果标注，供UpdateRulesFromProto和RenderRulesFromProto共用
+func (m *Manager) convertProtoConfigs(configs []*model.IptablesConfig) map[string]*Rule {
+	log := logger.GetIPTablesLogger()
+	newRules := make(map[string]*Rule, len(configs))
+
+	for _, cfg := range configs {
+		if !cfg.IsEnabled {
+			log.WithField("config_id", cfg.Id).Debug("跳过已禁用的iptables配置")
 			continue
 		}
 
 		// 转换proto配置为规则参数
-		table, chain, ruleText := api.ConvertProtoIptablesConfigToRuleParts(config)
+		table, chain, ruleText := api.ConvertProtoIptablesConfigToRuleParts(cfg)
+		family := detectFamily(cfg)
 
 		rule := &Rule{
-			ID:      fmt.Sprintf("%d", config.Id),
+			ID:      fmt.Sprintf("%d", cfg.Id),
 			Table:   table,
 			Chain:   chain,
 			Rule:    ruleText,
 			Action:  "add",
-			Enabled: config.IsEnabled,
+			Enabled: cfg.IsEnabled,
+			Family:  family,
+		}
+
+		if m.config.UseIPSet && (len(cfg.SourceIpSet) > 0 || len(cfg.DestIpSet) > 0) {
+			if !m.ipsetExec.Available() {
+				// ipset二进制不存在时fail-closed：整条规则跳过，而不是退化成
+				// 不带集合匹配、范围过宽的规则
+				log.WithField("config_id", cfg.Id).Warn("配置引用了ipset但宿主机未安装ipset，跳过该规则")
+				continue
+			}
+
+			if len(cfg.SourceIpSet) > 0 {
+				rule.SourceSet = fmt.Sprintf("%s%s_src", m.config.ChainPrefix, rule.ID)
+				rule.SourceSetMembers = cfg.SourceIpSet
+				rule.Rule += fmt.Sprintf(" -m set --match-set %s src", rule.SourceSet)
+			}
+			if len(cfg.DestIpSet) > 0 {
+				rule.DestSet = fmt.Sprintf("%s%s_dst", m.config.ChainPrefix, rule.ID)
+				rule.DestSetMembers = cfg.DestIpSet
+				rule.Rule += fmt.Sprintf(" -m set --match-set %s dst", rule.DestSet)
+			}
 		}
 
 		newRules[rule.ID] = rule
-		enabledCount++
 
 		log.WithFields(logrus.Fields{
-			"config_id": config.Id,
-			"server_id": config.ServerId,
+			"config_id": cfg.Id,
+			"server_id": cfg.ServerId,
 			"table":     rule.Table,
 			"chain":     rule.Chain,
 			"rule":      rule.Rule,
+			"family":    rule.Family,
 		}).Debug("转换proto iptables配置为规则")
 	}
 
+	return newRules
+}
+
+// UpdateRulesFromProto 使用proto配置更新iptables规则。转换、存入m.desired
+// 之后立即返回，实际的备份/生成/apply工作由runner的同步goroutine在syncOnce
+// 里完成：短时间内的多次调用会被runner合并成一次实际同步，调用方不再需要
+// 自己限流。类似kube-proxy的BoundedFrequencyRunner模式——Service/Endpoints
+// 事件只负责请求一次syncProxyRules，真正执行交给runner统一调度
+func (m *Manager) UpdateRulesFromProto(configs []*model.IptablesConfig) error {
+	if !m.config.Enable {
+		logger.GetIPTablesLogger().Info("iptables管理已禁用，跳过规则更新")
+		return nil
+	}
+
+	log := logger.GetIPTablesLogger()
+	log.WithField("config_count", len(configs)).Info("使用proto配置更新iptables规则")
+
+	newRules := m.convertProtoConfigs(configs)
+
 	log.WithFields(logrus.Fields{
 		"total_configs":   len(configs),
-		"enabled_configs": enabledCount,
-	}).Info("配置转换完成")
+		"enabled_configs": len(newRules),
+	}).Info("配置转换完成，已提交给同步runner")
 
-	// 3. 获取当前完整的iptables规则
-	currentRulesContent, err := m.getCurrentRulesContent()
-	if err != nil {
-		return fmt.Errorf("获取当前规则失败: %w", err)
+	m.mu.Lock()
+	m.desired = newRules
+	m.mu.Unlock()
+
+	m.runner.Run()
+	return nil
+}
+
+// syncOnce是boundedFrequencyRunner实际执行的同步函数，按Family把m.desired
+// 拆成v4/v6两份分别同步。两族各自独立应用、独立失败回滚：一族apply失败不会
+// 影响另一族已经成功落地的结果，失败的那族managedRules保留上一轮状态，下次
+// runner触发时会针对它重新计算diff并重试
+func (m *Manager) syncOnce() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.desired == nil {
+		return nil
 	}
+	newRules := m.desired
 
-	// 4. 生成新的规则文件内容
-	newRulesContent, err := m.generateRulesContent(currentRulesContent, newRules)
-	if err != nil {
-		return fmt.Errorf("生成新规则内容失败: %w", err)
+	startTime := time.Now()
+	log := logger.GetIPTablesLogger()
+
+	// 期望规则和上一轮内存里的managedRules完全一致时，没有必要备份、重新
+	// iptables-save+解析、生成规则内容、apply——直接跳过
+	added, removed, _ := m.diffLocked(newRules)
+	if len(added) == 0 && len(removed) == 0 {
+		m.stats.SkippedApplies++
+		m.lastUpdate = time.Now()
+		log.Info("期望规则与当前管理规则一致，跳过本轮规则生成与应用")
+		return nil
 	}
 
-	// 5. 应用新规则
-	if err := m.applyRules(newRulesContent); err != nil {
-		// 应用失败，尝试恢复
-		logger.LogError(err, "应用新规则失败，尝试恢复", nil)
-		if restoreErr := m.restoreFromBackup(); restoreErr != nil {
-			logger.LogError(restoreErr, "恢复规则失败", nil)
+	m.backupIPSets()
+
+	// 按Family拆分本轮desired规则和上一轮managedRules，v4/v6分别同步
+	v4Rules, v6Rules := splitRulesByFamily(newRules)
+	prevV4, prevV6 := splitRulesByFamily(m.managedRules)
+
+	finalManaged := make(map[string]*Rule, len(newRules))
+	var syncErrs []error
+
+	if err := m.syncFamily(FamilyV4, v4Rules, prevV4); err != nil {
+		syncErrs = append(syncErrs, fmt.Errorf("v4规则同步失败: %w", err))
+		for id, rule := range prevV4 {
+			finalManaged[id] = rule
+		}
+	} else {
+		for id, rule := range v4Rules {
+			finalManaged[id] = rule
 		}
-		return fmt.Errorf("应用规则失败: %w", err)
 	}
 
-	// 6. 保存规则文件
-	if err := m.saveRulesFile(newRulesContent); err != nil {
-		logger.LogError(err, "保存规则文件失败", nil)
+	if err := m.syncFamily(FamilyV6, v6Rules, prevV6); err != nil {
+		syncErrs = append(syncErrs, fmt.Errorf("v6规则同步失败: %w", err))
+		for id, rule := range prevV6 {
+			finalManaged[id] = rule
+		}
+	} else {
+		for id, rule := range v6Rules {
+			finalManaged[id] = rule
+		}
 	}
 
-	// 7. 更新内存状态
+	// 更新内存状态
 	oldRulesCount := len(m.managedRules)
-	m.managedRules = newRules
+	m.managedRules = finalManaged
 	m.lastUpdate = time.Now()
 
 	duration := time.Since(startTime)
 
 	// 记录性能指标
-	logger.LogPerformance("iptables_rules_update_from_proto", duration, logrus.Fields{
-		"configs_processed": len(configs),
-		"configs_enabled":   enabledCount,
-		"old_rules":         oldRulesCount,
-		"new_rules":         len(newRules),
+	logger.LogPerformance("iptables_rules_sync_once", duration, logrus.Fields{
+		"desired_rules": len(newRules),
+		"old_rules":     oldRulesCount,
+		"new_rules":     len(finalManaged),
 	})
 
 	log.WithFields(logrus.Fields{
 		"managed_rules": len(m.managedRules),
 		"last_update":   m.lastUpdate,
 		"duration_ms":   duration.Milliseconds(),
-	}).Info("iptables规则更新完成")
+	}).Info("iptables规则同步完成")
+
+	m.saveIPSetsFile(finalManaged)
+
+	if len(syncErrs) > 0 {
+		return errors.Join(syncErrs...)
+	}
+	return nil
+}
+
+// syncFamily对单个地址族（v4/v6）执行备份->生成规则内容->应用->保存文件这
+// 一整套流程。desired/prev分别是这个地址族本轮期望的规则集合和上一轮
+// managedRules里属于这个地址族的部分；desired和prev完全一致时直接跳过
+func (m *Manager) syncFamily(family string, desired, prev map[string]*Rule) error {
+	fr := m.families[family]
+	log := logger.GetIPTablesLogger().WithField("family", family)
+
+	comparison := compareRules(prev, desired)
+	if len(comparison.ToAdd) == 0 && len(comparison.ToDelete) == 0 {
+		return nil
+	}
+
+	// 备份当前规则
+	if err := m.backupCurrentRules(fr); err != nil {
+		logger.LogError(err, "备份当前规则失败", logrus.Fields{"family": family})
+	}
+
+	// 获取当前系统规则的解析状态，cacheTTL内复用上一次已经解析过的结果
+	tables, parseErrors, err := m.getCachedTables(fr)
+	if err != nil {
+		return fmt.Errorf("获取当前规则失败: %w", err)
+	}
+	m.stats.ParseErrors += parseErrors
+	if parseErrors > 0 {
+		log.WithField("parse_errors", parseErrors).Warn("当前规则解析中存在无法识别的行，已跳过")
+	}
+
+	// 生成新的规则文件内容，full模式下tables被就地修改为应用后的最终状态
+	content, err := m.generateRulesContent(tables, desired, prev)
+	if err != nil {
+		return fmt.Errorf("生成新规则内容失败: %w", err)
+	}
+
+	if err := m.applyIPSets(desired); err != nil {
+		return fmt.Errorf("应用ipset失败: %w", err)
+	}
+
+	if err := m.applyRules(content, comparison, fr); err != nil {
+		// 应用失败，尝试恢复；缓存的系统状态已经不可信，强制下一轮重新探测
+		logger.LogError(err, "应用新规则失败，尝试恢复", logrus.Fields{"family": family})
+		m.invalidateCachedTables(fr)
+		if restoreErr := m.restoreFromBackup(fr); restoreErr != nil {
+			logger.LogError(restoreErr, "恢复规则失败", logrus.Fields{"family": family})
+		}
+		return fmt.Errorf("应用规则失败: %w", err)
+	}
+
+	// apply成功后，tables就是内核里现在的实际状态，直接缓存下来，避免下一轮
+	// 为了对账再跑一次iptables-save/ip6tables-save
+	m.setCachedTables(fr, tables)
+
+	if err := m.saveRulesFile(content, fr); err != nil {
+		logger.LogError(err, "保存规则文件失败", logrus.Fields{"family": family})
+	}
 
+	log.WithField("rules", len(desired)).Info("规则同步完成")
 	return nil
 }
 
-// getCurrentRulesContent 获取当前的iptables规则内容
-func (m *Manager) getCurrentRulesContent() (string, error) {
+// generateIPSetRestoreContent 遍历rules里所有带SourceSet/DestSet的规则，为
+// 每个集合生成一条`create <name> <type> family <inet|inet6> -exist`、一条
+// `flush <name>`和若干条`add <name> <member>`，拼成`ipset restore`能直接
+// 消费的文本。集合类型按成员是否包含"/"在hash:net（网段）和hash:ip（单个
+// 地址）之间选择；family按成员里是否出现IPv6地址（含":"）在inet和inet6
+// 之间选择
+func generateIPSetRestoreContent(rules map[string]*Rule) string {
+	type setDef struct {
+		name    string
+		members []string
+	}
+
+	var sets []setDef
+	for _, rule := range rules {
+		if rule.SourceSet != "" {
+			sets = append(sets, setDef{name: rule.SourceSet, members: rule.SourceSetMembers})
+		}
+		if rule.DestSet != "" {
+			sets = append(sets, setDef{name: rule.DestSet, members: rule.DestSetMembers})
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].name < sets[j].name })
+
+	var content strings.Builder
+	for _, set := range sets {
+		setType := "hash:ip"
+		for _, member := range set.members {
+			if strings.Contains(member, "/") {
+				setType = "hash:net"
+				break
+			}
+		}
+
+		family := "inet"
+		for _, member := range set.members {
+			if strings.Contains(member, ":") {
+				family = "inet6"
+				break
+			}
+		}
+
+		content.WriteString(fmt.Sprintf("create %s %s family %s -exist\n", set.name, setType, family))
+		content.WriteString(fmt.Sprintf("flush %s\n", set.name))
+		for _, member := range set.members {
+			content.WriteString(fmt.Sprintf("add %s %s\n", set.name, member))
+		}
+	}
+
+	return content.String()
+}
+
+// applyIPSets在应用iptables规则之前先把desired里涉及到的ipset创建/刷新好，
+// 确保规则里`-m set --match-set`引用的集合在规则生效前已经存在。UseIPSet
+// 关闭或宿主机没有ipset时直接跳过，不算错误——这种情况下convertProtoConfigs
+// 已经fail-closed跳过了引用ipset的规则，desired里本就不会出现SourceSet/
+// DestSet
+func (m *Manager) applyIPSets(desired map[string]*Rule) error {
+	if !m.config.UseIPSet || m.ipsetExec == nil || !m.ipsetExec.Available() {
+		return nil
+	}
+
+	content := generateIPSetRestoreContent(desired)
+	if content == "" {
+		return nil
+	}
+
+	output, err := m.ipsetExec.Restore(content)
+	if err != nil {
+		return fmt.Errorf("ipset restore失败: %w, 输出: %s", err, string(output))
+	}
+	return nil
+}
+
+// backupIPSets在每轮同步开始前把当前ipset状态备份下来，和
+// backupCurrentRules是同一个目的：出问题时至少有最近一份现场可以人工核对。
+// UseIPSet关闭或ipset不可用时直接跳过
+func (m *Manager) backupIPSets() {
+	if !m.config.UseIPSet || m.ipsetExec == nil || !m.ipsetExec.Available() {
+		return
+	}
+
+	output, err := m.ipsetExec.Save()
+	if err != nil {
+		logger.LogError(err, "备份当前ipset状态失败", nil)
+		return
+	}
+
+	timestamp := time.Now().Format("20060102_150405")
+	backupFile := filepath.Join(m.backupDir, fmt.Sprintf("ipset_backup_%s.rules", timestamp))
+	if err := os.WriteFile(backupFile, output, 0644); err != nil {
+		logger.LogError(err, "写入ipset备份文件失败", logrus.Fields{"backup_file": backupFile})
+		return
+	}
+
+	logger.GetIPTablesLogger().WithField("backup_file", backupFile).Info("当前ipset状态备份完成")
+}
+
+// saveIPSetsFile把本轮desired规则对应的ipset定义落盘到m.ipsetsFilePath，和
+// saveRulesFile是同一个目的：重启/排障时能看到最近一次实际下发的内容
+func (m *Manager) saveIPSetsFile(desired map[string]*Rule) {
+	if !m.config.UseIPSet || m.ipsetsFilePath == "" {
+		return
+	}
+
+	content := generateIPSetRestoreContent(desired)
+	if err := os.WriteFile(m.ipsetsFilePath, []byte(content), 0644); err != nil {
+		logger.LogError(err, "保存ipset规则文件失败", logrus.Fields{"ipsets_file": m.ipsetsFilePath})
+		return
+	}
+
+	logger.GetIPTablesLogger().WithField("ipsets_file", m.ipsetsFilePath).Info("ipset规则文件保存完成")
+}
+
+// orphanIPSetNames返回prevRules里存在、但newRules里已经不再引用的
+// ChainPrefix前缀ipset名称，供removeOldManagedRules（full模式）和
+// generateNoflushRestoreContent（noflush/默认模式）清理不再需要的集合
+func orphanIPSetNames(newRules, prevRules map[string]*Rule) []string {
+	keep := make(map[string]bool)
+	for _, rule := range newRules {
+		if rule.SourceSet != "" {
+			keep[rule.SourceSet] = true
+		}
+		if rule.DestSet != "" {
+			keep[rule.DestSet] = true
+		}
+	}
+
+	var orphans []string
+	seen := make(map[string]bool)
+	for _, rule := range prevRules {
+		for _, name := range []string{rule.SourceSet, rule.DestSet} {
+			if name != "" && !keep[name] && !seen[name] {
+				seen[name] = true
+				orphans = append(orphans, name)
+			}
+		}
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// destroyOrphanIPSets对orphanIPSetNames找出的每个集合调用ipset destroy，
+// UseIPSet关闭或ipset不可用时直接跳过。destroy失败（比如集合仍被某条规则
+// 引用）只记日志，不中断同步流程；本轮同步成功后，下一轮的
+// orphanIPSetNames不再包含这个集合，不会再次尝试删除它
+func (m *Manager) destroyOrphanIPSets(names []string) {
+	if !m.config.UseIPSet || len(names) == 0 || m.ipsetExec == nil || !m.ipsetExec.Available() {
+		return
+	}
+
+	for _, name := range names {
+		if output, err := m.ipsetExec.Destroy(name); err != nil {
+			logger.GetIPTablesLogger().WithError(err).WithFields(logrus.Fields{
+				"ipset":  name,
+				"output": string(output),
+			}).Warn("清理不再使用的ipset失败")
+		} else {
+			logger.GetIPTablesLogger().WithField("ipset", name).Info("清理不再使用的ipset")
+		}
+	}
+}
+
+// RenderRulesFromProto 复用UpdateRulesFromProto的转换+模板渲染步骤（proto->
+// Rule、解析当前系统规则、通过TemplateManager生成完整iptables-restore格式
+// 文本），但跳过备份、应用和落盘，供`nspass-agent iptables show|test`这类
+// 只读调试命令在不改变系统状态的情况下预览最终会生效的规则。v4/v6两族各自
+// 渲染，用一行`# family=v4/v6`注释分隔。取写锁而不是读锁是因为
+// getCachedTables在缓存过期时会刷新m.stats.IPTablesSaveCalls这类计数器，
+// 和真正的规则更新共享同一把锁保护
+func (m *Manager) RenderRulesFromProto(configs []*model.IptablesConfig) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	newRules := m.convertProtoConfigs(configs)
+	v4Rules, v6Rules := splitRulesByFamily(newRules)
+	prevV4, prevV6 := splitRulesByFamily(m.managedRules)
+
+	var out strings.Builder
+	for _, item := range []struct {
+		family string
+		rules  map[string]*Rule
+		prev   map[string]*Rule
+	}{
+		{FamilyV4, v4Rules, prevV4},
+		{FamilyV6, v6Rules, prevV6},
+	} {
+		if len(item.rules) == 0 {
+			continue
+		}
+
+		fr := m.families[item.family]
+		tables, _, err := m.getCachedTables(fr)
+		if err != nil {
+			return "", fmt.Errorf("获取当前%s规则失败: %w", item.family, err)
+		}
+
+		content, err := m.generateRulesContent(tables, item.rules, item.prev)
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString(fmt.Sprintf("# family=%s\n", item.family))
+		out.WriteString(content)
+	}
+
+	return out.String(), nil
+}
+
+// getCurrentRulesContent 获取当前的iptables/ip6tables规则内容
+func (m *Manager) getCurrentRulesContent(fr *familyRuntime) (string, error) {
 	log := logger.GetIPTablesLogger()
 	log.Debug("获取当前系统iptables规则")
 
-	cmd := exec.Command("iptables-save")
-	output, err := cmd.Output()
+	output, err := fr.exec.Save()
 	if err != nil {
 		return "", fmt.Errorf("执行iptables-save失败: %w", err)
 	}
+	m.stats.IPTablesSaveCalls++
 
 	content := string(output)
 	log.WithField("rules_size", len(content)).Debug("当前规则获取完成")
 	return content, nil
 }
 
-// generateRulesContent 生成新的规则文件内容
-func (m *Manager) generateRulesContent(currentContent string, newRules map[string]*Rule) (string, error) {
-	log := logger.GetIPTablesLogger()
-	log.WithField("new_rules_count", len(newRules)).Info("开始生成新的规则文件内容")
+// getCachedTables返回当前系统iptables规则的解析结果，TTL内复用上一次已经
+// 拿到的状态（无论是上一次真的跑了iptables-save，还是我们自己上一轮apply
+// 成功后直接记下的最终状态），避免每次UpdateRulesFromProto都重新
+// iptables-save+解析一遍；超过cacheTTL则强制刷新一次，以便发现外部改动。
+// 返回的tables是缓存的深拷贝，调用方可以放心就地修改
+func (m *Manager) getCachedTables(fr *familyRuntime) (map[string]*IPTablesTable, int, error) {
+	fr.cacheMu.Lock()
+	if fr.tables != nil && time.Since(fr.cachedAt) < fr.cacheTTL {
+		tables := cloneTables(fr.tables)
+		fr.cacheMu.Unlock()
+		return tables, 0, nil
+	}
+	fr.cacheMu.Unlock()
+
+	content, err := m.getCurrentRulesContent(fr)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	// 解析当前规则
-	tables, err := m.parseIPTablesContent(currentContent)
+	tables, parseErrors, err := m.parseIPTablesContent(content)
 	if err != nil {
-		return "", fmt.Errorf("解析当前规则失败: %w", err)
+		return nil, 0, err
 	}
 
-	// 移除旧的管理规则
-	removedCount := m.removeOldManagedRules(tables)
+	m.setCachedTables(fr, tables)
+	return tables, parseErrors, nil
+}
+
+// setCachedTables把tables记为fr这个地址族当前缓存的系统状态，cachedAt重置
+// 为now。apply成功后用这个方法直接缓存我们自己刚生成的最终状态，下一轮对账
+// 不必为此专门再跑一次iptables-save
+func (m *Manager) setCachedTables(fr *familyRuntime, tables map[string]*IPTablesTable) {
+	fr.cacheMu.Lock()
+	fr.tables = cloneTables(tables)
+	fr.cachedAt = time.Now()
+	fr.cacheMu.Unlock()
+}
+
+// invalidateCachedTables强制fr这个地址族下一次getCachedTables重新跑一次
+// iptables-save，用于apply失败、已经发生restoreFromBackup这类我们无法确定
+// 缓存是否还准确的场景
+func (m *Manager) invalidateCachedTables(fr *familyRuntime) {
+	fr.cacheMu.Lock()
+	fr.tables = nil
+	fr.cacheMu.Unlock()
+}
+
+// cloneTables深拷贝一份tables，避免缓存和调用方各自对同一个
+// map/slice做不同方向的原地修改互相影响
+func cloneTables(tables map[string]*IPTablesTable) map[string]*IPTablesTable {
+	clone := make(map[string]*IPTablesTable, len(tables))
+	for name, table := range tables {
+		chains := make(map[string]*IPTablesChain, len(table.Chains))
+		for chainName, chain := range table.Chains {
+			chainCopy := *chain
+			chains[chainName] = &chainCopy
+		}
+		rules := make([]string, len(table.Rules))
+		copy(rules, table.Rules)
+		clone[name] = &IPTablesTable{Name: table.Name, Chains: chains, Rules: rules}
+	}
+	return clone
+}
+
+// generateRulesContent 根据m.config.RestoreMode生成新的iptables-restore输入。
+// "full"（旧行为）在tables（当前系统规则的解析结果，来自getCachedTables）
+// 基础上就地移除旧的管理规则、加入新规则，生成完整的iptables-save格式内容；
+// tables会被原地修改为应用后的最终状态，调用方可以把它直接交给
+// setCachedTables缓存起来。"noflush"（默认）只生成我们自己管理的表的
+// per-table restore片段，不依赖tables，只依赖newRules和prevRules（上一轮
+// 同一地址族的managedRules子集），具体见generateNoflushRestoreContent
+func (m *Manager) generateRulesContent(tables map[string]*IPTablesTable, newRules, prevRules map[string]*Rule) (string, error) {
+	log := logger.GetIPTablesLogger()
+	log.WithFields(logrus.Fields{
+		"new_rules_count": len(newRules),
+		"restore_mode":    m.config.RestoreMode,
+	}).Info("开始生成新的规则文件内容")
 
-	// 添加新的管理规则
-	addedCount := m.addNewManagedRules(tables, newRules)
+	if m.config.RestoreMode == "full" {
+		// 移除旧的管理规则，语义上和本轮desired规则等价的保留不动
+		removedCount, satisfied := m.removeOldManagedRules(tables, newRules)
 
-	// 生成新的规则内容
-	newContent, err := m.generateIPTablesContent(tables)
+		// 添加新的管理规则，跳过已经原样保留的
+		addedCount := m.addNewManagedRules(tables, newRules, satisfied)
+
+		// 生成新的规则内容
+		newContent, err := m.generateIPTablesContent(tables)
+		if err != nil {
+			return "", fmt.Errorf("生成规则内容失败: %w", err)
+		}
+
+		log.WithFields(logrus.Fields{
+			"new_content_size": len(newContent),
+			"rules_removed":    removedCount,
+			"rules_added":      addedCount,
+		}).Info("新规则文件内容生成完成")
+
+		return newContent, nil
+	}
+
+	newContent, err := m.generateNoflushRestoreContent(newRules, prevRules)
 	if err != nil {
 		return "", fmt.Errorf("生成规则内容失败: %w", err)
 	}
 
-	log.WithFields(logrus.Fields{
-		"new_content_size": len(newContent),
-		"rules_removed":    removedCount,
-		"rules_added":      addedCount,
-	}).Info("新规则文件内容生成完成")
+	log.WithField("new_content_size", len(newContent)).Info("新规则文件内容生成完成")
 
 	return newContent, nil
 }
 
-// parseIPTablesContent 解析iptables-save格式的内容
-func (m *Manager) parseIPTablesContent(content string) (map[string]*IPTablesTable, error) {
+// generateNoflushRestoreContent 生成--noflush模式下使用的iptables-restore
+// 输入：只包含newRules（本轮desired规则集合）和prevRules（上一轮同一地址族
+// 的managedRules子集）共同涉及到的表，每张表内只声明/清空我们自己
+// ChainPrefix下的链、只-A我们自己的规则，不再提前解析/拼接当前系统的完整
+// 规则状态——user/系统既有规则、我们不管理的表完全不出现在输出里，
+// --noflush应用时自然也就不会被触碰。不再需要的管理链（prevRules里存在、
+// newRules里已经没有规则指向它）先-F再-X删除
+func (m *Manager) generateNoflushRestoreContent(newRules, prevRules map[string]*Rule) (string, error) {
+	rulesByTable := make(map[string][]*Rule)
+	for _, rule := range newRules {
+		rulesByTable[rule.Table] = append(rulesByTable[rule.Table], rule)
+	}
+
+	prevChainsByTable := make(map[string]map[string]bool)
+	for _, rule := range prevRules {
+		if !strings.HasPrefix(rule.Chain, m.config.ChainPrefix) {
+			continue
+		}
+		if prevChainsByTable[rule.Table] == nil {
+			prevChainsByTable[rule.Table] = make(map[string]bool)
+		}
+		prevChainsByTable[rule.Table][rule.Chain] = true
+	}
+
+	tableNames := make(map[string]bool, len(rulesByTable)+len(prevChainsByTable))
+	for tableName := range rulesByTable {
+		tableNames[tableName] = true
+	}
+	for tableName := range prevChainsByTable {
+		tableNames[tableName] = true
+	}
+
+	orderedTables := make([]string, 0, len(tableNames))
+	for tableName := range tableNames {
+		orderedTables = append(orderedTables, tableName)
+	}
+	sort.Strings(orderedTables)
+
+	var content strings.Builder
+	for _, tableName := range orderedTables {
+		rules := rulesByTable[tableName]
+
+		desiredChains := make(map[string]bool)
+		for _, rule := range rules {
+			if strings.HasPrefix(rule.Chain, m.config.ChainPrefix) {
+				desiredChains[rule.Chain] = true
+			}
+		}
+
+		keepChains := make([]string, 0, len(desiredChains))
+		for chainName := range desiredChains {
+			keepChains = append(keepChains, chainName)
+		}
+		sort.Strings(keepChains)
+
+		staleChains := make([]string, 0)
+		for chainName := range prevChainsByTable[tableName] {
+			if !desiredChains[chainName] {
+				staleChains = append(staleChains, chainName)
+			}
+		}
+		sort.Strings(staleChains)
+
+		content.WriteString(fmt.Sprintf("*%s\n", tableName))
+
+		for _, chainName := range keepChains {
+			content.WriteString(fmt.Sprintf(":%s - [0:0]\n", chainName))
+		}
+		// 重新声明的管理链先-F清空，再重新-A写入本轮规则，避免同一条规则在
+		// 连续两轮noflush restore之后被重复追加
+		for _, chainName := range keepChains {
+			content.WriteString(fmt.Sprintf("-F %s\n", chainName))
+		}
+		// 不再需要的管理链必须先-F后-X，iptables不允许直接删除非空自定义链
+		for _, chainName := range staleChains {
+			content.WriteString(fmt.Sprintf("-F %s\n", chainName))
+			content.WriteString(fmt.Sprintf("-X %s\n", chainName))
+		}
+
+		sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+		for _, rule := range rules {
+			content.WriteString(m.renderRuleLine(rule) + "\n")
+		}
+
+		content.WriteString("COMMIT\n")
+	}
+
+	m.destroyOrphanIPSets(orphanIPSetNames(newRules, prevRules))
+
+	return content.String(), nil
+}
+
+// renderRuleLine 把单条Rule渲染成单行iptables-restore规则（不含表名/链声明/
+// COMMIT），优先用TemplateManager按规则类型渲染，模板管理器未初始化或渲染
+// 失败时回退到原始的字符串拼接；full/noflush两种模式共用这一份渲染逻辑
+func (m *Manager) renderRuleLine(rule *Rule) string {
+	if m.templateManager != nil {
+		ruleStr, err := m.templateManager.GenerateRule(rule)
+		if err != nil {
+			logger.GetIPTablesLogger().WithError(err).WithField("rule_id", rule.ID).Warn("使用模板生成规则失败，回退到字符串拼接")
+		} else if ruleStr != "" {
+			return ruleStr
+		}
+	}
+
+	var ruleStr string
+	if rule.Action == "insert" {
+		ruleStr = fmt.Sprintf("-I %s %s", rule.Chain, rule.Rule)
+	} else {
+		ruleStr = fmt.Sprintf("-A %s %s", rule.Chain, rule.Rule)
+	}
+	ruleStr += fmt.Sprintf(" -m comment --comment \"NSPass:%s\"", rule.ID)
+	return ruleStr
+}
+
+// parseIPTablesContent 解析iptables-save格式的内容，返回解析出的表以及遇到的
+// 无法识别行数（表头和COMMIT之间既非链定义也非-A/-I的行），供ManagerStats展示
+func (m *Manager) parseIPTablesContent(content string) (map[string]*IPTablesTable, int, error) {
 	tables := make(map[string]*IPTablesTable)
+	parseErrors := 0
 
 	scanner := bufio.NewScanner(strings.NewReader(content))
 	var currentTable *IPTablesTable
@@ -304,6 +1097,8 @@ func (m *Manager) parseIPTablesContent(content string) (map[string]*IPTablesTabl
 					Policy:   policy,
 					Counters: counters,
 				}
+			} else {
+				parseErrors++
 			}
 			continue
 		}
@@ -311,33 +1106,60 @@ func (m *Manager) parseIPTablesContent(content string) (map[string]*IPTablesTabl
 		// 规则行
 		if strings.HasPrefix(line, "-A ") || strings.HasPrefix(line, "-I ") {
 			currentTable.Rules = append(currentTable.Rules, line)
+			continue
 		}
+
+		parseErrors++
 	}
 
-	return tables, scanner.Err()
+	return tables, parseErrors, scanner.Err()
+}
+
+// ruleIDFromComment从一条已经渲染好的规则文本里取出NSPass:<id>注释携带的
+// 规则ID，取不到时返回空字符串
+func ruleIDFromComment(rule string) string {
+	comment := parseRule(rule).Comment
+	if id, ok := strings.CutPrefix(comment, "NSPass:"); ok {
+		return id
+	}
+	return ""
 }
 
-// removeOldManagedRules 移除旧的管理规则
-func (m *Manager) removeOldManagedRules(tables map[string]*IPTablesTable) int {
+// removeOldManagedRules 移除旧的管理规则。newRules是本轮desired规则，同一个
+// ID在这一轮里仍然存在、且渲染结果和系统里现有的规则文本语义等价
+// （RuleEqual）时保留原样不动，既不删除也不在addNewManagedRules里重新生成，
+// 避免纯粹因为flag顺序、CIDR主机位这类不影响实际行为的差异导致的
+// 删除+重建震荡，返回值是实际移除的规则数量和本轮无需重新添加的规则ID集合
+func (m *Manager) removeOldManagedRules(tables map[string]*IPTablesTable, newRules map[string]*Rule) (int, map[string]bool) {
 	log := logger.GetIPTablesLogger()
 	removedCount := 0
 	removedChains := 0
+	satisfied := make(map[string]bool)
 
 	for _, table := range tables {
-		var newRules []string
+		var keptRules []string
 		for _, rule := range table.Rules {
 			// 检查是否是我们管理的规则
 			if !m.isManagedRule(rule) {
-				newRules = append(newRules, rule)
-			} else {
-				removedCount++
-				log.WithFields(logrus.Fields{
-					"table": table.Name,
-					"rule":  rule,
-				}).Debug("移除旧的管理规则")
+				keptRules = append(keptRules, rule)
+				continue
+			}
+
+			if id := ruleIDFromComment(rule); id != "" {
+				if desired, ok := newRules[id]; ok && desired.Table == table.Name && RuleEqual(rule, m.renderRuleLine(desired)) {
+					keptRules = append(keptRules, rule)
+					satisfied[id] = true
+					continue
+				}
 			}
+
+			removedCount++
+			log.WithFields(logrus.Fields{
+				"table": table.Name,
+				"rule":  rule,
+			}).Debug("移除旧的管理规则")
 		}
-		table.Rules = newRules
+		table.Rules = keptRules
 
 		// 移除我们管理的自定义链
 		for chainName := range table.Chains {
@@ -355,20 +1177,28 @@ func (m *Manager) removeOldManagedRules(tables map[string]*IPTablesTable) int {
 	log.WithFields(logrus.Fields{
 		"removed_rules":  removedCount,
 		"removed_chains": removedChains,
+		"unchanged_kept": len(satisfied),
 	}).Info("旧的管理规则移除完成")
 
-	return removedCount
+	m.destroyOrphanIPSets(orphanIPSetNames(newRules, m.managedRules))
+
+	return removedCount, satisfied
 }
 
-// addNewManagedRules 添加新的管理规则
-func (m *Manager) addNewManagedRules(tables map[string]*IPTablesTable, newRules map[string]*Rule) int {
+// addNewManagedRules 添加新的管理规则。satisfied里的ID在
+// removeOldManagedRules阶段已经确认和系统里现有规则语义等价、原样保留，这里
+// 跳过不再重复添加
+func (m *Manager) addNewManagedRules(tables map[string]*IPTablesTable, newRules map[string]*Rule, satisfied map[string]bool) int {
 	log := logger.GetIPTablesLogger()
 	addedCount := 0
 	addedChains := 0
 
-	// 按表分组规则
+	// 按表分组规则，跳过本轮已经原样保留、无需重新添加的规则
 	rulesByTable := make(map[string][]*Rule)
-	for _, rule := range newRules {
+	for id, rule := range newRules {
+		if satisfied[id] {
+			continue
+		}
 		rulesByTable[rule.Table] = append(rulesByTable[rule.Table], rule)
 	}
 
@@ -411,27 +1241,7 @@ func (m *Manager) addNewManagedRules(tables map[string]*IPTablesTable, newRules
 
 		// 添加规则
 		for _, rule := range rules {
-			var ruleStr string
-			var err error
-
-			// 使用模板生成规则字符串
-			if m.templateManager != nil {
-				ruleStr, err = m.templateManager.GenerateRule(rule)
-				if err != nil {
-					log.WithError(err).WithField("rule_id", rule.ID).Warn("使用模板生成规则失败，回退到字符串拼接")
-					ruleStr = ""
-				}
-			}
-
-			// 如果模板生成失败或没有模板管理器，使用原始方式
-			if ruleStr == "" {
-				if rule.Action == "insert" {
-					ruleStr = fmt.Sprintf("-I %s %s", rule.Chain, rule.Rule)
-				} else {
-					ruleStr = fmt.Sprintf("-A %s %s", rule.Chain, rule.Rule)
-				}
-				ruleStr += fmt.Sprintf(" -m comment --comment \"NSPass:%s\"", rule.ID)
-			}
+			ruleStr := m.renderRuleLine(rule)
 
 			table.Rules = append(table.Rules, ruleStr)
 			addedCount++
@@ -499,9 +1309,64 @@ func (m *Manager) generateIPTablesContent(tables map[string]*IPTablesTable) (str
 	return content.String(), nil
 }
 
-// applyRules 应用新规则
-func (m *Manager) applyRules(content string) error {
+// Diff对比本轮期望的newRules和上一轮UpdateRulesFromProto应用后留在内存里的
+// managedRules，按ID区分出新增、删除、不变三类，外部调用（比如排查为什么
+// 某次同步明明配置没变却还是重新下发了一遍）自行加读锁
+func (m *Manager) Diff(newRules RuleSet) (added, removed, unchanged []*Rule) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.diffLocked(newRules)
+}
+
+// diffLocked是Diff去掉加锁之后的实现，供已经持有m.mu的syncOnce
+// 内部调用，避免sync.RWMutex不可重入导致的死锁
+func (m *Manager) diffLocked(newRules RuleSet) (added, removed, unchanged []*Rule) {
+	comparison := compareRules(m.managedRules, newRules)
+	return comparison.ToAdd, comparison.ToDelete, comparison.Unchanged
+}
+
+// compareRules 对比上一轮同一地址族的managedRules和本轮desired规则，按ID
+// 区分出新增、删除、不变三类，供applyRulesPerRule在iptables-restore不可用时
+// 只对真正变化的规则执行单条命令，而不是全量重放
+func compareRules(current, desired map[string]*Rule) *RuleComparison {
+	comparison := &RuleComparison{}
+
+	for id, rule := range desired {
+		if old, ok := current[id]; ok && old.Table == rule.Table && old.Chain == rule.Chain && old.Rule == rule.Rule {
+			comparison.Unchanged = append(comparison.Unchanged, rule)
+		} else {
+			comparison.ToAdd = append(comparison.ToAdd, rule)
+		}
+	}
+
+	for id, rule := range current {
+		if _, ok := desired[id]; !ok {
+			comparison.ToDelete = append(comparison.ToDelete, rule)
+		}
+	}
+
+	return comparison
+}
+
+// applyRules 应用新规则。优先走iptables-restore --noflush做原子批量替换，
+// 性能不随规则数线性劣化；content的范围取决于m.config.RestoreMode——"full"
+// 模式下是完整的iptables-save格式内容，"noflush"模式下只是generateRulesContent
+// 挑出来的per-table片段，只涉及我们自己管理的表和链。iptables-restore在
+// PATH上找不到时（精简镜像、容器里常见）回退到comparison驱动的逐条
+// iptables -A/-D。fr决定实际调用iptables还是ip6tables系列命令
+func (m *Manager) applyRules(content string, comparison *RuleComparison, fr *familyRuntime) error {
 	log := logger.GetIPTablesLogger()
+
+	if !fr.exec.RestoreAvailable() {
+		log.Warn("未找到iptables-restore，回退到逐条iptables命令应用规则")
+		m.stats.RestoreMode = "per-rule"
+		startTime := time.Now()
+		err := m.applyRulesPerRule(comparison, fr)
+		m.stats.RestoreDurationMs = time.Since(startTime).Milliseconds()
+		m.stats.BytesWritten = 0
+		return err
+	}
+
 	log.Info("开始应用新的iptables规则")
 
 	// 创建临时文件
@@ -518,27 +1383,86 @@ func (m *Manager) applyRules(content string) error {
 	}
 	tmpFile.Close()
 
-	// 应用规则
-	cmd := exec.Command("iptables-restore", tmpFile.Name())
-	output, err := cmd.CombinedOutput()
+	startTime := time.Now()
+	output, err := fr.exec.Restore(tmpFile.Name())
+	duration := time.Since(startTime)
+
+	m.stats.RestoreMode = "iptables-restore"
+	m.stats.RestoreDurationMs = duration.Milliseconds()
+	m.stats.BytesWritten = len(content)
+
 	if err != nil {
 		return fmt.Errorf("iptables-restore失败: %w, 输出: %s", err, string(output))
 	}
 
-	log.WithField("output", string(output)).Info("iptables规则应用成功")
+	log.WithFields(logrus.Fields{
+		"output":      string(output),
+		"duration_ms": duration.Milliseconds(),
+		"bytes":       len(content),
+	}).Info("iptables规则应用成功")
+	return nil
+}
+
+// ruleArgs 把Rule还原为iptables命令行参数（不含-t/-A/-D/chain），并追加与
+// 模板生成的iptables-restore规则一致的NSPass注释，供isManagedRule和后续
+// 删除时识别
+func ruleArgs(rule *Rule) []string {
+	args := strings.Fields(rule.Rule)
+	args = append(args, "-m", "comment", "--comment", fmt.Sprintf("NSPass:%s", rule.ID))
+	return args
+}
+
+// ensureChain 在table下创建chain（已存在时忽略错误），逐条应用模式下
+// iptables-restore通常隐式完成的自定义链创建需要手动补上
+func (m *Manager) ensureChain(fr *familyRuntime, table, chain string) {
+	fr.exec.Run("-t", table, "-N", chain)
+}
+
+// applyRulesPerRule 在iptables-restore不可用时逐条执行iptables -A/-D，
+// 只处理comparison里真正变化的规则，避免把所有managedRules全部重放一遍
+func (m *Manager) applyRulesPerRule(comparison *RuleComparison, fr *familyRuntime) error {
+	log := logger.GetIPTablesLogger()
+
+	for _, rule := range comparison.ToDelete {
+		args := append([]string{"-t", rule.Table, "-D", rule.Chain}, ruleArgs(rule)...)
+		if output, err := fr.exec.Run(args...); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				"rule_id": rule.ID,
+				"output":  string(output),
+			}).Warn("逐条删除规则失败，继续处理其余规则")
+		}
+	}
+
+	for _, rule := range comparison.ToAdd {
+		if strings.HasPrefix(rule.Chain, m.config.ChainPrefix) {
+			m.ensureChain(fr, rule.Table, rule.Chain)
+		}
+
+		args := append([]string{"-t", rule.Table, "-A", rule.Chain}, ruleArgs(rule)...)
+		output, err := fr.exec.Run(args...)
+		if err != nil {
+			return fmt.Errorf("添加规则%s失败: %w, 输出: %s", rule.ID, err, string(output))
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"added":   len(comparison.ToAdd),
+		"deleted": len(comparison.ToDelete),
+	}).Info("逐条iptables规则应用完成")
+
 	return nil
 }
 
-// backupCurrentRules 备份当前规则
-func (m *Manager) backupCurrentRules() error {
+// backupCurrentRules 备份fr这个地址族当前的规则
+func (m *Manager) backupCurrentRules(fr *familyRuntime) error {
 	timestamp := time.Now().Format("20060102_150405")
-	backupFile := filepath.Join(m.backupDir, fmt.Sprintf("iptables_backup_%s.rules", timestamp))
+	backupFile := filepath.Join(m.backupDir, fmt.Sprintf("%s%s.rules", fr.backupPrefix, timestamp))
 
-	cmd := exec.Command("iptables-save")
-	output, err := cmd.Output()
+	output, err := fr.exec.Save()
 	if err != nil {
 		return fmt.Errorf("备份当前规则失败: %w", err)
 	}
+	m.stats.IPTablesSaveCalls++
 
 	if err := os.WriteFile(backupFile, output, 0644); err != nil {
 		return fmt.Errorf("写入备份文件失败: %w", err)
@@ -548,18 +1472,19 @@ func (m *Manager) backupCurrentRules() error {
 	return nil
 }
 
-// saveRulesFile 保存规则文件
-func (m *Manager) saveRulesFile(content string) error {
-	if err := os.WriteFile(m.rulesFilePath, []byte(content), 0644); err != nil {
+// saveRulesFile 保存fr这个地址族的规则文件
+func (m *Manager) saveRulesFile(content string, fr *familyRuntime) error {
+	if err := os.WriteFile(fr.rulesFilePath, []byte(content), 0644); err != nil {
 		return fmt.Errorf("保存规则文件失败: %w", err)
 	}
 
-	logger.GetIPTablesLogger().WithField("rules_file", m.rulesFilePath).Info("规则文件保存完成")
+	logger.GetIPTablesLogger().WithField("rules_file", fr.rulesFilePath).Info("规则文件保存完成")
 	return nil
 }
 
-// restoreFromBackup 从备份恢复
-func (m *Manager) restoreFromBackup() error {
+// restoreFromBackup 从fr这个地址族自己的最新备份恢复，v4/v6各自独立回滚，
+// 互不影响
+func (m *Manager) restoreFromBackup(fr *familyRuntime) error {
 	// 找到最新的备份文件
 	files, err := os.ReadDir(m.backupDir)
 	if err != nil {
@@ -570,7 +1495,7 @@ func (m *Manager) restoreFromBackup() error {
 	var latestTime time.Time
 
 	for _, file := range files {
-		if strings.HasPrefix(file.Name(), "iptables_backup_") && strings.HasSuffix(file.Name(), ".rules") {
+		if strings.HasPrefix(file.Name(), fr.backupPrefix) && strings.HasSuffix(file.Name(), ".rules") {
 			if info, err := file.Info(); err == nil {
 				if info.ModTime().After(latestTime) {
 					latestTime = info.ModTime()
@@ -584,8 +1509,7 @@ func (m *Manager) restoreFromBackup() error {
 		return fmt.Errorf("未找到备份文件")
 	}
 
-	cmd := exec.Command("iptables-restore", latestBackup)
-	output, err := cmd.CombinedOutput()
+	output, err := fr.exec.RestoreFull(latestBackup)
 	if err != nil {
 		return fmt.Errorf("恢复备份失败: %w, 输出: %s", err, string(output))
 	}
@@ -599,18 +1523,86 @@ func (m *Manager) isManagedRule(rule string) bool {
 	return strings.Contains(rule, "NSPass:") || strings.Contains(rule, m.config.ChainPrefix)
 }
 
-// GetRulesSummary 获取规则摘要
+// CheckDrift分别对v4/v6重新执行一次iptables-save/ip6tables-save，统计其中
+// 带NSPass标记的-A/-I规则行数之和，与内存里m.managedRules的数量比较。只比较
+// 数量而不逐条比对规则文本，足以发现规则被整体清空或系统重启后未恢复这类
+// 最常见的漂移
+func (m *Manager) CheckDrift() (*RuleDriftReport, error) {
+	m.mu.RLock()
+	managedCount := len(m.managedRules)
+	m.mu.RUnlock()
+
+	liveCount := 0
+	for _, family := range []string{FamilyV4, FamilyV6} {
+		fr := m.families[family]
+		content, err := m.getCurrentRulesContent(fr)
+		if err != nil {
+			return nil, fmt.Errorf("获取当前%s规则失败: %w", family, err)
+		}
+
+		for _, line := range strings.Split(content, "\n") {
+			if !strings.HasPrefix(line, "-A ") && !strings.HasPrefix(line, "-I ") {
+				continue
+			}
+			if m.isManagedRule(line) {
+				liveCount++
+			}
+		}
+	}
+
+	return &RuleDriftReport{
+		Managed: managedCount,
+		Live:    liveCount,
+		Drifted: liveCount != managedCount,
+	}, nil
+}
+
+// GetRulesSummary 获取规则摘要，managed_rules_v4/v6是按Rule.Family拆分后的
+// 计数，FamilyBoth的规则会同时计入两边
 func (m *Manager) GetRulesSummary() map[string]interface{} {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	v4Count, v6Count := 0, 0
+	for _, rule := range m.managedRules {
+		switch rule.Family {
+		case FamilyV6:
+			v6Count++
+		case FamilyBoth:
+			v4Count++
+			v6Count++
+		default:
+			v4Count++
+		}
+	}
+
 	summary := map[string]interface{}{
+		"backend":             "iptables-restore",
 		"managed_rules_count": len(m.managedRules),
+		"managed_rules_v4":    v4Count,
+		"managed_rules_v6":    v6Count,
 		"enabled":             m.config.Enable,
 		"chain_prefix":        m.config.ChainPrefix,
-		"rules_file":          m.rulesFilePath,
+		"rules_file_v4":       m.families[FamilyV4].rulesFilePath,
+		"rules_file_v6":       m.families[FamilyV6].rulesFilePath,
 		"backup_dir":          m.backupDir,
 		"last_update":         m.lastUpdate.Format(time.RFC3339),
+		"restore_mode":        m.stats.RestoreMode,
+		"restore_duration_ms": m.stats.RestoreDurationMs,
+		"bytes_written":       m.stats.BytesWritten,
+		"parse_errors":        m.stats.ParseErrors,
+		"iptables_save_calls": m.stats.IPTablesSaveCalls,
+		"skipped_applies":     m.stats.SkippedApplies,
+		"use_ipset":           m.config.UseIPSet,
+		"ipsets_file":         m.ipsetsFilePath,
+	}
+
+	// runner_前缀的字段来自boundedFrequencyRunner，反映同步goroutine自身的
+	// 调度情况，和上面stats里"本次syncOnce做了什么"是互补的两个维度
+	if m.runner != nil {
+		for k, v := range m.runner.metrics.snapshot() {
+			summary["runner_"+k] = v
+		}
 	}
 
 	// 按表统计规则
@@ -0,0 +1,231 @@
+package iptables
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nspass/nspass-agent/generated/model"
+	"github.com/nspass/nspass-agent/pkg/config"
+)
+
+// fakeIPTablesExecutor是iptablesExecutor在单元测试里的替身，不fork任何真实
+// 子进程，只记录各方法被调用的次数，供断言"配置不变时不应该重复
+// iptables-save/iptables-restore"
+type fakeIPTablesExecutor struct {
+	mu sync.Mutex
+
+	saveCalls        int
+	restoreCalls     int
+	restoreFullCalls int
+	runCalls         int
+}
+
+func (f *fakeIPTablesExecutor) Save() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.saveCalls++
+	return []byte("*filter\n:INPUT ACCEPT [0:0]\n:FORWARD ACCEPT [0:0]\n:OUTPUT ACCEPT [0:0]\nCOMMIT\n*nat\n:PREROUTING ACCEPT [0:0]\n:POSTROUTING ACCEPT [0:0]\nCOMMIT\n"), nil
+}
+
+func (f *fakeIPTablesExecutor) Restore(path string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.restoreCalls++
+	return nil, nil
+}
+
+func (f *fakeIPTablesExecutor) RestoreFull(path string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.restoreFullCalls++
+	return nil, nil
+}
+
+func (f *fakeIPTablesExecutor) Run(args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runCalls++
+	return nil, nil
+}
+
+func (f *fakeIPTablesExecutor) RestoreAvailable() bool { return true }
+
+// newTestManager构建一个不依赖真实iptables工具链的Manager，backupDir/
+// 各地址族的rulesFilePath指向t.TempDir()，v4/v6的exec都换成同一个
+// fakeIPTablesExecutor，调用计数因此是两族的总和。runner用很短的
+// min/maxInterval启动，让UpdateRulesFromProto提交的请求能很快被同步
+// goroutine消费掉，测试里通过waitFor轮询结果而不是假设同步调用完成
+func newTestManager(t *testing.T) (*Manager, *fakeIPTablesExecutor) {
+	t.Helper()
+
+	dir := t.TempDir()
+	fake := &fakeIPTablesExecutor{}
+
+	m := &Manager{
+		config: config.IPTablesConfig{
+			Enable:      true,
+			ChainPrefix: "NSPASS_",
+		},
+		backupDir:    dir,
+		managedRules: make(map[string]*Rule),
+		families: map[string]*familyRuntime{
+			FamilyV4: {
+				exec:          fake,
+				rulesFilePath: dir + "/rules.v4",
+				backupPrefix:  "iptables_backup_",
+				cacheTTL:      defaultRuleCacheTTL,
+			},
+			FamilyV6: {
+				exec:          fake,
+				rulesFilePath: dir + "/rules.v6",
+				backupPrefix:  "ip6tables_backup_",
+				cacheTTL:      defaultRuleCacheTTL,
+			},
+		},
+	}
+
+	m.runner = newBoundedFrequencyRunner("test", m.syncOnce, 2*time.Millisecond, 50*time.Millisecond)
+	m.runner.Start()
+	t.Cleanup(m.runner.Stop)
+
+	return m, fake
+}
+
+// waitFor轮询cond直到返回true，超时后让测试失败。UpdateRulesFromProto提交
+// 请求给runner之后立即返回，实际同步在另一个goroutine异步完成，断言前都要
+// 先等它跑完，而不是假设调用返回时已经应用好
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待条件满足超时（%v）", timeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testConfigs(ids ...int64) []*model.IptablesConfig {
+	configs := make([]*model.IptablesConfig, 0, len(ids))
+	for _, id := range ids {
+		configs = append(configs, &model.IptablesConfig{
+			Id:        id,
+			ServerId:  "test-server",
+			IsEnabled: true,
+		})
+	}
+	return configs
+}
+
+func TestUpdateRulesFromProtoSkipsUnchangedConfig(t *testing.T) {
+	m, fake := newTestManager(t)
+	configs := testConfigs(1, 2, 3)
+
+	if err := m.UpdateRulesFromProto(configs); err != nil {
+		t.Fatalf("首次UpdateRulesFromProto失败: %v", err)
+	}
+
+	waitFor(t, 2*time.Second, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.saveCalls > 0
+	})
+
+	fake.mu.Lock()
+	firstSaveCalls := fake.saveCalls
+	firstRestoreCalls := fake.restoreCalls
+	fake.mu.Unlock()
+
+	m.mu.RLock()
+	skippedBefore := m.stats.SkippedApplies
+	m.mu.RUnlock()
+
+	// 配置完全不变时重复同步3次，这3次都应该跳过、不再调用iptables-save/
+	// iptables-restore。上面的waitFor在首次同步第一次Save后就返回，首次同步
+	// 剩下的至多1次save和1次restore可能计入下面的增量，所以断言允许增加1次
+	for i := 0; i < 3; i++ {
+		if err := m.UpdateRulesFromProto(configs); err != nil {
+			t.Fatalf("第%d次重复UpdateRulesFromProto失败: %v", i+2, err)
+		}
+	}
+
+	waitFor(t, 2*time.Second, func() bool {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.stats.SkippedApplies > skippedBefore
+	})
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if delta := fake.saveCalls - firstSaveCalls; delta > 1 {
+		t.Errorf("配置不变时3次重复同步不应该再产生超过1次iptables-save调用，实际增加了%d次", delta)
+	}
+	if delta := fake.restoreCalls - firstRestoreCalls; delta > 1 {
+		t.Errorf("配置不变时3次重复同步不应该再产生超过1次iptables-restore调用，实际增加了%d次", delta)
+	}
+}
+
+func TestUpdateRulesFromProtoAppliesOnChange(t *testing.T) {
+	m, fake := newTestManager(t)
+
+	if err := m.UpdateRulesFromProto(testConfigs(1, 2)); err != nil {
+		t.Fatalf("首次UpdateRulesFromProto失败: %v", err)
+	}
+
+	waitFor(t, 2*time.Second, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.restoreCalls > 0
+	})
+
+	fake.mu.Lock()
+	restoreCallsAfterFirst := fake.restoreCalls
+	fake.mu.Unlock()
+
+	// 新增一条配置，desired规则集合和managedRules不再一致，应该再走一次完整
+	// 的生成/应用流程
+	if err := m.UpdateRulesFromProto(testConfigs(1, 2, 3)); err != nil {
+		t.Fatalf("第二次UpdateRulesFromProto失败: %v", err)
+	}
+
+	waitFor(t, 2*time.Second, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.restoreCalls > restoreCallsAfterFirst
+	})
+}
+
+func TestManagerDiff(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	if err := m.UpdateRulesFromProto(testConfigs(1, 2)); err != nil {
+		t.Fatalf("UpdateRulesFromProto失败: %v", err)
+	}
+
+	waitFor(t, 2*time.Second, func() bool {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return len(m.managedRules) == 2
+	})
+
+	m.mu.RLock()
+	same := make(RuleSet, len(m.managedRules))
+	for id, rule := range m.managedRules {
+		ruleCopy := *rule
+		same[id] = &ruleCopy
+	}
+	m.mu.RUnlock()
+
+	added, removed, unchanged := m.Diff(same)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("规则集合未变化时Diff不应返回added/removed，实际added=%d removed=%d", len(added), len(removed))
+	}
+	if len(unchanged) != len(same) {
+		t.Errorf("期望unchanged覆盖全部%d条规则，实际为%d", len(same), len(unchanged))
+	}
+}
@@ -0,0 +1,413 @@
+package iptables
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/nspass/nspass-agent/generated/model"
+	"github.com/nspass/nspass-agent/pkg/api"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// nftablesManager 基于github.com/google/nftables（netlink）的管理器，替换
+// iptables-save/iptables-restore的fork/exec路径：整批规则变更在一次netlink
+// 事务（Conn.Flush）中原子提交，内核要么应用全部要么维持原状
+type nftablesManager struct {
+	config config.IPTablesConfig
+	mu     sync.RWMutex
+	conn   *nftables.Conn
+	table  *nftables.Table
+	chains map[string]*nftables.Chain // key: iptables风格的链名，例如"INPUT"/"NSPASS_FORWARD"
+
+	managedRules map[string]*Rule
+	lastUpdate   time.Time
+}
+
+// baseChainHooks 描述filter表内置链对应的netlink hook/优先级，自定义链（带
+// ChainPrefix）作为普通链创建，不挂接hook，只能被其他链跳转
+var baseChainHooks = map[string]struct {
+	hook     *nftables.ChainHook
+	priority *nftables.ChainPriority
+}{
+	"INPUT":   {nftables.ChainHookInput, nftables.ChainPriorityFilter},
+	"OUTPUT":  {nftables.ChainHookOutput, nftables.ChainPriorityFilter},
+	"FORWARD": {nftables.ChainHookForward, nftables.ChainPriorityFilter},
+}
+
+// newNFTablesManager 建立netlink连接并确保承载NSPass规则的表存在。表使用
+// ChainPrefix命名，避免和宿主机上其他工具（firewalld、ufw等）管理的表冲突
+func newNFTablesManager(cfg config.IPTablesConfig) (*nftablesManager, error) {
+	conn, err := nftables.New()
+	if err != nil {
+		return nil, fmt.Errorf("创建nftables netlink连接失败: %w", err)
+	}
+
+	tableName := strings.ToLower(cfg.ChainPrefix) + "filter"
+	table := conn.AddTable(&nftables.Table{
+		Name:   tableName,
+		Family: nftables.TableFamilyIPv4,
+	})
+	if err := conn.Flush(); err != nil {
+		return nil, fmt.Errorf("创建nftables表%s失败: %w", tableName, err)
+	}
+
+	manager := &nftablesManager{
+		config:       cfg,
+		conn:         conn,
+		table:        table,
+		chains:       make(map[string]*nftables.Chain),
+		managedRules: make(map[string]*Rule),
+	}
+
+	logger.LogStartup("nftables-manager", "1.0", map[string]interface{}{
+		"enabled":      cfg.Enable,
+		"chain_prefix": cfg.ChainPrefix,
+		"table":        tableName,
+	})
+
+	return manager, nil
+}
+
+// UpdateRulesFromProto 使用proto配置更新nftables规则，语义与Manager.UpdateRulesFromProto
+// 保持一致：按ID做整体替换，而不是逐条diff
+func (m *nftablesManager) UpdateRulesFromProto(configs []*model.IptablesConfig) error {
+	if !m.config.Enable {
+		logger.GetIPTablesLogger().Info("iptables管理已禁用，跳过规则更新")
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	startTime := time.Now()
+	log := logger.GetIPTablesLogger()
+
+	newRules := make(map[string]*Rule)
+	for _, cfg := range configs {
+		if !cfg.IsEnabled {
+			log.WithField("config_id", cfg.Id).Debug("跳过已禁用的iptables配置")
+			continue
+		}
+
+		_, chain, ruleText := api.ConvertProtoIptablesConfigToRuleParts(cfg)
+		newRules[fmt.Sprintf("%d", cfg.Id)] = &Rule{
+			ID:      fmt.Sprintf("%d", cfg.Id),
+			Table:   "filter",
+			Chain:   chain,
+			Rule:    ruleText,
+			Action:  "add",
+			Enabled: cfg.IsEnabled,
+		}
+	}
+
+	rulesByChain := make(map[string][]*Rule)
+	for _, rule := range newRules {
+		rulesByChain[rule.Chain] = append(rulesByChain[rule.Chain], rule)
+	}
+
+	// 先确保涉及到的链存在，再清空并重新填充，整批通过同一个Conn提交
+	for chainName := range rulesByChain {
+		if _, err := m.ensureChain(chainName); err != nil {
+			return fmt.Errorf("创建链%s失败: %w", chainName, err)
+		}
+	}
+
+	for _, chain := range m.chains {
+		m.conn.FlushChain(chain)
+	}
+
+	failedRules := 0
+	for chainName, rules := range rulesByChain {
+		chain := m.chains[chainName]
+		for _, rule := range rules {
+			exprs, err := parseRuleExprs(rule.Rule)
+			if err != nil {
+				log.WithError(err).WithField("rule_id", rule.ID).Warn("规则无法翻译为nftables表达式，跳过")
+				failedRules++
+				continue
+			}
+			m.conn.AddRule(&nftables.Rule{
+				Table:    m.table,
+				Chain:    chain,
+				Exprs:    exprs,
+				UserData: []byte("NSPass:" + rule.ID),
+			})
+		}
+	}
+
+	if err := m.conn.Flush(); err != nil {
+		return fmt.Errorf("提交nftables规则失败: %w", err)
+	}
+
+	m.managedRules = newRules
+	m.lastUpdate = time.Now()
+
+	duration := time.Since(startTime)
+	logger.LogPerformance("nftables_rules_update_from_proto", duration, logrus.Fields{
+		"configs_processed": len(configs),
+		"rules_applied":     len(newRules) - failedRules,
+		"rules_failed":      failedRules,
+	})
+
+	log.WithFields(logrus.Fields{
+		"managed_rules": len(m.managedRules),
+		"last_update":   m.lastUpdate,
+		"duration_ms":   duration.Milliseconds(),
+	}).Info("nftables规则更新完成")
+
+	return nil
+}
+
+// ensureChain 返回chainName对应的nftables链，不存在则创建；内置链（INPUT/
+// OUTPUT/FORWARD）挂接为base chain，自定义链创建为普通链
+func (m *nftablesManager) ensureChain(chainName string) (*nftables.Chain, error) {
+	if chain, ok := m.chains[chainName]; ok {
+		return chain, nil
+	}
+
+	chain := &nftables.Chain{
+		Name:  chainName,
+		Table: m.table,
+	}
+	if hook, ok := baseChainHooks[chainName]; ok {
+		chain.Type = nftables.ChainTypeFilter
+		chain.Hooknum = hook.hook
+		chain.Priority = hook.priority
+		chain.Policy = chainPolicyPtr(nftables.ChainPolicyAccept)
+	}
+
+	m.conn.AddChain(chain)
+	m.chains[chainName] = chain
+	return chain, nil
+}
+
+func chainPolicyPtr(p nftables.ChainPolicy) *nftables.ChainPolicy { return &p }
+
+// GetRulesSummary 获取规则摘要，字段与Manager.GetRulesSummary保持一致，额外
+// 标注backend，方便运维区分当前生效的是哪套实现
+func (m *nftablesManager) GetRulesSummary() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tableStats := make(map[string]int)
+	for _, rule := range m.managedRules {
+		tableStats[rule.Table]++
+	}
+
+	return map[string]interface{}{
+		"backend":             "nftables",
+		"managed_rules_count": len(m.managedRules),
+		"enabled":             m.config.Enable,
+		"chain_prefix":        m.config.ChainPrefix,
+		"table":               m.table.Name,
+		"last_update":         m.lastUpdate.Format(time.RFC3339),
+		"rules_by_table":      tableStats,
+	}
+}
+
+// CheckDrift遍历已创建过的链，通过netlink读取各链当前实际生效的规则
+// （UserData带"NSPass:"前缀的视为受管规则），与内存里m.managedRules的数量比较。
+// 与Manager.CheckDrift一样只比较数量，不逐条比对规则表达式
+func (m *nftablesManager) CheckDrift() (*RuleDriftReport, error) {
+	m.mu.RLock()
+	managedCount := len(m.managedRules)
+	chains := make([]*nftables.Chain, 0, len(m.chains))
+	for _, chain := range m.chains {
+		chains = append(chains, chain)
+	}
+	table := m.table
+	m.mu.RUnlock()
+
+	liveCount := 0
+	for _, chain := range chains {
+		rules, err := m.conn.GetRules(table, chain)
+		if err != nil {
+			return nil, fmt.Errorf("读取链%s当前规则失败: %w", chain.Name, err)
+		}
+		for _, rule := range rules {
+			if strings.HasPrefix(string(rule.UserData), "NSPass:") {
+				liveCount++
+			}
+		}
+	}
+
+	return &RuleDriftReport{
+		Managed: managedCount,
+		Live:    liveCount,
+		Drifted: liveCount != managedCount,
+	}, nil
+}
+
+// parseRuleExprs 把generateRulesContent中使用的那一小撮iptables风格规则片段
+// （-s/-d/-p/--sport/--dport/-j）翻译成nftables表达式链。只覆盖NSPass自己生成
+// 的规则形状，不是通用iptables语法解析器
+func parseRuleExprs(ruleText string) ([]expr.Any, error) {
+	fields := strings.Fields(ruleText)
+	var exprs []expr.Any
+	var proto string
+
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "-s", "--src":
+			i++
+			if i >= len(fields) {
+				return nil, fmt.Errorf("-s缺少参数")
+			}
+			e, err := matchAddr(fields[i], 12)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, e...)
+		case "-d", "--dst":
+			i++
+			if i >= len(fields) {
+				return nil, fmt.Errorf("-d缺少参数")
+			}
+			e, err := matchAddr(fields[i], 16)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, e...)
+		case "-p", "--protocol":
+			i++
+			if i >= len(fields) {
+				return nil, fmt.Errorf("-p缺少参数")
+			}
+			proto = fields[i]
+			exprs = append(exprs, matchProtocol(proto)...)
+		case "--sport":
+			i++
+			if i >= len(fields) {
+				return nil, fmt.Errorf("--sport缺少参数")
+			}
+			e, err := matchPort(proto, fields[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, e...)
+		case "--dport":
+			i++
+			if i >= len(fields) {
+				return nil, fmt.Errorf("--dport缺少参数")
+			}
+			e, err := matchPort(proto, fields[i], 2)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, e...)
+		case "-j", "--jump":
+			i++
+			if i >= len(fields) {
+				return nil, fmt.Errorf("-j缺少参数")
+			}
+			exprs = append(exprs, jumpVerdict(fields[i]))
+		case "-m", "--comment":
+			// 匹配扩展和注释不影响转发行为，nftables侧无需表达式，直接跳过
+			if i+1 < len(fields) {
+				i++
+			}
+		}
+	}
+
+	if len(exprs) == 0 {
+		return nil, fmt.Errorf("规则%q没有可翻译的表达式", ruleText)
+	}
+	return exprs, nil
+}
+
+func matchAddr(cidr string, offset uint32) ([]expr.Any, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		ip = net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("无法解析地址%q", cidr)
+		}
+		ip = ip.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("仅支持IPv4地址: %q", cidr)
+		}
+		return []expr.Any{
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: 4},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ip},
+		}, nil
+	}
+
+	ip = ip.To4()
+	mask := ipNet.Mask
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: mask, Xor: make([]byte, 4)},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ip.Mask(mask)},
+	}, nil
+}
+
+func matchProtocol(proto string) []expr.Any {
+	var num byte
+	switch strings.ToLower(proto) {
+	case "tcp":
+		num = byte(unix.IPPROTO_TCP)
+	case "udp":
+		num = byte(unix.IPPROTO_UDP)
+	case "icmp":
+		num = byte(unix.IPPROTO_ICMP)
+	}
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 9, Len: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{num}},
+	}
+}
+
+// matchPort 匹配TCP/UDP的源/目的端口，offset为传输层头内的偏移（0=源端口，2=目的端口）
+func matchPort(proto, portStr string, offset uint32) ([]expr.Any, error) {
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析端口%q: %w", portStr, err)
+	}
+	buf := []byte{byte(port >> 8), byte(port)}
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: offset, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: buf},
+	}, nil
+}
+
+func jumpVerdict(target string) expr.Any {
+	switch strings.ToUpper(target) {
+	case "ACCEPT":
+		return &expr.Verdict{Kind: expr.VerdictAccept}
+	case "DROP":
+		return &expr.Verdict{Kind: expr.VerdictDrop}
+	case "RETURN":
+		return &expr.Verdict{Kind: expr.VerdictReturn}
+	default:
+		// 跳转到自定义链（例如ChainPrefix_XXX），由调用方保证该链已创建
+		return &expr.Verdict{Kind: expr.VerdictJump, Chain: target}
+	}
+}
+
+// detectBackend 为"auto"模式探测宿主机能力：优先nftables（需要nft命令行
+// 工具存在，且/sys/module/nf_tables已加载，和kube-proxy选择
+// iptables/ipvs/nftables代理模式时的探测思路一致），探测失败回退到
+// iptables-restore
+func detectBackend() string {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return "iptables"
+	}
+	if _, err := os.Stat("/sys/module/nf_tables"); err != nil {
+		return "iptables"
+	}
+	if _, err := nftables.New(); err == nil {
+		return "nftables"
+	}
+	return "iptables"
+}
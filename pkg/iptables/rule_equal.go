@@ -0,0 +1,207 @@
+package iptables
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// parsedRule是一条iptables规则按常见标志解析后的归一化形式，专门用来判断
+// 两条规则文本是否语义等价，而不是逐字符比较——`-p tcp --dport 80 -j ACCEPT`
+// 和`-j ACCEPT -p tcp --dport 80`这种纯粹的flag顺序差异不应该被当成规则变化
+type parsedRule struct {
+	Protocol   string
+	SrcIP      string
+	DstIP      string
+	SrcPort    string
+	DstPort    string
+	InIface    string
+	OutIface   string
+	Match      []string
+	Target     string
+	TargetArgs string
+	Comment    string
+}
+
+// parseRule把一行iptables规则（形如`-A NSPASS_OUT -p tcp --dport 80 -j ACCEPT`，
+// 也兼容不带`-A <chain>`前缀、直接从`-p ...`开始的片段）拆成字段方便比较。
+// 未识别的标志被忽略，不影响已识别字段的比较结果
+func parseRule(rule string) parsedRule {
+	tokens := strings.Fields(rule)
+	var p parsedRule
+
+	// moduleOrder/moduleArgs把每个`-m <module>`之后、下一个`-m`/`-j`之前出现的
+	// flag+value都记在该module名下，而不是只记module名本身——`-m set
+	// --match-set BLOCK src`和`-m set --match-set ALLOW src`如果只比较"set"
+	// 这个module名会被误判成同一条规则。moduleOrder保留首次出现顺序，方便在
+	// 不存在对应module的token出现时找到"当前"module
+	var moduleOrder []string
+	moduleArgs := make(map[string]string)
+	currentModule := ""
+
+	negate := false
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+
+		if tok == "!" {
+			negate = true
+			continue
+		}
+
+		// 跳过`-A <chain>`/`-I <chain>`前缀，规则是否插入到同一条链由调用方
+		// 的Table/Chain字段单独比较，不参与这里的语义比较
+		if tok == "-A" || tok == "-I" {
+			i++
+			negate = false
+			continue
+		}
+
+		applyNegation := func(v string) string {
+			if negate {
+				return "!" + v
+			}
+			return v
+		}
+
+		switch tok {
+		case "-p", "--protocol":
+			if i+1 < len(tokens) {
+				i++
+				p.Protocol = applyNegation(strings.ToLower(tokens[i]))
+			}
+		case "-s", "--source":
+			if i+1 < len(tokens) {
+				i++
+				p.SrcIP = applyNegation(canonicalizeCIDR(tokens[i]))
+			}
+		case "-d", "--destination":
+			if i+1 < len(tokens) {
+				i++
+				p.DstIP = applyNegation(canonicalizeCIDR(tokens[i]))
+			}
+		case "--sport", "--source-port":
+			if i+1 < len(tokens) {
+				i++
+				p.SrcPort = applyNegation(tokens[i])
+			}
+		case "--dport", "--destination-port":
+			if i+1 < len(tokens) {
+				i++
+				p.DstPort = applyNegation(tokens[i])
+			}
+		case "-i", "--in-interface":
+			if i+1 < len(tokens) {
+				i++
+				p.InIface = applyNegation(tokens[i])
+			}
+		case "-o", "--out-interface":
+			if i+1 < len(tokens) {
+				i++
+				p.OutIface = applyNegation(tokens[i])
+			}
+		case "-m", "--match":
+			if i+1 < len(tokens) {
+				i++
+				currentModule = tokens[i]
+				if _, ok := moduleArgs[currentModule]; !ok {
+					moduleOrder = append(moduleOrder, currentModule)
+					moduleArgs[currentModule] = ""
+				}
+			}
+		case "-j", "--jump":
+			if i+1 < len(tokens) {
+				i++
+				p.Target = tokens[i]
+				currentModule = ""
+			}
+		case "--comment":
+			if i+1 < len(tokens) {
+				i++
+				p.Comment = strings.Trim(tokens[i], "\"")
+			}
+		default:
+			if !strings.HasPrefix(tok, "-") {
+				break
+			}
+
+			arg := tok
+			if i+1 < len(tokens) && !strings.HasPrefix(tokens[i+1], "-") {
+				i++
+				arg += " " + tokens[i]
+			}
+
+			switch {
+			case p.Target != "":
+				// target的附加参数（比如--to-destination、--reject-with）原样
+				// 拼接进TargetArgs，顺序在同一个target下是有意义的，不做排序
+				if p.TargetArgs != "" {
+					p.TargetArgs += " "
+				}
+				p.TargetArgs += arg
+			case currentModule != "":
+				// `-m <module>`之后、`-j`之前未被上面任何一个专门case识别的
+				// flag都当成该module的匹配参数，比如`-m set --match-set`、
+				// `-m conntrack --ctstate`、`--state`、`--tcp-flags`等，
+				// 顺序在同一个module下是有意义的，不做排序
+				if moduleArgs[currentModule] != "" {
+					moduleArgs[currentModule] += " "
+				}
+				moduleArgs[currentModule] += arg
+			}
+		}
+
+		negate = false
+	}
+
+	matches := make([]string, 0, len(moduleOrder))
+	for _, module := range moduleOrder {
+		entry := module
+		if args := moduleArgs[module]; args != "" {
+			entry += " " + args
+		}
+		matches = append(matches, entry)
+	}
+	sort.Strings(matches)
+	p.Match = matches
+
+	return p
+}
+
+// canonicalizeCIDR把`10.0.0.5/24`这样带主机位的CIDR归一化成
+// `net.ParseCIDR`返回的网络地址形式（`10.0.0.0/24`），这样同一个网段写成
+// 不同主机位时仍被认为是同一条规则；不是CIDR格式（纯IP、域名等）原样返回
+func canonicalizeCIDR(s string) string {
+	if !strings.Contains(s, "/") {
+		return s
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return s
+	}
+	return network.String()
+}
+
+// RuleEqual判断两条iptables规则文本在语义上是否等价：各自解析成parsedRule后
+// 逐字段比较，而不是比较原始字符串，因此可以容忍flag顺序、match模块顺序、
+// CIDR主机位这类不影响实际行为的差异
+func RuleEqual(a, b string) bool {
+	pa, pb := parseRule(a), parseRule(b)
+
+	if pa.Protocol != pb.Protocol || pa.SrcIP != pb.SrcIP || pa.DstIP != pb.DstIP ||
+		pa.SrcPort != pb.SrcPort || pa.DstPort != pb.DstPort ||
+		pa.InIface != pb.InIface || pa.OutIface != pb.OutIface ||
+		pa.Target != pb.Target || pa.TargetArgs != pb.TargetArgs || pa.Comment != pb.Comment {
+		return false
+	}
+
+	if len(pa.Match) != len(pb.Match) {
+		return false
+	}
+	for i := range pa.Match {
+		if pa.Match[i] != pb.Match[i] {
+			return false
+		}
+	}
+
+	return true
+}
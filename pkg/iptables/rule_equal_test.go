@@ -0,0 +1,99 @@
+package iptables
+
+import "testing"
+
+func TestRuleEqual(t *testing.T) {
+	cases := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{
+			name: "相同规则文本",
+			a:    "-A NSPASS_OUT -p tcp --dport 80 -j ACCEPT",
+			b:    "-A NSPASS_OUT -p tcp --dport 80 -j ACCEPT",
+			want: true,
+		},
+		{
+			name: "flag顺序不同但语义相同",
+			a:    "-p tcp --dport 80 -j ACCEPT",
+			b:    "-j ACCEPT -p tcp --dport 80",
+			want: true,
+		},
+		{
+			name: "match模块顺序不同但语义相同",
+			a:    "-p tcp -m tcp -m comment --comment \"NSPass:1\" --dport 80 -j ACCEPT",
+			b:    "-p tcp -m comment -m tcp --comment \"NSPass:1\" --dport 80 -j ACCEPT",
+			want: true,
+		},
+		{
+			name: "CIDR主机位不同但同一网段",
+			a:    "-s 10.0.0.0/24 -j ACCEPT",
+			b:    "-s 10.0.0.5/24 -j ACCEPT",
+			want: true,
+		},
+		{
+			name: "目的CIDR主机位不同但同一网段",
+			a:    "-d 192.168.1.0/24 -p tcp --dport 443 -j ACCEPT",
+			b:    "-p tcp -d 192.168.1.200/24 --dport 443 -j ACCEPT",
+			want: true,
+		},
+		{
+			name: "协议不同",
+			a:    "-p tcp --dport 80 -j ACCEPT",
+			b:    "-p udp --dport 80 -j ACCEPT",
+			want: false,
+		},
+		{
+			name: "端口不同",
+			a:    "-p tcp --dport 80 -j ACCEPT",
+			b:    "-p tcp --dport 443 -j ACCEPT",
+			want: false,
+		},
+		{
+			name: "不同网段",
+			a:    "-s 10.0.0.0/24 -j ACCEPT",
+			b:    "-s 10.0.1.0/24 -j ACCEPT",
+			want: false,
+		},
+		{
+			name: "否定条件不同",
+			a:    "-p tcp ! -s 10.0.0.0/24 -j ACCEPT",
+			b:    "-p tcp -s 10.0.0.0/24 -j ACCEPT",
+			want: false,
+		},
+		{
+			name: "target附加参数不同",
+			a:    "-p tcp -j DNAT --to-destination 10.0.0.1:80",
+			b:    "-p tcp -j DNAT --to-destination 10.0.0.2:80",
+			want: false,
+		},
+		{
+			name: "ipset名称不同",
+			a:    "-m set --match-set NSPASS_BLACKLIST src -j DROP",
+			b:    "-m set --match-set NSPASS_WHITELIST src -j DROP",
+			want: false,
+		},
+		{
+			name: "ipset规则语义相同",
+			a:    "-p tcp -m set --match-set NSPASS_BLACKLIST src -j DROP",
+			b:    "-m set --match-set NSPASS_BLACKLIST src -p tcp -j DROP",
+			want: true,
+		},
+		{
+			name: "ctstate不同",
+			a:    "-m conntrack --ctstate NEW -j ACCEPT",
+			b:    "-m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT",
+			want: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := RuleEqual(c.a, c.b); got != c.want {
+				t.Errorf("RuleEqual(%q, %q) = %v, 期望 %v", c.a, c.b, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package iptables
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultMinSyncInterval/defaultMaxSyncInterval是boundedFrequencyRunner在
+// IPTablesConfig没有显式配置时使用的默认值，和config.LoadConfig里
+// MinSyncIntervalSeconds/MaxSyncIntervalSeconds的默认值保持一致
+const (
+	defaultMinSyncInterval = time.Second
+	defaultMaxSyncInterval = 60 * time.Second
+)
+
+// runnerMetrics是boundedFrequencyRunner暴露给GetRulesSummary的运行时指标，
+// 均通过atomic读写，允许在同步goroutine之外并发读取
+type runnerMetrics struct {
+	runCount        int64 // 实际执行syncOnce的次数
+	coalescedCount  int64 // 被合并掉、没有单独触发一次同步的Run()请求次数
+	queueDepth      int64 // 当前已经排队、等待下一次同步的Run()请求数（0或1）
+	lastSyncNanos   int64 // 最近一次同步耗时（纳秒）
+	lastSyncAtNanos int64 // 最近一次同步开始时的unix纳秒时间戳
+	lastSyncError   atomic.Value
+}
+
+func (m *runnerMetrics) snapshot() map[string]interface{} {
+	var lastErr string
+	if v := m.lastSyncError.Load(); v != nil {
+		if s, ok := v.(string); ok {
+			lastErr = s
+		}
+	}
+
+	return map[string]interface{}{
+		"run_count":             atomic.LoadInt64(&m.runCount),
+		"coalesced_count":       atomic.LoadInt64(&m.coalescedCount),
+		"queue_depth":           atomic.LoadInt64(&m.queueDepth),
+		"last_sync_duration_ms": time.Duration(atomic.LoadInt64(&m.lastSyncNanos)).Milliseconds(),
+		"last_sync_error":       lastErr,
+	}
+}
+
+// boundedFrequencyRunner是Kubernetes pkg/util/async.BoundedFrequencyRunner
+// 的简化版本：合并短时间内多次Run()请求成一次实际同步，两次同步之间至少
+// 间隔minInterval（避免突发更新导致连续iptables-restore调用和xtables锁
+// 竞争），即使没有新的Run()请求也至少每maxInterval强制同步一次（保证长期
+// 没有收到更新时仍然定期和内核实际状态核对）
+type boundedFrequencyRunner struct {
+	name        string
+	minInterval time.Duration
+	maxInterval time.Duration
+	fn          func() error
+
+	// run是一个容量为1的channel，Run()非阻塞地往里塞一个信号；channel已满
+	// 时说明已经有一个尚未消费的请求在排队，直接计入coalescedCount后丢弃，
+	// 不需要再塞一次
+	run chan struct{}
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+
+	metrics runnerMetrics
+}
+
+// newBoundedFrequencyRunner创建一个尚未启动的boundedFrequencyRunner，
+// minInterval/maxInterval非正数时分别回退到defaultMinSyncInterval/
+// defaultMaxSyncInterval，调用方需要调用Start()来启动同步goroutine
+func newBoundedFrequencyRunner(name string, fn func() error, minInterval, maxInterval time.Duration) *boundedFrequencyRunner {
+	if minInterval <= 0 {
+		minInterval = defaultMinSyncInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = defaultMaxSyncInterval
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+
+	return &boundedFrequencyRunner{
+		name:        name,
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		fn:          fn,
+		run:         make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// Run请求调度一次同步，非阻塞。短时间内的多次调用会被合并成minInterval到期
+// 后的一次实际同步
+func (r *boundedFrequencyRunner) Run() {
+	select {
+	case r.run <- struct{}{}:
+		atomic.AddInt64(&r.metrics.queueDepth, 1)
+	default:
+		// 已经有一个请求在排队，本次调用被合并掉
+		atomic.AddInt64(&r.metrics.coalescedCount, 1)
+	}
+}
+
+// Start在一个新的goroutine里启动同步循环并立即返回，循环持续运行直到Stop()
+func (r *boundedFrequencyRunner) Start() {
+	go r.loop()
+}
+
+// Stop停止同步goroutine，幂等
+func (r *boundedFrequencyRunner) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+}
+
+func (r *boundedFrequencyRunner) loop() {
+	log := logger.GetIPTablesLogger().WithField("runner", r.name)
+	timer := time.NewTimer(r.maxInterval)
+	defer timer.Stop()
+
+	var lastRun time.Time
+
+	for {
+		select {
+		case <-r.stopCh:
+			return
+
+		case <-r.run:
+			atomic.AddInt64(&r.metrics.queueDepth, -1)
+
+			if wait := r.minInterval - time.Since(lastRun); wait > 0 {
+				// 距上次同步还不足minInterval，先把channel里可能陆续
+				// 进来的后续请求吸收掉（计入coalesced），再等到minInterval
+				// 到期后统一同步一次，而不是每个请求都单独排队等待
+				drainTimer := time.NewTimer(wait)
+				draining := true
+				for draining {
+					select {
+					case <-r.run:
+						atomic.AddInt64(&r.metrics.queueDepth, -1)
+						atomic.AddInt64(&r.metrics.coalescedCount, 1)
+					case <-drainTimer.C:
+						draining = false
+					case <-r.stopCh:
+						drainTimer.Stop()
+						return
+					}
+				}
+			}
+
+			lastRun = r.syncOnceTimed(log)
+			resetTimer(timer, r.maxInterval)
+
+		case <-timer.C:
+			lastRun = r.syncOnceTimed(log)
+			resetTimer(timer, r.maxInterval)
+		}
+	}
+}
+
+// syncOnceTimed执行一次fn()并记录耗时/错误指标，返回本次同步开始的时间，
+// 供loop()据此计算下一次minInterval等待截止时间
+func (r *boundedFrequencyRunner) syncOnceTimed(log *logrus.Entry) time.Time {
+	start := time.Now()
+	err := r.fn()
+	duration := time.Since(start)
+
+	atomic.AddInt64(&r.metrics.runCount, 1)
+	atomic.StoreInt64(&r.metrics.lastSyncNanos, int64(duration))
+	atomic.StoreInt64(&r.metrics.lastSyncAtNanos, start.UnixNano())
+
+	if err != nil {
+		r.metrics.lastSyncError.Store(err.Error())
+		log.WithError(err).Warn("定时同步iptables规则失败")
+	} else {
+		r.metrics.lastSyncError.Store("")
+	}
+
+	return start
+}
+
+// resetTimer把timer重置为下一次maxInterval到期时间。timer是否已经触发、
+// timer.C是否已被消费都可以调用：先Stop()并非阻塞地排空timer.C，再Reset
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
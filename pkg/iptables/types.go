@@ -16,6 +16,20 @@ type ManagerStats struct {
 	ChainPrefix       string         `json:"chain_prefix"`
 	RulesByTable      map[string]int `json:"rules_by_table"`
 	LastUpdate        string         `json:"last_update"`
+
+	// 以下字段反映最近一次UpdateRulesFromProto的应用过程，供排查批量同步的
+	// 耗时和异常；规则量较小时均接近0
+	RestoreMode       string `json:"restore_mode"`        // "iptables-restore"或"per-rule"（回退模式）
+	RestoreDurationMs int64  `json:"restore_duration_ms"` // 应用规则耗时
+	BytesWritten      int    `json:"bytes_written"`       // iptables-restore模式下写入的规则文件大小
+	ParseErrors       int    `json:"parse_errors"`        // 解析当前系统iptables-save输出时遇到的无法识别的行数
+
+	// IPTablesSaveCalls统计进程启动以来实际执行iptables-save的次数，配合
+	// ruleStateCache的TTL命中率一起看：配置长期不变时该值应该几乎不再增长
+	IPTablesSaveCalls int64 `json:"iptables_save_calls"`
+	// SkippedApplies统计UpdateRulesFromProto发现desired规则集合和上一轮
+	// managedRules完全一致、跳过了重新生成/应用规则的次数
+	SkippedApplies int64 `json:"skipped_applies"`
 }
 
 // RuleOperation 规则操作类型
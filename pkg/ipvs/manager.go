@@ -0,0 +1,287 @@
+package ipvs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	moobyipvs "github.com/moby/ipvs"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// sysctlTunables是TuneSysctls开启时要写入的内核参数，目的是避免real server
+// 权重调整或下线时，已有连接的conntrack条目把后续流量黏在旧real server上
+var sysctlTunables = map[string]string{
+	"/proc/sys/net/ipv4/vs/conn_reuse_mode":           "1",
+	"/proc/sys/net/ipv4/vs/expire_nodest_conn":        "1",
+	"/proc/sys/net/ipv4/vs/expire_quiescent_template": "1",
+}
+
+// ManagerInterface 定义IPVS管理器接口，与iptables.ManagerInterface平行
+type ManagerInterface interface {
+	UpdateServices(services []*Service) error
+	GetServicesSummary() map[string]interface{}
+}
+
+// Manager 基于内核IPVS（通过netlink）的虚拟服务管理器
+type Manager struct {
+	config config.IPVSConfig
+	mu     sync.RWMutex
+	handle *moobyipvs.Handle
+
+	managedServices ServiceSet // 当前管理的虚拟服务状态
+	lastUpdate      time.Time
+	stats           ManagerStats
+}
+
+// NewManager 创建IPVS管理器。cfg.Enable为false时返回的管理器所有操作都是
+// 空操作，调用方无需额外判断
+func NewManager(cfg config.IPVSConfig) ManagerInterface {
+	log := logger.GetIPVSLogger()
+
+	if !cfg.Enable {
+		log.Info("IPVS管理已禁用")
+		return &Manager{config: cfg, managedServices: make(ServiceSet)}
+	}
+
+	handle, err := moobyipvs.New("")
+	if err != nil {
+		logger.LogError(err, "初始化IPVS netlink句柄失败，IPVS管理器将不做任何同步", nil)
+		return &Manager{config: cfg, managedServices: make(ServiceSet)}
+	}
+
+	manager := &Manager{
+		config:          cfg,
+		handle:          handle,
+		managedServices: make(ServiceSet),
+	}
+
+	if cfg.TuneSysctls {
+		manager.tuneSysctls()
+	}
+
+	logger.LogStartup("ipvs-manager", "1.0", map[string]interface{}{
+		"enabled":           cfg.Enable,
+		"default_scheduler": cfg.DefaultScheduler,
+		"tune_sysctls":      cfg.TuneSysctls,
+	})
+
+	return manager
+}
+
+// tuneSysctls逐个写入sysctlTunables，单个参数写入失败只记录警告、不中断后续
+// 参数的调整，因为内核未编译ip_vs模块时这些路径本就不存在
+func (m *Manager) tuneSysctls() {
+	log := logger.GetIPVSLogger()
+	for path, value := range sysctlTunables {
+		if err := os.WriteFile(filepath.Clean(path), []byte(value), 0644); err != nil {
+			log.WithError(err).WithField("path", path).Warn("写入IPVS sysctl参数失败")
+		}
+	}
+}
+
+// UpdateServices 根据desired services与上一轮managedServices的差异增量同步
+// 内核IPVS表，O(1)的虚拟服务查找取代了iptables方案里按egress数量线性增长的
+// 规则链遍历
+func (m *Manager) UpdateServices(services []*Service) error {
+	if !m.config.Enable || m.handle == nil {
+		return nil
+	}
+
+	log := logger.GetIPVSLogger()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	desired := make(ServiceSet, len(services))
+	for _, svc := range services {
+		if svc.Scheduler == "" {
+			svc.Scheduler = m.config.DefaultScheduler
+		}
+		desired[svc.key()] = svc
+	}
+
+	comparison := compareServices(m.managedServices, desired)
+
+	for _, svc := range comparison.ToDelete {
+		if err := m.deleteService(svc); err != nil {
+			log.WithError(err).WithField("service", svc.key()).Warn("删除IPVS虚拟服务失败")
+		}
+	}
+	for _, svc := range comparison.ToAdd {
+		if err := m.applyService(svc); err != nil {
+			log.WithError(err).WithField("service", svc.key()).Warn("同步IPVS虚拟服务失败")
+			return fmt.Errorf("同步IPVS虚拟服务 %s 失败: %w", svc.key(), err)
+		}
+	}
+
+	m.managedServices = desired
+	m.lastUpdate = time.Now()
+	m.stats = ManagerStats{
+		DesiredServicesCount: len(desired),
+		CurrentServicesCount: len(desired),
+		Enabled:              m.config.Enable,
+		DefaultScheduler:     m.config.DefaultScheduler,
+		LastUpdate:           m.lastUpdate.Format(time.RFC3339),
+	}
+
+	return nil
+}
+
+// compareServices 对比上一轮managedServices和本轮desired，按Service的唯一
+// 标识区分出新增、删除、不变三类，与iptables.compareRules对应
+func compareServices(current, desired ServiceSet) *ServiceComparison {
+	comparison := &ServiceComparison{}
+
+	for key, svc := range desired {
+		if _, ok := current[key]; ok {
+			comparison.Unchanged = append(comparison.Unchanged, svc)
+		} else {
+			comparison.ToAdd = append(comparison.ToAdd, svc)
+		}
+	}
+
+	for key, svc := range current {
+		if _, ok := desired[key]; !ok {
+			comparison.ToDelete = append(comparison.ToDelete, svc)
+		}
+	}
+
+	return comparison
+}
+
+// applyService 在内核中创建虚拟服务及其全部real server
+func (m *Manager) applyService(svc *Service) error {
+	ipvsSvc := toIPVSService(svc)
+
+	if err := m.handle.NewService(ipvsSvc); err != nil {
+		return fmt.Errorf("创建虚拟服务失败: %w", err)
+	}
+
+	for _, dest := range svc.Destinations {
+		if err := m.handle.NewDestination(ipvsSvc, toIPVSDestination(dest)); err != nil {
+			return fmt.Errorf("添加real server %s:%d失败: %w", dest.Address, dest.Port, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteService 从内核中移除虚拟服务，其下的real server随之一并移除
+func (m *Manager) deleteService(svc *Service) error {
+	if err := m.handle.DelService(toIPVSService(svc)); err != nil {
+		return fmt.Errorf("删除虚拟服务失败: %w", err)
+	}
+	return nil
+}
+
+// toIPVSService 把Service转换为moby/ipvs库使用的服务描述
+func toIPVSService(svc *Service) *moobyipvs.Service {
+	return &moobyipvs.Service{
+		Address:   net.ParseIP(svc.Address),
+		Port:      uint16(svc.Port),
+		Protocol:  protocolNumber(svc.Protocol),
+		SchedName: svc.Scheduler,
+		AddressFamily: func() uint16 {
+			if net.ParseIP(svc.Address).To4() == nil {
+				return 10 // AF_INET6
+			}
+			return 2 // AF_INET
+		}(),
+	}
+}
+
+// toIPVSDestination 把Destination转换为moby/ipvs库使用的real server描述
+func toIPVSDestination(dest *Destination) *moobyipvs.Destination {
+	return &moobyipvs.Destination{
+		Address: net.ParseIP(dest.Address),
+		Port:    uint16(dest.Port),
+		Weight:  dest.Weight,
+	}
+}
+
+// protocolNumber 把"tcp"/"udp"转换为IPVS期望的IPPROTO常量，非法/空值按tcp处理
+func protocolNumber(protocol string) uint16 {
+	switch protocol {
+	case "udp":
+		return 17
+	default:
+		return 6
+	}
+}
+
+// GetServicesSummary 获取IPVS管理器摘要信息，供admin接口展示
+func (m *Manager) GetServicesSummary() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	servicesByScheduler := make(map[string]int)
+	for _, svc := range m.managedServices {
+		servicesByScheduler[svc.Scheduler]++
+	}
+
+	return map[string]interface{}{
+		"enabled":               m.config.Enable,
+		"default_scheduler":     m.config.DefaultScheduler,
+		"managed_services":      len(m.managedServices),
+		"services_by_scheduler": servicesByScheduler,
+		"last_update":           m.stats.LastUpdate,
+	}
+}
+
+// IsLoadBalanced检查一份出口配置是否描述了负载均衡型出口（EgressConfig里带
+// 多个backend/upstream地址），供ProxyMonitor.RegisterProxy决定是否需要同时
+// 向IPVS注册虚拟服务。model.EgressMode本身没有区分此类出口的枚举值，因此按
+// 约定的EgressConfig字段解析，而不是依赖proto新增常量
+func IsLoadBalanced(egressConfigJSON string) bool {
+	backends := ParseBackends(egressConfigJSON)
+	return len(backends) > 1
+}
+
+// backendEntry是EgressConfig中backends/upstreams字段的单个条目
+type backendEntry struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Weight  int    `json:"weight"`
+}
+
+// ParseBackends从EgressConfig的JSON内容中解析出backends（或upstreams）
+// 字段描述的real server列表，解析失败或字段不存在时返回nil
+func ParseBackends(egressConfigJSON string) []*Destination {
+	if egressConfigJSON == "" {
+		return nil
+	}
+
+	var raw struct {
+		Backends  []backendEntry `json:"backends"`
+		Upstreams []backendEntry `json:"upstreams"`
+	}
+	if err := json.Unmarshal([]byte(egressConfigJSON), &raw); err != nil {
+		return nil
+	}
+
+	entries := raw.Backends
+	if len(entries) == 0 {
+		entries = raw.Upstreams
+	}
+
+	destinations := make([]*Destination, 0, len(entries))
+	for _, e := range entries {
+		weight := e.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		destinations = append(destinations, &Destination{
+			Address: e.Address,
+			Port:    e.Port,
+			Weight:  weight,
+		})
+	}
+
+	return destinations
+}
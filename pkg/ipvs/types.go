@@ -0,0 +1,44 @@
+package ipvs
+
+import "strconv"
+
+// Destination 表示IPVS虚拟服务下的一台真实服务器（real server）
+type Destination struct {
+	Address string `json:"address"` // 真实服务器IP
+	Port    int    `json:"port"`
+	Weight  int    `json:"weight"` // 调度权重，wrr/sh调度器下影响分流比例
+}
+
+// Service 表示一个IPVS虚拟服务（virtual server），由VIP:Port:Protocol唯一标识，
+// 按Scheduler把流量分发到Destinations中的一组真实服务器
+type Service struct {
+	Address      string         `json:"address"`   // 虚拟服务IP（VIP）
+	Port         int            `json:"port"`      // 虚拟服务端口
+	Protocol     string         `json:"protocol"`  // tcp/udp
+	Scheduler    string         `json:"scheduler"` // rr/wrr/sh
+	Destinations []*Destination `json:"destinations"`
+}
+
+// ServiceSet 虚拟服务集合，用于配置对比，key为Service的唯一标识符
+type ServiceSet map[string]*Service
+
+// key 返回Service在ServiceSet中的唯一标识符
+func (s *Service) key() string {
+	return s.Protocol + ":" + s.Address + ":" + strconv.Itoa(s.Port)
+}
+
+// ServiceComparison 虚拟服务比较结果，与iptables.RuleComparison对应
+type ServiceComparison struct {
+	ToAdd     []*Service `json:"to_add"`
+	ToDelete  []*Service `json:"to_delete"`
+	Unchanged []*Service `json:"unchanged"`
+}
+
+// ManagerStats 管理器统计信息
+type ManagerStats struct {
+	DesiredServicesCount int    `json:"desired_services_count"`
+	CurrentServicesCount int    `json:"current_services_count"`
+	Enabled              bool   `json:"enabled"`
+	DefaultScheduler     string `json:"default_scheduler"`
+	LastUpdate           string `json:"last_update"`
+}
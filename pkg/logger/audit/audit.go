@@ -0,0 +1,140 @@
+// Package audit实现结构化的审计事件流：logger.LogAudit/LogStateChange产生的
+// 安全相关事件除了写入常规logrus管道外，还会fan-out到本包注册的Sink（本地
+// 文件、syslog、HTTP webhook）。每个事件都带上单调递增序号和上一事件的哈希，
+// 形成一条篡改后可被发现的链条。
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event 是投递给各Sink的一条审计事件
+type Event struct {
+	ServerID  string                 `json:"server_id"`
+	Sequence  uint64                 `json:"sequence"`
+	Timestamp time.Time              `json:"timestamp"`
+	Action    string                 `json:"action"`
+	User      string                 `json:"user,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	PrevHash  string                 `json:"prev_hash"`
+	Hash      string                 `json:"hash"`
+}
+
+// computeHash对PrevHash/Sequence/ServerID/Action/User/Fields做sha256，任何
+// 一个历史事件被篡改都会导致其后所有事件的Hash对不上PrevHash
+func (e Event) computeHash() string {
+	payload, _ := json.Marshal(struct {
+		PrevHash string                 `json:"prev_hash"`
+		Sequence uint64                 `json:"sequence"`
+		ServerID string                 `json:"server_id"`
+		Action   string                 `json:"action"`
+		User     string                 `json:"user"`
+		Fields   map[string]interface{} `json:"fields"`
+	}{e.PrevHash, e.Sequence, e.ServerID, e.Action, e.User, e.Fields})
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sink 是一个审计事件投递目的地，实现见file.go/syslog.go/webhook.go
+type Sink interface {
+	// Name 返回sink名称，用于错误日志
+	Name() string
+	// Write 投递一条审计事件
+	Write(evt Event) error
+	// Close 释放sink持有的资源（文件句柄、连接等）
+	Close() error
+}
+
+// Config 对应配置文件中的logger.audit块，Enable为false时不创建任何sink，
+// LogAudit/LogStateChange仍正常写入标准logrus管道
+type Config struct {
+	Enable  bool          `yaml:"enable" json:"enable"`
+	File    FileConfig    `yaml:"file" json:"file"`
+	Syslog  SyslogConfig  `yaml:"syslog" json:"syslog"`
+	Webhook WebhookConfig `yaml:"webhook" json:"webhook"`
+}
+
+// Recorder持有当前注册的Sink和哈希链状态
+type Recorder struct {
+	mu       sync.Mutex
+	seq      uint64
+	prevHash string
+	serverID string
+	sinks    []Sink
+}
+
+// NewRecorder按cfg构建并注册启用的Sink。cfg.Enable为false，或三个sink都没
+// 配置地址/路径时，返回的Recorder不持有任何Sink，Record只生成哈希链事件、
+// 不做任何投递
+func NewRecorder(serverID string, apiToken string, cfg Config) (*Recorder, error) {
+	r := &Recorder{serverID: serverID}
+
+	if !cfg.Enable {
+		return r, nil
+	}
+
+	if cfg.File.Path != "" {
+		r.sinks = append(r.sinks, newFileSink(cfg.File))
+	}
+
+	if cfg.Syslog.Address != "" {
+		sink, err := newSyslogSink(cfg.Syslog)
+		if err != nil {
+			return nil, fmt.Errorf("创建syslog审计sink失败: %w", err)
+		}
+		r.sinks = append(r.sinks, sink)
+	}
+
+	if cfg.Webhook.URL != "" {
+		r.sinks = append(r.sinks, newWebhookSink(cfg.Webhook, apiToken))
+	}
+
+	return r, nil
+}
+
+// Record 为action/user/fields生成带序号和哈希链的Event，并fan-out到所有
+// 注册的Sink；单个Sink失败只打到stderr，不影响其余Sink，也不影响调用方
+// （logger包依赖本包，这里不能反过来调用logger.LogError，会造成import cycle）
+func (r *Recorder) Record(action, user string, fields map[string]interface{}) Event {
+	r.mu.Lock()
+	r.seq++
+	evt := Event{
+		ServerID:  r.serverID,
+		Sequence:  r.seq,
+		Timestamp: time.Now(),
+		Action:    action,
+		User:      user,
+		Fields:    fields,
+		PrevHash:  r.prevHash,
+	}
+	evt.Hash = evt.computeHash()
+	r.prevHash = evt.Hash
+	sinks := r.sinks
+	r.mu.Unlock()
+
+	for _, sink := range sinks {
+		if err := sink.Write(evt); err != nil {
+			fmt.Fprintf(os.Stderr, "audit: sink %s写入失败: %v\n", sink.Name(), err)
+		}
+	}
+
+	return evt
+}
+
+// Close 关闭所有已注册的Sink
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	sinks := r.sinks
+	r.mu.Unlock()
+
+	for _, sink := range sinks {
+		sink.Close()
+	}
+}
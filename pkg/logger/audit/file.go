@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"encoding/json"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileConfig 控制本地文件Sink：内容为JSONL（一行一个Event），通过lumberjack
+// 按大小/天数轮转，Path为空时不创建该Sink
+type FileConfig struct {
+	Path       string `yaml:"path" json:"path"`
+	MaxSizeMB  int    `yaml:"max_size" json:"max_size"`
+	MaxBackups int    `yaml:"max_backups" json:"max_backups"`
+	MaxAge     int    `yaml:"max_age" json:"max_age"`
+	Compress   bool   `yaml:"compress" json:"compress"`
+}
+
+// fileSink 把Event以JSONL追加写入本地文件
+type fileSink struct {
+	mu  sync.Mutex
+	out *lumberjack.Logger
+}
+
+func newFileSink(cfg FileConfig) *fileSink {
+	maxSize := cfg.MaxSizeMB
+	if maxSize == 0 {
+		maxSize = 100
+	}
+	maxAge := cfg.MaxAge
+	if maxAge == 0 {
+		maxAge = 90 // 审计日志默认比普通日志(30天)保留更久
+	}
+
+	return &fileSink{
+		out: &lumberjack.Logger{
+			Filename:   cfg.Path,
+			MaxSize:    maxSize,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     maxAge,
+			Compress:   cfg.Compress,
+			LocalTime:  true,
+		},
+	}
+}
+
+func (f *fileSink) Name() string { return "file" }
+
+func (f *fileSink) Write(evt Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.out.Write(data)
+	return err
+}
+
+func (f *fileSink) Close() error {
+	return f.out.Close()
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyslogConfig 控制RFC5424 syslog Sink的远端地址，Address为空时不创建该Sink
+type SyslogConfig struct {
+	Network  string `yaml:"network" json:"network"` // "udp"/"tcp"，默认udp
+	Address  string `yaml:"address" json:"address"`
+	Facility int    `yaml:"facility" json:"facility"` // syslog facility，默认16（local0）
+	AppName  string `yaml:"app_name" json:"app_name"`
+}
+
+// syslogSink 按RFC5424格式把Event拼装成一条结构化日志消息发出。标准库
+// log/syslog只实现了BSD syslog(RFC3164)，这里自行拼装RFC5424帧以保留
+// server_id/sequence等结构化字段，而不是把它们压扁进一条文本消息
+type syslogSink struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	pri      int
+	appName  string
+	hostname string
+}
+
+func newSyslogSink(cfg SyslogConfig) (*syslogSink, error) {
+	network := cfg.Network
+	if network == "" {
+		network = "udp"
+	}
+	facility := cfg.Facility
+	if facility == 0 {
+		facility = 16
+	}
+	appName := cfg.AppName
+	if appName == "" {
+		appName = "nspass-agent"
+	}
+
+	conn, err := net.Dial(network, cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("连接syslog %s://%s失败: %w", network, cfg.Address, err)
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &syslogSink{
+		conn:     conn,
+		pri:      facility*8 + 6, // severity固定为Informational(6)，审计事件本身不代表异常
+		appName:  appName,
+		hostname: hostname,
+	}, nil
+}
+
+func (s *syslogSink) Name() string { return "syslog" }
+
+func (s *syslogSink) Write(evt Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s\n",
+		s.pri, evt.Timestamp.UTC().Format(time.RFC3339), s.hostname, s.appName,
+		os.Getpid(), evt.Action, data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.conn.Write([]byte(msg))
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookConfig 控制HTTP webhook Sink，URL为空时不创建该Sink
+type WebhookConfig struct {
+	URL        string `yaml:"url" json:"url"`
+	Timeout    int    `yaml:"timeout" json:"timeout"`         // 秒，默认5
+	RetryCount int    `yaml:"retry_count" json:"retry_count"` // 默认3
+	RetryDelay int    `yaml:"retry_delay" json:"retry_delay"` // 秒，默认1
+}
+
+// webhookSink把Event以JSON body POST到配置的URL，body用APIConfig.Token做
+// HMAC-SHA256签名并放入X-NSPass-Signature头，供接收端校验完整性和来源；
+// 失败按RetryCount/RetryDelay重试
+type webhookSink struct {
+	client     *http.Client
+	url        string
+	secret     string
+	retryCount int
+	retryDelay time.Duration
+}
+
+func newWebhookSink(cfg WebhookConfig, apiToken string) *webhookSink {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 5
+	}
+	retryCount := cfg.RetryCount
+	if retryCount == 0 {
+		retryCount = 3
+	}
+	retryDelay := cfg.RetryDelay
+	if retryDelay == 0 {
+		retryDelay = 1
+	}
+
+	return &webhookSink{
+		client:     &http.Client{Timeout: time.Duration(timeout) * time.Second},
+		url:        cfg.URL,
+		secret:     apiToken,
+		retryCount: retryCount,
+		retryDelay: time.Duration(retryDelay) * time.Second,
+	}
+}
+
+func (w *webhookSink) Name() string { return "webhook" }
+
+func (w *webhookSink) Write(evt Event) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, []byte(w.secret))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	var lastErr error
+	for attempt := 0; attempt <= w.retryCount; attempt++ {
+		if attempt > 0 {
+			time.Sleep(w.retryDelay)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-NSPass-Signature", signature)
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("webhook返回状态码%d", resp.StatusCode)
+	}
+
+	return fmt.Errorf("投递审计事件到webhook失败（已重试%d次）: %w", w.retryCount, lastErr)
+}
+
+func (w *webhookSink) Close() error {
+	return nil
+}
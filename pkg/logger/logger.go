@@ -8,20 +8,24 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nspass/nspass-agent/pkg/errorbus"
+	"github.com/nspass/nspass-agent/pkg/logger/audit"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // Config 日志配置
 type Config struct {
-	Level      string `yaml:"level" json:"level"`             // 日志级别: debug, info, warn, error
-	Format     string `yaml:"format" json:"format"`           // 日志格式: json, text
-	Output     string `yaml:"output" json:"output"`           // 输出方式: stdout, file, both
-	File       string `yaml:"file" json:"file"`               // 日志文件路径
-	MaxSize    int    `yaml:"max_size" json:"max_size"`       // 单个日志文件最大大小(MB)
-	MaxBackups int    `yaml:"max_backups" json:"max_backups"` // 保留的旧日志文件数量
-	MaxAge     int    `yaml:"max_age" json:"max_age"`         // 日志文件保留天数
-	Compress   bool   `yaml:"compress" json:"compress"`       // 是否压缩旧日志文件
+	Level      string       `yaml:"level" json:"level"`             // 日志级别: debug, info, warn, error
+	Format     string       `yaml:"format" json:"format"`           // 日志格式: json, text
+	Output     string       `yaml:"output" json:"output"`           // 输出方式: stdout, file, both
+	File       string       `yaml:"file" json:"file"`               // 日志文件路径
+	MaxSize    int          `yaml:"max_size" json:"max_size"`       // 单个日志文件最大大小(MB)
+	MaxBackups int          `yaml:"max_backups" json:"max_backups"` // 保留的旧日志文件数量
+	MaxAge     int          `yaml:"max_age" json:"max_age"`         // 日志文件保留天数
+	Compress   bool         `yaml:"compress" json:"compress"`       // 是否压缩旧日志文件
+	Audit      audit.Config `yaml:"audit" json:"audit"`             // 审计事件sink配置，见InitAudit
+	Loki       LokiConfig   `yaml:"loki" json:"loki"`               // Loki推送配置，Host为空时不启用
 }
 
 // DefaultConfig 返回默认配置
@@ -43,8 +47,31 @@ var (
 	globalLogger *logrus.Logger
 	// 组件专用logger映射
 	componentLoggers = make(map[string]*logrus.Entry)
+	// 全局审计事件recorder，nil时LogAudit/LogStateChange只写常规logrus管道
+	auditRecorder *audit.Recorder
+	// 当前启用的Loki推送hook，nil表示未启用；Initialize重新加载配置时据此
+	// 决定是否需要先关闭旧hook
+	currentLokiHook *lokiHook
 )
 
+// InitAudit 按cfg构建审计事件sink并注册为全局recorder，此后LogAudit/
+// LogStateChange产生的事件会fan-out到其中启用的sink。serverID/apiToken分
+// 别用于填充Event.ServerID和给webhook sink签名，对应config.Config的
+// ServerID和API.Token
+func InitAudit(serverID string, apiToken string, cfg audit.Config) error {
+	if auditRecorder != nil {
+		auditRecorder.Close()
+	}
+
+	recorder, err := audit.NewRecorder(serverID, apiToken, cfg)
+	if err != nil {
+		return fmt.Errorf("初始化审计事件sink失败: %w", err)
+	}
+
+	auditRecorder = recorder
+	return nil
+}
+
 // Initialize 初始化全局日志器
 func Initialize(config Config) error {
 	// 创建新的logger实例
@@ -102,7 +129,27 @@ func Initialize(config Config) error {
 	// 设置调用信息（在debug级别时显示）
 	logger.SetReportCaller(level == logrus.DebugLevel)
 
+	// 配置了Loki.Host时挂一个推送hook，批量把日志发往Loki；重新Initialize
+	// （例如热重载）时先关掉旧hook，避免两个hook同时往同一个Loki推重复数据
+	if currentLokiHook != nil {
+		currentLokiHook.Close()
+		currentLokiHook = nil
+	}
+	if config.Loki.Host != "" {
+		hook := newLokiHook(config.Loki)
+		logger.AddHook(hook)
+		currentLokiHook = hook
+	}
+
+	// 每次Initialize都是一个全新的logrus.Logger实例，不存在重复AddHook导致
+	// 同一条日志被errorbus记两次的问题，所以这里无条件挂，不像Loki hook那样
+	// 需要先关掉旧的
+	logger.AddHook(errorbus.NewLogrusHook(errorbus.Global()))
+
 	globalLogger = logger
+	// 已缓存的组件logger绑定在旧的logrus.Logger上，清空后GetComponentLogger
+	// 会基于新logger重新创建，让重新Initialize对组件日志同样生效
+	componentLoggers = make(map[string]*logrus.Entry)
 	return nil
 }
 
@@ -152,8 +199,10 @@ func GetComponentLogger(component string) *logrus.Entry {
 func GetAPILogger() *logrus.Entry      { return GetComponentLogger("api") }
 func GetProxyLogger() *logrus.Entry    { return GetComponentLogger("proxy") }
 func GetIPTablesLogger() *logrus.Entry { return GetComponentLogger("iptables") }
+func GetIPVSLogger() *logrus.Entry     { return GetComponentLogger("ipvs") }
 func GetConfigLogger() *logrus.Entry   { return GetComponentLogger("config") }
 func GetSystemLogger() *logrus.Entry   { return GetComponentLogger("system") }
+func GetAdminLogger() *logrus.Entry    { return GetComponentLogger("admin") }
 
 // 辅助方法 - 用于创建带有额外上下文的logger
 func WithField(key string, value interface{}) *logrus.Entry {
@@ -180,7 +229,8 @@ func LogPerformance(operation string, duration time.Duration, fields logrus.Fiel
 	GetLogger().WithFields(fields).Info("性能指标")
 }
 
-// 审计日志
+// 审计日志。除了写入常规logrus管道外，还会fan-out到InitAudit注册的Sink，
+// 事件带上单调递增序号和前一事件哈希，串成一条可检测篡改的链条
 func LogAudit(action string, user string, fields logrus.Fields) {
 	if fields == nil {
 		fields = logrus.Fields{}
@@ -189,9 +239,27 @@ func LogAudit(action string, user string, fields logrus.Fields) {
 	fields["user"] = user
 	fields["audit"] = true
 
+	recordAuditEvent(action, user, fields)
+
 	GetLogger().WithFields(fields).Info("审计日志")
 }
 
+// recordAuditEvent把fields转换为audit.Event所需的map并投递给全局recorder，
+// auditRecorder为nil（未调用InitAudit，或InitAudit时Audit.Enable为false）
+// 时是no-op
+func recordAuditEvent(action, user string, fields logrus.Fields) {
+	if auditRecorder == nil {
+		return
+	}
+
+	plain := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		plain[k] = v
+	}
+
+	auditRecorder.Record(action, user, plain)
+}
+
 // 错误日志增强
 func LogError(err error, message string, fields logrus.Fields) {
 	if fields == nil {
@@ -220,13 +288,17 @@ func LogShutdown(component string, duration time.Duration) {
 	}).Info("组件关闭")
 }
 
-// 状态变更日志
+// 状态变更日志，同LogAudit一样fan-out到审计sink，action固定为"state_change"
 func LogStateChange(component string, from string, to string, reason string) {
-	GetLogger().WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"component":    component,
 		"state_from":   from,
 		"state_to":     to,
 		"reason":       reason,
 		"state_change": true,
-	}).Info("状态变更")
+	}
+
+	recordAuditEvent("state_change", component, fields)
+
+	GetLogger().WithFields(fields).Info("状态变更")
 }
@@ -0,0 +1,275 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LokiConfig 控制把logrus日志推送到Loki的hook，Host为空时不启用
+type LokiConfig struct {
+	Host          string            `yaml:"host" json:"host"`
+	Port          int               `yaml:"port" json:"port"`                     // 默认3100
+	Source        string            `yaml:"source" json:"source"`                 // Loki里的app标签，默认nspass-agent
+	Labels        map[string]string `yaml:"labels" json:"labels"`                 // 附加静态标签，与按条目派生的component/server_id/level合并
+	BatchSize     int               `yaml:"batch_size" json:"batch_size"`         // 攒够多少条就立即flush一次，默认100
+	FlushInterval int               `yaml:"flush_interval" json:"flush_interval"` // 秒，默认5
+}
+
+const (
+	defaultLokiBatchSize     = 100
+	defaultLokiFlushInterval = 5
+	lokiPushPathFormat       = "http://%s:%d/loki/api/v1/push"
+)
+
+// lokiEntry是lokiHook缓冲区里的一条待推送日志
+type lokiEntry struct {
+	labels map[string]string
+	ts     time.Time
+	line   string
+}
+
+// lokiHook是一个logrus.Hook：把写入的每条日志攒进缓冲区，按BatchSize或
+// FlushInterval触发批量POST到Loki，同一批次里label集合相同的条目合并成一个
+// stream，对429/5xx做指数退避重试
+type lokiHook struct {
+	url          string
+	source       string
+	staticLabels map[string]string
+	client       *http.Client
+
+	batchSize     int
+	flushInterval time.Duration
+
+	mu  sync.Mutex
+	buf []lokiEntry
+
+	flushCh chan struct{}
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newLokiHook 创建并启动后台flush goroutine
+func newLokiHook(cfg LokiConfig) *lokiHook {
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultLokiBatchSize
+	}
+	flushInterval := cfg.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = defaultLokiFlushInterval
+	}
+	source := cfg.Source
+	if source == "" {
+		source = "nspass-agent"
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 3100
+	}
+
+	h := &lokiHook{
+		url:           fmt.Sprintf(lokiPushPathFormat, cfg.Host, port),
+		source:        source,
+		staticLabels:  cfg.Labels,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		batchSize:     batchSize,
+		flushInterval: time.Duration(flushInterval) * time.Second,
+		flushCh:       make(chan struct{}, 1),
+		closeCh:       make(chan struct{}),
+	}
+
+	h.wg.Add(1)
+	go h.loop()
+
+	return h
+}
+
+func (h *lokiHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire把entry转成一条lokiEntry塞进缓冲区；component/server_id标签直接取自
+// entry已有的字段（GetComponentLogger设的component，各调用点WithFields/
+// logrus.Fields里常带的server_id），不需要调用方为Loki单独打一遍标签
+func (h *lokiHook) Fire(entry *logrus.Entry) error {
+	labels := map[string]string{
+		"app":   h.source,
+		"level": entry.Level.String(),
+	}
+	for k, v := range h.staticLabels {
+		labels[k] = v
+	}
+	if component, ok := entry.Data["component"].(string); ok && component != "" {
+		labels["component"] = component
+	}
+	if serverID, ok := entry.Data["server_id"].(string); ok && serverID != "" {
+		labels["server_id"] = serverID
+	}
+
+	line, err := entry.String()
+	if err != nil {
+		line = entry.Message
+	}
+
+	h.mu.Lock()
+	h.buf = append(h.buf, lokiEntry{labels: labels, ts: entry.Time, line: line})
+	full := len(h.buf) >= h.batchSize
+	h.mu.Unlock()
+
+	if full {
+		select {
+		case h.flushCh <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (h *lokiHook) loop() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(h.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.flush()
+		case <-h.flushCh:
+			h.flush()
+		case <-h.closeCh:
+			h.flush()
+			return
+		}
+	}
+}
+
+func (h *lokiHook) flush() {
+	h.mu.Lock()
+	if len(h.buf) == 0 {
+		h.mu.Unlock()
+		return
+	}
+	batch := h.buf
+	h.buf = nil
+	h.mu.Unlock()
+
+	if err := h.push(batch); err != nil {
+		fmt.Fprintf(os.Stderr, "logger: 推送日志到Loki失败: %v\n", err)
+	}
+}
+
+// lokiStream对应Loki push API里streams数组的一个元素
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// push把entries按label集合分组成Loki streams，JSON编码后gzip压缩POST给
+// Loki，429/5xx按指数退避重试，其余状态码视为不可重试的失败
+func (h *lokiHook) push(entries []lokiEntry) error {
+	streams := make(map[string]*lokiStream)
+	order := make([]string, 0)
+	for _, e := range entries {
+		key := streamKey(e.labels)
+		s, ok := streams[key]
+		if !ok {
+			s = &lokiStream{Stream: e.labels}
+			streams[key] = s
+			order = append(order, key)
+		}
+		s.Values = append(s.Values, [2]string{fmt.Sprintf("%d", e.ts.UnixNano()), e.line})
+	}
+
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, k := range order {
+		payload.Streams = append(payload.Streams, streams[k])
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("序列化Loki推送payload失败: %w", err)
+	}
+
+	var gzBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	if _, err := gw.Write(body); err != nil {
+		return fmt.Errorf("gzip压缩Loki推送payload失败: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("gzip压缩Loki推送payload失败: %w", err)
+	}
+	payloadBytes := gzBuf.Bytes()
+
+	const maxAttempts = 3
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<uint(attempt)) * time.Second)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(payloadBytes))
+		if err != nil {
+			return fmt.Errorf("创建Loki推送请求失败: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+
+		resp, err := h.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("Loki返回状态码%d", resp.StatusCode)
+			continue
+		}
+		return fmt.Errorf("Loki返回状态码%d", resp.StatusCode)
+	}
+
+	return fmt.Errorf("推送日志到Loki失败（已尝试%d次）: %w", maxAttempts, lastErr)
+}
+
+// streamKey把label集合序列化成一个确定性字符串，用作streams map的key，
+// 保证同一label集合的条目总是聚合进同一个stream
+func streamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+// Close停止后台flush goroutine并做最后一次flush，不丢失关闭前缓冲的日志
+func (h *lokiHook) Close() error {
+	close(h.closeCh)
+	h.wg.Wait()
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package pkgmgr将"用哪种方式安装代理软件包"这件事从各代理实现里剥离出来，
+// 统一为Installer接口：apt/dnf/yum/zypper/pacman/apk等原生包管理器各有一个
+// 实现，找不到原生包管理器时回退到从镜像下载并校验签名的静态二进制安装方式。
+package pkgmgr
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Plan 描述Install将要执行的动作，由Installer.Plan不产生副作用地返回；DryRun
+// 模式下Install只把该计划写入日志而不实际执行，供操作员审计Agent打算在宿主机
+// 上做什么
+type Plan struct {
+	Installer  string   `json:"installer"`
+	Package    string   `json:"package"`
+	Command    []string `json:"command,omitempty"`
+	SourceURL  string   `json:"source_url,omitempty"`
+	InstallDir string   `json:"install_dir,omitempty"`
+}
+
+// Installer 是安装/卸载一个软件包的最小接口，使具体的包管理后端可插拔
+type Installer interface {
+	// Name 返回安装器名称，用于写入启动日志和Plan.Installer
+	Name() string
+	// Available 判断该安装器在当前宿主机上是否可用
+	Available() bool
+	// Plan 返回Install实际会执行的安装命令或下载地址，不产生副作用。Command只含
+	// 安装命令本身，不含apt-get update这类安装前执行的包列表更新命令
+	Plan(packageName string) Plan
+	// Install 安装packageName，dryRun为true时只记录计划、不执行
+	Install(packageName string, dryRun bool) error
+	// Remove 卸载packageName
+	Remove(packageName string) error
+}
+
+// Resolve按"显式override → 探测到的原生包管理器 → 静态二进制兜底"的顺序选出
+// Installer。installDir仅静态二进制安装器会用到
+func Resolve(cfg config.PackageManagerConfig, installDir string) Installer {
+	native := nativeInstallers()
+
+	if cfg.Override != "" {
+		if cfg.Override == staticInstallerName {
+			return newStaticBinaryInstaller(cfg.StaticBinary, installDir)
+		}
+		for _, inst := range native {
+			if inst.Name() == cfg.Override {
+				return inst
+			}
+		}
+		logger.GetProxyLogger().WithField("override", cfg.Override).
+			Warn("配置指定的包管理器未识别，回退到自动探测")
+	}
+
+	for _, inst := range native {
+		if inst.Available() {
+			return inst
+		}
+	}
+
+	return newStaticBinaryInstaller(cfg.StaticBinary, installDir)
+}
+
+// nativeInstallers按常见发行版的优先级返回所有已知原生包管理器，Resolve会
+// 依次探测其中第一个在当前宿主机上Available()的
+func nativeInstallers() []Installer {
+	return []Installer{
+		&execInstaller{name: "apt", lookPath: "apt-get",
+			updateArgs:  []string{"apt-get", "update"},
+			installArgs: func(pkg string) []string { return []string{"apt-get", "install", "-y", pkg} },
+			removeArgs:  func(pkg string) []string { return []string{"apt-get", "remove", "-y", pkg} },
+		},
+		&execInstaller{name: "dnf", lookPath: "dnf",
+			installArgs: func(pkg string) []string { return []string{"dnf", "install", "-y", pkg} },
+			removeArgs:  func(pkg string) []string { return []string{"dnf", "remove", "-y", pkg} },
+		},
+		&execInstaller{name: "yum", lookPath: "yum",
+			installArgs: func(pkg string) []string { return []string{"yum", "install", "-y", pkg} },
+			removeArgs:  func(pkg string) []string { return []string{"yum", "remove", "-y", pkg} },
+		},
+		&execInstaller{name: "zypper", lookPath: "zypper",
+			installArgs: func(pkg string) []string { return []string{"zypper", "--non-interactive", "install", pkg} },
+			removeArgs:  func(pkg string) []string { return []string{"zypper", "--non-interactive", "remove", pkg} },
+		},
+		&execInstaller{name: "pacman", lookPath: "pacman",
+			installArgs: func(pkg string) []string { return []string{"pacman", "-S", "--noconfirm", pkg} },
+			removeArgs:  func(pkg string) []string { return []string{"pacman", "-R", "--noconfirm", pkg} },
+		},
+		&execInstaller{name: "apk", lookPath: "apk",
+			installArgs: func(pkg string) []string { return []string{"apk", "add", "--no-cache", pkg} },
+			removeArgs:  func(pkg string) []string { return []string{"apk", "del", pkg} },
+		},
+	}
+}
+
+// execInstaller 是直接shell out到系统包管理器命令行工具的Installer实现，
+// apt/dnf/yum/zypper/pacman/apk共用同一套执行逻辑，只是命令行不同
+type execInstaller struct {
+	name string
+
+	lookPath    string
+	updateArgs  []string // 可选，安装前需要先执行的命令，例如apt-get update
+	installArgs func(pkg string) []string
+	removeArgs  func(pkg string) []string
+}
+
+func (e *execInstaller) Name() string { return e.name }
+
+func (e *execInstaller) Available() bool {
+	_, err := exec.LookPath(e.lookPath)
+	return err == nil
+}
+
+func (e *execInstaller) Plan(packageName string) Plan {
+	return Plan{Installer: e.name, Package: packageName, Command: e.installArgs(packageName)}
+}
+
+func (e *execInstaller) Install(packageName string, dryRun bool) error {
+	plan := e.Plan(packageName)
+	if dryRun {
+		logger.GetProxyLogger().WithField("plan", plan).Info("dry-run：跳过实际安装")
+		return nil
+	}
+
+	if len(e.updateArgs) > 0 {
+		if err := runCommand(e.updateArgs); err != nil {
+			return fmt.Errorf("更新%s包列表失败: %w", e.name, err)
+		}
+	}
+
+	if err := runCommand(plan.Command); err != nil {
+		return fmt.Errorf("使用%s安装%s失败: %w", e.name, packageName, err)
+	}
+
+	return nil
+}
+
+func (e *execInstaller) Remove(packageName string) error {
+	if err := runCommand(e.removeArgs(packageName)); err != nil {
+		return fmt.Errorf("使用%s卸载%s失败: %w", e.name, packageName, err)
+	}
+	return nil
+}
+
+func runCommand(args []string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Run(); err != nil {
+		logger.LogError(err, "执行包管理命令失败", logrus.Fields{
+			"command": args,
+		})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package pkgmgr
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// staticInstallerName是config.PackageManagerConfig.Override用来显式选择
+// staticBinaryInstaller的取值
+const staticInstallerName = "static"
+
+// staticBinaryInstaller 在宿主机没有任何已知原生包管理器时兜底：从配置的镜像
+// 下载一个预先钉住版本的发布包，校验SHA256（以及可选的GPG/cosign签名），解压
+// 到installDir
+type staticBinaryInstaller struct {
+	cfg        config.StaticBinaryConfig
+	installDir string
+}
+
+func newStaticBinaryInstaller(cfg config.StaticBinaryConfig, installDir string) *staticBinaryInstaller {
+	return &staticBinaryInstaller{cfg: cfg, installDir: installDir}
+}
+
+func (s *staticBinaryInstaller) Name() string { return staticInstallerName }
+
+// Available 静态二进制安装方式本身不依赖宿主机工具，只要配置了下载地址即视为可用
+func (s *staticBinaryInstaller) Available() bool {
+	return s.cfg.MirrorURL != ""
+}
+
+func (s *staticBinaryInstaller) sourceURL(packageName string) string {
+	url := s.cfg.MirrorURL
+	url = strings.ReplaceAll(url, "{package}", packageName)
+	url = strings.ReplaceAll(url, "{version}", s.cfg.Version)
+	return url
+}
+
+func (s *staticBinaryInstaller) Plan(packageName string) Plan {
+	return Plan{
+		Installer:  staticInstallerName,
+		Package:    packageName,
+		SourceURL:  s.sourceURL(packageName),
+		InstallDir: s.installDir,
+	}
+}
+
+// Install 下载packageName对应的发布包、校验后解压到installDir。dryRun为true时
+// 只打印计划，不发起任何网络请求
+func (s *staticBinaryInstaller) Install(packageName string, dryRun bool) error {
+	plan := s.Plan(packageName)
+	if dryRun {
+		logger.GetProxyLogger().WithField("plan", plan).Info("dry-run：跳过实际下载安装")
+		return nil
+	}
+
+	if s.cfg.MirrorURL == "" {
+		return fmt.Errorf("未配置静态二进制下载镜像，无法安装%s", packageName)
+	}
+
+	tmpFile, err := s.download(plan.SourceURL)
+	if err != nil {
+		return fmt.Errorf("下载%s失败: %w", packageName, err)
+	}
+	defer os.Remove(tmpFile)
+
+	// 签名文件与发布包放在镜像的同一位置、URL多一个.asc/.sig后缀，下载到
+	// tmpFile旁边供verify校验
+	for ext, key := range map[string]string{".asc": s.cfg.GPGPublicKey, ".sig": s.cfg.CosignPublicKey} {
+		if key == "" {
+			continue
+		}
+		sigFile, err := s.download(plan.SourceURL + ext)
+		if err != nil {
+			return fmt.Errorf("下载%s的签名文件失败: %w", packageName, err)
+		}
+		defer os.Remove(tmpFile + ext)
+		if err := os.Rename(sigFile, tmpFile+ext); err != nil {
+			os.Remove(sigFile)
+			return fmt.Errorf("保存%s的签名文件失败: %w", packageName, err)
+		}
+	}
+
+	if err := s.verify(tmpFile); err != nil {
+		return fmt.Errorf("校验%s失败: %w", packageName, err)
+	}
+
+	if err := os.MkdirAll(s.installDir, 0755); err != nil {
+		return fmt.Errorf("创建安装目录%s失败: %w", s.installDir, err)
+	}
+
+	if err := extractTarGz(tmpFile, s.installDir); err != nil {
+		return fmt.Errorf("解压%s失败: %w", packageName, err)
+	}
+
+	return nil
+}
+
+// Remove 静态二进制安装方式没有包管理数据库可查，只能由调用方自行清理
+// installDir，这里仅作为Installer接口的占位实现
+func (s *staticBinaryInstaller) Remove(packageName string) error {
+	return fmt.Errorf("静态二进制安装的%s需手动清理%s", packageName, s.installDir)
+}
+
+func (s *staticBinaryInstaller) download(url string) (string, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("下载%s返回状态码%d", url, resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp("", "nspass-pkg-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// verify 校验下载文件的SHA256，并在配置了GPG/cosign公钥时额外校验同目录下的
+// .asc/.sig签名文件
+func (s *staticBinaryInstaller) verify(path string) error {
+	if s.cfg.SHA256 != "" {
+		if err := verifySHA256(path, s.cfg.SHA256); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.GPGPublicKey != "" {
+		if err := exec.Command("gpg", "--batch", "--no-default-keyring",
+			"--keyring", s.cfg.GPGPublicKey, "--verify", path+".asc", path).Run(); err != nil {
+			return fmt.Errorf("GPG签名校验失败: %w", err)
+		}
+	}
+
+	if s.cfg.CosignPublicKey != "" {
+		if err := exec.Command("cosign", "verify-blob",
+			"--key", s.cfg.CosignPublicKey, "--signature", path+".sig", path).Run(); err != nil {
+			return fmt.Errorf("cosign签名校验失败: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func verifySHA256(path, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("SHA256不匹配：期望%s，实际%s", expected, actual)
+	}
+	return nil
+}
+
+// extractTarGz 将tar.gz格式的发布包解压到destDir
+func extractTarGz(archivePath, destDir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destDir, filepath.Clean("/"+header.Name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			out.Close()
+		}
+	}
+}
@@ -12,6 +12,8 @@ import (
 
 	"github.com/nspass/nspass-agent/pkg/config"
 	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/pkgmgr"
+	"github.com/nspass/nspass-agent/pkg/proxy/lifecycle"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,20 +23,28 @@ type BaseProxy struct {
 	config     config.ProxyConfig
 	configPath string
 	pidFile    string
+
+	installer pkgmgr.Installer  // 安装软件包时使用的包管理器，按config.ProxyConfig.PackageManager解析
+	backend   lifecycle.Backend // 非nil时代表子进程由lifecycle后端（supervisor或systemd）持有
 }
 
 // NewBaseProxy 创建基础代理实例
 func NewBaseProxy(proxyType string, cfg config.ProxyConfig, configFileName string) *BaseProxy {
+	installer := pkgmgr.Resolve(cfg.PackageManager, cfg.BinPath)
+
 	base := &BaseProxy{
 		proxyType:  proxyType,
 		config:     cfg,
 		configPath: filepath.Join(cfg.ConfigPath, configFileName),
 		pidFile:    filepath.Join(cfg.ConfigPath, proxyType+".pid"),
+		installer:  installer,
 	}
 
 	logger.LogStartup(proxyType+"-proxy", "1.0", map[string]interface{}{
 		"config_path": base.configPath,
 		"pid_file":    base.pidFile,
+		"installer":   installer.Name(),
+		"version":     cfg.PackageManager.StaticBinary.Version,
 	})
 
 	return base
@@ -60,63 +70,34 @@ func (b *BaseProxy) EnsureConfigDirectory() error {
 	configDir := filepath.Dir(b.configPath)
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		logger.LogError(err, "创建配置目录失败", logrus.Fields{
-			"proxy_type":  b.proxyType,
-			"config_dir":  configDir,
+			"proxy_type": b.proxyType,
+			"config_dir": configDir,
 		})
 		return fmt.Errorf("创建配置目录失败: %w", err)
 	}
 	return nil
 }
 
-// InstallPackage 使用系统包管理器安装软件包
+// InstallPackage 通过pkgmgr.Resolve选出的安装器安装软件包。是否为dry-run取自
+// b.config.PackageManager.DryRun
 func (b *BaseProxy) InstallPackage(packageName string) error {
 	startTime := time.Now()
 	log := logger.GetProxyLogger().WithField("proxy_type", b.proxyType)
 
-	var cmd *exec.Cmd
-	var pkgManager string
-
-	if _, err := exec.LookPath("apt-get"); err == nil {
-		// Debian/Ubuntu
-		pkgManager = "apt-get"
-		log.Debug("使用apt-get包管理器")
-		// 更新包列表
-		cmd = exec.Command("apt-get", "update")
-		if err := cmd.Run(); err != nil {
-			logger.LogError(err, "更新包列表失败", logrus.Fields{
-				"pkg_manager": pkgManager,
-			})
-			return fmt.Errorf("更新包列表失败: %w", err)
-		}
-		cmd = exec.Command("apt-get", "install", "-y", packageName)
-	} else if _, err := exec.LookPath("yum"); err == nil {
-		// CentOS/RHEL
-		pkgManager = "yum"
-		log.Debug("使用yum包管理器")
-		cmd = exec.Command("yum", "install", "-y", packageName)
-	} else if _, err := exec.LookPath("pacman"); err == nil {
-		// Arch Linux
-		pkgManager = "pacman"
-		log.Debug("使用pacman包管理器")
-		cmd = exec.Command("pacman", "-S", "--noconfirm", packageName)
-	} else {
-		logger.LogError(fmt.Errorf("未找到支持的包管理器"),
-			"不支持的系统，无法自动安装"+b.proxyType, nil)
-		return fmt.Errorf("不支持的系统，无法自动安装%s", b.proxyType)
-	}
+	log.WithField("installer", b.installer.Name()).Debug("使用已解析的包管理器安装")
 
-	if err := cmd.Run(); err != nil {
+	if err := b.installer.Install(packageName, b.config.PackageManager.DryRun); err != nil {
 		logger.LogError(err, "安装软件包失败", logrus.Fields{
-			"pkg_manager": pkgManager,
-			"package":     packageName,
+			"installer": b.installer.Name(),
+			"package":   packageName,
 		})
 		return fmt.Errorf("安装%s失败: %w", packageName, err)
 	}
 
 	duration := time.Since(startTime)
 	logger.LogPerformance(b.proxyType+"_install", duration, logrus.Fields{
-		"pkg_manager": pkgManager,
-		"package":     packageName,
+		"installer": b.installer.Name(),
+		"package":   packageName,
 	})
 
 	log.WithFields(logrus.Fields{
@@ -158,8 +139,35 @@ func (b *BaseProxy) CreateBinaryPlaceholder(installDir, binaryName string) error
 	return nil
 }
 
-// IsRunning 检查进程是否在运行
+// StartSupervised 启动子进程并交由当前激活的生命周期后端持有：宿主机有systemd
+// 时生成/安装unit并通过dbus驱动，否则回退到进程内supervisor。newCmd用于（重新）
+// 构造*exec.Cmd。调用后GetStatus/IsRunning均改为反映该后端的状态
+func (b *BaseProxy) StartSupervised(newCmd func() *exec.Cmd) error {
+	backend, err := lifecycle.Launch(b.proxyType, b.proxyType, b.pidFile, newCmd, b.config)
+	if err != nil {
+		return fmt.Errorf("启动%s监管进程失败: %w", b.proxyType, err)
+	}
+	b.backend = backend
+	return nil
+}
+
+// StopSupervised 停止由StartSupervised启动的受监管子进程
+func (b *BaseProxy) StopSupervised(timeout time.Duration) error {
+	if b.backend == nil {
+		return fmt.Errorf("%s未通过生命周期后端启动", b.proxyType)
+	}
+	err := b.backend.Stop(timeout)
+	b.backend = nil
+	return err
+}
+
+// IsRunning 检查进程是否在运行。由生命周期后端管理时直接反映其状态；否则回退到
+// PID文件探活，仅作为外部启动进程（未经由本Agent管理）的只读兜底
 func (b *BaseProxy) IsRunning() bool {
+	if b.backend != nil {
+		return b.backend.IsRunning()
+	}
+
 	pid := b.GetPID()
 	if pid == 0 {
 		return false
@@ -208,13 +216,36 @@ func (b *BaseProxy) RemovePIDFile() error {
 	return os.Remove(b.pidFile)
 }
 
-// StopProcess 停止进程
+// ShutdownStep 表示优雅停机升级阶梯中的一步：发送Signal后最多等待Wait时间
+type ShutdownStep struct {
+	Signal syscall.Signal
+	Wait   time.Duration
+}
+
+// DefaultShutdownLadder 返回默认的停机升级阶梯：SIGTERM等待10秒后SIGKILL
+func DefaultShutdownLadder() []ShutdownStep {
+	return []ShutdownStep{
+		{Signal: syscall.SIGTERM, Wait: 10 * time.Second},
+		{Signal: syscall.SIGKILL, Wait: 2 * time.Second},
+	}
+}
+
+// StopProcess 停止进程，使用默认的SIGTERM->SIGKILL升级阶梯
 func (b *BaseProxy) StopProcess() error {
+	return b.StopProcessWithLadder(DefaultShutdownLadder())
+}
+
+// StopProcessWithLadder 按照自定义的升级阶梯停止进程
+func (b *BaseProxy) StopProcessWithLadder(ladder []ShutdownStep) error {
 	pid := b.GetPID()
 	if pid == 0 {
 		return fmt.Errorf("进程未运行")
 	}
 
+	if len(ladder) == 0 {
+		ladder = DefaultShutdownLadder()
+	}
+
 	log := logger.GetProxyLogger().WithFields(logrus.Fields{
 		"proxy_type": b.proxyType,
 		"pid":        pid,
@@ -226,36 +257,44 @@ func (b *BaseProxy) StopProcess() error {
 		return fmt.Errorf("找不到进程: %w", err)
 	}
 
-	// 先尝试SIGTERM
-	if err := process.Signal(syscall.SIGTERM); err != nil {
-		log.WithError(err).Warn("发送SIGTERM信号失败，尝试SIGKILL")
-		if err := process.Signal(syscall.SIGKILL); err != nil {
-			log.WithError(err).Error("发送SIGKILL信号失败")
-			return fmt.Errorf("停止进程失败: %w", err)
-		}
-	}
-
-	// 等待进程退出
 	done := make(chan bool, 1)
 	go func() {
 		process.Wait()
 		done <- true
 	}()
 
-	select {
-	case <-done:
-		log.Info("进程已成功停止")
-	case <-time.After(10 * time.Second):
-		log.Warn("等待进程退出超时，强制终止")
-		process.Signal(syscall.SIGKILL)
+	for i, step := range ladder {
+		stepLog := log.WithFields(logrus.Fields{
+			"step":   i + 1,
+			"signal": step.Signal,
+			"wait":   step.Wait,
+		})
+
+		if err := process.Signal(step.Signal); err != nil {
+			stepLog.WithError(err).Warn("发送信号失败，尝试升级阶梯的下一步")
+			continue
+		}
+		stepLog.Info("已发送停机信号，等待进程退出")
+
+		select {
+		case <-done:
+			log.Info("进程已成功停止")
+			return b.RemovePIDFile()
+		case <-time.After(step.Wait):
+			stepLog.Warn("等待进程退出超时，升级到下一步")
+		}
 	}
 
-	// 清理PID文件
-	return b.RemovePIDFile()
+	log.Error("升级阶梯已用尽，进程仍未退出")
+	return fmt.Errorf("停止进程 %s(pid=%d) 失败：升级阶梯已用尽", b.proxyType, pid)
 }
 
-// GetStatus 获取状态
+// GetStatus 获取状态。由生命周期后端管理时直接转发其状态；否则回退到PID文件
+// 探活，仅作为外部启动进程的只读兜底
 func (b *BaseProxy) GetStatus() (string, error) {
+	if b.backend != nil {
+		return b.backend.Status()
+	}
 	if b.IsRunning() {
 		return "running", nil
 	}
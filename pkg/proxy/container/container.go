@@ -0,0 +1,188 @@
+// Package container通过docker/podman run驱动代理进程的生命周期，作为
+// pkg/proxy/supervisor（进程内监管）和pkg/proxy/systemd（systemd/dbus）之外
+// 的第三种lifecycle.Backend：代理进程运行在独立容器里，重启策略、资源限制和
+// 日志收集都交由容器运行时自身完成。
+package container
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Backend 是lifecycle.Backend的容器实现：每个代理实例对应一个独立命名的容器，
+// 避免同类型多实例互相冲突
+type Backend struct {
+	proxyType     string
+	containerName string
+	runtime       string
+	stopLogs      func()
+}
+
+// Available 判断runtime指定的容器运行时CLI是否存在；runtime为空时优先探测
+// docker，不存在则尝试podman，都不存在返回("", false)
+func Available(runtime string) (string, bool) {
+	if runtime != "" {
+		_, err := exec.LookPath(runtime)
+		return runtime, err == nil
+	}
+
+	for _, candidate := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(candidate); err == nil {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// New 用cfg.Supervisor.ContainerImage为proxyType的id实例启动一个容器，newCmd
+// 构造的*exec.Cmd描述容器内实际要跑的二进制和参数（与其他生命周期后端保持
+// 同一套构造方式），容器以--network host运行以复用代理原本依赖的本机网络
+// 命名空间，并把ConfigPath和二进制所在目录只读挂载进容器，使其能读到Agent
+// 已经渲染好的配置文件而不必重新打包镜像
+func New(proxyType, id string, newCmd func() *exec.Cmd, cfg config.ProxyConfig) (*Backend, error) {
+	runtime, ok := Available(cfg.Supervisor.ContainerRuntime)
+	if !ok {
+		return nil, fmt.Errorf("容器生命周期后端不可用：未找到docker或podman可执行文件")
+	}
+
+	if cfg.Supervisor.ContainerImage == "" {
+		return nil, fmt.Errorf("容器生命周期后端已指定但supervisor.container_image为空")
+	}
+
+	containerName := "nspass-" + proxyType + "-" + id
+
+	// 容器可能由上一次Agent运行遗留，先清理同名容器避免启动时名称冲突
+	_ = exec.Command(runtime, "rm", "-f", containerName).Run()
+
+	cmd := newCmd()
+	binDir := cmd.Path[:strings.LastIndex(cmd.Path, "/")]
+
+	args := []string{
+		"run", "-d",
+		"--name", containerName,
+		"--network", "host",
+		"-v", cfg.ConfigPath + ":" + cfg.ConfigPath + ":ro",
+		"-v", binDir + ":" + binDir + ":ro",
+		cfg.Supervisor.ContainerImage,
+		cmd.Path,
+	}
+	args = append(args, cmd.Args[1:]...)
+
+	if out, err := exec.Command(runtime, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("启动容器%s失败: %w (%s)", containerName, err, strings.TrimSpace(string(out)))
+	}
+
+	b := &Backend{proxyType: proxyType, containerName: containerName, runtime: runtime}
+	b.tailLogs()
+
+	logger.GetProxyLogger().WithFields(logrus.Fields{
+		"proxy_type": proxyType,
+		"container":  containerName,
+		"runtime":    runtime,
+	}).Info("已通过容器运行时启动代理服务")
+
+	return b, nil
+}
+
+// tailLogs启动一个runtime logs -f后台进程，把容器输出接入agent自身的logger，
+// 与systemd后端tailJournal的做法一致
+func (b *Backend) tailLogs() {
+	log := logger.GetComponentLogger(b.proxyType)
+
+	cmd := exec.Command(b.runtime, "logs", "-f", b.containerName)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.WithError(err).Warn("创建容器日志管道失败，跳过日志接入")
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		log.WithError(err).Warn("跟踪容器日志失败，跳过日志接入")
+		return
+	}
+
+	b.stopLogs = func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			log.Info(scanner.Text())
+		}
+		cmd.Wait()
+	}()
+}
+
+// Status 查询容器状态并映射为与supervisor/systemd后端一致的状态命名
+func (b *Backend) Status() (string, error) {
+	out, err := exec.Command(b.runtime, "inspect", "-f", "{{.State.Status}}", b.containerName).Output()
+	if err != nil {
+		return "", fmt.Errorf("查询容器%s状态失败: %w", b.containerName, err)
+	}
+
+	switch strings.TrimSpace(string(out)) {
+	case "running":
+		return "running", nil
+	case "created", "restarting":
+		return "starting", nil
+	case "exited", "dead":
+		return "stopped", nil
+	default:
+		return "stopped", nil
+	}
+}
+
+// IsRunning 实现lifecycle.Backend接口
+func (b *Backend) IsRunning() bool {
+	state, err := b.Status()
+	return err == nil && state == "running"
+}
+
+// Pid 实现lifecycle.Backend接口，查询容器主进程在宿主机PID命名空间里的PID
+func (b *Backend) Pid() (int, bool) {
+	out, err := exec.Command(b.runtime, "inspect", "-f", "{{.State.Pid}}", b.containerName).Output()
+	if err != nil {
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || pid == 0 {
+		return 0, false
+	}
+
+	return pid, true
+}
+
+// Stop 停止并移除容器，结束日志跟踪
+func (b *Backend) Stop(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+5*time.Second)
+	defer cancel()
+
+	timeoutSeconds := int(timeout.Seconds())
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = 1
+	}
+
+	if err := exec.CommandContext(ctx, b.runtime, "stop", "-t", strconv.Itoa(timeoutSeconds), b.containerName).Run(); err != nil {
+		return fmt.Errorf("停止容器%s失败: %w", b.containerName, err)
+	}
+
+	if b.stopLogs != nil {
+		b.stopLogs()
+	}
+
+	_ = exec.Command(b.runtime, "rm", "-f", b.containerName).Run()
+
+	return nil
+}
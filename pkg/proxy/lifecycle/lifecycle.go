@@ -0,0 +1,164 @@
+// Package lifecycle在pkg/proxy/supervisor（进程内监管）、pkg/proxy/systemd
+// （systemd/dbus）和pkg/proxy/container（docker/podman run）三种生命周期后端
+// 之间做选择，让BaseProxy和各代理实现只需面向同一套Backend接口，无需关心
+// 宿主机上实际用的是哪一种。
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/proxy/container"
+	"github.com/nspass/nspass-agent/pkg/proxy/supervisor"
+	"github.com/nspass/nspass-agent/pkg/proxy/systemd"
+)
+
+// Backend 是代理生命周期后端的统一接口，supervisor.Supervisor和systemd.Backend
+// 都满足该接口
+type Backend interface {
+	Status() (string, error)
+	IsRunning() bool
+	Stop(timeout time.Duration) error
+
+	// Pid返回后端当前持有进程的PID，第二个返回值在没有关联进程（未启动、已
+	// 退出）时为false。供ProxyMonitor写入状态快照，用于Agent重启后的进程接管
+	Pid() (int, bool)
+}
+
+// Launch为proxyType的id实例启动代理进程，生命周期后端的选择取决于
+// cfg.Supervisor.Backend：
+//   - "systemd": 强制使用systemd后端，不可用或启动失败时直接返回错误，不回退
+//   - "pidfile": 强制使用进程内supervisor，完全跳过systemd探测
+//   - "container": 强制使用容器后端（docker/podman run），不可用或启动失败时
+//     直接返回错误，不回退
+//   - 其他取值（包括留空）: 检测宿主机是否具备systemd并据此选择；不具备，或
+//     systemd后端启动失败（例如非root、dbus不可达）时回退到进程内supervisor
+//
+// id用于区分同一proxyType下的多个实例（systemd/container后端据此生成互不
+// 冲突的unit名/容器名）
+func Launch(proxyType, id string, pidFile string, newCmd func() *exec.Cmd, cfg config.ProxyConfig) (Backend, error) {
+	switch cfg.Supervisor.Backend {
+	case "systemd":
+		backend, err := systemd.New(proxyType, id, newCmd, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("systemd生命周期后端已显式指定但启动失败: %w", err)
+		}
+		return backend, nil
+	case "container":
+		backend, err := container.New(proxyType, id, newCmd, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("容器生命周期后端已显式指定但启动失败: %w", err)
+		}
+		return backend, nil
+	case "pidfile":
+		return supervisor.New(proxyType+"-"+id, pidFile, newCmd, cfg)
+	}
+
+	if systemd.Available() {
+		backend, err := systemd.New(proxyType, id, newCmd, cfg)
+		if err == nil {
+			return backend, nil
+		}
+		logger.GetProxyLogger().WithError(err).WithField("proxy_type", proxyType).
+			Warn("systemd后端启动失败，回退到进程内supervisor")
+	}
+
+	return supervisor.New(proxyType+"-"+id, pidFile, newCmd, cfg)
+}
+
+// GracefulTimeout决定GracefulStop应该给子进程多长时间自行退出后才升级为
+// SIGKILL：ctx设置了deadline时以其剩余时间为准（用于调用方用
+// context.WithTimeout统一控制多个代理的优雅停止预算），否则回退到
+// fallbackSeconds（<=0时回退到10秒，即Stop此前的硬编码超时）
+func GracefulTimeout(ctx context.Context, fallbackSeconds int) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining > 0 {
+			return remaining
+		}
+		return 0
+	}
+
+	if fallbackSeconds > 0 {
+		return time.Duration(fallbackSeconds) * time.Second
+	}
+	return 10 * time.Second
+}
+
+// VerifyExecutable校验pid当前运行的可执行文件是否匹配expectedNames中的一个
+// （按basename比较）。用于Adopt之前确认该PID仍是期望的代理二进制，而不是
+// Agent重启期间PID号被其他无关进程复用
+func VerifyExecutable(pid int, expectedNames ...string) bool {
+	target, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return false
+	}
+
+	base := filepath.Base(target)
+	for _, name := range expectedNames {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Adopt包装一个已经在运行、由此前的Agent进程启动的PID，不fork/exec新进程，
+// 仅通过信号和存活探测实现Backend接口，用于Agent重启或升级后接管存活的代理
+// 进程而不中断用户流量。调用方应先用VerifyExecutable确认PID仍是期望的二进制
+func Adopt(pid int) Backend {
+	return &adoptedProcess{pid: pid}
+}
+
+// adoptedProcess是Backend的最简实现：没有Launch时保存的*exec.Cmd可供Wait，
+// 只能靠向pid发信号、探测ESRCH来判断存活
+type adoptedProcess struct {
+	pid int
+}
+
+func (a *adoptedProcess) IsRunning() bool {
+	return syscall.Kill(a.pid, syscall.Signal(0)) == nil
+}
+
+func (a *adoptedProcess) Status() (string, error) {
+	if a.IsRunning() {
+		return "running", nil
+	}
+	return "stopped", nil
+}
+
+// Pid 实现Backend接口，接管的PID本身就是已知的
+func (a *adoptedProcess) Pid() (int, bool) {
+	return a.pid, true
+}
+
+// Stop先发SIGTERM等待其在timeout内退出，超时后改发SIGKILL强制终止
+func (a *adoptedProcess) Stop(timeout time.Duration) error {
+	if err := syscall.Kill(a.pid, syscall.SIGTERM); err != nil {
+		if err == syscall.ESRCH {
+			return nil
+		}
+		return fmt.Errorf("停止被接管进程pid %d失败: %w", a.pid, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !a.IsRunning() {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if err := syscall.Kill(a.pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("强制终止被接管进程pid %d失败: %w", a.pid, err)
+	}
+
+	logger.GetProxyLogger().WithField("pid", a.pid).Warn("被接管进程未在超时内响应SIGTERM，已强制SIGKILL")
+	return nil
+}
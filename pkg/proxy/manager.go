@@ -1,20 +1,44 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/moooyo/nspass-proto/generated/model"
 	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/errorbus"
+	"github.com/nspass/nspass-agent/pkg/ipvs"
 	"github.com/nspass/nspass-agent/pkg/logger"
-	"github.com/nspass/nspass-agent/pkg/proxy/shadowsocks"
-	"github.com/nspass/nspass-agent/pkg/proxy/snell"
-	"github.com/nspass/nspass-agent/pkg/proxy/trojan"
 	"github.com/sirupsen/logrus"
 )
 
+// restartWorkerPoolSize限制RestartAll并行优雅停止代理的worker数量，避免大量
+// 代理同时停止时产生的瞬时CPU/IO尖峰
+const restartWorkerPoolSize = 4
+
+// portReleaseTimeout是RestartAll确认旧进程已释放监听端口的最长等待时间，超过
+// 仍未释放也会继续尝试启动替换进程，不无限阻塞重启
+const portReleaseTimeout = 5 * time.Second
+
+// PortProbe是backend可选实现的接口，暴露自己的监听地址，供RestartAll在
+// GracefulStop和Start之间确认端口已经真正释放，避免替换进程与仍占用该端口的
+// 旧进程竞争bind
+type PortProbe interface {
+	ListenAddr() (string, bool)
+}
+
+// Reconfigurable是backend可选实现的接口：如果某次配置变更可以不停止进程就
+// 生效（比如只是备注名变化，或后端支持重写配置文件后发SIGHUP），则该backend
+// 实现本接口。Reconcile在配置哈希变化时优先尝试Reconfigure，返回错误（或
+// backend压根没实现本接口）则退回Stop+Configure+Start的老路径
+type Reconfigurable interface {
+	Reconfigure(cfg *model.EgressItem) error
+}
+
 // ProxyInterface 代理接口
 type ProxyInterface interface {
 	Configure(config *model.EgressItem) error
@@ -23,20 +47,54 @@ type ProxyInterface interface {
 	Status() (string, error)
 	IsInstalled() bool
 	IsRunning() bool
+
+	// GracefulStop先发SIGTERM，等待进程在ctx允许的时间内（或配置的
+	// GracefulWait）自行退出，超时后升级为SIGKILL，返回前已确认进程真正退出。
+	// performRestart据此取代旧版Stop()+固定time.Sleep的做法，既加快健康重启，
+	// 也避免慢退出的旧进程仍占用监听端口导致新进程bind失败
+	GracefulStop(ctx context.Context) error
+
+	// HealthCheck在IsRunning()为true的基础上做更进一步的应用层探测（拨号本地
+	// 监听端口，必要时做一次SOCKS5握手），用于发现PID存活但实际不可用的“假活”
+	// 状态；err非nil不代表进程已退出，monitor会按连续失败次数决定是否重启
+	HealthCheck(ctx context.Context) error
+
+	// Adopt接管一个由此前Agent进程启动、仍然存活的pid，不fork/exec新进程，
+	// 用于Agent重启或升级后恢复对该代理的监管而不中断用户流量。调用前
+	// ProxyMonitor已经校验过该pid对应的可执行文件仍是期望的代理二进制
+	Adopt(pid int) error
+
+	// Pid返回当前持有代理进程的PID，第二个返回值在未启动/未接管时为false。
+	// 供ProxyMonitor写入状态快照，用于下次Agent启动时决定能否直接接管
+	Pid() (int, bool)
 }
 
 // Manager 代理管理器
 type Manager struct {
-	proxies map[string]ProxyInterface
-	monitor *ProxyMonitor // 进程监控器
-	mu      sync.RWMutex
+	proxies   map[string]ProxyInterface
+	monitor   *ProxyMonitor // 进程监控器
+	supported map[model.EgressMode]Factory
+	mu        sync.RWMutex
+
+	// desired和applied是Reconcile的期望/实际状态缓存：desired记录最近一次下发
+	// 的完整EgressItem（Resync据此周期性重新对账），applied记录每个代理当前
+	// 已生效的configHash，用于在下一次Reconcile时跳过未变化的代理，避免
+	// UpdateProxies每次都无条件Stop/Configure/Start打断用户连接
+	desired map[string]*model.EgressItem
+	applied map[string]string
 }
 
-// NewManager 创建新的代理管理器
+// NewManager 创建新的代理管理器。支持的后端集合按cfg.EnabledTypes从全局
+// Register表里过滤得出，需要哪些后端由调用方blank import对应的
+// pkg/proxy/{shadowsocks,snell,trojan}包触发其init()注册决定，本包不再直接
+// 依赖具体后端实现
 func NewManager(cfg config.ProxyConfig) *Manager {
 	manager := &Manager{
-		proxies: make(map[string]ProxyInterface),
-		monitor: NewProxyMonitor(cfg.Monitor), // 初始化监控器
+		proxies:   make(map[string]ProxyInterface),
+		monitor:   NewProxyMonitor(cfg.Monitor), // 初始化监控器
+		supported: buildSupportedFactories(cfg.EnabledTypes),
+		desired:   make(map[string]*model.EgressItem),
+		applied:   make(map[string]string),
 	}
 
 	logger.LogStartup("proxy-manager", "1.0", map[string]interface{}{
@@ -63,54 +121,48 @@ func NewManager(cfg config.ProxyConfig) *Manager {
 	return manager
 }
 
-// getProxyInstance 获取代理实例
+// getProxyInstance 获取代理实例：按EgressMode在Manager.supported（NewManager
+// 已按cfg.EnabledTypes过滤过的注册表子集）里查找对应的Factory并调用
 func (m *Manager) getProxyInstance(config *model.EgressItem) (ProxyInterface, error) {
-	log := logger.GetProxyLogger()
-
-	// 检查类型是否支持
-	supported := false
-	if config.EgressMode == model.EgressMode_EGRESS_MODE_SS2022 {
-		supported = true
-	}
-
-	if !supported {
-		log.WithFields(logrus.Fields{
-			"proxy_type": config.EgressMode,
-		}).Warn("不支持的代理类型")
+	factory, ok := m.supported[config.EgressMode]
+	if !ok {
+		logger.GetProxyLogger().WithField("proxy_type", config.EgressMode).Warn("不支持的代理类型")
 		return nil, fmt.Errorf("不支持的代理类型: %s", config.EgressMode)
 	}
 
-	// 创建代理实例
-	switch config.EgressMode {
-	case model.EgressMode_EGRESS_MODE_SS2022:
-		return shadowsocks.New(config), nil
-	case model.EgressMode_EGRESS_MODE_TROJAN:
-		return trojan.New(config), nil
-	case model.EgressMode_EGRESS_MODE_SNELL:
-		return snell.New(config), nil
-	default:
-		log.WithField("proxy_type", config.EgressMode).Warn("不支持的代理类型")
-		return nil, fmt.Errorf("不支持的代理类型: %s", config.EgressMode)
-	}
+	return factory(config), nil
 }
 
-// UpdateProxies 更新代理配置
+// UpdateProxies 更新代理配置，是Reconcile的既有外部入口：把本次全量配置当作
+// 新的期望状态对账
 func (m *Manager) UpdateProxies(configs []*model.EgressItem) error {
+	return m.Reconcile(context.Background(), configs)
+}
+
+// Reconcile是informer→sync式的对账入口：根据configs与上一次已生效状态做
+// add/update/delete三路diff，未变化的代理（configHash相同）直接跳过，既避免
+// UpdateProxies过去那种每次都无条件Stop+Configure+Start打断用户连接的做法，
+// 也是Resync周期性纠偏、以及未来API推送更新共用的同一条路径
+func (m *Manager) Reconcile(ctx context.Context, configs []*model.EgressItem) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	startTime := time.Now()
 	log := logger.GetProxyLogger()
 
-	log.WithField("config_count", len(configs)).Info("开始更新代理配置")
+	log.WithField("config_count", len(configs)).Info("开始对账代理配置")
+
+	desired := make(map[string]*model.EgressItem, len(configs))
+	for _, cfg := range configs {
+		desired[cfg.EgressId] = cfg
+	}
+	m.desired = desired
 
 	successCount := 0
+	skippedCount := 0
 	errorCount := 0
 	var errors []string
 
-	// 记录当前配置的代理ID
-	configuredProxyIDs := make(map[string]bool)
-
 	for _, cfg := range configs {
 		proxyLog := log.WithFields(logrus.Fields{
 			"proxy_id":   cfg.EgressId,
@@ -118,28 +170,33 @@ func (m *Manager) UpdateProxies(configs []*model.EgressItem) error {
 			"proxy_name": cfg.EgressName,
 		})
 
-		configuredProxyIDs[cfg.EgressId] = true
+		hash := configHash(cfg)
+		if existingHash, ok := m.applied[cfg.EgressId]; ok && existingHash == hash {
+			skippedCount++
+			proxyLog.Debug("配置未变化，跳过")
+			continue
+		}
 
-		proxyLog.Info("开始配置代理")
+		proxyLog.Info("检测到配置变化，开始对账代理")
 
-		if err := m.configureProxy(cfg); err != nil {
+		if err := m.reconcileProxy(cfg, hash); err != nil {
 			errorCount++
-			errorMsg := fmt.Sprintf("配置代理 %s(%s) 失败: %v", cfg.EgressMode, cfg.EgressId, err)
+			errorMsg := fmt.Sprintf("对账代理 %s(%s) 失败: %v", cfg.EgressMode, cfg.EgressId, err)
 			errors = append(errors, errorMsg)
-			logger.LogError(err, "配置代理失败", logrus.Fields{
+			logger.LogError(err, "对账代理失败", logrus.Fields{
 				"proxy_id":   cfg.EgressId,
 				"proxy_type": cfg.EgressMode,
 				"proxy_name": cfg.EgressName,
 			})
 		} else {
 			successCount++
-			proxyLog.Info("代理配置完成")
+			proxyLog.Info("代理对账完成")
 		}
 	}
 
-	// 移除不在配置中的代理
+	// 移除不在期望状态中的代理
 	for proxyID := range m.proxies {
-		if !configuredProxyIDs[proxyID] {
+		if _, ok := desired[proxyID]; !ok {
 			log.WithField("proxy_id", proxyID).Info("移除不在配置中的代理")
 			if proxy := m.proxies[proxyID]; proxy != nil {
 				if err := proxy.Stop(); err != nil {
@@ -149,7 +206,7 @@ func (m *Manager) UpdateProxies(configs []*model.EgressItem) error {
 				}
 			}
 			delete(m.proxies, proxyID)
-			// 从监控器中取消注册
+			delete(m.applied, proxyID)
 			if m.monitor != nil {
 				m.monitor.UnregisterProxy(proxyID)
 			}
@@ -158,37 +215,85 @@ func (m *Manager) UpdateProxies(configs []*model.EgressItem) error {
 
 	duration := time.Since(startTime)
 
-	// 记录性能指标
-	logger.LogPerformance("proxy_update", duration, logrus.Fields{
+	logger.LogPerformance("proxy_reconcile", duration, logrus.Fields{
 		"total_proxies": len(configs),
 		"success_count": successCount,
+		"skipped_count": skippedCount,
 		"error_count":   errorCount,
 	})
 
 	log.WithFields(logrus.Fields{
 		"total_proxies": len(configs),
 		"success_count": successCount,
+		"skipped_count": skippedCount,
 		"error_count":   errorCount,
 		"duration_ms":   duration.Milliseconds(),
-	}).Info("代理配置更新完成")
+	}).Info("代理配置对账完成")
 
 	if errorCount > 0 {
-		return fmt.Errorf("部分代理配置失败，成功: %d, 失败: %d, 错误: %v",
-			successCount, errorCount, errors)
+		return fmt.Errorf("部分代理对账失败，成功: %d, 跳过: %d, 失败: %d, 错误: %v",
+			successCount, skippedCount, errorCount, errors)
 	}
 
 	return nil
 }
 
-// configureProxy 配置单个代理
-func (m *Manager) configureProxy(cfg *model.EgressItem) error {
+// Resync启动一个周期性goroutine，按interval把最近一次Reconcile记下的期望状态
+// 重新交给Reconcile，直到ctx被取消。对账逻辑与UpdateProxies/Reconcile完全
+// 一致：configHash与上次已生效哈希相同的代理会被跳过，所以实际重新应用的只有
+// 上次对账失败（没有记下已生效哈希）的代理；配置文件被手工改动这类不改变期望
+// 配置哈希的漂移不会被它纠正
+func (m *Manager) Resync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.mu.RLock()
+				snapshot := make([]*model.EgressItem, 0, len(m.desired))
+				for _, cfg := range m.desired {
+					snapshot = append(snapshot, cfg)
+				}
+				m.mu.RUnlock()
+
+				if len(snapshot) == 0 {
+					continue
+				}
+				if err := m.Reconcile(ctx, snapshot); err != nil {
+					logger.LogError(err, "周期性Resync对账失败", nil)
+				}
+			}
+		}
+	}()
+}
+
+// reconcileProxy把单个代理从当前已生效状态驱动到cfg描述的期望状态。已存在且
+// 实现了Reconfigurable的代理优先尝试热更新，失败或未实现时退回
+// Stop+Configure+Start的老路径
+func (m *Manager) reconcileProxy(cfg *model.EgressItem, hash string) error {
 	log := logger.GetProxyLogger().WithFields(logrus.Fields{
 		"proxy_id":   cfg.EgressId,
 		"proxy_type": cfg.EgressMode,
 	})
 
-	// 停止已存在的代理
 	if existing, exists := m.proxies[cfg.EgressId]; exists {
+		if reconfigurable, ok := existing.(Reconfigurable); ok {
+			log.Debug("尝试热更新代理配置")
+			if err := reconfigurable.Reconfigure(cfg); err == nil {
+				m.applied[cfg.EgressId] = hash
+				m.monitor.RegisterProxy(cfg, existing)
+				logger.LogStateChange("proxy", "configured", "reconfigured",
+					fmt.Sprintf("代理 %s(%s) 热更新完成", cfg.EgressMode, cfg.EgressId))
+				log.Info("代理热更新完成，无需重启")
+				return nil
+			}
+			log.Debug("热更新失败，退回Stop+Configure+Start")
+		}
+
 		log.Debug("停止现有代理")
 		if err := existing.Stop(); err != nil {
 			logger.LogError(err, "停止现有代理失败", logrus.Fields{
@@ -234,6 +339,7 @@ func (m *Manager) configureProxy(cfg *model.EgressItem) error {
 
 	// 保存代理实例
 	m.proxies[cfg.EgressId] = proxy
+	m.applied[cfg.EgressId] = hash
 
 	// 注册到监控器
 	m.monitor.RegisterProxy(cfg, proxy)
@@ -275,7 +381,52 @@ func (m *Manager) GetStatus() map[string]interface{} {
 	return summary
 }
 
-// RestartAll 重启所有代理服务
+// HealthCheckAll对每个已配置代理依次做IsRunning()+HealthCheck(ctx)探测，返回
+// 按代理ID索引的错误（nil表示健康），用于health_check任务查询代理状态而不必
+// 让pkg/websocket直接持有ProxyInterface实例
+func (m *Manager) HealthCheckAll(ctx context.Context) map[string]error {
+	m.mu.RLock()
+	proxies := make(map[string]ProxyInterface, len(m.proxies))
+	for id, proxy := range m.proxies {
+		proxies[id] = proxy
+	}
+	m.mu.RUnlock()
+
+	results := make(map[string]error, len(proxies))
+	for id, proxy := range proxies {
+		if !proxy.IsRunning() {
+			results[id] = fmt.Errorf("代理进程未运行")
+			continue
+		}
+		results[id] = proxy.HealthCheck(ctx)
+	}
+	return results
+}
+
+// ProxyPids返回每个已配置且当前持有进程的代理ID对应的PID，未启动/未接管的
+// 代理不出现在结果里。供pkg/websocket按进程维度采集CPU/内存/FD等资源占用，
+// 沿用HealthCheckAll先在锁内拍快照、再在锁外调用backend方法的方式
+func (m *Manager) ProxyPids() map[string]int {
+	m.mu.RLock()
+	proxies := make(map[string]ProxyInterface, len(m.proxies))
+	for id, proxy := range m.proxies {
+		proxies[id] = proxy
+	}
+	m.mu.RUnlock()
+
+	pids := make(map[string]int, len(proxies))
+	for id, proxy := range proxies {
+		if pid, ok := proxy.Pid(); ok {
+			pids[id] = pid
+		}
+	}
+	return pids
+}
+
+// RestartAll 重启所有代理服务：用restartWorkerPoolSize限制的worker池并行跑
+// GracefulStop（取代此前对proxy.Stop()的无等待串行调用），backend实现了
+// PortProbe时在Start前先确认端口已经释放，避免新进程与仍在收尾的旧进程竞争
+// bind同一端口
 func (m *Manager) RestartAll() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -285,32 +436,39 @@ func (m *Manager) RestartAll() error {
 
 	log.Info("开始重启所有代理服务")
 
-	successCount := 0
-	errorCount := 0
-	var errors []string
+	type restartResult struct {
+		proxyType string
+		err       error
+	}
 
-	for proxyType, proxy := range m.proxies {
-		proxyLog := log.WithField("proxy_type", proxyType)
+	sem := make(chan struct{}, restartWorkerPoolSize)
+	resultsCh := make(chan restartResult, len(m.proxies))
+	var wg sync.WaitGroup
 
-		proxyLog.Debug("重启代理服务")
-		if err := proxy.Stop(); err != nil {
-			errorMsg := fmt.Sprintf("停止 %s 代理失败: %v", proxyType, err)
-			errors = append(errors, errorMsg)
-			logger.LogError(err, "停止代理失败", logrus.Fields{
-				"proxy_type": proxyType,
-			})
-		}
+	for proxyType, instance := range m.proxies {
+		proxyType, instance := proxyType, instance
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resultsCh <- restartResult{proxyType: proxyType, err: m.restartProxyInstance(proxyType, instance)}
+		}()
+	}
+
+	wg.Wait()
+	close(resultsCh)
 
-		if err := proxy.Start(); err != nil {
+	successCount := 0
+	errorCount := 0
+	var errors []string
+	for r := range resultsCh {
+		if r.err != nil {
 			errorCount++
-			errorMsg := fmt.Sprintf("启动 %s 代理失败: %v", proxyType, err)
-			errors = append(errors, errorMsg)
-			logger.LogError(err, "启动代理失败", logrus.Fields{
-				"proxy_type": proxyType,
-			})
+			errors = append(errors, fmt.Sprintf("重启 %s 代理失败: %v", r.proxyType, r.err))
 		} else {
 			successCount++
-			proxyLog.Info("代理重启成功")
 		}
 	}
 
@@ -337,6 +495,63 @@ func (m *Manager) RestartAll() error {
 	return nil
 }
 
+// restartProxyInstance是RestartAll单个代理的重启步骤，供bounded worker池并发
+// 调用：GracefulStop确认进程真正退出（内部已完成SIGTERM→SIGKILL升级），
+// 确认端口释放后再Start。期间发出stopping/stopped/killed状态变更：GracefulStop
+// 返回错误时记为killed并放弃本次启动，否则记为stopped，在GracefulStop内部被
+// 升级为SIGKILL才退出的进程同样记为stopped，因此这些状态变更不区分优雅退出
+// 和强制终止
+func (m *Manager) restartProxyInstance(proxyType string, instance ProxyInterface) error {
+	proxyLog := logger.GetProxyLogger().WithField("proxy_type", proxyType)
+	proxyLog.Debug("重启代理服务")
+
+	logger.LogStateChange(proxyType, "running", "stopping", "RestartAll优雅停止")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err := instance.GracefulStop(ctx); err != nil {
+		logger.LogError(err, "停止代理失败", logrus.Fields{"proxy_type": proxyType})
+		errorbus.Global().Publish(errorbus.SeverityCritical, "proxy", "restart_stop_failed", err.Error())
+		logger.LogStateChange(proxyType, "stopping", "killed", err.Error())
+		return fmt.Errorf("停止%s代理失败: %w", proxyType, err)
+	}
+	logger.LogStateChange(proxyType, "stopping", "stopped", "进程已退出")
+
+	if probe, ok := instance.(PortProbe); ok {
+		if addr, ok := probe.ListenAddr(); ok {
+			waitForPortRelease(proxyLog, addr, portReleaseTimeout)
+		}
+	}
+
+	if err := instance.Start(); err != nil {
+		logger.LogError(err, "启动代理失败", logrus.Fields{"proxy_type": proxyType})
+		errorbus.Global().Publish(errorbus.SeverityCritical, "proxy", "restart_start_failed", err.Error())
+		return fmt.Errorf("启动%s代理失败: %w", proxyType, err)
+	}
+
+	proxyLog.Info("代理重启成功")
+	return nil
+}
+
+// waitForPortRelease轮询尝试在addr上临时监听，成功即说明旧进程已经释放端口
+// 并立刻关闭这个探测监听；超时后放弃等待直接返回，不让RestartAll无限阻塞
+func waitForPortRelease(log *logrus.Entry, addr string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil {
+			ln.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			log.WithField("addr", addr).Warn("等待端口释放超时，继续尝试启动替换进程")
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 // StopAll 停止所有代理服务
 func (m *Manager) StopAll() error {
 	m.mu.Lock()
@@ -412,6 +627,24 @@ func (m *Manager) GetProxyMonitorState(proxyID string) (*ProxyState, bool) {
 	return m.monitor.GetProxyState(proxyID)
 }
 
+// GetMonitorSnapshot 获取所有代理的重启计数/最近错误快照，供admin API的
+// GetStatus只读查询使用
+func (m *Manager) GetMonitorSnapshot() map[string]ProxyStateSnapshot {
+	if m.monitor == nil {
+		return nil
+	}
+
+	return m.monitor.Snapshot()
+}
+
+// SetIPVSManager 注入IPVS管理器，使监控器能够为负载均衡型出口同步虚拟服务。
+// 应在调用UpdateProxies之前完成注入
+func (m *Manager) SetIPVSManager(manager ipvs.ManagerInterface) {
+	if m.monitor != nil {
+		m.monitor.SetIPVSManager(manager)
+	}
+}
+
 // EnableProxyMonitor 启用指定代理的监控
 func (m *Manager) EnableProxyMonitor(proxyID string) {
 	if m.monitor != nil {
@@ -434,6 +667,35 @@ func (m *Manager) StopMonitor() error {
 	return nil
 }
 
+// RestartProxy 重启单个代理服务，供admin API的`proxy restart <name>`命令使用，
+// 复用RestartAll里stop+start的顺序，但只作用于一个proxyID
+func (m *Manager) RestartProxy(proxyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log := logger.GetProxyLogger().WithField("proxy_id", proxyID)
+
+	proxy, exists := m.proxies[proxyID]
+	if !exists {
+		return fmt.Errorf("代理 %s 不存在", proxyID)
+	}
+
+	log.Info("重启代理服务")
+
+	if err := proxy.Stop(); err != nil {
+		logger.LogError(err, "停止代理失败", logrus.Fields{
+			"proxy_id": proxyID,
+		})
+	}
+
+	if err := proxy.Start(); err != nil {
+		return fmt.Errorf("启动代理 %s 失败: %w", proxyID, err)
+	}
+
+	log.Info("代理重启成功")
+	return nil
+}
+
 // RemoveProxy 移除代理（包括从监控器中移除）
 func (m *Manager) RemoveProxy(proxyID string) error {
 	m.mu.Lock()
@@ -2,28 +2,154 @@ package proxy
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/moooyo/nspass-proto/generated/model"
 	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/ipvs"
 	"github.com/nspass/nspass-agent/pkg/logger"
 	"github.com/sirupsen/logrus"
 )
 
 // ProxyState 代理状态信息
 type ProxyState struct {
-	ID             string            // 代理ID
-	Type           model.EgressMode  // 代理类型
-	Instance       ProxyInterface    // 代理实例
-	Config         *model.EgressItem // 代理配置
-	LastCheck      time.Time         // 上次检查时间
-	LastRestart    time.Time         // 上次重启时间
-	Status         string            // 当前状态: running, stopped, crashed, restarting
-	RestartCount   int               // 重启次数
-	RestartHistory []RestartRecord   // 重启历史记录
-	Enabled        bool              // 是否启用
-	mu             sync.RWMutex      // 状态锁
+	ID                   string            // 代理ID
+	Type                 model.EgressMode  // 代理类型
+	Instance             ProxyInterface    // 代理实例
+	Config               *model.EgressItem // 代理配置
+	LastCheck            time.Time         // 上次检查时间
+	LastRestart          time.Time         // 上次重启时间
+	Status               string            // 当前状态: running, stopped, crashed, restarting
+	RestartCount         int               // 重启次数
+	RestartHistory       []RestartRecord   // 重启历史记录
+	ConsecutiveFailures  int               // 连续重启失败次数，每次重启成功后清零，用于指数退避
+	ConsecutiveUnhealthy int               // 连续HealthCheck失败次数，达到maxConsecutiveUnhealthy后按崩溃处理
+	ConsecutiveProbeFail int               // 连续可插拔HealthProber探测失败次数，达到ProbeFailureThreshold后按崩溃处理
+	LastProbeLatencyMs   int64             // 最近一次HealthProber探测耗时，未配置Prober时恒为0
+	LastError            string            // 最近一次重启失败的错误描述
+	Enabled              bool              // 是否启用
+	PID                  int               // 当前持有进程的PID，0表示未知或未运行
+	StartTime            time.Time         // 当前进程的启动（或被接管）时间
+	ConfigHash           string            // 当前配置的哈希，供Agent重启后判断快照是否仍对应同一份配置
+	BreakerState         string            // 熔断器状态: closed, open, half_open
+	BreakerOpenedAt      time.Time         // 进入open状态的时间，供换算OpenDuration是否已过
+	halfOpenProbing      bool              // half_open状态下是否已经放出过一次试探性重启，避免并发重复试探
+	mu                   sync.RWMutex      // 状态锁
+}
+
+// 熔断器状态常量，对应CanRestart/AddRestartRecord之间的状态机
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// proxyStateRecord是落盘到状态快照文件的单个代理记录，与ProxyState解耦，
+// 不包含Instance/Config等运行时字段
+type proxyStateRecord struct {
+	ID              string           `json:"id"`
+	Type            model.EgressMode `json:"type"`
+	PID             int              `json:"pid"`
+	StartTime       time.Time        `json:"start_time"`
+	ConfigHash      string           `json:"config_hash"`
+	RestartCount    int              `json:"restart_count"`
+	RestartHistory  []RestartRecord  `json:"restart_history"`
+	BreakerState    string           `json:"breaker_state"`
+	BreakerOpenedAt time.Time        `json:"breaker_opened_at"`
+}
+
+// configHash对cfg的JSON序列化结果做sha256，作为一份轻量的配置指纹，供Agent
+// 重启后比对状态快照是否仍对应同一份出口配置，避免接管一个配置已变更的进程
+func configHash(cfg *model.EgressItem) string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MarshalSnapshot序列化该代理当前状态为快照记录，供ProxyMonitor写入状态文件
+func (ps *ProxyState) MarshalSnapshot() ([]byte, error) {
+	ps.mu.RLock()
+	rec := proxyStateRecord{
+		ID:              ps.ID,
+		Type:            ps.Type,
+		PID:             ps.PID,
+		StartTime:       ps.StartTime,
+		ConfigHash:      ps.ConfigHash,
+		RestartCount:    ps.RestartCount,
+		RestartHistory:  append([]RestartRecord(nil), ps.RestartHistory...),
+		BreakerState:    ps.BreakerState,
+		BreakerOpenedAt: ps.BreakerOpenedAt,
+	}
+	ps.mu.RUnlock()
+
+	return json.Marshal(rec)
+}
+
+// UnmarshalSnapshot从MarshalSnapshot产生的字节还原状态字段。不还原Instance/
+// Config，调用方（RegisterProxy）需要在接管成功后自行补上
+func (ps *ProxyState) UnmarshalSnapshot(data []byte) error {
+	var rec proxyStateRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
+	}
+
+	ps.mu.Lock()
+	ps.ID = rec.ID
+	ps.Type = rec.Type
+	ps.PID = rec.PID
+	ps.StartTime = rec.StartTime
+	ps.ConfigHash = rec.ConfigHash
+	ps.RestartCount = rec.RestartCount
+	ps.RestartHistory = rec.RestartHistory
+	ps.BreakerState = rec.BreakerState
+	ps.BreakerOpenedAt = rec.BreakerOpenedAt
+	ps.mu.Unlock()
+
+	return nil
+}
+
+// defaultMaxRestartBackoff 是MonitorConfig.MaxBackoff未配置时的退避时间上限
+const defaultMaxRestartBackoff = 15 * time.Minute
+
+// maxConsecutiveUnhealthy是HealthCheck连续失败多少次后等同于进程崩溃触发重启，
+// 容忍偶发的探测抖动（网络瞬断、探测超时等）
+const maxConsecutiveUnhealthy = 3
+
+// backoffCooldown按连续失败次数对基础冷却时间做指数退避并叠加随机抖动，封顶
+// maxBackoffSeconds（0表示使用defaultMaxRestartBackoff）。抖动避免同一批次
+// 崩溃的代理在退避结束的同一时刻扎堆发起重启
+func backoffCooldown(cooldownSeconds, consecutiveFailures, maxBackoffSeconds, jitterSeconds int) time.Duration {
+	maxBackoff := defaultMaxRestartBackoff
+	if maxBackoffSeconds > 0 {
+		maxBackoff = time.Duration(maxBackoffSeconds) * time.Second
+	}
+
+	base := time.Duration(cooldownSeconds) * time.Second
+	backoff := base
+	for i := 0; i < consecutiveFailures; i++ {
+		backoff *= 2
+		if backoff >= maxBackoff {
+			backoff = maxBackoff
+			break
+		}
+	}
+
+	if jitterSeconds > 0 {
+		backoff += time.Duration(rand.Intn(jitterSeconds+1)) * time.Second
+	}
+
+	return backoff
 }
 
 // RestartRecord 重启记录
@@ -41,6 +167,16 @@ func (ps *ProxyState) GetStatus() string {
 	return ps.Status
 }
 
+// GetBreakerState 获取熔断器当前状态（线程安全），空字符串等价于closed
+func (ps *ProxyState) GetBreakerState() string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if ps.BreakerState == "" {
+		return breakerClosed
+	}
+	return ps.BreakerState
+}
+
 // SetStatus 设置代理状态（线程安全）
 func (ps *ProxyState) SetStatus(status string) {
 	ps.mu.Lock()
@@ -61,8 +197,11 @@ func (ps *ProxyState) SetStatus(status string) {
 	}
 }
 
-// AddRestartRecord 添加重启记录（线程安全）
-func (ps *ProxyState) AddRestartRecord(reason string, success bool, duration time.Duration) {
+// AddRestartRecord 添加重启记录（线程安全）并驱动熔断器状态机。errMsg仅在
+// success为false时有意义，成功时清零ConsecutiveFailures并关闭熔断器（若此前
+// 处于open/half_open），失败时递增ConsecutiveFailures，达到tripThreshold后
+// （或half_open试探失败时）打开熔断器
+func (ps *ProxyState) AddRestartRecord(reason string, success bool, errMsg string, duration time.Duration, tripThreshold int) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 
@@ -76,6 +215,21 @@ func (ps *ProxyState) AddRestartRecord(reason string, success bool, duration tim
 	ps.RestartHistory = append(ps.RestartHistory, record)
 	ps.RestartCount++
 	ps.LastRestart = record.Timestamp
+	ps.halfOpenProbing = false
+
+	if success {
+		ps.ConsecutiveFailures = 0
+		ps.LastError = ""
+		ps.BreakerState = breakerClosed
+	} else {
+		ps.ConsecutiveFailures++
+		ps.LastError = errMsg
+
+		if ps.BreakerState == breakerHalfOpen || (tripThreshold > 0 && ps.ConsecutiveFailures >= tripThreshold) {
+			ps.BreakerState = breakerOpen
+			ps.BreakerOpenedAt = record.Timestamp
+		}
+	}
 
 	// 保持重启历史记录在合理范围内（最多100条）
 	if len(ps.RestartHistory) > 100 {
@@ -100,22 +254,74 @@ func (ps *ProxyState) GetRecentRestarts() int {
 	return count
 }
 
-// CanRestart 检查是否可以重启（线程安全）
-func (ps *ProxyState) CanRestart(maxRestarts int, cooldownSeconds int) bool {
-	ps.mu.RLock()
-	defer ps.mu.RUnlock()
+// CanRestart 检查是否可以重启（线程安全）。熔断器处于open时直接拒绝，直到
+// OpenDuration过去后转入half_open、放行恰好一次试探性重启（由halfOpenProbing
+// 防止并发重复放行）；熔断器closed时按ConsecutiveFailures做指数退避+抖动
+// （见backoffCooldown），不再单独检查每小时重启次数上限——持续失败会在
+// TripThreshold次后触发熔断，比固定的每小时次数上限更早、更明确地止损
+func (ps *ProxyState) CanRestart(cfg config.MonitorConfig) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
 
-	// 检查是否在冷却期内
-	if !ps.LastRestart.IsZero() {
-		cooldownDuration := time.Duration(cooldownSeconds) * time.Second
-		if time.Since(ps.LastRestart) < cooldownDuration {
+	switch ps.BreakerState {
+	case breakerOpen:
+		openDuration := time.Duration(cfg.OpenDuration) * time.Second
+		if openDuration <= 0 {
+			openDuration = 5 * time.Minute
+		}
+		if time.Since(ps.BreakerOpenedAt) < openDuration {
 			return false
 		}
+		ps.BreakerState = breakerHalfOpen
+		ps.halfOpenProbing = true
+		return true
+	case breakerHalfOpen:
+		if ps.halfOpenProbing {
+			return false
+		}
+		ps.halfOpenProbing = true
+		return true
+	default:
+		if !ps.LastRestart.IsZero() {
+			cooldownDuration := backoffCooldown(cfg.RestartCooldown, ps.ConsecutiveFailures, cfg.MaxBackoff, cfg.JitterSeconds)
+			if time.Since(ps.LastRestart) < cooldownDuration {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// RecordHealthCheck 记录一次HealthCheck结果（线程安全），返回更新后的连续失败
+// 次数：healthy为true时清零，否则递增，供checkProxyHealth判断是否已达到
+// maxConsecutiveUnhealthy而按崩溃处理
+func (ps *ProxyState) RecordHealthCheck(healthy bool) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if healthy {
+		ps.ConsecutiveUnhealthy = 0
+	} else {
+		ps.ConsecutiveUnhealthy++
+	}
+
+	return ps.ConsecutiveUnhealthy
+}
+
+// RecordProbeResult 记录一次可插拔HealthProber探测结果（线程安全），返回
+// 更新后的连续失败次数，供checkProxyHealth判断是否已达到ProbeFailureThreshold
+func (ps *ProxyState) RecordProbeResult(latency time.Duration, probeErr error) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.LastProbeLatencyMs = latency.Milliseconds()
+	if probeErr == nil {
+		ps.ConsecutiveProbeFail = 0
+	} else {
+		ps.ConsecutiveProbeFail++
 	}
 
-	// 检查最近一小时的重启次数
-	recentRestarts := ps.GetRecentRestarts()
-	return recentRestarts < maxRestarts
+	return ps.ConsecutiveProbeFail
 }
 
 // ProxyMonitor 代理监控器
@@ -125,22 +331,50 @@ type ProxyMonitor struct {
 	mu      sync.RWMutex           // 状态映射锁
 	ctx     context.Context        // 上下文
 	cancel  context.CancelFunc     // 取消函数
-	ticker  *time.Ticker           // 定时器
+	ticker  *time.Ticker           // 全量重新入队安全网定时器
 	running bool                   // 是否运行中
 	log     *logrus.Entry          // 日志记录器
 
+	// queue是事件驱动reconciler的工作队列，RegisterProxy/EnableProxy/watchExit/
+	// fullResync都只是向其Add一个代理ID，真正的检查/重启由worker串行处理
+	queue *proxyWorkQueue
+	// workerWG供Stop等待所有worker处理完当前项后退出
+	workerWG sync.WaitGroup
+
+	// prober是config.Prober配置选择出的可插拔主动健康探测，Type为空时为nil，
+	// 表示不启用，checkProxyHealth只依赖instance.HealthCheck内置探测
+	prober HealthProber
+
+	// stateDir是状态快照文件所在目录，来自config.StateDir，留空表示不持久化
+	stateDir string
+	// pendingAdopt保存NewProxyMonitor加载快照后尚未被RegisterProxy认领的记录，
+	// 按代理ID索引；只在pm.mu保护下读写
+	pendingAdopt map[string]*ProxyState
+
+	// ipvsManager非nil时，RegisterProxy会在识别到负载均衡型出口
+	// （见ipvs.IsLoadBalanced）时把其real server列表同步为一个IPVS虚拟服务，
+	// 由SetIPVSManager注入，留空表示不启用IPVS
+	ipvsManager ipvs.ManagerInterface
+	// ipvsServices保存当前已识别为负载均衡型出口的虚拟服务定义，按代理ID索引。
+	// ipvs.Manager.UpdateServices每次都以传入列表作为完整期望状态做增删对比，
+	// 所以这里要维护全量集合，而不能每次只传本次注册的这一个代理
+	ipvsServices map[string]*ipvs.Service
+
 	// 统计信息
 	stats ProxyMonitorStats
 }
 
 // ProxyMonitorStats 监控统计信息
 type ProxyMonitorStats struct {
-	TotalChecks     int64        `json:"total_checks"`     // 总检查次数
-	TotalRestarts   int64        `json:"total_restarts"`   // 总重启次数
-	SuccessRestarts int64        `json:"success_restarts"` // 成功重启次数
-	FailedRestarts  int64        `json:"failed_restarts"`  // 失败重启次数
-	LastCheckTime   time.Time    `json:"last_check_time"`  // 最后检查时间
-	mu              sync.RWMutex // 统计锁
+	TotalChecks     int64     `json:"total_checks"`     // 总检查次数
+	TotalRestarts   int64     `json:"total_restarts"`   // 总重启次数
+	SuccessRestarts int64     `json:"success_restarts"` // 成功重启次数
+	FailedRestarts  int64     `json:"failed_restarts"`  // 失败重启次数
+	LastCheckTime   time.Time `json:"last_check_time"`  // 最后检查时间
+	// LastProbeLatencyMs是最近一次可插拔HealthProber探测（任意代理）的耗时，
+	// 未配置Prober时恒为0；各代理自身的探测延迟见ProxyStateSnapshot
+	LastProbeLatencyMs int64        `json:"last_probe_latency_ms"`
+	mu                 sync.RWMutex // 统计锁
 }
 
 // NewProxyMonitor 创建新的代理监控器
@@ -148,13 +382,28 @@ func NewProxyMonitor(config config.MonitorConfig) *ProxyMonitor {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	monitor := &ProxyMonitor{
-		config:  config,
-		states:  make(map[string]*ProxyState),
-		ctx:     ctx,
-		cancel:  cancel,
-		running: false,
-		log:     logger.GetProxyLogger().WithField("component", "monitor"),
-		stats:   ProxyMonitorStats{},
+		config:       config,
+		states:       make(map[string]*ProxyState),
+		ctx:          ctx,
+		cancel:       cancel,
+		running:      false,
+		log:          logger.GetProxyLogger().WithField("component", "monitor"),
+		stats:        ProxyMonitorStats{},
+		stateDir:     config.StateDir,
+		ipvsServices: make(map[string]*ipvs.Service),
+		prober:       NewHealthProber(config.Prober),
+		queue:        newProxyWorkQueue(),
+	}
+
+	if config.StateDir != "" {
+		pending, err := loadStateSnapshot(config.StateDir)
+		if err != nil {
+			monitor.log.WithError(err).Warn("加载代理状态快照失败，本次启动将不尝试接管存活进程")
+		} else if len(pending) > 0 {
+			monitor.pendingAdopt = pending
+			monitor.log.WithField("count", len(pending)).
+				Info("已加载代理状态快照，等待RegisterProxy时尝试接管存活进程")
+		}
 	}
 
 	logger.LogStartup("proxy-monitor", "1.0", map[string]interface{}{
@@ -162,16 +411,103 @@ func NewProxyMonitor(config config.MonitorConfig) *ProxyMonitor {
 		"restart_cooldown": config.RestartCooldown,
 		"max_restarts":     config.MaxRestarts,
 		"health_timeout":   config.HealthTimeout,
+		"state_dir":        config.StateDir,
 	})
 
 	return monitor
 }
 
-// RegisterProxy 注册代理进行监控
+// snapshotFileName是状态快照文件在StateDir下的固定名字
+const snapshotFileName = "proxy-state.json"
+
+// loadStateSnapshot读取stateDir下的状态快照文件，返回按代理ID索引的状态
+// 映射。文件不存在时返回空映射、不报错
+func loadStateSnapshot(stateDir string) (map[string]*ProxyState, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir, snapshotFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取状态快照文件失败: %w", err)
+	}
+
+	var records []json.RawMessage
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("解析状态快照文件失败: %w", err)
+	}
+
+	result := make(map[string]*ProxyState, len(records))
+	for _, rec := range records {
+		state := &ProxyState{}
+		if err := state.UnmarshalSnapshot(rec); err != nil {
+			logger.GetProxyLogger().WithError(err).Warn("跳过一条无法解析的代理状态快照记录")
+			continue
+		}
+		result[state.ID] = state
+	}
+
+	return result, nil
+}
+
+// SaveSnapshot把当前所有受监控代理的状态写入stateDir下的快照文件，StateDir
+// 为空时是no-op。写入采用临时文件+rename，避免Agent崩溃时留下半写的文件
+func (pm *ProxyMonitor) SaveSnapshot() error {
+	if pm.stateDir == "" {
+		return nil
+	}
+
+	pm.mu.RLock()
+	states := make([]*ProxyState, 0, len(pm.states))
+	for _, state := range pm.states {
+		states = append(states, state)
+	}
+	pm.mu.RUnlock()
+
+	records := make([]json.RawMessage, 0, len(states))
+	for _, state := range states {
+		data, err := state.MarshalSnapshot()
+		if err != nil {
+			return fmt.Errorf("序列化代理%s状态快照失败: %w", state.ID, err)
+		}
+		records = append(records, data)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化状态快照文件失败: %w", err)
+	}
+
+	if err := os.MkdirAll(pm.stateDir, 0755); err != nil {
+		return fmt.Errorf("创建状态目录%s失败: %w", pm.stateDir, err)
+	}
+
+	path := filepath.Join(pm.stateDir, snapshotFileName)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("写入状态快照文件失败: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// SetIPVSManager 注入IPVS管理器，使RegisterProxy能够为负载均衡型出口同步
+// 虚拟服务。必须在RegisterProxy之前调用才能覆盖到已注册的代理
+func (pm *ProxyMonitor) SetIPVSManager(manager ipvs.ManagerInterface) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.ipvsManager = manager
+}
+
+// RegisterProxy 注册代理进行监控。如果NewProxyMonitor加载的状态快照里存在
+// 该代理ID、配置哈希与当前配置一致、且记录的PID仍然存活并通过可执行文件校验，
+// 会调用instance.Adopt接管该进程而不是把它当作新代理等待下次健康检查前保持
+// unknown状态
 func (pm *ProxyMonitor) RegisterProxy(config *model.EgressItem, instance ProxyInterface) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
+	hash := configHash(config)
+
 	state := &ProxyState{
 		ID:             config.EgressId,
 		Type:           config.EgressMode,
@@ -182,16 +518,86 @@ func (pm *ProxyMonitor) RegisterProxy(config *model.EgressItem, instance ProxyIn
 		RestartCount:   0,
 		RestartHistory: make([]RestartRecord, 0),
 		Enabled:        true,
+		ConfigHash:     hash,
+	}
+
+	if snapshot, ok := pm.pendingAdopt[config.EgressId]; ok {
+		delete(pm.pendingAdopt, config.EgressId)
+		pm.adoptFromSnapshot(state, snapshot, hash, instance)
 	}
 
 	pm.states[config.EgressId] = state
 
+	pm.registerIPVSService(config)
+
+	pm.queue.Add(config.EgressId)
+	go pm.watchExit(config.EgressId, state)
+
 	pm.log.WithFields(logrus.Fields{
 		"proxy_id":   config.EgressId,
 		"proxy_type": config.EgressMode,
+		"status":     state.GetStatus(),
 	}).Info("代理已注册到监控器")
 }
 
+// registerIPVSService在EgressConfig描述了多个backend时（见ipvs.IsLoadBalanced），
+// 把出口自身的监听地址作为VIP、backends作为real server同步为一个IPVS虚拟服务，
+// 使这个出口对外表现为O(1)查找的单个负载均衡入口，而不是由上层再做一轮转发
+func (pm *ProxyMonitor) registerIPVSService(config *model.EgressItem) {
+	if pm.ipvsManager == nil || !ipvs.IsLoadBalanced(config.EgressConfig) {
+		return
+	}
+
+	var egressConfig struct {
+		Port int `json:"port"`
+	}
+	if err := json.Unmarshal([]byte(config.EgressConfig), &egressConfig); err != nil || egressConfig.Port == 0 {
+		pm.log.WithField("proxy_id", config.EgressId).Warn("出口配置描述了负载均衡backends，但无法解析监听端口，跳过IPVS虚拟服务同步")
+		return
+	}
+
+	pm.ipvsServices[config.EgressId] = &ipvs.Service{
+		Address:      "127.0.0.1",
+		Port:         egressConfig.Port,
+		Protocol:     "tcp",
+		Destinations: ipvs.ParseBackends(config.EgressConfig),
+	}
+
+	services := make([]*ipvs.Service, 0, len(pm.ipvsServices))
+	for _, svc := range pm.ipvsServices {
+		services = append(services, svc)
+	}
+
+	if err := pm.ipvsManager.UpdateServices(services); err != nil {
+		pm.log.WithError(err).WithField("proxy_id", config.EgressId).Warn("同步IPVS虚拟服务失败")
+	}
+}
+
+// adoptFromSnapshot尝试把snapshot记录的PID接管到state上。配置哈希不匹配
+// （出口配置已变更）或Adopt本身失败（PID已不是预期的可执行文件、进程已退出）
+// 时放弃接管，state保持初始的unknown状态，交由后续的健康检查重新拉起
+func (pm *ProxyMonitor) adoptFromSnapshot(state, snapshot *ProxyState, hash string, instance ProxyInterface) {
+	log := pm.log.WithField("proxy_id", state.ID)
+
+	if snapshot.ConfigHash != hash || snapshot.PID <= 0 {
+		log.Debug("状态快照配置哈希不匹配或无有效PID，跳过进程接管")
+		return
+	}
+
+	if err := instance.Adopt(snapshot.PID); err != nil {
+		log.WithError(err).WithField("pid", snapshot.PID).Warn("接管存活代理进程失败，按新进程处理")
+		return
+	}
+
+	state.PID = snapshot.PID
+	state.StartTime = snapshot.StartTime
+	state.RestartCount = snapshot.RestartCount
+	state.RestartHistory = snapshot.RestartHistory
+	state.SetStatus("running")
+
+	log.WithField("pid", snapshot.PID).Info("已接管Agent重启前存活的代理进程")
+}
+
 // UnregisterProxy 取消注册代理
 func (pm *ProxyMonitor) UnregisterProxy(id string) {
 	pm.mu.Lock()
@@ -217,6 +623,10 @@ func (pm *ProxyMonitor) EnableProxy(id string) {
 		state.Enabled = true
 		state.mu.Unlock()
 
+		// processProxy在代理被禁用时不再自我重新入队，重新启用后需要显式入队
+		// 一次才能恢复检查
+		pm.queue.Add(id)
+
 		pm.log.WithField("proxy_id", id).Info("代理监控已启用")
 	}
 }
@@ -236,7 +646,31 @@ func (pm *ProxyMonitor) DisableProxy(id string) {
 	}
 }
 
-// Start 启动监控器
+// monitorWorkerCount是同时从工作队列取出代理ID进行检查/重启的worker数量。
+// 代理数量通常不大，固定的小并发度足够避免队列空转，又不至于像原先按
+// CheckInterval对所有代理一次性起N个goroutine那样产生惊群
+const monitorWorkerCount = 4
+
+// fullResyncMultiplier决定安全网全量重新入队的周期相对CheckInterval的倍数：
+// 正常情况下每个代理都靠自身的AddAfter定时重新入队，这个ticker只是兜底，
+// 防止队列实现的潜在bug或某次AddAfter定时器异常导致某个代理从此不再被检查
+const fullResyncMultiplier = 5
+
+// exitWatchInterval是watchExit轮询instance.IsRunning()的间隔。之所以用轮询
+// 而不是严格意义上的SIGCHLD/os.Process.Wait：ProxyInterface/lifecycle.Backend
+// 刻意抽象了进程内supervisor（持有*exec.Cmd，可Wait）、systemd（进程根本不是
+// 本进程的子进程）、以及接管的历史进程（只知道PID）这三种后端，三者中只有第一种
+// 具备可Wait的子进程句柄，没有能统一套用的Wait信号；用短轮询换取对三种后端一致
+// 的、足够快的退出检测
+const exitWatchInterval = 1 * time.Second
+
+// fastRecheckInterval是代理处于crashed/unhealthy等非running状态时的重新入队
+// 间隔上限，用于在故障期间比正常CheckInterval更快地跟进，不必等到下一个整
+// 周期才发现重启是否生效
+const fastRecheckInterval = 5 * time.Second
+
+// Start 启动监控器：为当前已注册的代理各入队一次，再拉起worker池消费工作队列，
+// 并启动一个低频的全量重新入队安全网
 func (pm *ProxyMonitor) Start() error {
 	if !pm.config.Enable {
 		pm.log.Info("代理监控已禁用，跳过启动")
@@ -250,17 +684,27 @@ func (pm *ProxyMonitor) Start() error {
 
 	pm.running = true
 	interval := time.Duration(pm.config.CheckInterval) * time.Second
-	pm.ticker = time.NewTicker(interval)
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	pm.ticker = time.NewTicker(interval * fullResyncMultiplier)
 
 	pm.log.WithField("check_interval", interval).Info("代理监控器已启动")
 
-	// 启动监控循环
-	go pm.monitorLoop()
+	for i := 0; i < monitorWorkerCount; i++ {
+		pm.workerWG.Add(1)
+		go pm.worker(i)
+	}
+
+	go pm.resyncLoop()
+
+	// 启动时已注册的代理先各入队一次，不必等第一次安全网触发
+	pm.fullResync()
 
 	return nil
 }
 
-// Stop 停止监控器
+// Stop 停止监控器：关闭工作队列并等待所有worker处理完当前项后退出
 func (pm *ProxyMonitor) Stop() error {
 	if !pm.running {
 		return nil
@@ -273,6 +717,9 @@ func (pm *ProxyMonitor) Stop() error {
 		pm.ticker.Stop()
 	}
 
+	pm.queue.Shutdown()
+	pm.workerWG.Wait()
+
 	pm.log.Info("代理监控器已停止")
 	return nil
 }
@@ -307,60 +754,152 @@ func (pm *ProxyMonitor) GetAllStates() map[string]*ProxyState {
 	return states
 }
 
-// monitorLoop 监控循环
-func (pm *ProxyMonitor) monitorLoop() {
-	pm.log.Info("监控循环已启动")
+// resyncLoop只负责按fullResyncMultiplier*CheckInterval的周期触发安全网，
+// 真正的检查/重启节奏由worker消费工作队列驱动
+func (pm *ProxyMonitor) resyncLoop() {
+	pm.log.Info("事件驱动reconciler已启动")
 
 	for {
 		select {
 		case <-pm.ctx.Done():
-			pm.log.Info("监控循环已停止")
+			pm.log.Info("事件驱动reconciler已停止")
 			return
 		case <-pm.ticker.C:
-			pm.performHealthCheck()
+			pm.fullResync()
 		}
 	}
 }
 
-// performHealthCheck 执行健康检查
-func (pm *ProxyMonitor) performHealthCheck() {
+// fullResync把当前所有已注册代理重新入队一次。正常情况下每个代理会在自己被
+// 处理完后通过AddAfter安排下一次检查，fullResync只是兜底：防止队列实现的潜在
+// bug或定时器异常导致某个代理从此再也不会被检查
+func (pm *ProxyMonitor) fullResync() {
+	pm.mu.RLock()
+	ids := make([]string, 0, len(pm.states))
+	for id := range pm.states {
+		ids = append(ids, id)
+	}
+	pm.mu.RUnlock()
+
+	for _, id := range ids {
+		pm.queue.Add(id)
+	}
+
+	pm.log.WithField("proxy_count", len(ids)).Debug("执行全量重新入队(safety net)")
+}
+
+// worker不断从工作队列取出代理ID并串行处理，取代原先每个CheckInterval对所有
+// 代理一次性起N个goroutine的做法
+func (pm *ProxyMonitor) worker(workerID int) {
+	defer pm.workerWG.Done()
+
+	for {
+		id, ok := pm.queue.Get()
+		if !ok {
+			return
+		}
+
+		pm.processProxy(id)
+		pm.queue.Done(id)
+	}
+}
+
+// processProxy是工作队列单个item的处理函数：执行一次检查/重启流程，并根据
+// 结果为该代理安排下一次重新入队。代理在被UnregisterProxy之后仍可能有一个
+// 已经入队的旧事件，此时直接跳过，不再重新入队
+func (pm *ProxyMonitor) processProxy(id string) {
+	pm.mu.RLock()
+	state, exists := pm.states[id]
+	pm.mu.RUnlock()
+	if !exists {
+		return
+	}
+
 	startTime := time.Now()
-	pm.log.Debug("开始执行代理健康检查")
+	pm.checkProxyHealth(state)
+	duration := time.Since(startTime)
 
-	// 更新统计信息
 	pm.stats.mu.Lock()
 	pm.stats.TotalChecks++
 	pm.stats.LastCheckTime = startTime
 	pm.stats.mu.Unlock()
 
-	pm.mu.RLock()
-	states := make([]*ProxyState, 0, len(pm.states))
-	for _, state := range pm.states {
-		states = append(states, state)
+	logger.LogPerformance("proxy_health_check", duration, logrus.Fields{
+		"proxy_id": id,
+	})
+
+	if err := pm.SaveSnapshot(); err != nil {
+		pm.log.WithError(err).Warn("保存代理状态快照失败")
 	}
-	pm.mu.RUnlock()
 
-	// 并发检查所有代理
-	var wg sync.WaitGroup
-	for _, state := range states {
-		wg.Add(1)
-		go func(s *ProxyState) {
-			defer wg.Done()
-			pm.checkProxyHealth(s)
-		}(state)
+	state.mu.RLock()
+	enabled := state.Enabled
+	state.mu.RUnlock()
+	if !enabled {
+		// 已禁用：不再自我重新入队，等EnableProxy显式触发
+		return
 	}
 
-	wg.Wait()
+	pm.queue.AddAfter(id, pm.nextCheckDelay(state))
+}
 
-	duration := time.Since(startTime)
-	logger.LogPerformance("proxy_health_check", duration, logrus.Fields{
-		"checked_proxies": len(states),
-	})
+// nextCheckDelay决定processProxy之后该代理下一次重新入队的延迟：正常running
+// 状态下按完整CheckInterval；crashed/unhealthy等故障状态下改用更短的
+// fastRecheckInterval，以便更快跟进重启是否生效
+func (pm *ProxyMonitor) nextCheckDelay(state *ProxyState) time.Duration {
+	interval := time.Duration(pm.config.CheckInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
 
-	pm.log.WithFields(logrus.Fields{
-		"checked_proxies": len(states),
-		"duration_ms":     duration.Milliseconds(),
-	}).Debug("代理健康检查完成")
+	switch state.GetStatus() {
+	case "crashed", "unhealthy", "restarting", "circuit_open":
+		if interval > fastRecheckInterval {
+			return fastRecheckInterval
+		}
+	}
+
+	return interval
+}
+
+// watchExit以exitWatchInterval轮询instance.IsRunning()，在观察到代理从运行
+// 变为不再运行时立即把该代理加入工作队列，不必等到下一次周期性重新入队，从而
+// 把崩溃检测的反应时间从最长一个CheckInterval缩短到约exitWatchInterval。
+// state被重新RegisterProxy替换或UnregisterProxy移除后自行退出
+func (pm *ProxyMonitor) watchExit(id string, state *ProxyState) {
+	ticker := time.NewTicker(exitWatchInterval)
+	defer ticker.Stop()
+
+	wasRunning := state.GetStatus() == "running"
+
+	for {
+		select {
+		case <-pm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pm.mu.RLock()
+		current, exists := pm.states[id]
+		pm.mu.RUnlock()
+		if !exists || current != state {
+			return
+		}
+
+		state.mu.RLock()
+		enabled := state.Enabled
+		instance := state.Instance
+		state.mu.RUnlock()
+		if !enabled {
+			continue
+		}
+
+		running := instance.IsRunning()
+		if wasRunning && !running {
+			pm.queue.Add(id)
+		}
+		wasRunning = running
+	}
 }
 
 // checkProxyHealth 检查单个代理的健康状态
@@ -411,12 +950,86 @@ func (pm *ProxyMonitor) checkProxyHealth(state *ProxyState) {
 	// 更新状态
 	oldStatus := state.GetStatus()
 	var newStatus string
+	restartReason := "进程崩溃检测"
 
 	if isRunning {
 		newStatus = "running"
+
+		// 记录当前PID，供SaveSnapshot落盘；PID变化（比如底层进程重启过）视为
+		// 新一轮运行，重置StartTime
+		if pid, ok := instance.Pid(); ok {
+			state.mu.Lock()
+			if state.PID != pid {
+				state.PID = pid
+				state.StartTime = time.Now()
+			}
+			state.ConfigHash = configHash(state.Config)
+			state.mu.Unlock()
+		}
+
+		// PID存活不代表服务可用，额外做一次应用层探测
+		healthCtx, healthCancel := context.WithTimeout(pm.ctx, time.Duration(pm.config.HealthTimeout)*time.Second)
+		healthErr := instance.HealthCheck(healthCtx)
+		healthCancel()
+
+		consecutiveUnhealthy := state.RecordHealthCheck(healthErr == nil)
+		if healthErr != nil {
+			log.WithError(healthErr).WithField("consecutive_unhealthy", consecutiveUnhealthy).
+				Warn("代理健康检查未通过")
+
+			if consecutiveUnhealthy >= maxConsecutiveUnhealthy {
+				// 连续多次探测失败，等同于崩溃处理
+				newStatus = "crashed"
+				restartReason = "健康检查连续失败"
+			} else {
+				newStatus = "unhealthy"
+			}
+		}
+
+		// 与instance.HealthCheck并行的可插拔探测（pm.prober非nil时），失败计数
+		// 与ConsecutiveUnhealthy分开统计，达到ProbeFailureThreshold后同样按崩溃处理
+		if newStatus != "crashed" && pm.prober != nil {
+			probeCtx, probeCancel := context.WithTimeout(pm.ctx, time.Duration(pm.config.HealthTimeout)*time.Second)
+			latency, probeErr := pm.prober.Probe(probeCtx)
+			probeCancel()
+
+			logger.LogPerformance("proxy_health_probe", latency, logrus.Fields{
+				"proxy_id":   proxyID,
+				"proxy_type": proxyType,
+				"success":    probeErr == nil,
+			})
+
+			consecutiveProbeFail := state.RecordProbeResult(latency, probeErr)
+
+			pm.stats.mu.Lock()
+			pm.stats.LastProbeLatencyMs = latency.Milliseconds()
+			pm.stats.mu.Unlock()
+
+			if probeErr != nil {
+				failureThreshold := pm.config.ProbeFailureThreshold
+				if failureThreshold <= 0 {
+					failureThreshold = 3
+				}
+
+				log.WithError(probeErr).WithField("consecutive_probe_failures", consecutiveProbeFail).
+					Warn("可插拔健康探测未通过")
+
+				if consecutiveProbeFail >= failureThreshold {
+					newStatus = "crashed"
+					restartReason = "可插拔健康探测连续失败"
+				} else if newStatus == "running" {
+					newStatus = "unhealthy"
+				}
+			}
+		}
 	} else {
+		// 进程已不在，清空PID避免SaveSnapshot里残留一个已经不存在的PID
+		state.mu.Lock()
+		state.PID = 0
+		state.mu.Unlock()
+
 		// 判断是否是异常退出
-		if oldStatus == "running" || oldStatus == "unknown" {
+		if oldStatus == "running" || oldStatus == "unknown" || oldStatus == "unhealthy" {
 			newStatus = "crashed"
 			log.Warn("检测到代理进程异常退出")
 		} else {
@@ -426,10 +1039,10 @@ func (pm *ProxyMonitor) checkProxyHealth(state *ProxyState) {
 
 	state.SetStatus(newStatus)
 
-	// 如果检测到崩溃且启用了自动重启，则尝试重启
+	// 如果检测到崩溃（进程退出或健康检查连续失败）且启用了自动重启，则尝试重启
 	if newStatus == "crashed" && pm.config.Enable {
-		log.Info("代理进程崩溃，尝试自动重启")
-		pm.attemptRestart(state, "进程崩溃检测")
+		log.WithField("reason", restartReason).Info("代理异常，尝试自动重启")
+		pm.attemptRestart(state, restartReason)
 	}
 }
 
@@ -449,8 +1062,13 @@ func (pm *ProxyMonitor) attemptRestart(state *ProxyState, reason string) {
 	})
 
 	// 检查是否可以重启
-	if !state.CanRestart(pm.config.MaxRestarts, pm.config.RestartCooldown) {
-		log.Warn("代理重启被限制（达到最大重启次数或在冷却期内）")
+	if !state.CanRestart(pm.config) {
+		if state.GetBreakerState() == breakerOpen {
+			state.SetStatus("circuit_open")
+			log.Warn("代理重启被熔断器拒绝，等待OpenDuration过后再试探")
+		} else {
+			log.Warn("代理重启在退避冷却期内，暂不重试")
+		}
 		return
 	}
 
@@ -465,12 +1083,29 @@ func (pm *ProxyMonitor) attemptRestart(state *ProxyState, reason string) {
 	pm.stats.TotalRestarts++
 	pm.stats.mu.Unlock()
 
+	breakerStateBefore := state.GetBreakerState()
+
 	// 执行重启操作
-	success := pm.performRestart(instance, config, log)
+	success, restartErr := pm.performRestart(instance, config, log)
 	duration := time.Since(startTime)
 
-	// 记录重启结果
-	state.AddRestartRecord(reason, success, duration)
+	// 记录重启结果，驱动熔断器状态机
+	state.AddRestartRecord(reason, success, restartErr, duration, pm.config.TripThreshold)
+
+	if breakerStateAfter := state.GetBreakerState(); breakerStateAfter != breakerStateBefore {
+		if breakerStateAfter == breakerOpen {
+			logger.LogAudit("proxy_circuit_open", "system", logrus.Fields{
+				"proxy_id":   proxyID,
+				"proxy_type": proxyType,
+				"reason":     "连续重启失败达到TripThreshold，或half_open试探失败",
+			})
+		} else if breakerStateAfter == breakerClosed {
+			logger.LogAudit("proxy_circuit_closed", "system", logrus.Fields{
+				"proxy_id":   proxyID,
+				"proxy_type": proxyType,
+			})
+		}
+	}
 
 	// 更新统计信息
 	pm.stats.mu.Lock()
@@ -500,45 +1135,49 @@ func (pm *ProxyMonitor) attemptRestart(state *ProxyState, reason string) {
 			"reason":     reason,
 			"duration":   duration.Milliseconds(),
 		})
+	} else if state.GetBreakerState() == breakerOpen {
+		state.SetStatus("circuit_open")
+		log.WithField("duration_ms", duration.Milliseconds()).Error("代理重启失败且已触发熔断")
 	} else {
 		state.SetStatus("crashed")
 		log.WithField("duration_ms", duration.Milliseconds()).Error("代理重启失败")
 	}
 }
 
-// performRestart 执行重启操作
-func (pm *ProxyMonitor) performRestart(instance ProxyInterface, config map[string]interface{}, log *logrus.Entry) bool {
-	// 1. 尝试停止进程（如果还在运行）
-	log.Debug("停止代理进程")
-	if err := instance.Stop(); err != nil {
-		log.WithError(err).Warn("停止代理进程失败，继续重启流程")
+// performRestart 执行重启操作，返回是否成功及失败时的错误描述（供
+// ProxyState.LastError展示给API层）
+func (pm *ProxyMonitor) performRestart(instance ProxyInterface, config *model.EgressItem, log *logrus.Entry) (bool, string) {
+	// 1. 优雅停止进程（如果还在运行）：GracefulStop内部按SIGTERM等待自行退出、
+	// 超时后升级SIGKILL，返回前已确认进程真正退出，不再需要像此前那样额外
+	// time.Sleep猜测一个固定等待时间——这段固定sleep曾经有可能在旧进程仍占着
+	// 监听端口时就放行第3步的启动，导致新进程bind失败
+	log.Debug("优雅停止代理进程")
+	if err := instance.GracefulStop(pm.ctx); err != nil {
+		log.WithError(err).Warn("优雅停止代理进程失败，继续重启流程")
 	}
 
-	// 2. 等待一小段时间确保进程完全停止
-	time.Sleep(2 * time.Second)
-
-	// 3. 重新配置
+	// 2. 重新配置
 	log.Debug("重新配置代理")
 	if err := instance.Configure(config); err != nil {
 		log.WithError(err).Error("重新配置代理失败")
-		return false
+		return false, fmt.Sprintf("重新配置代理失败: %v", err)
 	}
 
-	// 4. 启动代理
+	// 3. 启动代理
 	log.Debug("启动代理进程")
 	if err := instance.Start(); err != nil {
 		log.WithError(err).Error("启动代理进程失败")
-		return false
+		return false, fmt.Sprintf("启动代理进程失败: %v", err)
 	}
 
-	// 5. 验证启动是否成功（等待几秒后检查）
+	// 4. 验证启动是否成功（等待几秒后检查）
 	time.Sleep(3 * time.Second)
 	if !instance.IsRunning() {
 		log.Error("代理启动后验证失败，进程未运行")
-		return false
+		return false, "代理启动后验证失败，进程未运行"
 	}
 
-	return true
+	return true, ""
 }
 
 // GetMonitorSummary 获取监控摘要信息
@@ -555,11 +1194,13 @@ func (pm *ProxyMonitor) GetMonitorSummary() map[string]interface{} {
 
 	// 按状态统计代理数量
 	statusCount := make(map[string]int)
+	breakerStateCount := make(map[string]int)
 	enabledCount := 0
 
 	for _, state := range pm.states {
 		status := state.GetStatus()
 		statusCount[status]++
+		breakerStateCount[state.GetBreakerState()]++
 
 		state.mu.RLock()
 		if state.Enabled {
@@ -569,6 +1210,7 @@ func (pm *ProxyMonitor) GetMonitorSummary() map[string]interface{} {
 	}
 
 	summary["status_count"] = statusCount
+	summary["breaker_state_count"] = breakerStateCount
 	summary["enabled_proxies"] = enabledCount
 
 	return summary
@@ -578,3 +1220,53 @@ func (pm *ProxyMonitor) GetMonitorSummary() map[string]interface{} {
 func (pm *ProxyMonitor) IsRunning() bool {
 	return pm.running
 }
+
+// ProxyStateSnapshot是Snapshot()为单个代理返回的只读状态，供API层展示，
+// 不暴露ProxyState内部的Instance/Config等字段
+type ProxyStateSnapshot struct {
+	Status              string    `json:"status"`
+	RestartCount        int       `json:"restart_count"`
+	RecentRestarts      int       `json:"recent_restarts"`      // 最近一小时
+	ConsecutiveFailures int       `json:"consecutive_failures"` // 用于换算当前退避冷却时间
+	LastError           string    `json:"last_error,omitempty"` // 最近一次重启失败的错误描述
+	LastRestart         time.Time `json:"last_restart,omitempty"`
+	BreakerState        string    `json:"breaker_state"`               // closed/open/half_open
+	BreakerOpenedAt     time.Time `json:"breaker_opened_at,omitempty"` // BreakerState为open/half_open时有意义
+}
+
+// Snapshot 返回所有受监控代理的重启计数/最近错误快照，供admin API的GetStatus
+// 等只读查询使用，避免直接暴露ProxyState
+func (pm *ProxyMonitor) Snapshot() map[string]ProxyStateSnapshot {
+	pm.mu.RLock()
+	states := make([]*ProxyState, 0, len(pm.states))
+	ids := make([]string, 0, len(pm.states))
+	for id, state := range pm.states {
+		ids = append(ids, id)
+		states = append(states, state)
+	}
+	pm.mu.RUnlock()
+
+	snapshot := make(map[string]ProxyStateSnapshot, len(states))
+	for i, state := range states {
+		recentRestarts := state.GetRecentRestarts() // 内部自行加锁，不能在下面的RLock区间里调用
+
+		state.mu.RLock()
+		breakerState := state.BreakerState
+		if breakerState == "" {
+			breakerState = breakerClosed
+		}
+		snapshot[ids[i]] = ProxyStateSnapshot{
+			Status:              state.Status,
+			RestartCount:        state.RestartCount,
+			RecentRestarts:      recentRestarts,
+			ConsecutiveFailures: state.ConsecutiveFailures,
+			LastError:           state.LastError,
+			LastRestart:         state.LastRestart,
+			BreakerState:        breakerState,
+			BreakerOpenedAt:     state.BreakerOpenedAt,
+		}
+		state.mu.RUnlock()
+	}
+
+	return snapshot
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+)
+
+func TestBackoffCooldownExponentialAndCapped(t *testing.T) {
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 20 * time.Second},
+		{2, 40 * time.Second},
+		{10, 5 * time.Minute}, // 封顶maxBackoffSeconds
+	}
+
+	for _, tc := range cases {
+		got := backoffCooldown(10, tc.failures, 300, 0)
+		if got != tc.want {
+			t.Errorf("backoffCooldown(10, %d, 300, 0) = %v, want %v", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestBackoffCooldownUsesDefaultMaxWhenUnset(t *testing.T) {
+	got := backoffCooldown(60, 20, 0, 0)
+	if got != defaultMaxRestartBackoff {
+		t.Fatalf("backoffCooldown在maxBackoffSeconds为0时应封顶defaultMaxRestartBackoff, got %v", got)
+	}
+}
+
+func TestBackoffCooldownJitterAddsWithinRange(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		got := backoffCooldown(10, 0, 300, 5)
+		if got < 10*time.Second || got > 15*time.Second {
+			t.Fatalf("backoffCooldown抖动后应落在[10s,15s]区间内, got %v", got)
+		}
+	}
+}
+
+func TestCanRestartClosedStateUsesBackoffCooldown(t *testing.T) {
+	ps := &ProxyState{}
+	cfg := config.MonitorConfig{RestartCooldown: 10}
+
+	if !ps.CanRestart(cfg) {
+		t.Fatal("从未重启过时应允许重启")
+	}
+
+	ps.LastRestart = time.Now()
+	if ps.CanRestart(cfg) {
+		t.Fatal("冷却时间未到时不应允许重启")
+	}
+
+	ps.LastRestart = time.Now().Add(-time.Hour)
+	if !ps.CanRestart(cfg) {
+		t.Fatal("冷却时间已过时应允许重启")
+	}
+}
+
+func TestAddRestartRecordTripsBreaker(t *testing.T) {
+	ps := &ProxyState{}
+	const tripThreshold = 3
+
+	for i := 0; i < tripThreshold-1; i++ {
+		ps.AddRestartRecord("crash", false, "boom", time.Second, tripThreshold)
+		if ps.GetBreakerState() != breakerClosed {
+			t.Fatalf("未达到tripThreshold前熔断器应保持closed，第%d次失败后却是%s", i+1, ps.GetBreakerState())
+		}
+	}
+
+	ps.AddRestartRecord("crash", false, "boom", time.Second, tripThreshold)
+	if ps.GetBreakerState() != breakerOpen {
+		t.Fatalf("达到tripThreshold后熔断器应打开, got %s", ps.GetBreakerState())
+	}
+}
+
+func TestAddRestartRecordSuccessClosesBreaker(t *testing.T) {
+	ps := &ProxyState{BreakerState: breakerOpen, ConsecutiveFailures: 5}
+	ps.AddRestartRecord("manual", true, "", time.Second, 3)
+
+	if ps.GetBreakerState() != breakerClosed {
+		t.Fatalf("重启成功后熔断器应关闭, got %s", ps.GetBreakerState())
+	}
+	if ps.ConsecutiveFailures != 0 {
+		t.Fatalf("重启成功后ConsecutiveFailures应清零, got %d", ps.ConsecutiveFailures)
+	}
+}
+
+func TestCanRestartOpenBreakerRejectsUntilOpenDuration(t *testing.T) {
+	ps := &ProxyState{BreakerState: breakerOpen, BreakerOpenedAt: time.Now()}
+	cfg := config.MonitorConfig{OpenDuration: 60}
+
+	if ps.CanRestart(cfg) {
+		t.Fatal("熔断器open且未到OpenDuration时不应允许重启")
+	}
+
+	ps.BreakerOpenedAt = time.Now().Add(-time.Hour)
+	if !ps.CanRestart(cfg) {
+		t.Fatal("OpenDuration已过后应转入half_open并放行一次试探性重启")
+	}
+	if ps.GetBreakerState() != breakerHalfOpen {
+		t.Fatalf("OpenDuration已过后熔断器应转为half_open, got %s", ps.GetBreakerState())
+	}
+
+	// half_open状态下，在试探结果落地前不应重复放行
+	if ps.CanRestart(cfg) {
+		t.Fatal("half_open试探进行中时不应再次放行重启")
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+)
+
+// HealthProber是与instance.HealthCheck并行的、可插拔的主动健康探测，由
+// config.MonitorConfig.Prober配置选择具体实现。与instance.HealthCheck不同，
+// HealthProber由运维按部署环境自行配置探测方式，不需要各代理类型内置支持
+type HealthProber interface {
+	// Probe执行一次探测，返回本次探测耗时（无论成功失败都返回，供观测探测
+	// 延迟劣化趋势）和探测结果
+	Probe(ctx context.Context) (time.Duration, error)
+}
+
+// NewHealthProber根据cfg.Type构造对应的HealthProber，Type为空或无法识别时
+// 返回nil，表示不启用可插拔探测
+func NewHealthProber(cfg config.HealthProbeConfig) HealthProber {
+	switch cfg.Type {
+	case "tcp":
+		return &tcpProber{cfg: cfg}
+	case "http", "https":
+		return &httpProber{cfg: cfg}
+	case "socks5":
+		return &socks5Prober{cfg: cfg}
+	case "exec":
+		return &execProber{cfg: cfg}
+	default:
+		return nil
+	}
+}
+
+// probeTimeout返回cfg.Timeout对应的time.Duration，未配置时回退到5秒
+func probeTimeout(cfg config.HealthProbeConfig) time.Duration {
+	if cfg.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(cfg.Timeout) * time.Second
+}
+
+// tcpProber 通过TCP三次握手判断cfg.Target（host:port）是否可连接
+type tcpProber struct {
+	cfg config.HealthProbeConfig
+}
+
+func (p *tcpProber) Probe(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	dialer := net.Dialer{Timeout: probeTimeout(p.cfg)}
+	conn, err := dialer.DialContext(ctx, "tcp", p.cfg.Target)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, fmt.Errorf("tcp探测%s失败: %w", p.cfg.Target, err)
+	}
+	conn.Close()
+	return latency, nil
+}
+
+// httpProber 对cfg.Target发起一次GET请求，5xx视为探测失败
+type httpProber struct {
+	cfg config.HealthProbeConfig
+}
+
+func (p *httpProber) Probe(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Target, nil)
+	if err != nil {
+		return 0, fmt.Errorf("构造http探测请求失败: %w", err)
+	}
+
+	client := &http.Client{Timeout: probeTimeout(p.cfg)}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, fmt.Errorf("http探测%s失败: %w", p.cfg.Target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return latency, fmt.Errorf("http探测%s返回状态码%d", p.cfg.Target, resp.StatusCode)
+	}
+	return latency, nil
+}
+
+// socks5Prober 对cfg.Target（host:port）发起一次SOCKS5问候握手（不含认证），
+// 用于探测socks5代理端口是否仍按协议响应，而不只是端口是否可连接
+type socks5Prober struct {
+	cfg config.HealthProbeConfig
+}
+
+func (p *socks5Prober) Probe(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	dialer := net.Dialer{Timeout: probeTimeout(p.cfg)}
+	conn, err := dialer.DialContext(ctx, "tcp", p.cfg.Target)
+	if err != nil {
+		return time.Since(start), fmt.Errorf("socks5探测%s连接失败: %w", p.cfg.Target, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// 问候：版本5，1种认证方式，无需认证(0x00)
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return time.Since(start), fmt.Errorf("socks5探测%s发送问候失败: %w", p.cfg.Target, err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := conn.Read(reply); err != nil {
+		return time.Since(start), fmt.Errorf("socks5探测%s读取响应失败: %w", p.cfg.Target, err)
+	}
+	latency := time.Since(start)
+
+	if reply[0] != 0x05 || reply[1] != 0x00 {
+		return latency, fmt.Errorf("socks5探测%s返回非预期的握手应答: %v", p.cfg.Target, reply)
+	}
+	return latency, nil
+}
+
+// execProber 运行cfg.Command，退出码非0视为探测失败
+type execProber struct {
+	cfg config.HealthProbeConfig
+}
+
+func (p *execProber) Probe(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, p.cfg.Command, p.cfg.Args...)
+	err := cmd.Run()
+	latency := time.Since(start)
+	if err != nil {
+		return latency, fmt.Errorf("exec探测%s失败: %w", p.cfg.Command, err)
+	}
+	return latency, nil
+}
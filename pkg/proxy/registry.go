@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/moooyo/nspass-proto/generated/model"
+)
+
+// Factory根据EgressItem构造一个具体后端的ProxyInterface实例，由各代理后端包
+// （shadowsocks/snell/trojan，以及未来的hysteria2/vless等）在各自的init()里
+// 通过Register注册，取代此前getProxyInstance里硬编码的switch
+type Factory func(cfg *model.EgressItem) ProxyInterface
+
+// registration是Register记录的一条后端注册信息
+type registration struct {
+	mode    model.EgressMode
+	name    string
+	factory Factory
+}
+
+// RegisterOption定制一次Register调用
+type RegisterOption func(*registration)
+
+// WithName为后端关联一个config.ProxyConfig.EnabledTypes里使用的名字（如
+// "shadowsocks"/"trojan"/"snell"），NewManager按名字过滤启用的后端集合，不区分
+// 大小写。不提供WithName的后端没有对应名字可匹配，因此始终被视为启用，不受
+// EnabledTypes约束
+func WithName(name string) RegisterOption {
+	return func(r *registration) { r.name = name }
+}
+
+var (
+	registryMu    sync.RWMutex
+	registrations = make(map[model.EgressMode]*registration)
+)
+
+// Register把mode对应的factory登记进全局后端注册表。各后端包应在自己的init()
+// 里调用（效仿database/sql驱动靠blank import触发注册的模式），proxy包本身不再
+// 直接import shadowsocks/snell/trojan，由调用方（如pkg/agent）blank import所有
+// 需要启用的后端包
+func Register(mode model.EgressMode, factory Factory, opts ...RegisterOption) {
+	r := &registration{mode: mode, factory: factory}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registrations[mode] = r
+}
+
+// buildSupportedFactories按enabledTypes过滤全局注册表，返回NewManager用来构建
+// Manager.supported的mode到factory映射。enabledTypes为空时返回全部已注册后端
+func buildSupportedFactories(enabledTypes []string) map[model.EgressMode]Factory {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	enabled := make(map[string]bool, len(enabledTypes))
+	for _, t := range enabledTypes {
+		enabled[strings.ToLower(t)] = true
+	}
+
+	supported := make(map[model.EgressMode]Factory, len(registrations))
+	for mode, r := range registrations {
+		if len(enabledTypes) > 0 && r.name != "" && !enabled[strings.ToLower(r.name)] {
+			continue
+		}
+		supported[mode] = r.factory
+	}
+	return supported
+}
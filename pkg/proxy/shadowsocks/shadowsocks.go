@@ -1,38 +1,68 @@
 package shadowsocks
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/moooyo/nspass-proto/generated/model"
 	"github.com/nspass/nspass-agent/pkg/config"
 	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/pkgmgr"
+	"github.com/nspass/nspass-agent/pkg/proxy"
+	"github.com/nspass/nspass-agent/pkg/proxy/lifecycle"
 	"github.com/sirupsen/logrus"
 )
 
+// init把shadowsocks登记进proxy包的全局后端注册表，对应EnabledTypes里的
+// "shadowsocks"，取代manager.go此前硬编码的switch分支
+func init() {
+	proxy.Register(model.EgressMode_EGRESS_MODE_SS2022, func(cfg *model.EgressItem) proxy.ProxyInterface {
+		return New(cfg)
+	}, proxy.WithName("shadowsocks"))
+}
+
 // Shadowsocks shadowsocks代理实现
 type Shadowsocks struct {
 	config     config.ProxyConfig
+	proxyID    string
 	configPath string
 	pidFile    string
+
+	localAddr string // ss-local实际监听地址，供HealthCheck拨测
+	localPort int
+	udpMode   bool // true时HealthCheck额外做一次UDP探测
+
+	variant    Variant // 按method自动选择的发行版，决定二进制和配置schema
+	serverMode bool    // true时cfg携带了多用户凭据，以ss-server多用户模式运行而非ss-local出口
+
+	installer pkgmgr.Installer  // 安装shadowsocks-libev/shadowsocks-rust时使用的包管理器
+	backend   lifecycle.Backend // 非nil时代表ss-local由lifecycle后端（supervisor或systemd）持有
 }
 
 // New 创建新的Shadowsocks实例
 func New(cfg *model.EgressItem) *Shadowsocks {
 	ss := &Shadowsocks{
 		config:     cfg,
+		proxyID:    cfg.EgressId,
 		configPath: filepath.Join(cfg.ConfigPath, "shadowsocks.json"),
 		pidFile:    filepath.Join(cfg.ConfigPath, "shadowsocks.pid"),
+		installer:  pkgmgr.Resolve(cfg.PackageManager, cfg.BinPath),
 	}
 
 	logger.LogStartup("shadowsocks-proxy", "1.0", map[string]interface{}{
 		"config_path": ss.configPath,
 		"pid_file":    ss.pidFile,
+		"installer":   ss.installer.Name(),
+		"version":     cfg.PackageManager.StaticBinary.Version,
 	})
 
 	return ss
@@ -43,7 +73,7 @@ func (s *Shadowsocks) Type() string {
 	return "shadowsocks"
 }
 
-// Install 安装shadowsocks
+// Install 安装shadowsocks，通过pkgmgr.Resolve选出的安装器完成
 func (s *Shadowsocks) Install() error {
 	startTime := time.Now()
 	log := logger.GetProxyLogger().WithField("proxy_type", "shadowsocks")
@@ -54,57 +84,31 @@ func (s *Shadowsocks) Install() error {
 		return nil
 	}
 
-	log.Info("开始安装shadowsocks-libev")
-
-	// 使用包管理器安装
-	var cmd *exec.Cmd
-	var pkgManager string
-
-	if _, err := exec.LookPath("apt-get"); err == nil {
-		// Debian/Ubuntu
-		pkgManager = "apt-get"
-		log.Debug("使用apt-get包管理器")
-		cmd = exec.Command("apt-get", "update")
-		if err := cmd.Run(); err != nil {
-			logger.LogError(err, "更新包列表失败", logrus.Fields{
-				"pkg_manager": pkgManager,
-			})
-			return fmt.Errorf("更新包列表失败: %w", err)
-		}
-		cmd = exec.Command("apt-get", "install", "-y", "shadowsocks-libev")
-	} else if _, err := exec.LookPath("yum"); err == nil {
-		// CentOS/RHEL
-		pkgManager = "yum"
-		log.Debug("使用yum包管理器")
-		cmd = exec.Command("yum", "install", "-y", "shadowsocks-libev")
-	} else if _, err := exec.LookPath("pacman"); err == nil {
-		// Arch Linux
-		pkgManager = "pacman"
-		log.Debug("使用pacman包管理器")
-		cmd = exec.Command("pacman", "-S", "--noconfirm", "shadowsocks-libev")
-	} else {
-		logger.LogError(fmt.Errorf("未找到支持的包管理器"),
-			"不支持的系统，无法自动安装shadowsocks", nil)
-		return fmt.Errorf("不支持的系统，无法自动安装shadowsocks")
-	}
+	pkg := packageName(s.variant)
+	log.WithFields(logrus.Fields{
+		"installer": s.installer.Name(),
+		"package":   pkg,
+	}).Info("开始安装shadowsocks")
 
-	if err := cmd.Run(); err != nil {
+	if err := s.installer.Install(pkg, s.config.PackageManager.DryRun); err != nil {
 		logger.LogError(err, "安装shadowsocks失败", logrus.Fields{
-			"pkg_manager": pkgManager,
+			"installer": s.installer.Name(),
+			"package":   pkg,
 		})
 		return fmt.Errorf("安装shadowsocks失败: %w", err)
 	}
 
 	duration := time.Since(startTime)
 	logger.LogPerformance("shadowsocks_install", duration, logrus.Fields{
-		"pkg_manager": pkgManager,
+		"installer": s.installer.Name(),
+		"package":   pkg,
 	})
 
-	log.WithField("duration_ms", duration.Milliseconds()).Info("shadowsocks-libev安装完成")
+	log.WithField("duration_ms", duration.Milliseconds()).Info("shadowsocks安装完成")
 	return nil
 }
 
-// Uninstall 卸载shadowsocks
+// Uninstall 卸载shadowsocks，通过pkgmgr.Resolve选出的安装器完成
 func (s *Shadowsocks) Uninstall() error {
 	// 先停止服务
 	if s.IsRunning() {
@@ -113,20 +117,8 @@ func (s *Shadowsocks) Uninstall() error {
 		}
 	}
 
-	// 使用包管理器卸载
-	var cmd *exec.Cmd
-	if _, err := exec.LookPath("apt-get"); err == nil {
-		cmd = exec.Command("apt-get", "remove", "-y", "shadowsocks-libev")
-	} else if _, err := exec.LookPath("yum"); err == nil {
-		cmd = exec.Command("yum", "remove", "-y", "shadowsocks-libev")
-	} else if _, err := exec.LookPath("pacman"); err == nil {
-		cmd = exec.Command("pacman", "-R", "--noconfirm", "shadowsocks-libev")
-	}
-
-	if cmd != nil {
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("卸载shadowsocks失败: %w", err)
-		}
+	if err := s.installer.Remove(packageName(s.variant)); err != nil {
+		return fmt.Errorf("卸载shadowsocks失败: %w", err)
 	}
 
 	// 清理配置文件
@@ -152,16 +144,40 @@ func (s *Shadowsocks) Configure(cfg *model.EgressItem) error {
 		return fmt.Errorf("创建配置目录失败: %w", err)
 	}
 
+	method := fmt.Sprintf("%v", cfg["method"])
+	s.variant = DetectVariant(method)
+
+	if err := ValidateKey(method, fmt.Sprintf("%v", cfg["password"])); err != nil {
+		log.WithError(err).Error("shadowsocks-2022密钥校验失败")
+		return err
+	}
+
 	// 生成shadowsocks配置
 	config := map[string]interface{}{
 		"server":      cfg["server"],
 		"server_port": cfg["port"],
-		"password":    cfg["password"],
-		"method":      cfg["method"],
+		"method":      method,
 		"timeout":     cfg["timeout"],
 		"fast_open":   true,
 	}
 
+	// users非空代表server侧多用户模式（仅shadowsocks-rust支持），否则走单用户
+	// server/password配置
+	users, err := buildUsers(cfg["users"], method)
+	if err != nil {
+		log.WithError(err).Error("shadowsocks-2022多用户密钥校验失败")
+		return err
+	}
+	if users != nil {
+		if s.variant != VariantRust {
+			return fmt.Errorf("多用户server模式仅shadowsocks-rust支持，method %s当前解析为%s", method, s.variant)
+		}
+		config["users"] = users
+		s.serverMode = true
+	} else {
+		config["password"] = cfg["password"]
+	}
+
 	// 如果有本地配置
 	if localPort, ok := cfg["local_port"]; ok {
 		config["local_port"] = localPort
@@ -175,6 +191,17 @@ func (s *Shadowsocks) Configure(cfg *model.EgressItem) error {
 		config["local_address"] = "0.0.0.0"
 	}
 
+	// 记录HealthCheck拨测用的本地地址，0.0.0.0无法直接拨号，探测时改连127.0.0.1
+	s.localAddr = fmt.Sprintf("%v", config["local_address"])
+	if s.localAddr == "0.0.0.0" || s.localAddr == "" {
+		s.localAddr = "127.0.0.1"
+	}
+	fmt.Sscanf(fmt.Sprintf("%v", config["local_port"]), "%d", &s.localPort)
+
+	if mode, ok := cfg["mode"]; ok {
+		s.udpMode = strings.Contains(fmt.Sprintf("%v", mode), "udp")
+	}
+
 	// 写入配置文件
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
@@ -204,7 +231,9 @@ func (s *Shadowsocks) Configure(cfg *model.EgressItem) error {
 	return nil
 }
 
-// Start 启动shadowsocks
+// Start 启动shadowsocks，并交由当前激活的生命周期后端（systemd或进程内
+// supervisor）持有和监管ss-local，异常退出后按s.config中的重启策略和健康探测
+// 配置自动重启
 func (s *Shadowsocks) Start() error {
 	startTime := time.Now()
 	log := logger.GetProxyLogger().WithField("proxy_type", "shadowsocks")
@@ -221,33 +250,52 @@ func (s *Shadowsocks) Start() error {
 
 	log.Debug("启动shadowsocks服务")
 
-	// 启动ss-local
-	cmd := exec.Command("ss-local", "-c", s.configPath, "-f", s.pidFile)
-	if err := cmd.Start(); err != nil {
+	binary := clientBinary(s.variant)
+	if s.serverMode {
+		binary = serverBinary(s.variant)
+	}
+	newCmd := func() *exec.Cmd {
+		return exec.Command(binary, "-c", s.configPath)
+	}
+
+	backend, err := lifecycle.Launch("shadowsocks", s.proxyID, s.pidFile, newCmd, s.config)
+	if err != nil {
 		logger.LogError(err, "启动shadowsocks失败", logrus.Fields{
 			"config_path": s.configPath,
 			"pid_file":    s.pidFile,
 		})
 		return fmt.Errorf("启动shadowsocks失败: %w", err)
 	}
+	s.backend = backend
 
 	duration := time.Since(startTime)
 	logger.LogPerformance("shadowsocks_start", duration, nil)
 
-	// 记录状态变更
-	logger.LogStateChange("shadowsocks", "stopped", "running", "正常启动")
-
 	log.WithField("duration_ms", duration.Milliseconds()).Info("shadowsocks服务已启动")
 	return nil
 }
 
-// Stop 停止shadowsocks
+// Stop 停止shadowsocks。由生命周期后端管理时交由其完成优雅停机；否则回退到
+// 直接读取PID文件发送信号，仅作为外部启动进程的兜底
 func (s *Shadowsocks) Stop() error {
 	startTime := time.Now()
 	log := logger.GetProxyLogger().WithField("proxy_type", "shadowsocks")
 
 	log.Debug("停止shadowsocks服务")
 
+	if s.backend != nil {
+		if err := s.backend.Stop(10 * time.Second); err != nil {
+			logger.LogError(err, "停止shadowsocks失败", nil)
+			return fmt.Errorf("停止shadowsocks失败: %w", err)
+		}
+		s.backend = nil
+
+		duration := time.Since(startTime)
+		logger.LogPerformance("shadowsocks_stop", duration, nil)
+		log.WithField("duration_ms", duration.Milliseconds()).Info("shadowsocks服务已停止")
+		return nil
+	}
+
 	// 读取PID文件
 	pidData, err := os.ReadFile(s.pidFile)
 	if err != nil {
@@ -296,6 +344,46 @@ func (s *Shadowsocks) Stop() error {
 	return nil
 }
 
+// GracefulStop实现ProxyInterface：按lifecycle.GracefulTimeout(ctx,
+// s.config.GracefulWait)算出的超时发SIGTERM等待自行退出，超时后由后端升级为
+// SIGKILL，返回前已确认进程真正退出。没有backend（只留了PID文件）时把PID包装
+// 成一个lifecycle.Adopt，复用同一套SIGTERM→SIGKILL升级逻辑，而不是自行重新
+// 实现一遍
+func (s *Shadowsocks) GracefulStop(ctx context.Context) error {
+	log := logger.GetProxyLogger().WithField("proxy_type", "shadowsocks")
+	timeout := lifecycle.GracefulTimeout(ctx, s.config.GracefulWait)
+
+	if s.backend != nil {
+		if err := s.backend.Stop(timeout); err != nil {
+			return fmt.Errorf("优雅停止shadowsocks失败: %w", err)
+		}
+		s.backend = nil
+		log.WithField("timeout", timeout).Debug("shadowsocks已优雅停止")
+		return nil
+	}
+
+	pidData, err := os.ReadFile(s.pidFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("读取PID文件失败: %w", err)
+	}
+
+	var pid int
+	if _, err := fmt.Sscanf(string(pidData), "%d", &pid); err != nil {
+		return fmt.Errorf("解析PID失败: %w", err)
+	}
+
+	if err := lifecycle.Adopt(pid).Stop(timeout); err != nil {
+		return fmt.Errorf("优雅停止shadowsocks失败: %w", err)
+	}
+	os.Remove(s.pidFile)
+
+	log.WithFields(logrus.Fields{"pid": pid, "timeout": timeout}).Debug("shadowsocks已优雅停止")
+	return nil
+}
+
 // Restart 重启shadowsocks
 func (s *Shadowsocks) Restart() error {
 	if err := s.Stop(); err != nil {
@@ -305,7 +393,7 @@ func (s *Shadowsocks) Restart() error {
 	return s.Start()
 }
 
-// Status 获取shadowsocks状态
+// Status 获取shadowsocks状态。由生命周期后端管理时直接转发其状态
 func (s *Shadowsocks) Status() (string, error) {
 	log := logger.GetProxyLogger().WithField("proxy_type", "shadowsocks")
 
@@ -314,6 +402,10 @@ func (s *Shadowsocks) Status() (string, error) {
 		return "not_installed", nil
 	}
 
+	if s.backend != nil {
+		return s.backend.Status()
+	}
+
 	if s.IsRunning() {
 		log.Debug("shadowsocks正在运行")
 		return "running", nil
@@ -323,23 +415,35 @@ func (s *Shadowsocks) Status() (string, error) {
 	return "stopped", nil
 }
 
-// IsInstalled 检查是否已安装
+// IsInstalled 检查是否已安装。serverMode下查找ss-server，否则查找当前variant
+// 对应的客户端二进制（ss-local或sslocal）
 func (s *Shadowsocks) IsInstalled() bool {
-	_, err := exec.LookPath("ss-local")
+	binary := clientBinary(s.variant)
+	if s.serverMode {
+		binary = serverBinary(s.variant)
+	}
+
+	_, err := exec.LookPath(binary)
 	installed := err == nil
 
 	logger.GetProxyLogger().WithFields(logrus.Fields{
 		"proxy_type": "shadowsocks",
+		"binary":     binary,
 		"installed":  installed,
 	}).Debug("检查安装状态")
 
 	return installed
 }
 
-// IsRunning 检查是否正在运行
+// IsRunning 检查是否正在运行。由生命周期后端管理时直接反映其状态；否则回退到
+// PID文件探活，仅作为外部启动进程（未经由本Agent管理）的只读兜底
 func (s *Shadowsocks) IsRunning() bool {
 	log := logger.GetProxyLogger().WithField("proxy_type", "shadowsocks")
 
+	if s.backend != nil {
+		return s.backend.IsRunning()
+	}
+
 	// 检查PID文件
 	pidData, err := os.ReadFile(s.pidFile)
 	if err != nil {
@@ -362,3 +466,81 @@ func (s *Shadowsocks) IsRunning() bool {
 	log.WithField("pid", pid).Debug("shadowsocks进程运行中")
 	return true
 }
+
+// Adopt 接管一个由此前Agent进程启动、仍然存活的ss-local/sslocal/ss-server
+// 进程，跳过Start()里的exec.Command，避免Agent重启或升级中断用户流量
+func (s *Shadowsocks) Adopt(pid int) error {
+	expected := clientBinary(s.variant)
+	if s.serverMode {
+		expected = serverBinary(s.variant)
+	}
+
+	if !lifecycle.VerifyExecutable(pid, expected) {
+		return fmt.Errorf("接管失败: pid %d不是预期的%s", pid, expected)
+	}
+
+	s.backend = lifecycle.Adopt(pid)
+	return nil
+}
+
+// Pid 返回当前持有shadowsocks的生命周期后端报告的PID
+func (s *Shadowsocks) Pid() (int, bool) {
+	if s.backend == nil {
+		return 0, false
+	}
+	return s.backend.Pid()
+}
+
+// ListenAddr实现proxy.PortProbe：暴露ss-local本地监听地址，供Manager.RestartAll
+// 在重启前确认旧进程已经真正释放端口，未配置本地端口时返回false
+func (s *Shadowsocks) ListenAddr() (string, bool) {
+	if s.localPort == 0 {
+		return "", false
+	}
+	return net.JoinHostPort(s.localAddr, fmt.Sprintf("%d", s.localPort)), true
+}
+
+// HealthCheck 拨测ss-local本地监听端口，发现PID存活但端口无响应（配置加载失败、
+// 连接耗尽等）的情况。TCP探测会额外发送一次SOCKS5问候并校验响应版本号；
+// udpMode为true时再做一次UDP探测，失败不视为致命（UDP无连接，仅尽力确认端口可写）
+func (s *Shadowsocks) HealthCheck(ctx context.Context) error {
+	if s.localPort == 0 {
+		return fmt.Errorf("healthcheck: 本地端口未配置")
+	}
+
+	addr := net.JoinHostPort(s.localAddr, fmt.Sprintf("%d", s.localPort))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("连接ss-local本地端口%s失败: %w", addr, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// SOCKS5问候：版本5、1种认证方式（无认证），期望收到"05 00"
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return fmt.Errorf("发送SOCKS5问候失败: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("读取SOCKS5问候响应失败: %w", err)
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("SOCKS5问候响应版本号异常: %#x", reply[0])
+	}
+
+	if s.udpMode {
+		udpConn, err := net.DialTimeout("udp", addr, 2*time.Second)
+		if err != nil {
+			logger.GetProxyLogger().WithError(err).WithField("addr", addr).Warn("shadowsocks UDP探测失败")
+		} else {
+			udpConn.Close()
+		}
+	}
+
+	return nil
+}
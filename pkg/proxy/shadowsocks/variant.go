@@ -0,0 +1,116 @@
+package shadowsocks
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// Variant 标识具体对接的shadowsocks发行版：libev沿用ss-local/ss-server的
+// server+port+password+method单用户JSON配置；rust(shadowsocks-rust)额外
+// 支持AEAD-2022密码族，并能以users数组承载server侧多用户凭据
+type Variant string
+
+const (
+	// VariantLibev 对应shadowsocks-libev的ss-local/ss-server
+	VariantLibev Variant = "ss-libev"
+	// VariantRust 对应shadowsocks-rust的sslocal/ss-server
+	VariantRust Variant = "ss-rust"
+)
+
+// aead2022KeySizes记录shadowsocks-2022各cipher要求的base64解码后密钥字节数，
+// 只有shadowsocks-rust实现了这一密码族
+var aead2022KeySizes = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
+// IsAEAD2022 判断method是否属于shadowsocks-2022密码族
+func IsAEAD2022(method string) bool {
+	_, ok := aead2022KeySizes[method]
+	return ok
+}
+
+// DetectVariant 依据method选择配置/二进制变体：AEAD-2022密码族只有
+// shadowsocks-rust支持，其余沿用shadowsocks-libev
+func DetectVariant(method string) Variant {
+	if IsAEAD2022(method) {
+		return VariantRust
+	}
+	return VariantLibev
+}
+
+// ValidateKey 校验AEAD-2022密码的base64密钥长度是否匹配method要求的字节数；
+// 非AEAD-2022密码由ss-libev自身在启动时校验，这里不做处理
+func ValidateKey(method, password string) error {
+	size, ok := aead2022KeySizes[method]
+	if !ok {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(password)
+	if err != nil {
+		return fmt.Errorf("解析%s密钥失败，需为base64编码: %w", method, err)
+	}
+	if len(key) != size {
+		return fmt.Errorf("%s要求密钥长度为%d字节，实际%d字节", method, size, len(key))
+	}
+
+	return nil
+}
+
+// clientBinary 按variant返回客户端（出口侧ss-local）二进制名称
+func clientBinary(v Variant) string {
+	if v == VariantRust {
+		return "sslocal"
+	}
+	return "ss-local"
+}
+
+// serverBinary 按variant返回服务端（多用户server模式）二进制名称，两个发行版
+// 下都叫ss-server
+func serverBinary(v Variant) string {
+	return "ss-server"
+}
+
+// packageName 按variant返回安装器应安装的包名
+func packageName(v Variant) string {
+	if v == VariantRust {
+		return "shadowsocks-rust"
+	}
+	return "shadowsocks-libev"
+}
+
+// buildUsers 将cfg["users"]（[]interface{}，每个元素是含name/password的map）
+// 转换为shadowsocks-rust server多用户配置所需的users数组；cfg中没有users或
+// 格式不符时返回nil，调用方应退回单用户server/password配置。每个用户自己的
+// password才是真正参与AEAD-2022加解密的密钥材料，因此和单用户路径一样，这里
+// 也对每个密钥调用ValidateKey——否则一个长度不对的用户密钥会一路写进ss-server
+// 配置，直到进程启动时才暴露
+func buildUsers(rawUsers interface{}, method string) ([]map[string]interface{}, error) {
+	users, ok := rawUsers.([]interface{})
+	if !ok || len(users) == 0 {
+		return nil, nil
+	}
+
+	result := make([]map[string]interface{}, 0, len(users))
+	for _, u := range users {
+		user, ok := u.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		password := fmt.Sprintf("%v", user["password"])
+		if err := ValidateKey(method, password); err != nil {
+			return nil, fmt.Errorf("用户%v密钥校验失败: %w", user["name"], err)
+		}
+		result = append(result, map[string]interface{}{
+			"name":     user["name"],
+			"password": user["password"],
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
@@ -0,0 +1,48 @@
+package shadowsocks
+
+import "testing"
+
+func TestValidateKey(t *testing.T) {
+	// "2022-blake3-aes-128-gcm"要求16字节密钥
+	validKey := "MTIzNDU2Nzg5MDEyMzQ1Ng==" // base64("1234567890123456")，16字节
+	if err := ValidateKey("2022-blake3-aes-128-gcm", validKey); err != nil {
+		t.Fatalf("ValidateKey对合法密钥返回错误: %v", err)
+	}
+
+	if err := ValidateKey("2022-blake3-aes-128-gcm", "too-short"); err == nil {
+		t.Fatal("ValidateKey应当拒绝长度不符的密钥")
+	}
+
+	if err := ValidateKey("2022-blake3-aes-128-gcm", "not base64!!"); err == nil {
+		t.Fatal("ValidateKey应当拒绝非法base64密钥")
+	}
+
+	// 非AEAD-2022的method不做校验
+	if err := ValidateKey("aes-256-cfb", "anything"); err != nil {
+		t.Fatalf("ValidateKey不应校验非AEAD-2022的method: %v", err)
+	}
+}
+
+func TestBuildUsersValidatesEachKey(t *testing.T) {
+	validKey := "MTIzNDU2Nzg5MDEyMzQ1Ng==" // 16字节
+
+	rawUsers := []interface{}{
+		map[string]interface{}{"name": "alice", "password": validKey},
+		map[string]interface{}{"name": "bob", "password": validKey},
+	}
+	users, err := buildUsers(rawUsers, "2022-blake3-aes-128-gcm")
+	if err != nil {
+		t.Fatalf("buildUsers对合法多用户密钥返回错误: %v", err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("buildUsers返回%d个用户，want 2", len(users))
+	}
+
+	badUsers := []interface{}{
+		map[string]interface{}{"name": "alice", "password": validKey},
+		map[string]interface{}{"name": "bob", "password": "too-short"},
+	}
+	if _, err := buildUsers(badUsers, "2022-blake3-aes-128-gcm"); err == nil {
+		t.Fatal("buildUsers应当拒绝长度不符的用户密钥")
+	}
+}
@@ -1,8 +1,10 @@
 package snell
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,10 +14,20 @@ import (
 	"time"
 
 	"github.com/moooyo/nspass-proto/generated/model"
+	"github.com/nspass/nspass-agent/pkg/config"
 	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/proxy"
+	"github.com/nspass/nspass-agent/pkg/proxy/lifecycle"
 	"github.com/sirupsen/logrus"
 )
 
+// init把snell登记进proxy包的全局后端注册表，对应EnabledTypes里的"snell"
+func init() {
+	proxy.Register(model.EgressMode_EGRESS_MODE_SNELL, func(cfg *model.EgressItem) proxy.ProxyInterface {
+		return New(cfg)
+	}, proxy.WithName("snell"))
+}
+
 const (
 	// DefaultConfigPath 默认代理配置文件路径
 	DefaultConfigPath = "/etc/nspass-agent"
@@ -28,14 +40,22 @@ const (
 // Snell snell代理实现
 type Snell struct {
 	egressItem *model.EgressItem // 出口配置
+	config     config.ProxyConfig
+	proxyID    string
 	configPath string
 	pidFile    string
+
+	listenPort int // snell-server监听端口，供HealthCheck拨测（始终探测127.0.0.1）
+
+	backend lifecycle.Backend // 非nil时代表snell-server由lifecycle后端（supervisor或systemd）持有
 }
 
 // New 创建新的Snell实例
 func New(egressItem *model.EgressItem) *Snell {
 	s := &Snell{
 		egressItem: egressItem,
+		config:     egressItem,
+		proxyID:    egressItem.EgressId,
 		configPath: filepath.Join(DefaultConfigPath, fmt.Sprintf("snell-%s.conf", egressItem.EgressId)),
 		pidFile:    filepath.Join(DefaultConfigPath, fmt.Sprintf("snell-%s.pid", egressItem.EgressId)),
 	}
@@ -86,6 +106,9 @@ func (s *Snell) Configure(cfg *model.EgressItem) error {
 		}
 	}
 
+	// 记录HealthCheck拨测用的监听端口
+	fmt.Sscanf(fmt.Sprintf("%v", egressConfig["port"]), "%d", &s.listenPort)
+
 	// 生成snell配置
 	var configLines []string
 	configLines = append(configLines, "[snell-server]")
@@ -125,7 +148,8 @@ func (s *Snell) Configure(cfg *model.EgressItem) error {
 	return nil
 }
 
-// Start 启动snell
+// Start 启动snell，并交由当前激活的生命周期后端（systemd或进程内supervisor）
+// 持有和监管snell-server
 func (s *Snell) Start() error {
 	startTime := time.Now()
 	log := logger.GetProxyLogger().WithField("proxy_type", "snell")
@@ -142,50 +166,49 @@ func (s *Snell) Start() error {
 
 	log.Debug("启动snell服务")
 
-	// 启动snell-server
 	snellBinaryPath := filepath.Join(DefaultBinPath, "snell-server")
-	cmd := exec.Command(snellBinaryPath, "-c", s.configPath)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	newCmd := func() *exec.Cmd {
+		return exec.Command(snellBinaryPath, "-c", s.configPath)
+	}
 
-	if err := cmd.Start(); err != nil {
+	backend, err := lifecycle.Launch("snell", s.proxyID, s.pidFile, newCmd, s.config)
+	if err != nil {
 		logger.LogError(err, "启动snell失败", logrus.Fields{
 			"config_path": s.configPath,
+			"pid_file":    s.pidFile,
 		})
 		return fmt.Errorf("启动snell失败: %w", err)
 	}
-
-	// 写入PID文件
-	pid := cmd.Process.Pid
-	if err := os.WriteFile(s.pidFile, []byte(strconv.Itoa(pid)), 0644); err != nil {
-		logger.LogError(err, "写入PID文件失败", logrus.Fields{
-			"pid":      pid,
-			"pid_file": s.pidFile,
-		})
-	}
+	s.backend = backend
 
 	duration := time.Since(startTime)
-	logger.LogPerformance("snell_start", duration, logrus.Fields{
-		"pid": pid,
-	})
-
-	// 记录状态变更
-	logger.LogStateChange("snell", "stopped", "running", "正常启动")
-
-	log.WithFields(logrus.Fields{
-		"pid":         pid,
-		"duration_ms": duration.Milliseconds(),
-	}).Info("snell-server服务已启动")
+	logger.LogPerformance("snell_start", duration, nil)
 
+	log.WithField("duration_ms", duration.Milliseconds()).Info("snell-server服务已启动")
 	return nil
 }
 
-// Stop 停止snell
+// Stop 停止snell。由生命周期后端管理时交由其完成优雅停机；否则回退到直接读取
+// PID文件发送信号，仅作为外部启动进程的兜底
 func (s *Snell) Stop() error {
 	startTime := time.Now()
 	log := logger.GetProxyLogger().WithField("proxy_type", "snell")
 
 	log.Debug("停止snell服务")
 
+	if s.backend != nil {
+		if err := s.backend.Stop(10 * time.Second); err != nil {
+			logger.LogError(err, "停止snell失败", nil)
+			return fmt.Errorf("停止snell失败: %w", err)
+		}
+		s.backend = nil
+
+		duration := time.Since(startTime)
+		logger.LogPerformance("snell_stop", duration, nil)
+		log.WithField("duration_ms", duration.Milliseconds()).Info("snell服务已停止")
+		return nil
+	}
+
 	// 读取PID文件
 	pidData, err := os.ReadFile(s.pidFile)
 	if err != nil {
@@ -234,6 +257,46 @@ func (s *Snell) Stop() error {
 	return nil
 }
 
+// GracefulStop实现ProxyInterface：按lifecycle.GracefulTimeout(ctx,
+// s.config.GracefulWait)算出的超时发SIGTERM等待自行退出，超时后由后端升级为
+// SIGKILL，返回前已确认进程真正退出。没有backend（只留了PID文件）时把PID包装
+// 成一个lifecycle.Adopt，复用同一套SIGTERM→SIGKILL升级逻辑，而不是自行重新
+// 实现一遍
+func (s *Snell) GracefulStop(ctx context.Context) error {
+	log := logger.GetProxyLogger().WithField("proxy_type", "snell")
+	timeout := lifecycle.GracefulTimeout(ctx, s.config.GracefulWait)
+
+	if s.backend != nil {
+		if err := s.backend.Stop(timeout); err != nil {
+			return fmt.Errorf("优雅停止snell失败: %w", err)
+		}
+		s.backend = nil
+		log.WithField("timeout", timeout).Debug("snell已优雅停止")
+		return nil
+	}
+
+	pidData, err := os.ReadFile(s.pidFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("读取PID文件失败: %w", err)
+	}
+
+	pid, err := strconv.Atoi(string(pidData))
+	if err != nil {
+		return fmt.Errorf("解析PID失败: %w", err)
+	}
+
+	if err := lifecycle.Adopt(pid).Stop(timeout); err != nil {
+		return fmt.Errorf("优雅停止snell失败: %w", err)
+	}
+	os.Remove(s.pidFile)
+
+	log.WithFields(logrus.Fields{"pid": pid, "timeout": timeout}).Debug("snell已优雅停止")
+	return nil
+}
+
 // Restart 重启snell
 func (s *Snell) Restart() error {
 	if err := s.Stop(); err != nil {
@@ -252,6 +315,10 @@ func (s *Snell) Status() (string, error) {
 		return "not_installed", nil
 	}
 
+	if s.backend != nil {
+		return s.backend.Status()
+	}
+
 	if s.IsRunning() {
 		log.Debug("snell正在运行")
 		return "running", nil
@@ -302,3 +369,51 @@ func (s *Snell) IsRunning() bool {
 	log.WithField("pid", pid).Debug("snell进程运行中")
 	return true
 }
+
+// Adopt 接管一个由此前Agent进程启动、仍然存活的snell-server进程，跳过
+// Start()里的exec.Command，避免Agent重启或升级中断用户流量
+func (s *Snell) Adopt(pid int) error {
+	if !lifecycle.VerifyExecutable(pid, "snell-server") {
+		return fmt.Errorf("接管失败: pid %d不是预期的snell-server", pid)
+	}
+
+	s.backend = lifecycle.Adopt(pid)
+	return nil
+}
+
+// Pid 返回当前持有snell-server的生命周期后端报告的PID
+func (s *Snell) Pid() (int, bool) {
+	if s.backend == nil {
+		return 0, false
+	}
+	return s.backend.Pid()
+}
+
+// ListenAddr实现proxy.PortProbe：暴露snell-server监听地址（探测统一走
+// 127.0.0.1回环，与HealthCheck一致），供Manager.RestartAll在重启前确认旧
+// 进程已经真正释放端口，未配置监听端口时返回false
+func (s *Snell) ListenAddr() (string, bool) {
+	if s.listenPort == 0 {
+		return "", false
+	}
+	return net.JoinHostPort("127.0.0.1", fmt.Sprintf("%d", s.listenPort)), true
+}
+
+// HealthCheck 拨测snell-server监听端口，发现PID存活但端口无响应的情况。
+// snell-server绑定0.0.0.0，探测统一走127.0.0.1回环
+func (s *Snell) HealthCheck(ctx context.Context) error {
+	if s.listenPort == 0 {
+		return fmt.Errorf("healthcheck: 监听端口未配置")
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", fmt.Sprintf("%d", s.listenPort))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("连接snell-server监听端口%s失败: %w", addr, err)
+	}
+	conn.Close()
+
+	return nil
+}
@@ -0,0 +1,183 @@
+// Package supervisor在pkg/utils.Supervised之上为代理进程维护一组对外可见的
+// 生命周期状态（starting/running/backoff/failed/stopped），并根据
+// config.ProxyConfig中的重启策略与健康探测配置对其加以驱动。各代理实现通过
+// New启动子进程后即不再需要自行管理PID文件和exec.Cmd，只需在Stop时调用Stop。
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/utils"
+)
+
+// State 代理进程的监管状态
+type State string
+
+const (
+	StateStarting State = "starting" // 已提交启动，尚未观察到首次成功运行
+	StateRunning  State = "running"  // 子进程正在运行
+	StateBackoff  State = "backoff"  // 子进程已退出，正在等待退避时间后重启
+	StateFailed   State = "failed"   // 窗口期内重启次数已耗尽，已放弃监管
+	StateStopped  State = "stopped"  // 按重启策略不再重启，或被主动停止
+)
+
+// Supervisor 持有一个被监管代理进程的句柄，并维护其对外可见状态
+type Supervisor struct {
+	name string
+	sup  *utils.Supervised
+
+	mu    sync.Mutex
+	state State
+}
+
+// New启动并监管name对应的代理进程。newCmd用于（重新）构造*exec.Cmd，重启策略取自
+// cfg.RestartPolicy（为空时回退到cfg.RestartOnFail），重启窗口固定为一小时并受
+// cfg.Monitor.MaxRestarts限制，退避初始等待取自cfg.Monitor.RestartCooldown。当
+// cfg.HealthProbe配置了探测方式时，探测失败会主动终止子进程以触发重启，即使PID
+// 仍然存活。每次状态变化都会通过logger.LogStateChange记录
+func New(name string, pidFile string, newCmd func() *exec.Cmd, cfg config.ProxyConfig) (*Supervisor, error) {
+	s := &Supervisor{name: name, state: StateStarting}
+
+	opts := utils.DefaultSupervisorOptions()
+	opts.Policy = restartPolicy(cfg)
+	opts.RestartWindow = time.Hour
+	if cfg.Monitor.MaxRestarts > 0 {
+		opts.MaxRestarts = cfg.Monitor.MaxRestarts
+	}
+	if cfg.Monitor.RestartCooldown > 0 {
+		opts.BackoffInitial = time.Duration(cfg.Monitor.RestartCooldown) * time.Second
+	}
+
+	if check := buildHealthCheck(cfg.HealthProbe); check != nil {
+		opts.HealthCheck = check
+		if cfg.HealthProbe.Interval > 0 {
+			opts.HealthCheckInterval = time.Duration(cfg.HealthProbe.Interval) * time.Second
+		}
+	}
+
+	opts.OnSpawn = func() { s.setState(StateRunning, "进程已启动") }
+	opts.OnBackoff = func(delay time.Duration) {
+		s.setState(StateBackoff, fmt.Sprintf("等待%s后重启", delay))
+	}
+	opts.OnStopped = func() { s.setState(StateStopped, "重启策略不允许重启") }
+	opts.OnGiveUp = func() { s.setState(StateFailed, "窗口期内重启次数已耗尽") }
+
+	sup, err := utils.StartSupervised(name, pidFile, newCmd, opts)
+	if err != nil {
+		s.setState(StateFailed, err.Error())
+		return nil, err
+	}
+	s.sup = sup
+
+	return s, nil
+}
+
+// State 返回当前监管状态
+func (s *Supervisor) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Status 以(string, error)形式返回当前监管状态，与proxy.ProxyInterface.Status
+// 的签名保持一致，便于代理实现直接转发
+func (s *Supervisor) Status() (string, error) {
+	return string(s.State()), nil
+}
+
+// IsRunning 实现lifecycle.Backend接口，等价于State()处于starting或running
+func (s *Supervisor) IsRunning() bool {
+	state := s.State()
+	return state == StateRunning || state == StateStarting
+}
+
+// Pid 实现lifecycle.Backend接口，转发到底层被监管进程当前的PID
+func (s *Supervisor) Pid() (int, bool) {
+	return s.sup.Pid()
+}
+
+// Stop 停止受监管的子进程并结束监管
+func (s *Supervisor) Stop(timeout time.Duration) error {
+	err := s.sup.Stop(timeout)
+	s.setState(StateStopped, "主动停止")
+	return err
+}
+
+func (s *Supervisor) setState(state State, reason string) {
+	s.mu.Lock()
+	prev := s.state
+	s.state = state
+	s.mu.Unlock()
+
+	if prev != state {
+		logger.LogStateChange(s.name, string(prev), string(state), reason)
+	}
+}
+
+// restartPolicy 将cfg换算为utils.RestartPolicy：RestartPolicy非空时直接使用，
+// 否则按RestartOnFail换算（true对应on_failure，false对应never），以兼容仅设置
+// 过旧版RestartOnFail字段的配置
+func restartPolicy(cfg config.ProxyConfig) utils.RestartPolicy {
+	switch utils.RestartPolicy(cfg.RestartPolicy) {
+	case utils.RestartAlways, utils.RestartOnFailure, utils.RestartNever:
+		return utils.RestartPolicy(cfg.RestartPolicy)
+	}
+
+	if cfg.RestartOnFail {
+		return utils.RestartOnFailure
+	}
+	return utils.RestartNever
+}
+
+// buildHealthCheck根据探测类型构造健康检查函数，探测类型为空或无法识别时返回nil
+// （表示不启用健康探测）
+func buildHealthCheck(probe config.HealthProbeConfig) func() error {
+	timeout := time.Duration(probe.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	switch probe.Type {
+	case "tcp":
+		return func() error {
+			conn, err := net.DialTimeout("tcp", probe.Target, timeout)
+			if err != nil {
+				return fmt.Errorf("tcp探测%s失败: %w", probe.Target, err)
+			}
+			conn.Close()
+			return nil
+		}
+	case "http":
+		client := &http.Client{Timeout: timeout}
+		return func() error {
+			resp, err := client.Get(probe.Target)
+			if err != nil {
+				return fmt.Errorf("http探测%s失败: %w", probe.Target, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				return fmt.Errorf("http探测%s返回状态码%d", probe.Target, resp.StatusCode)
+			}
+			return nil
+		}
+	case "exec":
+		return func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := exec.CommandContext(ctx, probe.Command, probe.Args...).Run(); err != nil {
+				return fmt.Errorf("exec探测%s失败: %w", probe.Command, err)
+			}
+			return nil
+		}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,261 @@
+// Package systemd通过systemd/dbus驱动代理进程的生命周期，作为pkg/proxy/supervisor
+// 进程内监管方式的替代后端：宿主机存在systemd时，代理进程由systemd持有，重启、
+// 资源限制和日志收集都交由systemd自身完成，而不是本Agent用信号和PID文件模拟。
+package systemd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultUnitDir是发行版约定的管理员自定义unit目录，优先级高于包管理器安装的
+// unit，cfg.Supervisor.UnitDir为空时使用
+const defaultUnitDir = "/etc/systemd/system"
+
+const unitTemplate = `[Unit]
+Description=NSPass {{.ProxyType}} proxy ({{.InstanceID}})
+After=network-online.target
+Wants=network-online.target
+{{- if .StartLimitBurst}}
+StartLimitIntervalSec=3600
+StartLimitBurst={{.StartLimitBurst}}
+{{- end}}
+
+[Service]
+Type=simple
+ExecStart={{.ExecStart}}
+Restart=on-failure
+RestartSec={{.RestartSec}}
+{{- if .MemoryMax}}
+MemoryMax={{.MemoryMax}}
+{{- end}}
+{{- if .CPUQuota}}
+CPUQuota={{.CPUQuota}}
+{{- end}}
+
+[Install]
+WantedBy=multi-user.target
+`
+
+type unitParams struct {
+	ProxyType       string
+	InstanceID      string
+	ExecStart       string
+	RestartSec      int
+	StartLimitBurst int
+	MemoryMax       string
+	CPUQuota        string
+}
+
+// Backend 是lifecycle.Backend的systemd实现：每个代理实例对应一个
+// nspass-<proxyType>-<id>.service unit，避免同类型多实例共用一个unit
+type Backend struct {
+	proxyType   string
+	unitName    string
+	unitDir     string
+	conn        *dbus.Conn
+	stopJournal func()
+}
+
+// Available 判断宿主机是否运行systemd，采用systemd自身约定的探测方式：
+// /run/systemd/system 目录仅在systemd作为PID 1运行时存在
+func Available() bool {
+	info, err := os.Stat("/run/systemd/system")
+	return err == nil && info.IsDir()
+}
+
+// New 为proxyType的id实例生成并安装对应的systemd unit，通过dbus启动它，并开始
+// 将journalctl输出接入agent自身的logger
+func New(proxyType, id string, newCmd func() *exec.Cmd, cfg config.ProxyConfig) (*Backend, error) {
+	unitName := "nspass-" + proxyType + "-" + id + ".service"
+
+	unitDir := cfg.Supervisor.UnitDir
+	if unitDir == "" {
+		unitDir = defaultUnitDir
+	}
+
+	if err := installUnit(unitDir, unitName, proxyType, id, newCmd(), cfg); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("连接systemd dbus失败: %w", err)
+	}
+
+	if err := conn.ReloadContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reload systemd配置失败: %w", err)
+	}
+
+	resultCh := make(chan string, 1)
+	if _, err := conn.StartUnitContext(ctx, unitName, "replace", resultCh); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("启动%s失败: %w", unitName, err)
+	}
+	if result := <-resultCh; result != "done" {
+		conn.Close()
+		return nil, fmt.Errorf("启动%s未完成: %s", unitName, result)
+	}
+
+	b := &Backend{proxyType: proxyType, unitName: unitName, unitDir: unitDir, conn: conn}
+	b.tailJournal()
+
+	logger.GetProxyLogger().WithFields(logrus.Fields{
+		"proxy_type": proxyType,
+		"unit":       unitName,
+	}).Info("已通过systemd启动代理服务")
+
+	return b, nil
+}
+
+// installUnit渲染unit模板并写入unitDir，资源限制字段留空时对应的配置项直接省略，
+// 让systemd按自身默认值处理
+func installUnit(unitDir, unitName, proxyType, id string, cmd *exec.Cmd, cfg config.ProxyConfig) error {
+	params := unitParams{
+		ProxyType:  proxyType,
+		InstanceID: id,
+		ExecStart:  strings.Join(append([]string{cmd.Path}, cmd.Args[1:]...), " "),
+		RestartSec: 5,
+	}
+	if cfg.Monitor.RestartCooldown > 0 {
+		params.RestartSec = cfg.Monitor.RestartCooldown
+	}
+	if cfg.Monitor.MaxRestarts > 0 {
+		params.StartLimitBurst = cfg.Monitor.MaxRestarts
+	}
+	if cfg.Resources.MemoryMaxMB > 0 {
+		params.MemoryMax = fmt.Sprintf("%dM", cfg.Resources.MemoryMaxMB)
+	}
+	if cfg.Resources.CPUQuotaPercent > 0 {
+		params.CPUQuota = fmt.Sprintf("%d%%", cfg.Resources.CPUQuotaPercent)
+	}
+
+	tmpl, err := template.New("unit").Parse(unitTemplate)
+	if err != nil {
+		return fmt.Errorf("解析systemd unit模板失败: %w", err)
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, params); err != nil {
+		return fmt.Errorf("渲染systemd unit失败: %w", err)
+	}
+
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return fmt.Errorf("创建systemd unit目录%s失败: %w", unitDir, err)
+	}
+
+	unitPath := filepath.Join(unitDir, unitName)
+	if err := os.WriteFile(unitPath, []byte(rendered.String()), 0644); err != nil {
+		return fmt.Errorf("写入systemd unit文件%s失败: %w", unitPath, err)
+	}
+
+	return nil
+}
+
+// tailJournal 启动一个journalctl -f后台进程，把该unit自身启动以来的日志行接入
+// agent自身的logger，取代此前依赖stdout重定向到PID文件同目录的方式
+func (b *Backend) tailJournal() {
+	log := logger.GetComponentLogger(b.proxyType)
+
+	cmd := exec.Command("journalctl", "-u", b.unitName, "--since", "now", "-f", "--no-pager")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.WithError(err).Warn("创建journalctl管道失败，跳过日志接入")
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		log.WithError(err).Warn("启动journalctl跟踪失败，跳过日志接入")
+		return
+	}
+
+	b.stopJournal = func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			log.Info(scanner.Text())
+		}
+		cmd.Wait()
+	}()
+}
+
+// Status 查询unit的ActiveState并映射为与supervisor后端一致的状态命名
+func (b *Backend) Status() (string, error) {
+	props, err := b.conn.GetUnitPropertiesContext(context.Background(), b.unitName)
+	if err != nil {
+		return "", fmt.Errorf("查询%s状态失败: %w", b.unitName, err)
+	}
+
+	activeState, _ := props["ActiveState"].(string)
+	switch activeState {
+	case "active":
+		return "running", nil
+	case "activating", "reloading":
+		return "starting", nil
+	case "failed":
+		return "failed", nil
+	default:
+		return "stopped", nil
+	}
+}
+
+// IsRunning 实现lifecycle.Backend接口
+func (b *Backend) IsRunning() bool {
+	state, err := b.Status()
+	return err == nil && state == "running"
+}
+
+// Pid 实现lifecycle.Backend接口，查询unit的MainPID属性。MainPID为0表示
+// unit当前没有关联进程（未运行，或刚启动尚未被systemd观测到）
+func (b *Backend) Pid() (int, bool) {
+	prop, err := b.conn.GetUnitTypePropertyContext(context.Background(), b.unitName, "Service", "MainPID")
+	if err != nil {
+		return 0, false
+	}
+
+	pid, ok := prop.Value.Value().(uint32)
+	if !ok || pid == 0 {
+		return 0, false
+	}
+
+	return int(pid), true
+}
+
+// Stop 通过dbus停止unit并结束journalctl跟踪
+func (b *Backend) Stop(timeout time.Duration) error {
+	resultCh := make(chan string, 1)
+	if _, err := b.conn.StopUnitContext(context.Background(), b.unitName, "replace", resultCh); err != nil {
+		return fmt.Errorf("停止%s失败: %w", b.unitName, err)
+	}
+
+	select {
+	case <-resultCh:
+	case <-time.After(timeout):
+		return fmt.Errorf("停止%s超时", b.unitName)
+	}
+
+	if b.stopJournal != nil {
+		b.stopJournal()
+	}
+	b.conn.Close()
+
+	return nil
+}
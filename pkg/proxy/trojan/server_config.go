@@ -0,0 +1,409 @@
+package trojan
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// run_type取值，对应trojan-gfw支持的四种运行模式
+const (
+	runTypeClient  = "client"
+	runTypeServer  = "server"
+	runTypeForward = "forward"
+	runTypeNAT     = "nat"
+)
+
+const (
+	defaultCipherList      = "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384"
+	defaultCipherTLS13List = "TLS_AES_128_GCM_SHA256:TLS_CHACHA20_POLY1305_SHA256:TLS_AES_256_GCM_SHA384"
+)
+
+// acmeCommand是issueACMECertificate调用的外部ACME客户端命令名，约定其行为和
+// acme.sh兼容。仓库不引入ACME客户端依赖，签发完全委托给operator环境里已安装
+// 的命令行工具
+var acmeCommand = "acme.sh"
+
+// buildClientConfig 生成client模式配置，即过去Configure里硬编码的透明代理行为：
+// 本地默认监听127.0.0.1:1080，可由cfg["local_addr"]/cfg["local_port"]覆盖
+func buildClientConfig(cfg map[string]interface{}) (map[string]interface{}, error) {
+	password, _ := cfg["password"].(string)
+	if password == "" {
+		return nil, fmt.Errorf("client模式缺少password")
+	}
+
+	config := map[string]interface{}{
+		"run_type":    runTypeClient,
+		"local_addr":  "127.0.0.1",
+		"local_port":  1080,
+		"remote_addr": cfg["server"],
+		"remote_port": cfg["port"],
+		"password":    []string{password},
+		"log_level":   1,
+		"ssl": map[string]interface{}{
+			"verify":          true,
+			"verify_hostname": true,
+			"cert":            "",
+			"cipher":          defaultCipherList,
+			"cipher_tls13":    defaultCipherTLS13List,
+			"sni":             cfg["sni"],
+		},
+		"tcp": map[string]interface{}{
+			"no_delay":       true,
+			"keep_alive":     true,
+			"reuse_port":     false,
+			"fast_open":      false,
+			"fast_open_qlen": 20,
+		},
+	}
+
+	if localPort, ok := cfg["local_port"]; ok {
+		config["local_port"] = localPort
+	}
+	if localAddr, ok := cfg["local_addr"]; ok {
+		config["local_addr"] = localAddr
+	}
+
+	return config, nil
+}
+
+// buildServerConfig 生成server（inbound落地）模式配置，对应trojan-gfw服务端
+// schema：监听地址默认0.0.0.0:443，camouflage到remote_addr/remote_port，
+// 证书由ensureServerCertificate就地签发或生成，鉴权支持密码列表或mysql两种方式
+func (t *Trojan) buildServerConfig(cfg map[string]interface{}) (map[string]interface{}, error) {
+	passwords, err := requirePasswords(cfg["password"])
+	if err != nil {
+		return nil, err
+	}
+
+	certPath, keyPath, err := t.ensureServerCertificate(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("准备服务证书失败: %w", err)
+	}
+
+	config := map[string]interface{}{
+		"run_type":    runTypeServer,
+		"local_addr":  stringOr(cfg["local_addr"], "0.0.0.0"),
+		"local_port":  intOr(cfg["local_port"], 443),
+		"remote_addr": stringOr(cfg["remote_addr"], "127.0.0.1"),
+		"remote_port": intOr(cfg["remote_port"], 80),
+		"password":    passwords,
+		"log_level":   intOr(cfg["log_level"], 1),
+		"ssl": map[string]interface{}{
+			"cert":                 certPath,
+			"key":                  keyPath,
+			"key_password":         stringOr(cfg["ssl_key_password"], ""),
+			"cipher":               defaultCipherList,
+			"cipher_tls13":         defaultCipherTLS13List,
+			"prefer_server_cipher": boolOr(cfg["ssl_prefer_server_cipher"], true),
+			"alpn":                 stringSliceOr(cfg["ssl_alpn"], []string{"http/1.1"}),
+			"alpn_port_override":   map[string]interface{}{},
+			"reuse_session":        boolOr(cfg["ssl_reuse_session"], true),
+			"session_ticket":       boolOr(cfg["ssl_session_ticket"], false),
+			"session_timeout":      intOr(cfg["ssl_session_timeout"], 600),
+			"plain_http_response":  stringOr(cfg["ssl_plain_http_response"], ""),
+			"curves":               stringOr(cfg["ssl_curves"], ""),
+			"dhparam":              stringOr(cfg["ssl_dhparam"], ""),
+		},
+		"tcp": map[string]interface{}{
+			"no_delay":       true,
+			"keep_alive":     true,
+			"reuse_port":     false,
+			"fast_open":      false,
+			"fast_open_qlen": 20,
+		},
+		"mysql": buildMySQLAuthConfig(cfg),
+	}
+
+	if plugin, ok := cfg["transport_plugin"]; ok {
+		config["transport_plugin"] = plugin
+	}
+
+	return config, nil
+}
+
+// buildForwardConfig 生成forward/nat模式配置。两者都是trojan-gfw的落地转发
+// 模式，区别只在于目的地址的来源（forward固定target_addr/target_port，nat由
+// iptables REDIRECT在运行时提供真实目的地址），因此复用同一份schema
+func (t *Trojan) buildForwardConfig(runType string, cfg map[string]interface{}) (map[string]interface{}, error) {
+	targetAddr, _ := cfg["target_addr"].(string)
+	if targetAddr == "" {
+		return nil, fmt.Errorf("%s模式缺少target_addr", runType)
+	}
+	targetPort, err := requireInt(cfg["target_port"])
+	if err != nil {
+		return nil, fmt.Errorf("%s模式target_port无效: %w", runType, err)
+	}
+
+	passwords, err := requirePasswords(cfg["password"])
+	if err != nil {
+		return nil, err
+	}
+
+	certPath, keyPath, err := t.ensureServerCertificate(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("准备服务证书失败: %w", err)
+	}
+
+	return map[string]interface{}{
+		"run_type":    runType,
+		"local_addr":  stringOr(cfg["local_addr"], "0.0.0.0"),
+		"local_port":  intOr(cfg["local_port"], 443),
+		"remote_addr": stringOr(cfg["remote_addr"], "127.0.0.1"),
+		"remote_port": intOr(cfg["remote_port"], 80),
+		"target_addr": targetAddr,
+		"target_port": targetPort,
+		"password":    passwords,
+		"log_level":   intOr(cfg["log_level"], 1),
+		"ssl": map[string]interface{}{
+			"cert":         certPath,
+			"key":          keyPath,
+			"cipher":       defaultCipherList,
+			"cipher_tls13": defaultCipherTLS13List,
+		},
+	}, nil
+}
+
+// buildMySQLAuthConfig生成mysql鉴权配置块。cfg未声明mysql_enabled时enabled为
+// false，trojan-gfw服务端会忽略其余字段并回退到password鉴权
+func buildMySQLAuthConfig(cfg map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":     boolOr(cfg["mysql_enabled"], false),
+		"server_addr": stringOr(cfg["mysql_server_addr"], "127.0.0.1"),
+		"server_port": intOr(cfg["mysql_server_port"], 3306),
+		"database":    stringOr(cfg["mysql_database"], "trojan"),
+		"username":    stringOr(cfg["mysql_username"], "trojan"),
+		"password":    stringOr(cfg["mysql_password"], ""),
+		"key":         stringOr(cfg["mysql_key"], ""),
+		"cafile":      stringOr(cfg["mysql_cafile"], ""),
+	}
+}
+
+// ensureServerCertificate决定server/forward/nat模式使用的证书路径：cfg显式
+// 指定ssl_cert/ssl_key时直接复用；声明了acme_domain时尝试用外部acme客户端签发，
+// 签发失败则回退自签名证书；已经生成过的自签名证书会被直接复用而不是每次重新
+// 生成，保证operator不配置真实证书也能把落地服务端跑起来
+func (t *Trojan) ensureServerCertificate(cfg map[string]interface{}) (string, string, error) {
+	if cert, ok := cfg["ssl_cert"].(string); ok && cert != "" {
+		key, _ := cfg["ssl_key"].(string)
+		if key == "" {
+			return "", "", fmt.Errorf("指定了ssl_cert但缺少ssl_key")
+		}
+		return cert, key, nil
+	}
+
+	certDir := filepath.Join(filepath.Dir(t.configPath), "tls")
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		return "", "", fmt.Errorf("创建证书目录失败: %w", err)
+	}
+	certPath := filepath.Join(certDir, "server.crt")
+	keyPath := filepath.Join(certDir, "server.key")
+
+	domain := stringOr(cfg["acme_domain"], stringOr(cfg["sni"], "localhost"))
+
+	if acmeDomain, ok := cfg["acme_domain"].(string); ok && acmeDomain != "" {
+		if err := issueACMECertificate(acmeDomain, certPath, keyPath); err == nil {
+			return certPath, keyPath, nil
+		}
+		logger.GetProxyLogger().WithField("proxy_type", "trojan").
+			WithField("domain", acmeDomain).Warn("ACME证书签发失败，回退为自签名证书")
+	}
+
+	if _, err := os.Stat(certPath); err == nil {
+		if _, err := os.Stat(keyPath); err == nil {
+			return certPath, keyPath, nil
+		}
+	}
+
+	if err := generateSelfSignedCert(domain, certPath, keyPath); err != nil {
+		return "", "", fmt.Errorf("生成自签名证书失败: %w", err)
+	}
+	return certPath, keyPath, nil
+}
+
+// issueACMECertificate通过外部acme客户端（默认acme.sh）以standalone方式签发
+// 证书并安装到certPath/keyPath。仓库不引入任何ACME客户端依赖，命令不存在或
+// 签发失败都直接返回错误，由调用方回退到自签名证书
+func issueACMECertificate(domain, certPath, keyPath string) error {
+	if _, err := exec.LookPath(acmeCommand); err != nil {
+		return fmt.Errorf("未找到acme客户端%q: %w", acmeCommand, err)
+	}
+
+	issue := exec.Command(acmeCommand, "--issue", "--domain", domain, "--standalone")
+	if output, err := issue.CombinedOutput(); err != nil {
+		return fmt.Errorf("acme签发失败: %w, output: %s", err, output)
+	}
+
+	install := exec.Command(acmeCommand, "--install-cert", "--domain", domain,
+		"--cert-file", certPath, "--key-file", keyPath)
+	if output, err := install.CombinedOutput(); err != nil {
+		return fmt.Errorf("acme安装证书失败: %w, output: %s", err, output)
+	}
+
+	return nil
+}
+
+// generateSelfSignedCert生成一份有效期一年的自签名证书，仅用于operator没有
+// 配置真实证书或ACME签发失败时让trojan服务端先跑起来——客户端连接这类证书需要
+// 关闭证书校验或显式信任该证书
+func generateSelfSignedCert(commonName, certPath, keyPath string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("生成RSA密钥失败: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("生成证书序列号失败: %w", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"nspass"}},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("生成证书失败: %w", err)
+	}
+
+	certOut, err := os.OpenFile(certPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("创建证书文件失败: %w", err)
+	}
+	defer certOut.Close()
+	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		return fmt.Errorf("写入证书文件失败: %w", err)
+	}
+
+	keyOut, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("创建私钥文件失败: %w", err)
+	}
+	defer keyOut.Close()
+	if err := pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}); err != nil {
+		return fmt.Errorf("写入私钥文件失败: %w", err)
+	}
+
+	return nil
+}
+
+// stringOr返回v的字符串值，v不是非空字符串时返回def
+func stringOr(v interface{}, def string) string {
+	if s, ok := v.(string); ok && s != "" {
+		return s
+	}
+	return def
+}
+
+// boolOr返回v的布尔值，v不是bool时返回def
+func boolOr(v interface{}, def bool) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return def
+}
+
+// intOr返回v的整数值，v无法解析为整数时返回def
+func intOr(v interface{}, def int) int {
+	if n, ok := toInt(v); ok {
+		return n
+	}
+	return def
+}
+
+// stringSliceOr返回v的字符串列表，v是[]string/[]interface{}（JSON解码后的常见
+// 形态）之外的类型或为空时返回def
+func stringSliceOr(v interface{}, def []string) []string {
+	switch val := v.(type) {
+	case []string:
+		if len(val) > 0 {
+			return val
+		}
+	case []interface{}:
+		result := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		if len(result) > 0 {
+			return result
+		}
+	}
+	return def
+}
+
+// toInt尝试把cfg里常见的数值类型（JSON解码后的float64、原生int、字符串）转换
+// 成int
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	case string:
+		parsed, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, false
+		}
+		return parsed, true
+	default:
+		return 0, false
+	}
+}
+
+// requireInt是toInt的必填版本，转换失败时返回描述性错误
+func requireInt(v interface{}) (int, error) {
+	n, ok := toInt(v)
+	if !ok {
+		return 0, fmt.Errorf("字段缺失或不是合法的数字: %v", v)
+	}
+	return n, nil
+}
+
+// requirePasswords把cfg["password"]统一成trojan-gfw server端需要的密码列表，
+// 兼容单个字符串密码和字符串数组两种写法，两者都为空时返回错误
+func requirePasswords(v interface{}) ([]string, error) {
+	switch val := v.(type) {
+	case string:
+		if val != "" {
+			return []string{val}, nil
+		}
+	case []string:
+		if len(val) > 0 {
+			return val, nil
+		}
+	case []interface{}:
+		passwords := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok && s != "" {
+				passwords = append(passwords, s)
+			}
+		}
+		if len(passwords) > 0 {
+			return passwords, nil
+		}
+	}
+	return nil, fmt.Errorf("server模式缺少password")
+}
@@ -1,8 +1,10 @@
 package trojan
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,20 +15,36 @@ import (
 	"github.com/moooyo/nspass-proto/generated/model"
 	"github.com/nspass/nspass-agent/pkg/config"
 	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/proxy"
+	"github.com/nspass/nspass-agent/pkg/proxy/lifecycle"
 	"github.com/sirupsen/logrus"
 )
 
+// init把trojan登记进proxy包的全局后端注册表，对应EnabledTypes里的"trojan"
+func init() {
+	proxy.Register(model.EgressMode_EGRESS_MODE_TROJAN, func(cfg *model.EgressItem) proxy.ProxyInterface {
+		return New(cfg)
+	}, proxy.WithName("trojan"))
+}
+
 // Trojan trojan代理实现
 type Trojan struct {
 	config     config.ProxyConfig
+	proxyID    string
 	configPath string
 	pidFile    string
+
+	localAddr string // trojan客户端本地监听地址，供HealthCheck拨测
+	localPort int
+
+	backend lifecycle.Backend // 非nil时代表trojan进程由lifecycle后端（supervisor或systemd）持有
 }
 
 // New 创建新的Trojan实例
 func New(cfg *model.EgressItem) *Trojan {
 	t := &Trojan{
 		config:     cfg,
+		proxyID:    cfg.EgressId,
 		configPath: filepath.Join(cfg.ConfigPath, "trojan.json"),
 		pidFile:    filepath.Join(cfg.ConfigPath, "trojan.pid"),
 	}
@@ -146,40 +164,34 @@ func (t *Trojan) Configure(cfg *model.EgressItem) error {
 		}
 	}
 
-	// 生成trojan配置
-	config := map[string]interface{}{
-		"run_type":    "client",
-		"local_addr":  "127.0.0.1",
-		"local_port":  1080,
-		"remote_addr": cfg["server"],
-		"remote_port": cfg["port"],
-		"password":    []string{cfg["password"].(string)},
-		"log_level":   1,
-		"ssl": map[string]interface{}{
-			"verify":          true,
-			"verify_hostname": true,
-			"cert":            "",
-			"cipher":          "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384",
-			"cipher_tls13":    "TLS_AES_128_GCM_SHA256:TLS_CHACHA20_POLY1305_SHA256:TLS_AES_256_GCM_SHA384",
-			"sni":             cfg["sni"],
-		},
-		"tcp": map[string]interface{}{
-			"no_delay":       true,
-			"keep_alive":     true,
-			"reuse_port":     false,
-			"fast_open":      false,
-			"fast_open_qlen": 20,
-		},
-	}
-
-	// 如果有自定义本地端口
-	if localPort, ok := cfg["local_port"]; ok {
-		config["local_port"] = localPort
-	}
-
-	if localAddr, ok := cfg["local_addr"]; ok {
-		config["local_addr"] = localAddr
+	// 按run_type生成对应模式的trojan配置，未指定时沿用过去只支持client的行为
+	runType, _ := cfg["run_type"].(string)
+	if runType == "" {
+		runType = runTypeClient
+	}
+
+	var config map[string]interface{}
+	var err error
+	switch runType {
+	case runTypeClient:
+		config, err = buildClientConfig(cfg)
+	case runTypeServer:
+		config, err = t.buildServerConfig(cfg)
+	case runTypeForward, runTypeNAT:
+		config, err = t.buildForwardConfig(runType, cfg)
+	default:
+		err = fmt.Errorf("不支持的run_type: %s", runType)
 	}
+	if err != nil {
+		logger.LogError(err, "生成trojan配置失败", logrus.Fields{
+			"run_type": runType,
+		})
+		return fmt.Errorf("生成trojan配置失败: %w", err)
+	}
+
+	// 记录HealthCheck拨测用的本地地址
+	t.localAddr = fmt.Sprintf("%v", config["local_addr"])
+	fmt.Sscanf(fmt.Sprintf("%v", config["local_port"]), "%d", &t.localPort)
 
 	// 写入配置文件
 	data, err := json.MarshalIndent(config, "", "  ")
@@ -210,7 +222,8 @@ func (t *Trojan) Configure(cfg *model.EgressItem) error {
 	return nil
 }
 
-// Start 启动trojan
+// Start 启动trojan，并交由当前激活的生命周期后端（systemd或进程内supervisor）
+// 持有和监管进程
 func (t *Trojan) Start() error {
 	startTime := time.Now()
 	log := logger.GetProxyLogger().WithField("proxy_type", "trojan")
@@ -227,49 +240,48 @@ func (t *Trojan) Start() error {
 
 	log.Debug("启动trojan服务")
 
-	// 启动trojan
-	cmd := exec.Command("trojan", "-c", t.configPath)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	newCmd := func() *exec.Cmd {
+		return exec.Command("trojan", "-c", t.configPath)
+	}
 
-	if err := cmd.Start(); err != nil {
+	backend, err := lifecycle.Launch("trojan", t.proxyID, t.pidFile, newCmd, t.config)
+	if err != nil {
 		logger.LogError(err, "启动trojan失败", logrus.Fields{
 			"config_path": t.configPath,
+			"pid_file":    t.pidFile,
 		})
 		return fmt.Errorf("启动trojan失败: %w", err)
 	}
-
-	// 写入PID文件
-	pid := cmd.Process.Pid
-	if err := os.WriteFile(t.pidFile, []byte(strconv.Itoa(pid)), 0644); err != nil {
-		logger.LogError(err, "写入PID文件失败", logrus.Fields{
-			"pid":      pid,
-			"pid_file": t.pidFile,
-		})
-	}
+	t.backend = backend
 
 	duration := time.Since(startTime)
-	logger.LogPerformance("trojan_start", duration, logrus.Fields{
-		"pid": pid,
-	})
-
-	// 记录状态变更
-	logger.LogStateChange("trojan", "stopped", "running", "正常启动")
-
-	log.WithFields(logrus.Fields{
-		"pid":         pid,
-		"duration_ms": duration.Milliseconds(),
-	}).Info("trojan服务已启动")
+	logger.LogPerformance("trojan_start", duration, nil)
 
+	log.WithField("duration_ms", duration.Milliseconds()).Info("trojan服务已启动")
 	return nil
 }
 
-// Stop 停止trojan
+// Stop 停止trojan。由生命周期后端管理时交由其完成优雅停机；否则回退到直接读取
+// PID文件发送信号，仅作为外部启动进程的兜底
 func (t *Trojan) Stop() error {
 	startTime := time.Now()
 	log := logger.GetProxyLogger().WithField("proxy_type", "trojan")
 
 	log.Debug("停止trojan服务")
 
+	if t.backend != nil {
+		if err := t.backend.Stop(10 * time.Second); err != nil {
+			logger.LogError(err, "停止trojan失败", nil)
+			return fmt.Errorf("停止trojan失败: %w", err)
+		}
+		t.backend = nil
+
+		duration := time.Since(startTime)
+		logger.LogPerformance("trojan_stop", duration, nil)
+		log.WithField("duration_ms", duration.Milliseconds()).Info("trojan服务已停止")
+		return nil
+	}
+
 	// 读取PID文件
 	pidData, err := os.ReadFile(t.pidFile)
 	if err != nil {
@@ -318,6 +330,46 @@ func (t *Trojan) Stop() error {
 	return nil
 }
 
+// GracefulStop实现ProxyInterface：按lifecycle.GracefulTimeout(ctx,
+// t.config.GracefulWait)算出的超时发SIGTERM等待自行退出，超时后由后端升级为
+// SIGKILL，返回前已确认进程真正退出。没有backend（只留了PID文件）时把PID包装
+// 成一个lifecycle.Adopt，复用同一套SIGTERM→SIGKILL升级逻辑，而不是自行重新
+// 实现一遍
+func (t *Trojan) GracefulStop(ctx context.Context) error {
+	log := logger.GetProxyLogger().WithField("proxy_type", "trojan")
+	timeout := lifecycle.GracefulTimeout(ctx, t.config.GracefulWait)
+
+	if t.backend != nil {
+		if err := t.backend.Stop(timeout); err != nil {
+			return fmt.Errorf("优雅停止trojan失败: %w", err)
+		}
+		t.backend = nil
+		log.WithField("timeout", timeout).Debug("trojan已优雅停止")
+		return nil
+	}
+
+	pidData, err := os.ReadFile(t.pidFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("读取PID文件失败: %w", err)
+	}
+
+	pid, err := strconv.Atoi(string(pidData))
+	if err != nil {
+		return fmt.Errorf("解析PID失败: %w", err)
+	}
+
+	if err := lifecycle.Adopt(pid).Stop(timeout); err != nil {
+		return fmt.Errorf("优雅停止trojan失败: %w", err)
+	}
+	os.Remove(t.pidFile)
+
+	log.WithFields(logrus.Fields{"pid": pid, "timeout": timeout}).Debug("trojan已优雅停止")
+	return nil
+}
+
 // Restart 重启trojan
 func (t *Trojan) Restart() error {
 	if err := t.Stop(); err != nil {
@@ -336,6 +388,10 @@ func (t *Trojan) Status() (string, error) {
 		return "not_installed", nil
 	}
 
+	if t.backend != nil {
+		return t.backend.Status()
+	}
+
 	if t.IsRunning() {
 		log.Debug("trojan正在运行")
 		return "running", nil
@@ -358,10 +414,15 @@ func (t *Trojan) IsInstalled() bool {
 	return installed
 }
 
-// IsRunning 检查是否正在运行
+// IsRunning 检查是否正在运行。由生命周期后端管理时直接反映其状态；否则回退到
+// PID文件探活，仅作为外部启动进程（未经由本Agent管理）的只读兜底
 func (t *Trojan) IsRunning() bool {
 	log := logger.GetProxyLogger().WithField("proxy_type", "trojan")
 
+	if t.backend != nil {
+		return t.backend.IsRunning()
+	}
+
 	// 检查PID文件
 	pidData, err := os.ReadFile(t.pidFile)
 	if err != nil {
@@ -384,3 +445,49 @@ func (t *Trojan) IsRunning() bool {
 	log.WithField("pid", pid).Debug("trojan进程运行中")
 	return true
 }
+
+// Adopt 接管一个由此前Agent进程启动、仍然存活的trojan进程，跳过Start()里的
+// exec.Command，避免Agent重启或升级中断用户流量
+func (t *Trojan) Adopt(pid int) error {
+	if !lifecycle.VerifyExecutable(pid, "trojan") {
+		return fmt.Errorf("接管失败: pid %d不是预期的trojan", pid)
+	}
+
+	t.backend = lifecycle.Adopt(pid)
+	return nil
+}
+
+// Pid 返回当前持有trojan的生命周期后端报告的PID
+func (t *Trojan) Pid() (int, bool) {
+	if t.backend == nil {
+		return 0, false
+	}
+	return t.backend.Pid()
+}
+
+// ListenAddr实现proxy.PortProbe：暴露trojan本地监听地址，供Manager.RestartAll
+// 在重启前确认旧进程已经真正释放端口，未配置本地端口时返回false
+func (t *Trojan) ListenAddr() (string, bool) {
+	if t.localPort == 0 {
+		return "", false
+	}
+	return net.JoinHostPort(t.localAddr, fmt.Sprintf("%d", t.localPort)), true
+}
+
+// HealthCheck 拨测trojan本地监听端口，发现PID存活但端口无响应的情况
+func (t *Trojan) HealthCheck(ctx context.Context) error {
+	if t.localPort == 0 {
+		return fmt.Errorf("healthcheck: 本地端口未配置")
+	}
+
+	addr := net.JoinHostPort(t.localAddr, fmt.Sprintf("%d", t.localPort))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("连接trojan本地端口%s失败: %w", addr, err)
+	}
+	conn.Close()
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// proxyWorkQueue是一个按代理ID去重的FIFO工作队列，供ProxyMonitor的事件驱动
+// reconciler使用：RegisterProxy/EnableProxy等事件源和进程退出
+// 检测都只是向队列Add一个ID，真正的检查/重启动作由独立的worker取出后串行执行，
+// 取代了原先每个CheckInterval对所有代理并发扫描一遍的做法。
+//
+// 同一个ID在还排在队列里尚未被worker取出时重复Add不会产生重复项；在worker正在
+// 处理该ID时被Add会记一条dirty标记，处理完成（Done）后立即重新入队，保证处理
+// 期间发生的事件不会被丢弃
+type proxyWorkQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	queue      []string
+	queued     map[string]bool // 当前排在queue里、尚未被取出的ID
+	processing map[string]bool // 当前正在被某个worker处理的ID
+	dirty      map[string]bool // 处理期间又被Add过，Done后需要立即重新入队的ID
+	closed     bool
+}
+
+// newProxyWorkQueue 创建一个空的工作队列
+func newProxyWorkQueue() *proxyWorkQueue {
+	wq := &proxyWorkQueue{
+		queued:     make(map[string]bool),
+		processing: make(map[string]bool),
+		dirty:      make(map[string]bool),
+	}
+	wq.cond = sync.NewCond(&wq.mu)
+	return wq
+}
+
+// Add把id加入队列，已经在排队或正在被处理则只做去重标记
+func (wq *proxyWorkQueue) Add(id string) {
+	wq.mu.Lock()
+	defer wq.mu.Unlock()
+
+	if wq.closed {
+		return
+	}
+	if wq.processing[id] {
+		wq.dirty[id] = true
+		return
+	}
+	if wq.queued[id] {
+		return
+	}
+
+	wq.queued[id] = true
+	wq.queue = append(wq.queue, id)
+	wq.cond.Signal()
+}
+
+// AddAfter在delay之后把id加入队列，用于按健康检查间隔或重启退避时间安排下一次
+// 重新入队。delay<=0时等价于立即Add
+func (wq *proxyWorkQueue) AddAfter(id string, delay time.Duration) {
+	if delay <= 0 {
+		wq.Add(id)
+		return
+	}
+	time.AfterFunc(delay, func() { wq.Add(id) })
+}
+
+// Get阻塞直到拿到一个待处理的id，ok为false表示队列已Shutdown且已排空。取出后
+// 该id进入processing状态，调用方处理完毕后必须调用Done
+func (wq *proxyWorkQueue) Get() (id string, ok bool) {
+	wq.mu.Lock()
+	defer wq.mu.Unlock()
+
+	for len(wq.queue) == 0 && !wq.closed {
+		wq.cond.Wait()
+	}
+	if len(wq.queue) == 0 {
+		return "", false
+	}
+
+	id = wq.queue[0]
+	wq.queue = wq.queue[1:]
+	delete(wq.queued, id)
+	wq.processing[id] = true
+
+	return id, true
+}
+
+// Done标记id已处理完成。如果处理期间又被Add过（dirty），立即重新入队
+func (wq *proxyWorkQueue) Done(id string) {
+	wq.mu.Lock()
+	defer wq.mu.Unlock()
+
+	delete(wq.processing, id)
+	if wq.dirty[id] {
+		delete(wq.dirty, id)
+		wq.queued[id] = true
+		wq.queue = append(wq.queue, id)
+		wq.cond.Signal()
+	}
+}
+
+// Shutdown关闭队列并唤醒所有阻塞在Get上的worker；队列中已有的项仍会被取出处理完，
+// 之后的Get调用立即返回ok=false
+func (wq *proxyWorkQueue) Shutdown() {
+	wq.mu.Lock()
+	defer wq.mu.Unlock()
+	wq.closed = true
+	wq.cond.Broadcast()
+}
@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// consulRegistry 使用Consul KV + session实现与etcdRegistry等价的TTL租约语义：
+// 创建一个带TTL的session，把AgentInfo以该session持有的方式写入KV，并周期性
+// 调用session.renew保活
+type consulRegistry struct {
+	client    *consulapi.Client
+	namespace string
+	ttl       int
+	sessionID string
+	serverID  string
+}
+
+func newConsulRegistry(cfg config.RegistryConfig) (*consulRegistry, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("consul注册中心至少需要一个endpoint")
+	}
+
+	client, err := consulapi.NewClient(&consulapi.Config{Address: cfg.Endpoints[0]})
+	if err != nil {
+		return nil, fmt.Errorf("创建consul客户端失败: %w", err)
+	}
+
+	return &consulRegistry{client: client, namespace: cfg.Namespace, ttl: cfg.TTL}, nil
+}
+
+// Start 创建TTL session，把AgentInfo写入KV并绑定该session，随后启动周期性
+// 续约，续约失败达到session.ttl前都会被consul当作仍然存活
+func (r *consulRegistry) Start(ctx context.Context, info AgentInfo) error {
+	log := logger.GetComponentLogger("registry-consul")
+
+	session := r.client.Session()
+	sessionID, _, err := session.Create(&consulapi.SessionEntry{
+		TTL:      fmt.Sprintf("%ds", r.ttl),
+		Behavior: consulapi.SessionBehaviorDelete,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("创建consul session失败: %w", err)
+	}
+	r.sessionID = sessionID
+	r.serverID = info.ServerID
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("序列化AgentInfo失败: %w", err)
+	}
+
+	key := agentKey(r.namespace, info.ServerID)
+	kv := r.client.KV()
+	acquired, _, err := kv.Acquire(&consulapi.KVPair{Key: key, Value: data, Session: sessionID}, nil)
+	if err != nil {
+		return fmt.Errorf("写入consul自注册记录失败: %w", err)
+	}
+	if !acquired {
+		return fmt.Errorf("获取consul session锁失败，key %s可能已被其他实例持有", key)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		session.RenewPeriodic(fmt.Sprintf("%ds", r.ttl), sessionID, nil, doneCh)
+	}()
+	go func() {
+		<-ctx.Done()
+		close(doneCh)
+	}()
+
+	log.WithFields(map[string]interface{}{"key": key, "ttl": r.ttl}).Info("已在consul完成Agent自注册")
+	return nil
+}
+
+// WatchConfig 用阻塞查询（blocking query）轮询configKey的ModifyIndex，一旦
+// 变化即把新值交给onUpdate，由调用方按自己的schema解码
+func (r *consulRegistry) WatchConfig(ctx context.Context, onUpdate func(data []byte)) error {
+	key := configKey(r.namespace, r.serverID)
+	kv := r.client.KV()
+
+	var lastIndex uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		pair, meta, err := kv.Get(key, &consulapi.QueryOptions{
+			WaitIndex: lastIndex,
+			WaitTime:  30 * time.Second,
+		})
+		if err != nil {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if meta.LastIndex > lastIndex && pair != nil && lastIndex != 0 {
+			onUpdate(pair.Value)
+		}
+		lastIndex = meta.LastIndex
+	}
+}
+
+// Campaign用consul session + KV CAS锁实现与etcdRegistry.Campaign等价的
+// leader选举语义：反复尝试用一个新session去Acquire选举key，成功即视为当选，
+// 当选期间周期性检查session是否还持有该锁，一旦丢失就cancel leaderCtx
+func (r *consulRegistry) Campaign(ctx context.Context, onElected func(leaderCtx context.Context)) error {
+	log := logger.GetComponentLogger("registry-consul")
+	key := electionKey(r.namespace, r.serverID)
+
+	session := r.client.Session()
+	kv := r.client.KV()
+
+	var electionSessionID string
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		sid, _, err := session.Create(&consulapi.SessionEntry{
+			TTL:      fmt.Sprintf("%ds", r.ttl),
+			Behavior: consulapi.SessionBehaviorDelete,
+		}, nil)
+		if err != nil {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		acquired, _, err := kv.Acquire(&consulapi.KVPair{Key: key, Value: []byte(r.serverID), Session: sid}, nil)
+		if err != nil || !acquired {
+			session.Destroy(sid, nil)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		electionSessionID = sid
+		break
+	}
+
+	log.WithField("server_id", r.serverID).Info("已当选为leader")
+
+	doneCh := make(chan struct{})
+	go session.RenewPeriodic(fmt.Sprintf("%ds", r.ttl), electionSessionID, nil, doneCh)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-leaderCtx.Done():
+				return
+			case <-ticker.C:
+				pair, _, err := kv.Get(key, nil)
+				if err != nil || pair == nil || pair.Session != electionSessionID {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	onElected(leaderCtx)
+
+	close(doneCh)
+	cancel()
+	kv.Release(&consulapi.KVPair{Key: key, Session: electionSessionID}, nil)
+	session.Destroy(electionSessionID, nil)
+
+	return ctx.Err()
+}
+
+func (r *consulRegistry) Close() error {
+	if r.sessionID != "" {
+		r.client.Session().Destroy(r.sessionID, nil)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// etcdRegistry 用etcd v3的租约机制实现自注册。申请租约、写入记录或启动
+// KeepAlive失败（例如etcd不可达）时Start返回error，由调用方决定是否退回HTTP
+// 上报；Start返回之后的续约失败只记一条Warn日志，不会通知调用方
+type etcdRegistry struct {
+	client    *clientv3.Client
+	namespace string
+	ttl       int
+	leaseID   clientv3.LeaseID
+	serverID  string
+}
+
+func newEtcdRegistry(cfg config.RegistryConfig) (*etcdRegistry, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("etcd注册中心至少需要一个endpoint")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建etcd客户端失败: %w", err)
+	}
+
+	return &etcdRegistry{client: client, namespace: cfg.Namespace, ttl: cfg.TTL}, nil
+}
+
+// Start 创建一个TTL租约，写入AgentInfo，并启动KeepAlive续约直到ctx取消
+func (r *etcdRegistry) Start(ctx context.Context, info AgentInfo) error {
+	log := logger.GetComponentLogger("registry-etcd")
+
+	lease, err := r.client.Grant(ctx, int64(r.ttl))
+	if err != nil {
+		return fmt.Errorf("申请etcd租约失败: %w", err)
+	}
+	r.leaseID = lease.ID
+	r.serverID = info.ServerID
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("序列化AgentInfo失败: %w", err)
+	}
+
+	key := agentKey(r.namespace, info.ServerID)
+	if _, err := r.client.Put(ctx, key, string(data), clientv3.WithLease(lease.ID)); err != nil {
+		return fmt.Errorf("写入etcd自注册记录失败: %w", err)
+	}
+
+	keepAlive, err := r.client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return fmt.Errorf("启动etcd租约续约失败: %w", err)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case resp, ok := <-keepAlive:
+				if !ok {
+					log.WithField("server_id", info.ServerID).Warn("etcd租约续约通道已关闭")
+					return
+				}
+				if resp == nil {
+					log.WithField("server_id", info.ServerID).Warn("etcd租约续约响应为空，租约可能已过期")
+					return
+				}
+			}
+		}
+	}()
+
+	log.WithFields(map[string]interface{}{
+		"key": key,
+		"ttl": r.ttl,
+	}).Info("已在etcd完成Agent自注册")
+
+	return nil
+}
+
+// WatchConfig 监听configKey的变更，每次变更（PUT）都把新值的原始字节交给
+// onUpdate，由调用方按自己的schema解码，避免registry包绑定具体配置格式
+func (r *etcdRegistry) WatchConfig(ctx context.Context, onUpdate func(data []byte)) error {
+	key := configKey(r.namespace, r.serverID)
+	watchChan := r.client.Watch(ctx, key)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case resp, ok := <-watchChan:
+			if !ok {
+				return fmt.Errorf("etcd配置watch通道已关闭")
+			}
+			for _, event := range resp.Events {
+				if event.Type == clientv3.EventTypePut {
+					onUpdate(event.Kv.Value)
+				}
+			}
+		}
+	}
+}
+
+// Campaign基于etcd的concurrency.Election实现leader选举：先建一个绑定了TTL
+// 租约的Session，用该Session竞选electionKey，Campaign调用阻塞到当选为止；
+// 当选后用Session.Done()派生出leaderCtx——一旦续约失败/Session关闭，
+// leaderCtx会被cancel，onElected里的WatchConfig循环据此退出，外层由
+// Campaign的调用方决定是否重新参选
+func (r *etcdRegistry) Campaign(ctx context.Context, onElected func(leaderCtx context.Context)) error {
+	session, err := concurrency.NewSession(r.client, concurrency.WithTTL(r.ttl))
+	if err != nil {
+		return fmt.Errorf("创建etcd选举session失败: %w", err)
+	}
+	defer session.Close()
+
+	election := concurrency.NewElection(session, electionKey(r.namespace, r.serverID))
+	if err := election.Campaign(ctx, r.serverID); err != nil {
+		return fmt.Errorf("参与etcd leader选举失败: %w", err)
+	}
+
+	log := logger.GetComponentLogger("registry-etcd")
+	log.WithField("server_id", r.serverID).Info("已当选为leader")
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	onElected(leaderCtx)
+
+	if resignErr := election.Resign(context.Background()); resignErr != nil {
+		log.WithError(resignErr).Warn("释放etcd leader选举失败")
+	}
+
+	return ctx.Err()
+}
+
+func (r *etcdRegistry) Close() error {
+	if r.leaseID != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		r.client.Revoke(ctx, r.leaseID)
+	}
+	return r.client.Close()
+}
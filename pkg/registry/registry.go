@@ -0,0 +1,92 @@
+// Package registry把Agent的自注册、租约续期和配置下发监听从HTTP轮询中剥离
+// 出来：Registry在宿主机上可插拔地使用etcd或Consul作为后端，在
+// <namespace>/agents/<server_id>下维护一条带TTL的活性记录，并监听
+// <namespace>/config/<server_id>，一旦服务端推送了新配置就立即触发回调，不必
+// 等待UpdateInterval轮询。Backend为空或"none"时返回noopRegistry，调用方应改
+// 为走既有的HTTP上报路径。
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+)
+
+// AgentInfo 是写入注册中心的Agent活性记录
+type AgentInfo struct {
+	ServerID   string            `json:"server_id"`
+	IPv4       string            `json:"ipv4,omitempty"`
+	IPv6       string            `json:"ipv6,omitempty"`
+	ProxyPorts []int32           `json:"proxy_ports,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Registry 是自注册/续约 + 配置下发监听的统一接口，etcd和Consul各有一份实现，
+// Backend配置为"none"时使用no-op实现
+type Registry interface {
+	// Start 写入AgentInfo并在后台持续续约，直到ctx被取消
+	Start(ctx context.Context, info AgentInfo) error
+	// WatchConfig 监听该Agent的配置key，变更时把新值（配置下发key当前持有的
+	// 原始字节）交给onUpdate；阻塞直到ctx被取消。调用方负责解码data，
+	// Registry本身不绑定具体的配置schema
+	WatchConfig(ctx context.Context, onUpdate func(data []byte)) error
+	// Campaign参与该server_id下的leader选举，阻塞直到当选；当选后用一个
+	// 生命周期等于leader任期的leaderCtx调用onElected，该ctx在失去leader身份
+	// 或外层ctx取消时被cancel。用于HA部署下只让一个副本消费WatchConfig，
+	// 避免多副本并发apply同一份配置；未开启选举（ElectionEnable=false）的
+	// 调用方不需要调用本方法
+	Campaign(ctx context.Context, onElected func(leaderCtx context.Context)) error
+	// Close 释放底层客户端连接和租约
+	Close() error
+}
+
+// New按cfg.Backend选择具体实现，未配置或配置为"none"时返回noopRegistry
+func New(cfg config.RegistryConfig) (Registry, error) {
+	switch cfg.Backend {
+	case "", "none":
+		return &noopRegistry{}, nil
+	case "etcd":
+		return newEtcdRegistry(cfg)
+	case "consul":
+		return newConsulRegistry(cfg)
+	default:
+		return nil, fmt.Errorf("不支持的注册中心后端: %s", cfg.Backend)
+	}
+}
+
+// agentKey 返回AgentInfo在注册中心中的key
+func agentKey(namespace, serverID string) string {
+	return namespace + "/agents/" + serverID
+}
+
+// configKey 返回该Agent配置下发key
+func configKey(namespace, serverID string) string {
+	return namespace + "/config/" + serverID
+}
+
+// electionKey 返回该Agent的leader选举key，与agentKey/configKey同一命名空间
+// 下按server_id区分，不同server_id的多副本各自独立选举
+func electionKey(namespace, serverID string) string {
+	return namespace + "/election/" + serverID
+}
+
+// noopRegistry 在未配置注册中心后端时使用，Start/WatchConfig都立即返回，
+// 调用方据此判断应退回到既有的HTTP轮询上报路径
+type noopRegistry struct{}
+
+func (n *noopRegistry) Start(ctx context.Context, info AgentInfo) error { return nil }
+
+func (n *noopRegistry) WatchConfig(ctx context.Context, onUpdate func(data []byte)) error {
+	return nil
+}
+
+// Campaign在noopRegistry下直接认为自己是唯一副本，立即以外层ctx作为
+// leaderCtx调用onElected，然后阻塞到ctx取消
+func (n *noopRegistry) Campaign(ctx context.Context, onElected func(leaderCtx context.Context)) error {
+	onElected(ctx)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (n *noopRegistry) Close() error { return nil }
@@ -0,0 +1,459 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultOCIRegistry = "registry-1.docker.io"
+	defaultOCICacheDir = "/var/lib/nspass/oci-cache"
+
+	mediaTypeManifestV2   = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+)
+
+// ociManifestList对应manifest list/OCI image index响应，按platform挑选子manifest
+type ociManifestList struct {
+	MediaType string `json:"mediaType"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// ociManifest对应具体架构的manifest，只关心按顺序应用的layer摘要
+type ociManifest struct {
+	MediaType string `json:"mediaType"`
+	Layers    []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Size      int64  `json:"size"`
+	} `json:"layers"`
+}
+
+// OCIInstaller从OCI兼容镜像仓库拉取代理二进制，绕开发行版仓库里没有
+// shadowsocks/xray等软件包的问题。按sha256摘要缓存已下载的layer blob，重复
+// 安装同一个ref时不再重新下载
+type OCIInstaller struct {
+	logger     *logrus.Entry
+	httpClient *http.Client
+	cacheDir   string
+}
+
+// NewOCIInstaller 创建OCI安装器实例，cacheDir为空时使用默认缓存目录
+func NewOCIInstaller(component string, cacheDir string) *OCIInstaller {
+	if cacheDir == "" {
+		cacheDir = defaultOCICacheDir
+	}
+
+	return &OCIInstaller{
+		logger:     logger.GetComponentLogger(component + "-oci"),
+		httpClient: &http.Client{},
+		cacheDir:   cacheDir,
+	}
+}
+
+// ociRef是解析后的镜像引用：registry/name@tag
+type ociRef struct {
+	registry string
+	name     string
+	tag      string
+}
+
+// parseOCIRef解析"[registry/]name@tag"形式的引用，不带registry前缀时按
+// Docker Hub的习惯补上registry-1.docker.io和library/前缀
+func parseOCIRef(ref string) (ociRef, error) {
+	at := strings.LastIndex(ref, "@")
+	if at < 0 {
+		return ociRef{}, fmt.Errorf("镜像引用缺少@tag: %s", ref)
+	}
+	path, tag := ref[:at], ref[at+1:]
+	if path == "" || tag == "" {
+		return ociRef{}, fmt.Errorf("镜像引用格式无效: %s", ref)
+	}
+
+	registry := defaultOCIRegistry
+	name := path
+	if slash := strings.Index(path, "/"); slash >= 0 {
+		first := path[:slash]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			registry = first
+			name = path[slash+1:]
+		}
+	}
+	if registry == defaultOCIRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	return ociRef{registry: registry, name: name, tag: tag}, nil
+}
+
+// InstallFromRegistry把ref（如"xtls/xray@25.1.1"）对应架构（如"linux/amd64"）
+// 的镜像内容解包到destDir：解析manifest（必要时从manifest list里按platform
+// 挑选）、逐层拉取blob并校验sha256摘要、gunzip后展开tar，跳过AUFS白化文件。
+// 已下载过的layer blob按摘要缓存在cacheDir下，重复安装同一ref不会重新下载
+func (o *OCIInstaller) InstallFromRegistry(ref string, arch string, destDir string) error {
+	parsed, err := parseOCIRef(ref)
+	if err != nil {
+		return err
+	}
+	osName, archName, err := splitPlatform(arch)
+	if err != nil {
+		return err
+	}
+
+	log := o.logger.WithFields(logrus.Fields{
+		"ref":      ref,
+		"platform": arch,
+		"dest":     destDir,
+	})
+	log.Info("开始从OCI镜像仓库安装")
+
+	auth := newOCIAuth(o.httpClient, parsed.registry)
+
+	manifest, err := o.fetchManifest(parsed, osName, archName, auth)
+	if err != nil {
+		return fmt.Errorf("获取%s的manifest失败: %w", ref, err)
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("创建安装目录失败: %w", err)
+	}
+	if err := os.MkdirAll(o.cacheDir, 0755); err != nil {
+		return fmt.Errorf("创建blob缓存目录失败: %w", err)
+	}
+
+	for _, layer := range manifest.Layers {
+		blobPath, err := o.fetchBlob(parsed, layer.Digest, auth)
+		if err != nil {
+			return fmt.Errorf("拉取layer %s失败: %w", layer.Digest, err)
+		}
+		if err := extractLayer(blobPath, destDir); err != nil {
+			return fmt.Errorf("展开layer %s失败: %w", layer.Digest, err)
+		}
+	}
+
+	log.WithField("layers", len(manifest.Layers)).Info("OCI镜像安装完成")
+	return nil
+}
+
+// splitPlatform把"linux/amd64"拆成os、arch两部分
+func splitPlatform(platform string) (string, string, error) {
+	parts := strings.SplitN(platform, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("架构标识格式无效，期望os/arch: %s", platform)
+	}
+	return parts[0], parts[1], nil
+}
+
+// fetchManifest获取ref对应的manifest，响应是manifest list/OCI index时按
+// os/arch挑选匹配的子manifest再取一次
+func (o *OCIInstaller) fetchManifest(ref ociRef, osName, archName string, auth *ociAuth) (*ociManifest, error) {
+	accept := strings.Join([]string{
+		mediaTypeManifestV2, mediaTypeManifestList, mediaTypeOCIManifest, mediaTypeOCIIndex,
+	}, ", ")
+
+	body, mediaType, err := o.getV2(ref, fmt.Sprintf("manifests/%s", ref.tag), accept, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType == mediaTypeManifestList || mediaTypeOCIIndex == mediaType {
+		var list ociManifestList
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("解析manifest list失败: %w", err)
+		}
+
+		for _, m := range list.Manifests {
+			if m.Platform.OS == osName && m.Platform.Architecture == archName {
+				body, _, err = o.getV2(ref, fmt.Sprintf("manifests/%s", m.Digest), accept, auth)
+				if err != nil {
+					return nil, err
+				}
+				var manifest ociManifest
+				if err := json.Unmarshal(body, &manifest); err != nil {
+					return nil, fmt.Errorf("解析manifest失败: %w", err)
+				}
+				return &manifest, nil
+			}
+		}
+		return nil, fmt.Errorf("manifest list中没有匹配%s/%s的平台", osName, archName)
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("解析manifest失败: %w", err)
+	}
+	return &manifest, nil
+}
+
+// fetchBlob按digest拉取blob，命中磁盘缓存时跳过网络请求，否则下载后校验
+// sha256摘要并写入缓存，返回blob在磁盘上的路径
+func (o *OCIInstaller) fetchBlob(ref ociRef, digest string, auth *ociAuth) (string, error) {
+	cachePath := filepath.Join(o.cacheDir, strings.ReplaceAll(digest, ":", "_"))
+	if _, err := os.Stat(cachePath); err == nil {
+		o.logger.WithField("digest", digest).Debug("命中blob缓存")
+		return cachePath, nil
+	}
+
+	body, _, err := o.getV2(ref, fmt.Sprintf("blobs/%s", digest), "*/*", auth)
+	if err != nil {
+		return "", err
+	}
+
+	if err := verifyDigest(digest, body); err != nil {
+		return "", err
+	}
+
+	tmpPath := cachePath + ".tmp"
+	if err := os.WriteFile(tmpPath, body, 0644); err != nil {
+		return "", fmt.Errorf("写入blob缓存失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		return "", fmt.Errorf("落盘blob缓存失败: %w", err)
+	}
+
+	return cachePath, nil
+}
+
+// verifyDigest校验body的sha256摘要是否与"sha256:<hex>"形式的digest一致
+func verifyDigest(digest string, body []byte) error {
+	const prefix = "sha256:"
+	if !strings.HasPrefix(digest, prefix) {
+		return fmt.Errorf("不支持的摘要算法: %s", digest)
+	}
+	sum := sha256.Sum256(body)
+	got := hex.EncodeToString(sum[:])
+	want := strings.TrimPrefix(digest, prefix)
+	if got != want {
+		return fmt.Errorf("blob摘要校验失败，期望%s实际%s", want, got)
+	}
+	return nil
+}
+
+// getV2对/v2/<name>/<path>发起GET请求，首次401时走bearer token流程换取
+// token后重试一次
+func (o *OCIInstaller) getV2(ref ociRef, path string, accept string, auth *ociAuth) ([]byte, string, error) {
+	url := fmt.Sprintf("https://%s/v2/%s/%s", ref.registry, ref.name, path)
+
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("创建请求失败: %w", err)
+		}
+		req.Header.Set("Accept", accept)
+		if token := auth.token(ref.name); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return o.httpClient.Do(req)
+	}
+
+	resp, err := do()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		if err := auth.authenticate(resp, ref.name); err != nil {
+			return nil, "", fmt.Errorf("获取访问令牌失败: %w", err)
+		}
+		resp, err = do()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, "", fmt.Errorf("仓库返回状态码%d: %s", resp.StatusCode, string(body))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// extractLayer把gzip压缩的tar层展开到destDir，跳过AUFS白化标记（".wh."
+// 前缀），这些条目只在分层文件系统里标记删除，对单层直接解包没有意义
+func extractLayer(blobPath string, destDir string) error {
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return fmt.Errorf("打开blob失败: %w", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("gzip解压失败: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取tar条目失败: %w", err)
+		}
+
+		name := filepath.Clean(hdr.Name)
+		base := filepath.Base(name)
+		if strings.HasPrefix(base, ".wh.") {
+			continue
+		}
+
+		target := filepath.Join(destDir, name)
+		if !strings.HasPrefix(target, filepath.Clean(destDir)+string(os.PathSeparator)) {
+			return fmt.Errorf("tar条目路径越界: %s", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)|0755); err != nil {
+				return fmt.Errorf("创建目录%s失败: %w", target, err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("创建目录%s失败: %w", filepath.Dir(target), err)
+			}
+			if err := writeTarFile(target, tr, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("创建符号链接%s失败: %w", target, err)
+			}
+		}
+	}
+}
+
+// writeTarFile把tar条目内容写入target，保留tar里记录的权限位
+func writeTarFile(target string, r io.Reader, mode os.FileMode) error {
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("创建文件%s失败: %w", target, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("写入文件%s失败: %w", target, err)
+	}
+	return nil
+}
+
+// ociAuth实现registry的bearer token认证流程：401响应带的WWW-Authenticate
+// 指明realm/service/scope，向realm换取token后缓存供后续请求复用
+type ociAuth struct {
+	httpClient *http.Client
+	registry   string
+	token      func(repo string) string
+	cached     map[string]string
+}
+
+func newOCIAuth(httpClient *http.Client, registry string) *ociAuth {
+	a := &ociAuth{httpClient: httpClient, registry: registry, cached: make(map[string]string)}
+	a.token = func(repo string) string { return a.cached[repo] }
+	return a
+}
+
+// authenticate解析401响应的WWW-Authenticate头，向其realm请求一个针对repo的
+// pull token并缓存
+func (a *ociAuth) authenticate(resp *http.Response, repo string) error {
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if challenge == "" {
+		return fmt.Errorf("仓库未返回WWW-Authenticate质询")
+	}
+
+	params := parseAuthChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return fmt.Errorf("认证质询缺少realm: %s", challenge)
+	}
+
+	tokenURL := realm
+	query := make([]string, 0, 3)
+	if service := params["service"]; service != "" {
+		query = append(query, "service="+service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", repo)
+	}
+	query = append(query, "scope="+scope)
+	if strings.Contains(tokenURL, "?") {
+		tokenURL += "&" + strings.Join(query, "&")
+	} else {
+		tokenURL += "?" + strings.Join(query, "&")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return fmt.Errorf("创建token请求失败: %w", err)
+	}
+	tokenResp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求token失败: %w", err)
+	}
+	defer tokenResp.Body.Close()
+
+	if tokenResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(tokenResp.Body)
+		return fmt.Errorf("token端点返回状态码%d: %s", tokenResp.StatusCode, string(body))
+	}
+
+	var payload struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(tokenResp.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("解析token响应失败: %w", err)
+	}
+
+	token := payload.Token
+	if token == "" {
+		token = payload.AccessToken
+	}
+	a.cached[repo] = token
+	return nil
+}
+
+// parseAuthChallenge解析形如`Bearer realm="...",service="...",scope="..."`的
+// WWW-Authenticate头，返回参数名到值的映射
+func parseAuthChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+	rest := strings.TrimPrefix(challenge, "Bearer ")
+	for _, part := range strings.Split(rest, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+	return params
+}
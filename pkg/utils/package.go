@@ -14,12 +14,14 @@ type PackageManager struct {
 	logger    *logrus.Entry
 	manager   string
 	available bool
+	oci       *OCIInstaller
 }
 
 // NewPackageManager 创建包管理器实例
 func NewPackageManager(component string) *PackageManager {
 	pm := &PackageManager{
 		logger: logger.GetComponentLogger(component + "-package"),
+		oci:    NewOCIInstaller(component, ""),
 	}
 
 	// 检测可用的包管理器
@@ -149,6 +151,36 @@ func (pm *PackageManager) InstallPackage(packageName string) error {
 	return nil
 }
 
+// InstallFromRegistry 从OCI兼容镜像仓库安装软件包，用于shadowsocks/xray等
+// 不一定在发行版仓库里的代理二进制。ref形如"xtls/xray@25.1.1"，arch形如
+// "linux/amd64"，与InstallPackage走系统包管理器不同，不依赖pm.available
+func (pm *PackageManager) InstallFromRegistry(ref string, arch string, destDir string) error {
+	startTime := time.Now()
+
+	if err := pm.oci.InstallFromRegistry(ref, arch, destDir); err != nil {
+		logger.LogError(err, "从OCI镜像仓库安装软件包失败", logrus.Fields{
+			"ref":  ref,
+			"arch": arch,
+			"dest": destDir,
+		})
+		return err
+	}
+
+	duration := time.Since(startTime)
+	logger.LogPerformance("oci_package_install", duration, logrus.Fields{
+		"ref":  ref,
+		"arch": arch,
+	})
+
+	pm.logger.WithFields(logrus.Fields{
+		"ref":         ref,
+		"arch":        arch,
+		"dest":        destDir,
+		"duration_ms": duration.Milliseconds(),
+	}).Info("OCI软件包安装完成")
+	return nil
+}
+
 // RemovePackage 卸载软件包
 func (pm *PackageManager) RemovePackage(packageName string) error {
 	if !pm.available {
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// PIDLock 表示对一个PID文件的独占持有
+type PIDLock struct {
+	path string
+	file *os.File
+	pid  int
+}
+
+// AcquirePIDFile 获取一个PID文件的独占锁，避免多个agent/worker争抢同一个进程
+//
+// expectedComm 是期望持有该PID文件的可执行文件名（不含路径），用于判断文件中记录的
+// PID是否仍然对应同一个程序：如果PID存在但comm不匹配，则视为陈旧PID文件并接管。
+func AcquirePIDFile(path string, expectedComm string) (*PIDLock, error) {
+	log := logger.GetComponentLogger("pidlock")
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开PID文件失败: %w", err)
+	}
+
+	if err := tryFlock(file); err != nil {
+		// 拿不到文件锁，检查记录的PID是否仍然存活且匹配
+		existingPID, readErr := readPID(file)
+		if readErr == nil && existingPID > 0 && processMatches(existingPID, expectedComm) {
+			file.Close()
+			return nil, fmt.Errorf("PID文件 %s 已被进程 %d 持有", path, existingPID)
+		}
+
+		log.WithFields(logrus.Fields{
+			"pid_file": path,
+			"pid":      existingPID,
+		}).Warn("检测到陈旧的PID文件，接管该文件")
+	}
+
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("清空PID文件失败: %w", err)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("定位PID文件失败: %w", err)
+	}
+
+	pid := os.Getpid()
+	if _, err := file.WriteString(strconv.Itoa(pid)); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("写入PID失败: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("同步PID文件失败: %w", err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"pid_file": path,
+		"pid":      pid,
+	}).Debug("已获取PID文件锁")
+
+	return &PIDLock{path: path, file: file, pid: pid}, nil
+}
+
+// Release 释放PID文件锁，只有当文件中记录的PID仍是本进程时才会删除文件
+func (l *PIDLock) Release() error {
+	defer l.file.Close()
+
+	pid, err := readPID(l.file)
+	if err == nil && pid == os.Getpid() {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除PID文件失败: %w", err)
+		}
+	}
+
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
+
+// tryFlock 尝试获取非阻塞的排他flock
+func tryFlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// readPID 从打开的PID文件中读取PID数值
+func readPID(file *os.File) (int, error) {
+	if _, err := file.Seek(0, 0); err != nil {
+		return 0, err
+	}
+
+	data := make([]byte, 32)
+	n, err := file.Read(data)
+	if err != nil && n == 0 {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	if err != nil {
+		return 0, err
+	}
+
+	return pid, nil
+}
+
+// processMatches 检查pid对应的进程是否存活，并且其命令名与expectedComm匹配
+func processMatches(pid int, expectedComm string) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	if err := process.Signal(syscall.Signal(0)); err != nil {
+		return false
+	}
+
+	comm, err := processComm(pid)
+	if err != nil {
+		// 无法读取comm时（例如非Linux平台），只能退化为"存活即匹配"
+		return true
+	}
+
+	return comm == expectedComm
+}
+
+// processComm 读取/proc/<pid>/comm获取进程的命令名（仅Linux可用）
+func processComm(pid int) (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", fmt.Errorf("当前平台不支持读取进程comm")
+	}
+
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -75,16 +75,40 @@ func (p *ProcessManager) RemovePIDFile(pidFile string) error {
 	return p.fileUtils.RemoveFileIfExists(pidFile)
 }
 
-// StopProcess 停止进程
+// ShutdownStep 表示优雅停机升级阶梯中的一步：发送signal后最多等待Wait时间
+type ShutdownStep struct {
+	Signal syscall.Signal
+	Wait   time.Duration
+}
+
+// DefaultShutdownLadder 返回默认的停机升级阶梯：SIGTERM等待10秒后SIGKILL
+func DefaultShutdownLadder() []ShutdownStep {
+	return []ShutdownStep{
+		{Signal: syscall.SIGTERM, Wait: 10 * time.Second},
+		{Signal: syscall.SIGKILL, Wait: 2 * time.Second},
+	}
+}
+
+// StopProcess 停止进程，使用默认的SIGTERM->SIGKILL升级阶梯
 func (p *ProcessManager) StopProcess(pidFile string, processName string) error {
+	return p.StopProcessWithLadder(pidFile, processName, DefaultShutdownLadder())
+}
+
+// StopProcessWithLadder 按照自定义的升级阶梯停止进程：依次发送ladder中的信号，
+// 每发送一个信号后等待对应的Wait时间，如果进程在该时间内未退出则发送阶梯中的下一个信号。
+func (p *ProcessManager) StopProcessWithLadder(pidFile string, processName string, ladder []ShutdownStep) error {
 	pid := p.GetPIDFromFile(pidFile)
 	if pid == 0 {
 		return fmt.Errorf("进程未运行")
 	}
 
+	if len(ladder) == 0 {
+		ladder = DefaultShutdownLadder()
+	}
+
 	log := p.logger.WithFields(logrus.Fields{
 		"process_name": processName,
-		"pid":         pid,
+		"pid":          pid,
 	})
 
 	process, err := os.FindProcess(pid)
@@ -93,32 +117,36 @@ func (p *ProcessManager) StopProcess(pidFile string, processName string) error {
 		return fmt.Errorf("找不到进程: %w", err)
 	}
 
-	// 先尝试SIGTERM
-	if err := process.Signal(syscall.SIGTERM); err != nil {
-		log.WithError(err).Warn("发送SIGTERM信号失败，尝试SIGKILL")
-		if err := process.Signal(syscall.SIGKILL); err != nil {
-			log.WithError(err).Error("发送SIGKILL信号失败")
-			return fmt.Errorf("停止进程失败: %w", err)
-		}
-	}
-
-	// 等待进程退出
 	done := make(chan bool, 1)
 	go func() {
 		process.Wait()
 		done <- true
 	}()
 
-	select {
-	case <-done:
-		log.Info("进程已成功停止")
-	case <-time.After(10 * time.Second):
-		log.Warn("等待进程退出超时，强制终止")
-		process.Signal(syscall.SIGKILL)
+	for i, step := range ladder {
+		stepLog := log.WithFields(logrus.Fields{
+			"step":   i + 1,
+			"signal": step.Signal,
+			"wait":   step.Wait,
+		})
+
+		if err := process.Signal(step.Signal); err != nil {
+			stepLog.WithError(err).Warn("发送信号失败，尝试升级阶梯的下一步")
+			continue
+		}
+		stepLog.Info("已发送停机信号，等待进程退出")
+
+		select {
+		case <-done:
+			log.Info("进程已成功停止")
+			return p.RemovePIDFile(pidFile)
+		case <-time.After(step.Wait):
+			stepLog.Warn("等待进程退出超时，升级到下一步")
+		}
 	}
 
-	// 清理PID文件
-	return p.RemovePIDFile(pidFile)
+	log.Error("升级阶梯已用尽，进程仍未退出")
+	return fmt.Errorf("停止进程 %s(pid=%d) 失败：升级阶梯已用尽", processName, pid)
 }
 
 // GetProcessStatus 获取进程状态
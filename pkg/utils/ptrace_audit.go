@@ -0,0 +1,174 @@
+//go:build linux && amd64
+
+package utils
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// SyscallEvent 表示被追踪进程的一次系统调用事件
+type SyscallEvent struct {
+	PID     int
+	Syscall string // 系统调用名（只在interestingSyscalls里的调用会上报）
+	Args    [3]uint64
+	Entry   bool  // true=调用进入（PTRACE_SYSCALL-ENTER），false=调用返回（EXIT）
+	Retval  int64 // 仅Entry为false时有效，取自Rax
+}
+
+// interestingSyscalls是AuditSyscalls默认关心的系统调用号（x86_64），覆盖
+// operator排查"这个shadowsocks worker在碰什么不该碰的文件/主机"最常用的
+// 四类：出站连接、监听、加载新程序、打开文件
+var interestingSyscalls = map[uint64]string{
+	42:  "connect",
+	49:  "bind",
+	59:  "execve",
+	257: "openat",
+}
+
+// syscallNumberByName是interestingSyscalls的反向索引，供filter参数按名字
+// 筛选用
+var syscallNumberByName = func() map[string]uint64 {
+	m := make(map[string]uint64, len(interestingSyscalls))
+	for nr, name := range interestingSyscalls {
+		m[name] = nr
+	}
+	return m
+}()
+
+// auditStopRequested登记希望尽快结束追踪的pid。ptrace的所有控制调用必须来自
+// 附加时的同一个OS线程，所以停止请求不能直接从另一个goroutine调用
+// PtraceDetach，只能设置标记，由AuditSyscalls自己的追踪循环在下一次停止点
+// 看到标记后、在同一个线程上完成detach
+var (
+	auditStopMu        sync.Mutex
+	auditStopRequested = make(map[int]bool)
+)
+
+// RequestAuditStop 请求结束对pid的系统调用审计，供CLI在收到中断信号（如
+// Ctrl+C）时调用；AuditSyscalls会在下一次系统调用停止点检测到请求并正常退出，
+// 不会终止被追踪的进程本身
+func RequestAuditStop(pid int) {
+	auditStopMu.Lock()
+	auditStopRequested[pid] = true
+	auditStopMu.Unlock()
+}
+
+func consumeAuditStop(pid int) bool {
+	auditStopMu.Lock()
+	defer auditStopMu.Unlock()
+	if auditStopRequested[pid] {
+		delete(auditStopRequested, pid)
+		return true
+	}
+	return false
+}
+
+// AuditSyscalls 以ptrace(2)附加到一个正在运行的进程pid，持续追踪其系统调用
+// 进入/退出，对filter命中的调用触发cb；filter为空时上报interestingSyscalls
+// 全集。本调用会阻塞，直到被追踪进程退出、RequestAuditStop请求了停止，或
+// ptrace附加/追踪本身出错才返回。
+//
+// ptrace的控制调用（PtraceAttach/PtraceSyscall/PtraceGetRegs等）必须全部来自
+// 同一个操作系统线程，否则内核会返回ESRCH，所以整个追踪循环固定运行在一个
+// 通过runtime.LockOSThread锁住的OS线程上。
+func AuditSyscalls(pid int, filter []string, cb func(event SyscallEvent)) error {
+	if cb == nil {
+		return fmt.Errorf("cb不能为空")
+	}
+
+	want := interestingSyscalls
+	if len(filter) > 0 {
+		want = make(map[uint64]string, len(filter))
+		for _, name := range filter {
+			nr, ok := syscallNumberByName[name]
+			if !ok {
+				return fmt.Errorf("不支持追踪系统调用%q（目前仅支持connect/bind/execve/openat）", name)
+			}
+			want[nr] = name
+		}
+	}
+
+	log := logger.GetComponentLogger("ptrace-audit")
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := syscall.PtraceAttach(pid); err != nil {
+		return fmt.Errorf("附加到pid %d失败: %w", pid, err)
+	}
+	defer syscall.PtraceDetach(pid)
+
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil {
+		return fmt.Errorf("等待pid %d附加停止失败: %w", pid, err)
+	}
+
+	if err := syscall.PtraceSetOptions(pid, syscall.PTRACE_O_TRACESYSGOOD); err != nil {
+		return fmt.Errorf("设置PTRACE_O_TRACESYSGOOD失败: %w", err)
+	}
+
+	log.WithField("pid", pid).Info("已附加，开始审计系统调用")
+
+	entering := true
+	var pendingName string
+
+	for {
+		if consumeAuditStop(pid) {
+			log.WithField("pid", pid).Info("收到停止请求，结束审计")
+			return nil
+		}
+
+		if err := syscall.PtraceSyscall(pid, 0); err != nil {
+			return fmt.Errorf("继续追踪pid %d失败: %w", pid, err)
+		}
+
+		if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil {
+			return fmt.Errorf("等待pid %d停止失败: %w", pid, err)
+		}
+
+		if ws.Exited() || ws.Signaled() {
+			log.WithField("pid", pid).Info("被追踪进程已退出，审计结束")
+			return nil
+		}
+
+		if !ws.Stopped() {
+			continue
+		}
+
+		var regs syscall.PtraceRegs
+		if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+			log.WithError(err).Debug("读取寄存器失败，跳过本次停止")
+			entering = !entering
+			continue
+		}
+
+		if entering {
+			if name, ok := want[regs.Orig_rax]; ok {
+				pendingName = name
+				cb(SyscallEvent{
+					PID:     pid,
+					Syscall: name,
+					Args:    [3]uint64{regs.Rdi, regs.Rsi, regs.Rdx},
+					Entry:   true,
+				})
+			} else {
+				pendingName = ""
+			}
+		} else if pendingName != "" {
+			cb(SyscallEvent{
+				PID:     pid,
+				Syscall: pendingName,
+				Args:    [3]uint64{regs.Rdi, regs.Rsi, regs.Rdx},
+				Entry:   false,
+				Retval:  int64(regs.Rax),
+			})
+		}
+
+		entering = !entering
+	}
+}
@@ -0,0 +1,49 @@
+//go:build linux && amd64
+
+package utils
+
+import "testing"
+
+func TestSyscallNumberByNameMatchesInterestingSyscalls(t *testing.T) {
+	if len(syscallNumberByName) != len(interestingSyscalls) {
+		t.Fatalf("syscallNumberByName有%d项, interestingSyscalls有%d项，两者应一一对应",
+			len(syscallNumberByName), len(interestingSyscalls))
+	}
+	for nr, name := range interestingSyscalls {
+		gotNr, ok := syscallNumberByName[name]
+		if !ok {
+			t.Fatalf("syscallNumberByName缺少%q", name)
+		}
+		if gotNr != nr {
+			t.Fatalf("syscallNumberByName[%q] = %d, want %d", name, gotNr, nr)
+		}
+	}
+}
+
+func TestAuditSyscallsRejectsNilCallback(t *testing.T) {
+	if err := AuditSyscalls(1, nil, nil); err == nil {
+		t.Fatal("AuditSyscalls应当在cb为nil时报错")
+	}
+}
+
+func TestAuditSyscallsRejectsUnsupportedFilter(t *testing.T) {
+	err := AuditSyscalls(1, []string{"not-a-real-syscall"}, func(SyscallEvent) {})
+	if err == nil {
+		t.Fatal("AuditSyscalls应当拒绝不受支持的filter名")
+	}
+}
+
+func TestRequestAuditStopConsumedOnce(t *testing.T) {
+	const pid = 999999
+	if consumeAuditStop(pid) {
+		t.Fatal("未请求过停止时consumeAuditStop应返回false")
+	}
+
+	RequestAuditStop(pid)
+	if !consumeAuditStop(pid) {
+		t.Fatal("RequestAuditStop后consumeAuditStop应返回true")
+	}
+	if consumeAuditStop(pid) {
+		t.Fatal("consumeAuditStop应当是一次性的，消费后应清除请求标记")
+	}
+}
@@ -0,0 +1,229 @@
+//go:build linux
+
+package utils
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// managedPIDs记录目前由调用方自己通过cmd.Wait()追踪退出状态的直接子进程
+// （比如StartSupervised创建的每个Supervised实例）。登记在案的pid不会被
+// ZombieReaper.reapAll抢先reap：syscall.Wait4(-1, WNOHANG)会不分青红皂白地
+// 抢走任意一个可reap子进程的退出状态，如果和cmd.Wait()内部针对同一个pid的
+// wait4竞争，谁先谁赢，输的一方会得到ECHILD——所以reapAll改为只对managedPIDs
+// 之外、被重新挂接到本进程名下的孙子进程发起针对性的wait4，从根上避免这个竞争
+var (
+	managedPIDsMu sync.Mutex
+	managedPIDs   = make(map[int]bool)
+)
+
+// RegisterManagedPID 登记pid由调用方自己负责reap（通常是随后会调用
+// cmd.Wait()的直接子进程），期间ZombieReaper不会对这个pid发起Wait4。
+// StartSupervised等直接fork子进程的地方应在子进程启动成功后调用，cmd.Wait()
+// 返回后应调用UnregisterManagedPID撤销登记
+func RegisterManagedPID(pid int) {
+	managedPIDsMu.Lock()
+	managedPIDs[pid] = true
+	managedPIDsMu.Unlock()
+}
+
+// UnregisterManagedPID 撤销RegisterManagedPID登记
+func UnregisterManagedPID(pid int) {
+	managedPIDsMu.Lock()
+	delete(managedPIDs, pid)
+	managedPIDsMu.Unlock()
+}
+
+func isManagedPID(pid int) bool {
+	managedPIDsMu.Lock()
+	defer managedPIDsMu.Unlock()
+	return managedPIDs[pid]
+}
+
+// ZombieReaper 负责回收被重新挂接到agent名下的孙子进程产生的僵尸状态
+//
+// 当代理软件（shadowsocks/trojan等）通过中间shell或包装脚本启动真正的工作进程时，
+// 中间进程退出后其自身的子进程会被重新挂接到最近的subreaper；只有调用过
+// PR_SET_CHILD_SUBREAPER的进程才会被内核选为这个新父进程，否则它们会被过继给
+// PID 1，agent完全观察不到也就无从reap。reapAll只处理这类重新挂接来的孙子
+// 进程，不会碰managedPIDs登记的、由StartSupervised这类调用方自己cmd.Wait()的
+// 直接子进程
+type ZombieReaper struct {
+	mu      sync.Mutex
+	running bool
+	sigChan chan os.Signal
+	done    chan struct{}
+	log     *logrus.Entry
+
+	reapedCount int64 // 累计reap到的孙子进程数量，供状态/metrics展示
+
+	// gracePingsLeft是reapAll扑空（当前没有可reap的孙子进程）时仍打一条Debug
+	// 日志说明这是正常现象的剩余次数；次数用完后扑空不再打任何日志
+	gracePingsLeft int32
+}
+
+// defaultGracePings是NewZombieReaper默认的gracePingsLeft初始值
+const defaultGracePings = 3
+
+// NewZombieReaper 创建一个新的僵尸进程回收器
+func NewZombieReaper() *ZombieReaper {
+	return &ZombieReaper{
+		log:            logger.GetComponentLogger("zombie-reaper"),
+		gracePingsLeft: defaultGracePings,
+	}
+}
+
+// Start 启动回收循环：先把本进程标记为child subreaper，再监听SIGCHLD，每次
+// 收到信号后reap所有当前已经被重新挂接到本进程名下、且未被RegisterManagedPID
+// 登记的孙子进程
+func (r *ZombieReaper) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running {
+		return
+	}
+
+	// PR_SET_CHILD_SUBREAPER是这个reaper能够观察到孙子进程的前提：没有它，
+	// 孙子进程的直接父进程退出后，孙子进程会被过继给PID 1而不是本进程，
+	// reapAll从/proc枚举到的candidates永远是空的
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		r.log.WithError(err).Warn("设置PR_SET_CHILD_SUBREAPER失败，重新挂接的孙子进程仍会被过继给init而非本进程")
+	}
+
+	r.sigChan = make(chan os.Signal, 16)
+	r.done = make(chan struct{})
+	signal.Notify(r.sigChan, syscall.SIGCHLD)
+	r.running = true
+
+	go r.reapLoop()
+
+	r.log.Info("僵尸进程回收器已启动")
+}
+
+// Stop 停止回收循环
+func (r *ZombieReaper) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.running {
+		return
+	}
+
+	signal.Stop(r.sigChan)
+	close(r.done)
+	r.running = false
+
+	r.log.Info("僵尸进程回收器已停止")
+}
+
+// reapLoop 收到SIGCHLD后循环reap所有当前可回收的孙子进程
+func (r *ZombieReaper) reapLoop() {
+	// 启动时先做一次清理，避免错过启动前已经退出的孙子进程
+	r.reapAll()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.sigChan:
+			r.reapAll()
+		}
+	}
+}
+
+// reapAll枚举当前被重新挂接到本进程名下、且不在managedPIDs登记表里的候选pid，
+// 对每一个单独调用Wait4(pid, WNOHANG)。和全局的Wait4(-1, WNOHANG)不同，
+// 针对单个pid的wait4只会影响这一个pid，不会抢走managedPIDs里那些仍由
+// cmd.Wait()等待的子进程的退出状态，因此不会和Supervised.superviseLoop之类
+// 的既有child-management路径竞争
+func (r *ZombieReaper) reapAll() {
+	candidates := r.unmanagedChildren()
+	if len(candidates) == 0 {
+		if atomic.AddInt32(&r.gracePingsLeft, -1) >= 0 {
+			r.log.Debug("当前没有待reap的孙子进程（启动初期的空进程组是正常现象）")
+		}
+		return
+	}
+
+	for _, pid := range candidates {
+		var status syscall.WaitStatus
+		var rusage syscall.Rusage
+		got, err := syscall.Wait4(pid, &status, syscall.WNOHANG, &rusage)
+		if err != nil {
+			if err != syscall.ECHILD {
+				r.log.WithError(err).WithField("pid", pid).Debug("回收孙子进程时出错")
+			}
+			continue
+		}
+		if got <= 0 {
+			// 还没有退出，等下一次SIGCHLD再处理
+			continue
+		}
+
+		atomic.AddInt64(&r.reapedCount, 1)
+		r.log.WithFields(logrus.Fields{
+			"pid":         pid,
+			"exited":      status.Exited(),
+			"exit_status": status.ExitStatus(),
+			"signaled":    status.Signaled(),
+			"signal":      status.Signal(),
+			"core_dump":   status.CoreDump(),
+		}).Info("已reap被重新挂接的孙子进程")
+	}
+}
+
+// unmanagedChildren枚举当前实际存活、挂在本进程名下、且未被RegisterManagedPID
+// 登记的子进程pid，通过/proc/self/task/<tid>/children读取（Linux 3.5+支持，
+// 不需要ptrace权限）。Go runtime的各个线程都可能是某个子进程的记录所有者，
+// 因此要遍历task下的每一个tid，而不能只看主线程
+func (r *ZombieReaper) unmanagedChildren() []int {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		r.log.WithError(err).Debug("读取/proc/self/task失败，本轮跳过reap")
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	var candidates []int
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join("/proc/self/task", entry.Name(), "children"))
+		if err != nil {
+			continue
+		}
+
+		for _, field := range strings.Fields(string(data)) {
+			pid, err := strconv.Atoi(field)
+			if err != nil || seen[pid] || isManagedPID(pid) {
+				continue
+			}
+			seen[pid] = true
+			candidates = append(candidates, pid)
+		}
+	}
+
+	return candidates
+}
+
+// ReapedCount 返回累计reap到的孙子进程数量，供状态接口/metrics展示
+func (r *ZombieReaper) ReapedCount() int64 {
+	return atomic.LoadInt64(&r.reapedCount)
+}
+
+// IsRunning 返回回收器是否正在运行
+func (r *ZombieReaper) IsRunning() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
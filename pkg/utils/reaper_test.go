@@ -0,0 +1,70 @@
+//go:build linux
+
+package utils
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRegisterUnregisterManagedPID(t *testing.T) {
+	const pid = 123456
+	if isManagedPID(pid) {
+		t.Fatal("未登记的pid不应被认为是managed")
+	}
+
+	RegisterManagedPID(pid)
+	if !isManagedPID(pid) {
+		t.Fatal("RegisterManagedPID后isManagedPID应返回true")
+	}
+
+	UnregisterManagedPID(pid)
+	if isManagedPID(pid) {
+		t.Fatal("UnregisterManagedPID后isManagedPID应返回false")
+	}
+}
+
+func TestUnmanagedChildrenExcludesManagedPID(t *testing.T) {
+	cmd := exec.Command("sleep", "5")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("无法启动测试子进程: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	pid := cmd.Process.Pid
+	RegisterManagedPID(pid)
+	defer UnregisterManagedPID(pid)
+
+	r := NewZombieReaper()
+
+	candidates := r.unmanagedChildren()
+	for _, c := range candidates {
+		if c == pid {
+			t.Fatalf("unmanagedChildren不应包含已被RegisterManagedPID登记的pid %d", pid)
+		}
+	}
+
+	UnregisterManagedPID(pid)
+
+	// /proc/self/task/*/children的更新不保证在Start()返回后立即可见，给一点余量
+	deadline := time.Now().Add(2 * time.Second)
+	found := false
+	for time.Now().Before(deadline) {
+		for _, c := range r.unmanagedChildren() {
+			if c == pid {
+				found = true
+			}
+		}
+		if found {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !found {
+		t.Fatalf("取消登记后，unmanagedChildren应能在/proc中观察到pid %d", pid)
+	}
+}
@@ -0,0 +1,437 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// RestartPolicy 子进程重启策略
+type RestartPolicy string
+
+const (
+	RestartAlways    RestartPolicy = "always"     // 无论退出原因如何都重启
+	RestartOnFailure RestartPolicy = "on_failure" // 仅在非正常退出时重启
+	RestartNever     RestartPolicy = "never"      // 从不自动重启
+)
+
+// ExitClassification 子进程退出原因分类
+type ExitClassification struct {
+	Exited     bool // 进程是否正常退出（调用了exit）
+	ExitStatus int  // 正常退出时的状态码
+	Signaled   bool // 进程是否被信号终止
+	Signal     syscall.Signal
+	CoreDump   bool // 是否产生了core dump
+}
+
+// Success 判断这次退出是否应被视为成功
+func (e ExitClassification) Success() bool {
+	return e.Exited && e.ExitStatus == 0
+}
+
+// SupervisorOptions 进程监管选项
+type SupervisorOptions struct {
+	Policy RestartPolicy
+
+	// 重启退避参数
+	BackoffInitial time.Duration // 首次重启前的等待时间
+	BackoffMax     time.Duration // 退避等待时间上限
+	BackoffFactor  float64       // 每次失败后退避时间的放大系数
+	Jitter         float64       // 退避时间的随机抖动比例(0~1)
+
+	// 重启次数窗口限制：窗口期内超过MaxRestarts次重启则放弃
+	RestartWindow time.Duration
+	MaxRestarts   int
+
+	// HealthCheck 周期性健康检查，返回非nil表示不健康，将触发重启
+	HealthCheck         func() error
+	HealthCheckInterval time.Duration
+
+	// 以下回调均为可选，用于让调用方在不暴露内部状态的前提下观察生命周期事件，
+	// 例如将 starting/running/backoff/failed 等状态上报给更上层的状态展示
+	OnSpawn   func()                    // 子进程（重新）启动成功后调用
+	OnExit    func(ExitClassification)  // 子进程退出、完成退出分类后调用
+	OnBackoff func(delay time.Duration) // 决定等待退避时间后重启前调用
+	OnStopped func()                    // 根据重启策略不再重启（非放弃式终止）时调用
+	OnGiveUp  func()                    // 达到重启次数上限而放弃监管时调用
+}
+
+// DefaultSupervisorOptions 返回一组合理的默认监管参数
+func DefaultSupervisorOptions() SupervisorOptions {
+	return SupervisorOptions{
+		Policy:         RestartOnFailure,
+		BackoffInitial: time.Second,
+		BackoffMax:     time.Minute,
+		BackoffFactor:  2.0,
+		Jitter:         0.2,
+		RestartWindow:  time.Minute,
+		MaxRestarts:    5,
+	}
+}
+
+// Supervised 代表一个被监管的子进程
+type Supervised struct {
+	name    string
+	newCmd  func() *exec.Cmd
+	pidFile string
+	opts    SupervisorOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu            sync.Mutex
+	cmd           *exec.Cmd
+	restartTimes  []time.Time
+	lastExit      ExitClassification
+	giveUp        bool
+	stoppedByUser bool
+
+	log *logrus.Entry
+}
+
+// StartSupervised 启动并监管一个子进程，子进程退出后根据策略自动重启
+//
+// newCmd 每次需要（重新）启动子进程时都会被调用一次，用于构造全新的 *exec.Cmd，
+// 因为 exec.Cmd 在 Wait 之后不能被复用。
+func StartSupervised(name string, pidFile string, newCmd func() *exec.Cmd, opts SupervisorOptions) (*Supervised, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Supervised{
+		name:    name,
+		newCmd:  newCmd,
+		pidFile: pidFile,
+		opts:    opts,
+		ctx:     ctx,
+		cancel:  cancel,
+		log:     logger.GetComponentLogger(name + "-supervisor"),
+	}
+
+	if err := s.spawn(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("启动受监管进程 %s 失败: %w", name, err)
+	}
+
+	s.wg.Add(1)
+	go s.superviseLoop()
+
+	if opts.HealthCheck != nil {
+		s.wg.Add(1)
+		go s.healthCheckLoop()
+	}
+
+	return s, nil
+}
+
+// spawn 启动子进程并将其PID原子地写入PID文件
+func (s *Supervised) spawn() error {
+	cmd := s.newCmd()
+
+	cmd.Stdout = logger.GetComponentLogger(s.name).WriterLevel(logrus.InfoLevel)
+	cmd.Stderr = logger.GetComponentLogger(s.name).WriterLevel(logrus.WarnLevel)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.cmd = cmd
+	s.mu.Unlock()
+
+	// 登记给ZombieReaper：这个pid的退出状态由下面的superviseLoop通过
+	// cmd.Wait()负责回收，reapAll不应该对它发起Wait4
+	RegisterManagedPID(cmd.Process.Pid)
+
+	pm := NewProcessManager(s.name)
+	if err := pm.WritePIDFile(s.pidFile, cmd.Process.Pid); err != nil {
+		s.log.WithError(err).Warn("写入PID文件失败")
+	}
+
+	s.log.WithField("pid", cmd.Process.Pid).Info("受监管进程已启动")
+
+	if s.opts.OnSpawn != nil {
+		s.opts.OnSpawn()
+	}
+
+	return nil
+}
+
+// superviseLoop 等待子进程退出，分类退出原因并根据策略决定是否重启
+func (s *Supervised) superviseLoop() {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		cmd := s.cmd
+		s.mu.Unlock()
+
+		err := cmd.Wait()
+		UnregisterManagedPID(cmd.Process.Pid)
+		classification := classifyExit(err)
+
+		s.mu.Lock()
+		s.lastExit = classification
+		s.mu.Unlock()
+
+		s.log.WithFields(logrus.Fields{
+			"exited":      classification.Exited,
+			"exit_status": classification.ExitStatus,
+			"signaled":    classification.Signaled,
+			"signal":      classification.Signal,
+			"core_dump":   classification.CoreDump,
+		}).Info("受监管进程已退出")
+
+		if s.opts.OnExit != nil {
+			s.opts.OnExit(classification)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		default:
+		}
+
+		if s.stoppedByUser {
+			return
+		}
+
+		if !s.shouldRestart(classification) {
+			s.log.Info("根据重启策略不再重启进程")
+			if s.opts.OnStopped != nil {
+				s.opts.OnStopped()
+			}
+			return
+		}
+
+		delay, ok := s.nextRestartDelay()
+		if !ok {
+			s.log.Warn("重启次数已达到窗口期上限，放弃重启")
+			s.mu.Lock()
+			s.giveUp = true
+			s.mu.Unlock()
+			if s.opts.OnGiveUp != nil {
+				s.opts.OnGiveUp()
+			}
+			return
+		}
+
+		if s.opts.OnBackoff != nil {
+			s.opts.OnBackoff(delay)
+		}
+
+		s.log.WithField("delay", delay).Info("等待退避时间后重启进程")
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if err := s.spawn(); err != nil {
+			s.log.WithError(err).Error("重启进程失败")
+		}
+	}
+}
+
+// shouldRestart 根据重启策略和上次退出分类判断是否需要重启
+func (s *Supervised) shouldRestart(c ExitClassification) bool {
+	switch s.opts.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return !c.Success()
+	default:
+		return false
+	}
+}
+
+// nextRestartDelay 计算下一次重启的退避时间，超过窗口期限制时返回false
+func (s *Supervised) nextRestartDelay() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	window := s.opts.RestartWindow
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	// 清理窗口期外的重启记录
+	kept := s.restartTimes[:0]
+	for _, t := range s.restartTimes {
+		if now.Sub(t) <= window {
+			kept = append(kept, t)
+		}
+	}
+	s.restartTimes = kept
+
+	maxRestarts := s.opts.MaxRestarts
+	if maxRestarts > 0 && len(s.restartTimes) >= maxRestarts {
+		return 0, false
+	}
+
+	s.restartTimes = append(s.restartTimes, now)
+
+	initial := s.opts.BackoffInitial
+	if initial <= 0 {
+		initial = time.Second
+	}
+	factor := s.opts.BackoffFactor
+	if factor <= 1 {
+		factor = 2.0
+	}
+	maxDelay := s.opts.BackoffMax
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+
+	attempt := len(s.restartTimes) - 1
+	delay := float64(initial)
+	for i := 0; i < attempt; i++ {
+		delay *= factor
+	}
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+
+	if s.opts.Jitter > 0 {
+		jitterRange := delay * s.opts.Jitter
+		delay += (rand.Float64()*2 - 1) * jitterRange
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	return time.Duration(delay), true
+}
+
+// healthCheckLoop 周期性调用健康检查钩子，失败时主动终止子进程以触发重启
+func (s *Supervised) healthCheckLoop() {
+	defer s.wg.Done()
+
+	interval := s.opts.HealthCheckInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.opts.HealthCheck(); err != nil {
+				s.log.WithError(err).Warn("健康检查失败，终止进程以触发重启")
+				s.mu.Lock()
+				cmd := s.cmd
+				s.mu.Unlock()
+				if cmd != nil && cmd.Process != nil {
+					cmd.Process.Signal(syscall.SIGTERM)
+				}
+			}
+		}
+	}
+}
+
+// Stop 停止监管并终止子进程
+func (s *Supervised) Stop(timeout time.Duration) error {
+	s.mu.Lock()
+	s.stoppedByUser = true
+	cmd := s.cmd
+	s.mu.Unlock()
+
+	s.cancel()
+
+	if cmd != nil && cmd.Process != nil {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			s.log.WithError(err).Warn("发送SIGTERM失败，尝试SIGKILL")
+			cmd.Process.Signal(syscall.SIGKILL)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.mu.Lock()
+		cmd := s.cmd
+		s.mu.Unlock()
+		if cmd != nil && cmd.Process != nil {
+			cmd.Process.Signal(syscall.SIGKILL)
+		}
+	}
+
+	pm := NewProcessManager(s.name)
+	return pm.RemovePIDFile(s.pidFile)
+}
+
+// LastExit 返回最近一次子进程退出的分类结果
+func (s *Supervised) LastExit() ExitClassification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastExit
+}
+
+// GaveUp 是否因达到最大重启次数而放弃监管
+func (s *Supervised) GaveUp() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.giveUp
+}
+
+// Pid 返回最近一次启动的被监管子进程的PID，子进程尚未启动时返回false。子进程
+// 退出后、重启之前仍返回上一个子进程的PID和true，不反映它是否还在运行
+func (s *Supervised) Pid() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil || s.cmd.Process == nil {
+		return 0, false
+	}
+	return s.cmd.Process.Pid, true
+}
+
+// classifyExit 使用syscall.WaitStatus对cmd.Wait()返回的错误进行退出原因分类
+func classifyExit(waitErr error) ExitClassification {
+	var classification ExitClassification
+
+	if waitErr == nil {
+		classification.Exited = true
+		classification.ExitStatus = 0
+		return classification
+	}
+
+	exitErr, ok := waitErr.(*exec.ExitError)
+	if !ok {
+		return classification
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return classification
+	}
+
+	classification.Exited = status.Exited()
+	if classification.Exited {
+		classification.ExitStatus = status.ExitStatus()
+	}
+	classification.Signaled = status.Signaled()
+	if classification.Signaled {
+		classification.Signal = status.Signal()
+	}
+	classification.CoreDump = status.CoreDump()
+
+	return classification
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// authExpirySafetyCheckInterval 周期性检查挑战验证是否已过期的间隔。服务端通常
+// 会在到期前重新下发CHALLENGE，这里只是一道安全网：即使重新挑战因故丢失，也能
+// 在到期后及时收紧权限，而不是无限期信任一个已过期的验证结果
+const authExpirySafetyCheckInterval = 10 * time.Second
+
+// authState跟踪挑战握手的验证结果：EGRESS_CONFIG/IPTABLES_CONFIG等特权消息在
+// validated为false或已超过expiry时一律被拒绝，直到服务端发起新的挑战并通过
+type authState struct {
+	mu           sync.RWMutex
+	validated    bool
+	expiry       time.Time
+	pendingNonce string // 当前正在等待CHALLENGE_OK确认的nonce，来自最近一次CHALLENGE
+}
+
+// valid报告当前是否处于已验证且未过期的状态
+func (a *authState) valid() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.validated && time.Now().Before(a.expiry)
+}
+
+// markValidated在收到服务端CHALLENGE_OK后记录验证结果及其有效期
+func (a *authState) markValidated(expiry time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.validated = true
+	a.expiry = expiry
+}
+
+// reset清除验证状态，在建立新连接或验证过期时调用，强制重新走一次挑战握手
+func (a *authState) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.validated = false
+	a.expiry = time.Time{}
+	a.pendingNonce = ""
+}
+
+// setPendingNonce记录本次CHALLENGE握手用的nonce，供随后收到CHALLENGE_OK时
+// 核对服务端确实是在确认这一次握手，而不是重放/伪造一条不相关的确认消息
+func (a *authState) setPendingNonce(nonce string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pendingNonce = nonce
+}
+
+// takePendingNonce取出并清空当前待确认的nonce，一次CHALLENGE_OK只能消费一次，
+// 防止同一条被窃听/重放的CHALLENGE_OK反复用来延长验证有效期
+func (a *authState) takePendingNonce() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	nonce := a.pendingNonce
+	a.pendingNonce = ""
+	return nonce
+}
+
+// expireIfPast在已验证但已过期时清除验证状态，返回是否发生了过期收紧
+func (a *authState) expireIfPast() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.validated && time.Now().After(a.expiry) {
+		a.validated = false
+		a.expiry = time.Time{}
+		return true
+	}
+	return false
+}
+
+// signChallenge对(agentID, nonce, token, timestamp)计算HMAC-SHA256签名，使用
+// config.API.ChallengeKey作为预共享密钥证明控制通道确为可信服务端发起，防止被
+// 冒充/劫持的控制通道在跳过TLS校验（TLSSkipVerify）时也能下发恶意iptables规则
+func (c *Client) signChallenge(nonce string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(c.config.API.ChallengeKey))
+	fmt.Fprintf(mac, "%s:%s:%s:%d", c.agentID, nonce, c.token, timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyChallengeOk对(agentID, nonce, validUntil)计算HMAC-SHA256并与服务端
+// 随CHALLENGE_OK带来的signature做常量时间比较，用和signChallenge相同的
+// ChallengeKey预共享密钥。没有这一步，一个冒充/劫持了控制通道的中间人（在
+// TLSSkipVerify开启时尤其现实）只要原样回放agent自己发出的CHALLENGE_RESPONSE
+// 的nonce、拼出一条裸的CHALLENGE_OK，就能在完全不知道ChallengeKey的情况下
+// 让agent解锁EGRESS_CONFIG/IPTABLES_CONFIG等特权消息
+func (c *Client) verifyChallengeOk(nonce string, validUntil time.Time, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(c.config.API.ChallengeKey))
+	fmt.Fprintf(mac, "%s:%s:%d", c.agentID, nonce, validUntil.Unix())
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// authExpirySafetyLoop 周期性检查挑战验证是否已过期，过期后收紧权限直到服务端
+// 重新下发挑战并通过验证
+func (c *Client) authExpirySafetyLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(authExpirySafetyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if c.auth.expireIfPast() {
+				c.log.Warn("挑战验证已过期，在服务端重新下发挑战前将拒绝特权配置消息")
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+)
+
+func TestAuthStateValidAndReset(t *testing.T) {
+	a := &authState{}
+	if a.valid() {
+		t.Fatal("未验证的authState不应为valid")
+	}
+
+	a.markValidated(time.Now().Add(time.Minute))
+	if !a.valid() {
+		t.Fatal("markValidated后且未过期时应为valid")
+	}
+
+	a.reset()
+	if a.valid() {
+		t.Fatal("reset后不应再为valid")
+	}
+}
+
+func TestAuthStateExpireIfPast(t *testing.T) {
+	a := &authState{}
+	a.markValidated(time.Now().Add(-time.Second))
+	if !a.expireIfPast() {
+		t.Fatal("已过期的authState，expireIfPast应返回true")
+	}
+	if a.valid() {
+		t.Fatal("expireIfPast收紧后不应再为valid")
+	}
+	if a.expireIfPast() {
+		t.Fatal("再次调用expireIfPast不应重复触发")
+	}
+}
+
+func TestAuthStatePendingNonce(t *testing.T) {
+	a := &authState{}
+	a.setPendingNonce("nonce-1")
+	if got := a.takePendingNonce(); got != "nonce-1" {
+		t.Fatalf("takePendingNonce() = %q, want %q", got, "nonce-1")
+	}
+	if got := a.takePendingNonce(); got != "" {
+		t.Fatalf("takePendingNonce应当是一次性的，第二次调用得到 %q, want \"\"", got)
+	}
+}
+
+func newTestClient(challengeKey string) *Client {
+	return &Client{
+		config:  &config.Config{API: config.APIConfig{ChallengeKey: challengeKey}},
+		agentID: "agent-1",
+		token:   "test-token",
+	}
+}
+
+// signChallengeOkForTest复现verifyChallengeOk期望的摘要格式，供测试构造一个
+// 合法的CHALLENGE_OK签名，而不依赖服务端实现
+func signChallengeOkForTest(challengeKey, agentID, nonce string, validUntil time.Time) string {
+	mac := hmac.New(sha256.New, []byte(challengeKey))
+	fmt.Fprintf(mac, "%s:%s:%d", agentID, nonce, validUntil.Unix())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyChallengeOk(t *testing.T) {
+	c := newTestClient("shared-secret")
+	nonce := "nonce-abc"
+	validUntil := time.Now().Add(time.Minute)
+
+	sig := signChallengeOkForTest("shared-secret", "agent-1", nonce, validUntil)
+	if !c.verifyChallengeOk(nonce, validUntil, sig) {
+		t.Fatal("正确签名的CHALLENGE_OK应当通过校验")
+	}
+
+	if c.verifyChallengeOk(nonce, validUntil, "0000000000000000000000000000000000000000000000000000000000000000") {
+		t.Fatal("被篡改的签名不应通过校验")
+	}
+
+	if c.verifyChallengeOk("other-nonce", validUntil, sig) {
+		t.Fatal("nonce不匹配时不应通过校验")
+	}
+
+	wrongKeyClient := newTestClient("wrong-secret")
+	if wrongKeyClient.verifyChallengeOk(nonce, validUntil, sig) {
+		t.Fatal("ChallengeKey不一致时不应通过校验")
+	}
+}
+
+func TestSignChallenge(t *testing.T) {
+	c := newTestClient("shared-secret")
+	ts := time.Now().Unix()
+
+	sig1 := c.signChallenge("nonce-1", ts)
+	sig2 := c.signChallenge("nonce-1", ts)
+	if sig1 != sig2 {
+		t.Fatal("signChallenge对相同输入应产生确定性的签名")
+	}
+
+	if c.signChallenge("nonce-2", ts) == sig1 {
+		t.Fatal("不同nonce应产生不同签名")
+	}
+}
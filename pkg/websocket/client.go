@@ -63,16 +63,57 @@ type Client struct {
 	// 代理管理器
 	proxyManager *proxy.Manager
 
+	// 待确认消息跟踪器，为任务结果/配置ACK/监控上报等消息提供可靠投递保证
+	ackTracker *PendingAckTracker
+
+	// 按优先级分桶的出站消息队列，由单个写协程消费，避免并发WriteMessage
+	outbound *outboundQueues
+
+	// resync跟踪最近一次成功应用的配置版本，支持reconnect后的list-watch式校验
+	resync *resyncTracker
+
+	// 正在执行的任务的取消函数，key为TaskId，支持服务端下发取消请求
+	runningTasks   map[string]context.CancelFunc
+	runningTasksMu sync.Mutex
+
+	// 握手阶段与服务端协商出的出站消息压缩编解码器，默认codecNone直到connect()协商成功
+	codec            Codec
+	codecMu          sync.RWMutex
+	compressionStats compressionStats
+
+	// auth跟踪挑战握手的验证状态，EGRESS_CONFIG/IPTABLES_CONFIG等特权消息在验证
+	// 通过前一律被拒绝
+	auth authState
+
+	// syncHook在egress/iptables推送配置成功应用后调用，kind为"proxy"或
+	// "iptables"，供调用方（agent.Reconciler）记账和安排一次安全复核；推送
+	// 本身的应用路径不受影响
+	syncHook func(kind string)
+
 	log *logrus.Entry
 }
 
 // TaskHandler 任务处理器接口
 type TaskHandler interface {
-	HandleTask(ctx context.Context, task *model.TaskMessage) (*model.TaskResult, error)
+	HandleTask(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error)
 	CheckTaskStatus(taskID string, taskType model.TaskType) (shouldExecute bool, existingResult *model.TaskResult)
+	CancelTask(taskID string) error
 	GetTaskStats() map[string]int
 }
 
+// TaskProgressReporter 允许任务在执行过程中上报阶段性进度、日志行和部分输出，
+// 由Client实现并通过AGENT_TYPE_TASK_PROGRESS消息发送，CorrelationId关联回
+// 原始的任务消息
+type TaskProgressReporter interface {
+	// Report 上报一次阶段性进度（百分比+描述）
+	Report(percent int32, message string)
+	// Log 上报一条任务执行过程中产生的日志行，level是日志级别（如"info"/"warn"）
+	Log(level string, line string)
+	// Partial 上报一段尚未结束的输出（例如长时间运行命令的stdout/stderr增量），
+	// 与最终TaskResult.Output是累积关系而非替换关系
+	Partial(output string)
+}
+
 // MetricsCollector 监控数据收集器接口
 type MetricsCollector interface {
 	CollectSystemMetrics() (*model.SystemMetrics, error)
@@ -86,7 +127,7 @@ type MetricsCollector interface {
 func NewClient(cfg *config.Config, agentID, token string, taskHandler TaskHandler, metricsCollector MetricsCollector, iptablesManager iptables.ManagerInterface, proxyManager *proxy.Manager) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Client{
+	c := &Client{
 		config:           cfg,
 		agentID:          agentID,
 		token:            token,
@@ -102,6 +143,13 @@ func NewClient(cfg *config.Config, agentID, token string, taskHandler TaskHandle
 		reconnectChan:    make(chan struct{}, 1),
 		log:              logger.GetComponentLogger("websocket-client"),
 	}
+	c.ackTracker = NewPendingAckTracker(c.sendMessage)
+	c.outbound = newOutboundQueues()
+	c.resync = newResyncTracker(cfg.Proxy.ConfigPath)
+	c.runningTasks = make(map[string]context.CancelFunc)
+	c.codec = codecNone
+
+	return c
 }
 
 // Start 启动WebSocket客户端
@@ -120,6 +168,10 @@ func (c *Client) Start() error {
 	c.wg.Add(1)
 	go c.messageProcessLoop()
 
+	// 启动出站消息写协程，独占conn.WriteMessage的调用
+	c.wg.Add(1)
+	go c.writerLoop()
+
 	// 启动心跳协程
 	c.wg.Add(1)
 	go c.heartbeatLoop()
@@ -128,6 +180,14 @@ func (c *Client) Start() error {
 	c.wg.Add(1)
 	go c.metricsReportLoop()
 
+	// 启动周期性安全resync协程
+	c.wg.Add(1)
+	go c.resyncSafetyLoop()
+
+	// 启动挑战验证过期安全检查协程
+	c.wg.Add(1)
+	go c.authExpirySafetyLoop()
+
 	// 触发初始连接
 	c.triggerReconnect()
 
@@ -235,6 +295,7 @@ func (c *Client) connect() error {
 	headers["Server-ID"] = []string{c.agentID}
 	headers["Agent-Token"] = []string{c.token}
 	headers["User-Agent"] = []string{"nspass-agent/1.0"}
+	headers["Agent-Compression"] = []string{supportedCodecNames()}
 
 	// 建立连接
 	conn, response, err := dialer.Dial(wsURL, headers)
@@ -252,16 +313,42 @@ func (c *Client) connect() error {
 		return fmt.Errorf("WebSocket连接失败,状态码: %d", response.StatusCode)
 	}
 
+	// 协商出站消息压缩编解码器：服务端在握手响应中回显它支持的编解码器列表，
+	// 双方偏好顺序的交集中优先级最高者胜出；服务端未回显该头时退回不压缩
+	codec := negotiateCodec(response.Header.Get("Agent-Compression"))
+	c.setCodec(codec)
+
+	// 每条新连接都需要重新完成一次挑战握手才能下发特权配置，防止沿用上一条
+	// 连接（可能已被劫持或重放）遗留下来的验证状态
+	c.auth.reset()
+
 	c.connMu.Lock()
 	c.conn = conn
 	c.connected = true
 	c.connMu.Unlock()
 
-	c.log.Info("WebSocket连接建立成功")
+	c.log.WithField("codec", codec.Name()).Info("WebSocket连接建立成功")
+
+	// 每次(重)连接成功后都请求一次resync，防止断线期间错过的配置更新导致静默漂移
+	c.sendResyncRequest()
 
 	return nil
 }
 
+// setCodec 更新当前连接协商出的压缩编解码器
+func (c *Client) setCodec(codec Codec) {
+	c.codecMu.Lock()
+	c.codec = codec
+	c.codecMu.Unlock()
+}
+
+// getCodec 返回当前连接使用的压缩编解码器
+func (c *Client) getCodec() Codec {
+	c.codecMu.RLock()
+	defer c.codecMu.RUnlock()
+	return c.codec
+}
+
 // buildWebSocketURL 构建WebSocket URL
 func (c *Client) buildWebSocketURL(agentID string) (string, error) {
 	u, err := url.Parse(c.config.API.BaseURL)
@@ -333,10 +420,19 @@ func (c *Client) readMessageLoop() {
 				continue
 			}
 
+			c.compressionStats.recordIn(len(messageData))
+
+			// 传输帧可能携带压缩payload，先还原出原始的Protocol Buffer字节
+			rawData, err := decodeFrame(messageData)
+			if err != nil {
+				c.log.WithError(err).WithField("message_size", len(messageData)).Error("解码传输帧失败")
+				continue
+			}
+
 			// 使用proto.Unmarshal解析二进制格式的WebSocket消息
 			var wsMessage model.WebSocketMessage
-			if err := proto.Unmarshal(messageData, &wsMessage); err != nil {
-				c.log.WithError(err).WithField("message_size", len(messageData)).Error("解析WebSocket Protocol Buffer消息失败")
+			if err := proto.Unmarshal(rawData, &wsMessage); err != nil {
+				c.log.WithError(err).WithField("message_size", len(rawData)).Error("解析WebSocket Protocol Buffer消息失败")
 				continue
 			}
 
@@ -370,6 +466,17 @@ func (c *Client) messageProcessLoop() {
 	}
 }
 
+// isPrivilegedMessage报告该消息类型是否需要先通过挑战握手验证才能被接受
+func isPrivilegedMessage(msgType model.WebSocketMessageType) bool {
+	switch msgType {
+	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_SERVER_TYPE_EGRESS_CONFIG,
+		model.WebSocketMessageType_WEBSOCKET_MESSAGE_SERVER_TYPE_IPTABLES_CONFIG:
+		return true
+	default:
+		return false
+	}
+}
+
 // processMessage 处理WebSocket消息
 func (c *Client) processMessage(message *model.WebSocketMessage) {
 	c.log.WithFields(logrus.Fields{
@@ -377,6 +484,14 @@ func (c *Client) processMessage(message *model.WebSocketMessage) {
 		"message_type": message.MessageType.String(),
 	}).Info("处理WebSocket消息")
 
+	// EGRESS_CONFIG/IPTABLES_CONFIG等特权消息在挑战握手验证通过前一律拒绝，防御
+	// 一条跳过TLS校验（TLSSkipVerify）的被冒充/劫持的控制通道下发恶意iptables规则
+	if isPrivilegedMessage(message.MessageType) && !c.auth.valid() {
+		c.log.WithField("message_type", message.MessageType.String()).Warn("挑战验证未通过，拒绝特权配置消息")
+		c.sendErrorAck(message.MessageId, "unauthenticated", "challenge handshake not completed or expired")
+		return
+	}
+
 	switch message.MessageType {
 	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_SERVER_TYPE_TASK:
 		c.handleTaskMessage(message)
@@ -388,6 +503,12 @@ func (c *Client) processMessage(message *model.WebSocketMessage) {
 		c.handleEgressConfig(message)
 	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_SERVER_TYPE_IPTABLES_CONFIG:
 		c.handleIptablesConfig(message)
+	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_SERVER_TYPE_TASK_CANCEL:
+		c.handleTaskCancelMessage(message)
+	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_SERVER_TYPE_CHALLENGE:
+		c.handleChallengeMessage(message)
+	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_SERVER_TYPE_CHALLENGE_OK:
+		c.handleChallengeOkMessage(message)
 	default:
 		c.log.WithField("message_type", message.MessageType.String()).Warn("未知的消息类型")
 	}
@@ -422,6 +543,10 @@ func (c *Client) handleEgressConfig(message *model.WebSocketMessage) {
 
 	c.log.Info("egress配置应用成功")
 
+	// 记录本次已成功应用的配置版本，供reconnect后的resync请求比对
+	c.resync.SetEgressVersion(configVersion(&agentEgressConfigs))
+	c.notifySync("proxy")
+
 	// 发送成功确认
 	c.sendEgressConfigSuccessAck(message.MessageId, agentEgressConfigs.EgressItems)
 }
@@ -461,7 +586,7 @@ func (c *Client) sendEgressConfigSuccessAck(messageID string, egressItems []*mod
 		Success:   true,
 	}
 
-	c.sendAckMessage(ackMessage)
+	c.sendAckMessage(ackMessage, true)
 }
 
 func (c *Client) handleIptablesConfig(message *model.WebSocketMessage) {
@@ -502,6 +627,10 @@ func (c *Client) handleIptablesConfig(message *model.WebSocketMessage) {
 		"applied_rules": len(serverConfig.Configs),
 	}).Info("iptables配置应用成功")
 
+	// 记录本次已成功应用的配置版本，供reconnect后的resync请求比对
+	c.resync.SetIptablesVersion(configVersion(&serverConfig))
+	c.notifySync("iptables")
+
 	// 发送成功确认
 	c.sendConfigSuccessAck(message.MessageId, &serverConfig)
 }
@@ -530,7 +659,7 @@ func (c *Client) sendConfigSuccessAck(messageID string, serverConfig *model.Ipta
 		Success:   true,
 	}
 
-	c.sendAckMessage(ackMessage)
+	c.sendAckMessage(ackMessage, true)
 }
 
 // handleTaskMessage 处理任务消息
@@ -584,8 +713,16 @@ func (c *Client) handleTaskMessage(message *model.WebSocketMessage) {
 func (c *Client) executeTask(messageID string, task *model.TaskMessage) {
 	startTime := time.Now()
 
+	// 为该任务派生一个可取消的context，登记取消函数以便服务端下发TASK_CANCEL时终止
+	taskCtx, cancel := context.WithCancel(c.ctx)
+	c.registerRunningTask(task.TaskId, cancel)
+	defer c.unregisterRunningTask(task.TaskId)
+	defer cancel()
+
+	reporter := &taskProgressReporter{client: c, correlationID: messageID, taskID: task.TaskId}
+
 	// 执行任务
-	result, err := c.taskHandler.HandleTask(c.ctx, task)
+	result, err := c.taskHandler.HandleTask(taskCtx, task, reporter)
 
 	// 构建任务结果
 	taskResult := &model.TaskResult{
@@ -594,11 +731,18 @@ func (c *Client) executeTask(messageID string, task *model.TaskMessage) {
 		CompletedAt: timestamppb.New(time.Now()),
 	}
 
-	if err != nil {
+	switch {
+	case taskCtx.Err() == context.Canceled:
+		// 任务已被服务端取消：无论HandleTask返回什么结果都以取消状态为准，这样
+		// 重新投递的同一任务会被CheckTaskStatus识别为TASK_STATUS_CANCELLED而不会重新执行
+		taskResult.Status = model.TaskStatus_TASK_STATUS_CANCELLED
+		taskResult.ErrorMessage = "任务已被服务端取消"
+		c.log.WithField("task_id", task.TaskId).Info("任务已取消")
+	case err != nil:
 		taskResult.Status = model.TaskStatus_TASK_STATUS_FAILED
 		taskResult.ErrorMessage = err.Error()
 		c.log.WithError(err).WithField("task_id", task.TaskId).Error("任务执行失败")
-	} else {
+	default:
 		taskResult.Status = model.TaskStatus_TASK_STATUS_COMPLETED
 		if result != nil {
 			taskResult.Output = result.Output
@@ -611,6 +755,188 @@ func (c *Client) executeTask(messageID string, task *model.TaskMessage) {
 	c.sendTaskResultAck(messageID, taskResult)
 }
 
+// registerRunningTask 登记一个正在执行的任务的取消函数
+func (c *Client) registerRunningTask(taskID string, cancel context.CancelFunc) {
+	c.runningTasksMu.Lock()
+	defer c.runningTasksMu.Unlock()
+	c.runningTasks[taskID] = cancel
+}
+
+// unregisterRunningTask 移除一个已结束任务的取消函数登记
+func (c *Client) unregisterRunningTask(taskID string) {
+	c.runningTasksMu.Lock()
+	defer c.runningTasksMu.Unlock()
+	delete(c.runningTasks, taskID)
+}
+
+// handleTaskCancelMessage 处理服务端下发的任务取消请求：取消本地正在执行的
+// context（如果任务仍在运行），并将任务状态标记为已取消，防止被重新投递时再次执行
+func (c *Client) handleTaskCancelMessage(message *model.WebSocketMessage) {
+	var cancelRequest model.TaskMessage
+	if err := message.Payload.UnmarshalTo(&cancelRequest); err != nil {
+		c.log.WithError(err).Error("解析任务取消消息失败")
+		c.sendErrorAck(message.MessageId, "解析任务取消消息失败", err.Error())
+		return
+	}
+
+	c.log.WithField("task_id", cancelRequest.TaskId).Info("收到服务端任务取消请求")
+
+	c.runningTasksMu.Lock()
+	cancel, running := c.runningTasks[cancelRequest.TaskId]
+	c.runningTasksMu.Unlock()
+	if running {
+		cancel()
+	}
+
+	if c.taskHandler != nil {
+		if err := c.taskHandler.CancelTask(cancelRequest.TaskId); err != nil {
+			c.log.WithError(err).WithField("task_id", cancelRequest.TaskId).Warn("标记任务为已取消失败")
+		}
+	}
+
+	ackMessage := &model.AckMessage{
+		MessageId: message.MessageId,
+		Success:   true,
+	}
+	c.sendAckMessage(ackMessage, true)
+}
+
+// handleChallengeMessage 处理服务端下发的挑战握手请求：对携带的nonce做签名后
+// 以AGENT_TYPE_CHALLENGE_RESPONSE回复，验证结果由后续的CHALLENGE_OK消息确认
+func (c *Client) handleChallengeMessage(message *model.WebSocketMessage) {
+	var challenge model.ChallengeMessage
+	if err := message.Payload.UnmarshalTo(&challenge); err != nil {
+		c.log.WithError(err).Error("解析挑战握手消息失败")
+		c.sendErrorAck(message.MessageId, "解析挑战握手消息失败", err.Error())
+		return
+	}
+
+	timestamp := time.Now().Unix()
+	response := &model.ChallengeResponse{
+		AgentId:   c.agentID,
+		Nonce:     challenge.Nonce,
+		Timestamp: timestamp,
+		Signature: c.signChallenge(challenge.Nonce, timestamp),
+	}
+
+	// 记下这次握手用的nonce，随后收到CHALLENGE_OK时凭它校验签名、确认确实是
+	// 服务端对这一次握手的确认，而不是被冒充的控制通道伪造的裸确认消息
+	c.auth.setPendingNonce(challenge.Nonce)
+
+	payload, err := anypb.New(response)
+	if err != nil {
+		c.log.WithError(err).Error("封装挑战响应失败")
+		return
+	}
+
+	wsMessage := &model.WebSocketMessage{
+		MessageId:     c.generateMessageID(),
+		MessageType:   model.WebSocketMessageType_WEBSOCKET_MESSAGE_AGENT_TYPE_CHALLENGE_RESPONSE,
+		Timestamp:     timestamppb.Now(),
+		Payload:       payload,
+		CorrelationId: message.MessageId,
+	}
+
+	c.log.Info("收到服务端挑战握手请求，已发送签名响应")
+	c.sendReliableMessage(wsMessage)
+}
+
+// handleChallengeOkMessage 处理服务端确认挑战验证通过的消息：必须携带对
+// (agentID, nonce, validUntil)的HMAC签名且nonce与本次握手一致才会被采信，
+// 记录有效期；在此之前、以及签名/nonce校验失败时，EGRESS_CONFIG/
+// IPTABLES_CONFIG等特权消息都会被拒绝
+func (c *Client) handleChallengeOkMessage(message *model.WebSocketMessage) {
+	var ok model.ChallengeOk
+	if err := message.Payload.UnmarshalTo(&ok); err != nil {
+		c.log.WithError(err).Error("解析挑战验证结果消息失败")
+		return
+	}
+
+	nonce := c.auth.takePendingNonce()
+	expiry := ok.ValidUntil.AsTime()
+
+	if nonce == "" || ok.Nonce != nonce || !c.verifyChallengeOk(nonce, expiry, ok.Signature) {
+		c.log.Warn("CHALLENGE_OK的nonce或签名校验未通过，拒绝信任本次挑战验证结果")
+		return
+	}
+
+	c.auth.markValidated(expiry)
+	c.log.WithField("valid_until", expiry).Info("挑战握手验证通过")
+}
+
+// taskProgressReporter 是TaskProgressReporter的默认实现，通过AGENT_TYPE_TASK_PROGRESS
+// 消息上报进度，CorrelationId关联回原始的任务消息ID
+type taskProgressReporter struct {
+	client        *Client
+	correlationID string
+	taskID        string
+}
+
+// Report 发送一次任务进度更新
+func (r *taskProgressReporter) Report(percent int32, message string) {
+	progressResult := &model.TaskResult{
+		TaskId: r.taskID,
+		Status: model.TaskStatus_TASK_STATUS_RUNNING,
+		Output: fmt.Sprintf("[%d%%] %s", percent, message),
+	}
+
+	resultData, err := anypb.New(progressResult)
+	if err != nil {
+		r.client.log.WithError(err).Error("创建任务进度数据失败")
+		return
+	}
+
+	wsMessage := &model.WebSocketMessage{
+		MessageId:     r.client.generateMessageID(),
+		MessageType:   model.WebSocketMessageType_WEBSOCKET_MESSAGE_AGENT_TYPE_TASK_PROGRESS,
+		Timestamp:     timestamppb.Now(),
+		Payload:       resultData,
+		CorrelationId: r.correlationID,
+	}
+
+	r.client.sendMessage(wsMessage)
+	r.client.log.WithFields(logrus.Fields{
+		"task_id": r.taskID,
+		"percent": percent,
+	}).Debug("上报任务进度")
+}
+
+// Log 发送一条任务日志行
+func (r *taskProgressReporter) Log(level string, line string) {
+	r.sendProgressOutput(fmt.Sprintf("[%s] %s", level, line))
+}
+
+// Partial 发送一段部分输出
+func (r *taskProgressReporter) Partial(output string) {
+	r.sendProgressOutput(output)
+}
+
+// sendProgressOutput 是Log/Partial共用的发送逻辑：都是携带一段文本、状态仍为
+// RUNNING的AGENT_TYPE_TASK_PROGRESS消息，与Report的区别只在Output的内容
+func (r *taskProgressReporter) sendProgressOutput(output string) {
+	progressResult := &model.TaskResult{
+		TaskId: r.taskID,
+		Status: model.TaskStatus_TASK_STATUS_RUNNING,
+		Output: output,
+	}
+
+	resultData, err := anypb.New(progressResult)
+	if err != nil {
+		r.client.log.WithError(err).Error("创建任务进度数据失败")
+		return
+	}
+
+	wsMessage := &model.WebSocketMessage{
+		MessageId:     r.client.generateMessageID(),
+		MessageType:   model.WebSocketMessageType_WEBSOCKET_MESSAGE_AGENT_TYPE_TASK_PROGRESS,
+		Timestamp:     timestamppb.Now(),
+		Payload:       resultData,
+		CorrelationId: r.correlationID,
+	}
+
+	r.client.sendMessage(wsMessage)
+}
+
 // sendTaskResultAck 发送任务结果确认
 func (c *Client) sendTaskResultAck(messageID string, taskResult *model.TaskResult) {
 	resultData, err := anypb.New(taskResult)
@@ -629,7 +955,38 @@ func (c *Client) sendTaskResultAck(messageID string, taskResult *model.TaskResul
 		ackMessage.ErrorMessage = taskResult.ErrorMessage
 	}
 
-	c.sendAckMessage(ackMessage)
+	c.sendAckMessage(ackMessage, true)
+}
+
+// FlushOutbound阻塞直到所有出站队列都被writerLoop清空，或timeout到期（以先
+// 发生者为准）。用于restartAgentService在syscall.Exec替换进程镜像前，确保
+// 已经入队但还没真正写到连接上的消息不会因为进程镜像被替换而丢失
+func (c *Client) FlushOutbound(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		empty := true
+		for _, ch := range c.outbound.queues {
+			if len(ch) > 0 {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("等待出站队列清空超时")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// SendDeferredTaskResult补发一次自重启（syscall.Exec替换进程镜像）之前没能
+// 发出的TaskResult确认。taskID同时用作ack消息的MessageId——
+// RestartPendingMarker里没有保留原始消息ID，但服务端按TaskResult.TaskId识别
+// 任务，不依赖MessageId与某条具体下发消息精确对应
+func (c *Client) SendDeferredTaskResult(taskID string, result *model.TaskResult) {
+	c.sendTaskResultAck(taskID, result)
 }
 
 // sendErrorAck 发送错误确认
@@ -653,11 +1010,13 @@ func (c *Client) sendErrorAck(messageID, errorMessage, details string) {
 		}
 	}
 
-	c.sendAckMessage(ackMessage)
+	c.sendAckMessage(ackMessage, true)
 }
 
-// sendAckMessage 发送确认消息
-func (c *Client) sendAckMessage(ackMessage *model.AckMessage) {
+// sendAckMessage 发送确认消息。reliable为true时通过PendingAckTracker登记重试，
+// 确保任务结果/配置确认在服务端未及时响应时不会被静默丢失；心跳确认等best-effort
+// 场景应传入false。
+func (c *Client) sendAckMessage(ackMessage *model.AckMessage, reliable bool) {
 	payload, err := anypb.New(ackMessage)
 	if err != nil {
 		c.log.WithError(err).Error("创建确认消息载荷失败")
@@ -672,7 +1031,11 @@ func (c *Client) sendAckMessage(ackMessage *model.AckMessage) {
 		CorrelationId: ackMessage.MessageId,
 	}
 
-	c.sendMessage(wsMessage)
+	if reliable {
+		c.sendReliableMessage(wsMessage)
+	} else {
+		c.sendMessage(wsMessage)
+	}
 }
 
 // handleHeartbeatMessage 处理心跳消息
@@ -691,15 +1054,16 @@ func (c *Client) sendHeartbeatAck(messageID string) {
 		Success:   true,
 	}
 
-	c.sendAckMessage(ackMessage)
+	c.sendAckMessage(ackMessage, false)
 }
 
 // handleAckMessage 处理确认消息
 func (c *Client) handleAckMessage(message *model.WebSocketMessage) {
 	c.log.WithField("correlation_id", message.CorrelationId).Debug("收到确认消息")
 
-	// 这里可以处理待确认的消息队列
-	// 实际实现中可以维护一个待确认消息的映射
+	// 完成待确认跟踪：如果该CorrelationId对应一条通过sendReliableMessage发出的消息，
+	// 停止其重试计时器并从跟踪表中移除
+	c.ackTracker.Complete(message.CorrelationId)
 }
 
 // heartbeatLoop 心跳循环
@@ -752,6 +1116,65 @@ func (c *Client) sendHeartbeat() {
 	c.log.Debug("发送心跳消息")
 }
 
+// resyncSafetyLoop 周期性地（默认5分钟）主动请求一次resync，防止长期运行过程中
+// 因消息丢失等原因导致本地配置与服务端静默漂移
+func (c *Client) resyncSafetyLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(resyncSafetyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if c.isConnected() {
+				c.sendResyncRequest()
+			}
+		}
+	}
+}
+
+// sendResyncRequest 在连接建立后（以及周期性安全校验时）请求服务端核对配置版本。
+//
+// nspass-proto协议尚未提供专门的AGENT_TYPE_RESYNC_REQUEST消息类型，这里复用已有
+// 的心跳消息承载resync语义：通过Metadata携带"type=resync_request"及各配置域已
+// 应用的版本号，服务端据此判断本地配置是否已过期、是否需要下发全量快照。等协议
+// 补充了专门的消息类型后应迁移过去。
+func (c *Client) sendResyncRequest() {
+	resyncMessage := &model.HeartbeatMessage{
+		AgentId:   c.agentID,
+		Timestamp: timestamppb.Now(),
+		Status:    "online",
+		Metadata: map[string]string{
+			"version":          "1.0.0",
+			"type":             "resync_request",
+			"egress_version":   c.resync.EgressVersion(),
+			"iptables_version": c.resync.IptablesVersion(),
+		},
+	}
+
+	payload, err := anypb.New(resyncMessage)
+	if err != nil {
+		c.log.WithError(err).Error("创建resync请求载荷失败")
+		return
+	}
+
+	wsMessage := &model.WebSocketMessage{
+		MessageId:   c.generateMessageID(),
+		MessageType: model.WebSocketMessageType_WEBSOCKET_MESSAGE_AGENT_TYPE_HEARTBEAT,
+		Timestamp:   timestamppb.Now(),
+		Payload:     payload,
+	}
+
+	c.sendMessage(wsMessage)
+	c.log.WithFields(logrus.Fields{
+		"egress_version":   c.resync.EgressVersion(),
+		"iptables_version": c.resync.IptablesVersion(),
+	}).Info("已发送配置resync请求")
+}
+
 // metricsReportLoop 监控数据上报循环
 func (c *Client) metricsReportLoop() {
 	defer c.wg.Done()
@@ -878,12 +1301,70 @@ func (c *Client) sendMetrics(metricsType model.MetricsType, data proto.Message)
 		Payload:     payload,
 	}
 
-	c.sendMessage(wsMessage)
+	c.sendReliableMessage(wsMessage)
 	c.log.WithField("metrics_type", metricsType.String()).Debug("发送监控数据")
 }
 
-// sendMessage 发送WebSocket消息
+// sendReliableMessage 发送需要可靠投递保证的消息：立即尝试发送一次，并登记到
+// PendingAckTracker等待服务端ACK，超时未确认时按退避策略自动重试
+func (c *Client) sendReliableMessage(message *model.WebSocketMessage) {
+	c.sendMessage(message)
+	c.ackTracker.Track(message, c.handleDeliveryFailure)
+}
+
+// handleDeliveryFailure 在一条可靠消息重试耗尽后被调用，记录最终投递失败
+func (c *Client) handleDeliveryFailure(message *model.WebSocketMessage, err error) {
+	logger.LogError(err, "消息最终投递失败", logrus.Fields{
+		"message_id":   message.MessageId,
+		"message_type": message.MessageType.String(),
+	})
+}
+
+// sendMessage 发送WebSocket消息：根据消息类型分类后非阻塞地投入对应优先级的
+// 出站队列，实际的conn.WriteMessage调用由writerLoop协程统一执行
 func (c *Client) sendMessage(message *model.WebSocketMessage) {
+	c.outbound.enqueue(message, classifyOutbound(message.MessageType))
+}
+
+// writerLoop 是唯一允许调用conn.WriteMessage的协程：gorilla/websocket明确禁止
+// 并发写入，这里通过单协程消费按优先级分桶的队列来满足该约束。每轮先尝试优先
+// 处理control队列（心跳等），队列为空时再在全部队列间公平select，从而保证
+// control > ack > task-result > metrics的投递顺序。
+func (c *Client) writerLoop() {
+	defer c.wg.Done()
+
+	control := c.outbound.queues[priorityControl]
+	ack := c.outbound.queues[priorityAck]
+	taskResult := c.outbound.queues[priorityTaskResult]
+	metrics := c.outbound.queues[priorityMetrics]
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case message := <-control:
+			c.writeToConn(message)
+			continue
+		default:
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case message := <-control:
+			c.writeToConn(message)
+		case message := <-ack:
+			c.writeToConn(message)
+		case message := <-taskResult:
+			c.writeToConn(message)
+		case message := <-metrics:
+			c.writeToConn(message)
+		}
+	}
+}
+
+// writeToConn 将消息序列化后通过底层连接实际发出
+func (c *Client) writeToConn(message *model.WebSocketMessage) {
 	c.connMu.RLock()
 	conn := c.conn
 	c.connMu.RUnlock()
@@ -900,8 +1381,14 @@ func (c *Client) sendMessage(message *model.WebSocketMessage) {
 		return
 	}
 
+	// 按握手协商出的编解码器对超过阈值的payload做压缩，并附加传输帧头
+	frame := encodeFrame(c.getCodec(), messageData)
+	c.compressionStats.recordOut(len(messageData), len(frame))
+
+	conn.SetWriteDeadline(time.Now().Add(outboundWriteDeadline))
+
 	// 发送二进制消息
-	if err := conn.WriteMessage(websocket.BinaryMessage, messageData); err != nil {
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
 		c.log.WithError(err).Error("发送WebSocket消息失败")
 		c.handleConnectionError(err)
 		return
@@ -911,6 +1398,7 @@ func (c *Client) sendMessage(message *model.WebSocketMessage) {
 		"message_id":   message.MessageId,
 		"message_type": message.MessageType.String(),
 		"message_size": len(messageData),
+		"frame_size":   len(frame),
 	}).Debug("发送WebSocket Protocol Buffer消息成功")
 }
 
@@ -926,6 +1414,10 @@ func (c *Client) handleConnectionError(err error) {
 	c.connected = false
 	c.connMu.Unlock()
 
+	if dropped := c.outbound.drain(); dropped > 0 {
+		c.log.WithField("dropped", dropped).Warn("连接断开，已清空待发送队列中的过期消息")
+	}
+
 	// 触发重连
 	c.triggerReconnect()
 }
@@ -942,10 +1434,46 @@ func (c *Client) generateMessageID() string {
 	return fmt.Sprintf("msg_agent_%d_%s", time.Now().UnixNano(), c.agentID)
 }
 
+// SetSyncHook设置egress/iptables推送配置成功应用后的回调，必须在Start之前
+// 调用；不设置时推送流程不受影响，只是没有外部记账
+func (c *Client) SetSyncHook(hook func(kind string)) {
+	c.syncHook = hook
+}
+
+// notifySync在syncHook非空时调用，kind为"proxy"或"iptables"
+func (c *Client) notifySync(kind string) {
+	if c.syncHook != nil {
+		c.syncHook(kind)
+	}
+}
+
+// statsProviderSetter是DefaultMetricsCollector的Set*Provider方法子集，
+// EBPFCollector内嵌了*DefaultMetricsCollector所以也实现了这个接口——这里用
+// 接口断言而不是断言具体类型*DefaultMetricsCollector，这样不管client.go实际
+// 拿到的是/proc轮询实现还是eBPF实现，都能把任务/队列/压缩这几类与流量采集
+// 后端无关的统计接上
+type statsProviderSetter interface {
+	SetTaskStatsProvider(provider TaskStatsProvider)
+	SetQueueStatsProvider(provider QueueStatsProvider)
+	SetCompressionStatsProvider(provider CompressionStatsProvider)
+}
+
 // SetTaskStatsProvider sets the task stats provider for metrics collection
 func (c *Client) SetTaskStatsProvider() {
-	if collector, ok := c.metricsCollector.(*DefaultMetricsCollector); ok {
+	if collector, ok := c.metricsCollector.(statsProviderSetter); ok {
 		collector.SetTaskStatsProvider(c.taskHandler)
+		collector.SetQueueStatsProvider(c)
+		collector.SetCompressionStatsProvider(c)
 		c.log.Info("Task stats provider set for metrics collection")
 	}
 }
+
+// GetOutboundQueueStats 返回出站优先级队列的发送/丢弃计数，实现QueueStatsProvider接口
+func (c *Client) GetOutboundQueueStats() map[string]int64 {
+	return c.outbound.stats()
+}
+
+// GetCompressionStats 返回出站/入站字节数及压缩率，实现CompressionStatsProvider接口
+func (c *Client) GetCompressionStats() map[string]int64 {
+	return c.compressionStats.snapshot()
+}
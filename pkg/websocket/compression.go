@@ -0,0 +1,233 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec 是消息级压缩编解码器的最小接口，使压缩算法可插拔。新增算法只需实现该
+// 接口并加入supportedCodecs，无需改动帧编解码逻辑
+type Codec interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// compressionThreshold 只有超过该大小的消息才会被压缩，避免对本就很小的心跳/ACK
+// 消息引入不必要的CPU开销和帧头膨胀
+const compressionThreshold = 1024
+
+// frameHeaderSize 传输帧头：1字节codec id + 4字节未压缩长度（大端）
+const frameHeaderSize = 1 + 4
+
+type frameCodecID byte
+
+const (
+	frameCodecNone frameCodecID = iota
+	frameCodecGzip
+	frameCodecZstd
+)
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string                           { return "none" }
+func (noneCodec) Compress(data []byte) ([]byte, error)   { return data, nil }
+func (noneCodec) Decompress(data []byte) ([]byte, error) { return data, nil }
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// zstdCodec是默认编解码器，在压缩率和CPU开销之间取得较好平衡。encoder/decoder
+// 都是并发安全的，可在所有协程间共享同一实例
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec() *zstdCodec {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		panic(fmt.Sprintf("初始化zstd编码器失败: %v", err))
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		panic(fmt.Sprintf("初始化zstd解码器失败: %v", err))
+	}
+	return &zstdCodec{encoder: encoder, decoder: decoder}
+}
+
+func (c *zstdCodec) Name() string { return "zstd" }
+
+func (c *zstdCodec) Compress(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, make([]byte, 0, len(data))), nil
+}
+
+func (c *zstdCodec) Decompress(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
+
+var (
+	codecNone = noneCodec{}
+	codecGzip = gzipCodec{}
+	codecZstd = newZstdCodec()
+)
+
+// supportedCodecs按本地偏好顺序从高到低排列，握手协商和帧解码都以此为准
+var supportedCodecs = []Codec{codecZstd, codecGzip, codecNone}
+
+// supportedCodecNames 用于填充Agent-Compression请求头
+func supportedCodecNames() string {
+	names := make([]string, len(supportedCodecs))
+	for i, c := range supportedCodecs {
+		names[i] = c.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// negotiateCodec解析服务端握手响应头中Agent-Compression字段列出的编解码器，
+// 按本地偏好顺序选出双方都支持的最优编解码器；服务端未声明该头或值无法识别时
+// 退回codecNone，保持与不支持压缩协商的旧服务端兼容
+func negotiateCodec(serverSupported string) Codec {
+	if serverSupported == "" {
+		return codecNone
+	}
+
+	serverSet := make(map[string]bool)
+	for _, name := range strings.Split(serverSupported, ",") {
+		serverSet[strings.TrimSpace(name)] = true
+	}
+
+	for _, c := range supportedCodecs {
+		if serverSet[c.Name()] {
+			return c
+		}
+	}
+	return codecNone
+}
+
+func idForCodec(c Codec) frameCodecID {
+	switch c.Name() {
+	case "zstd":
+		return frameCodecZstd
+	case "gzip":
+		return frameCodecGzip
+	default:
+		return frameCodecNone
+	}
+}
+
+func codecForID(id frameCodecID) Codec {
+	switch id {
+	case frameCodecZstd:
+		return codecZstd
+	case frameCodecGzip:
+		return codecGzip
+	default:
+		return codecNone
+	}
+}
+
+// encodeFrame按需压缩payload并附加传输帧头。消息体小于compressionThreshold，或
+// 压缩后体积没有变小（小/高熵数据常见），都会退回不压缩，以codecNone帧头发出
+func encodeFrame(codec Codec, payload []byte) []byte {
+	if codec.Name() != "none" && len(payload) >= compressionThreshold {
+		if compressed, err := codec.Compress(payload); err == nil && len(compressed) < len(payload) {
+			return buildFrame(idForCodec(codec), compressed, len(payload))
+		}
+	}
+
+	return buildFrame(frameCodecNone, payload, len(payload))
+}
+
+func buildFrame(id frameCodecID, payload []byte, uncompressedSize int) []byte {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	frame[0] = byte(id)
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(uncompressedSize))
+	copy(frame[frameHeaderSize:], payload)
+	return frame
+}
+
+// decodeFrame还原encodeFrame产生的传输帧，返回解压后的原始消息字节
+func decodeFrame(frame []byte) ([]byte, error) {
+	if len(frame) < frameHeaderSize {
+		return nil, fmt.Errorf("帧数据过短: %d字节", len(frame))
+	}
+
+	id := frameCodecID(frame[0])
+	uncompressedSize := binary.BigEndian.Uint32(frame[1:frameHeaderSize])
+	codec := codecForID(id)
+
+	data, err := codec.Decompress(frame[frameHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("使用%s解压消息失败: %w", codec.Name(), err)
+	}
+
+	if uint32(len(data)) != uncompressedSize {
+		return nil, fmt.Errorf("解压后大小不匹配: 期望%d字节，实际%d字节", uncompressedSize, len(data))
+	}
+
+	return data, nil
+}
+
+// compressionStats 统计出站消息的压缩前/压缩后字节数，供MetricsCollector上报
+type compressionStats struct {
+	bytesOutRaw        int64
+	bytesOutCompressed int64
+	bytesIn            int64
+}
+
+func (s *compressionStats) recordOut(raw, compressed int) {
+	atomic.AddInt64(&s.bytesOutRaw, int64(raw))
+	atomic.AddInt64(&s.bytesOutCompressed, int64(compressed))
+}
+
+func (s *compressionStats) recordIn(n int) {
+	atomic.AddInt64(&s.bytesIn, int64(n))
+}
+
+// snapshot返回当前计数，以及压缩率（压缩后/压缩前，1.0表示未节省任何空间）
+func (s *compressionStats) snapshot() map[string]int64 {
+	rawOut := atomic.LoadInt64(&s.bytesOutRaw)
+	compressedOut := atomic.LoadInt64(&s.bytesOutCompressed)
+
+	ratioPercent := int64(100)
+	if rawOut > 0 {
+		ratioPercent = compressedOut * 100 / rawOut
+	}
+
+	return map[string]int64{
+		"bytes_out_raw":        rawOut,
+		"bytes_out_compressed": compressedOut,
+		"bytes_in":             atomic.LoadInt64(&s.bytesIn),
+		"ratio_percent":        ratioPercent,
+	}
+}
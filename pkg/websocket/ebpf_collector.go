@@ -0,0 +1,313 @@
+//go:build linux && ebpf
+
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/moooyo/nspass-proto/generated/model"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/proxy"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// NewMetricsCollector 在`-tags ebpf`的linux构建下，为client.go选择实际使用的
+// MetricsCollector实现：优先尝试NewEBPFCollector加载内核态探针，只有在加载/
+// attach失败（权限不足、内核版本不支持kprobe、BPF对象文件缺失等）时才退回
+// DefaultMetricsCollector的/proc轮询实现。调用方（client.go）始终只依赖
+// MetricsCollector接口，不关心具体选中了哪个后端
+func NewMetricsCollector(proxyManager *proxy.Manager) MetricsCollector {
+	fallback := NewDefaultMetricsCollector(proxyManager)
+
+	collector, err := NewEBPFCollector("", fallback)
+	if err != nil {
+		fallback.log.WithError(err).Warn("加载eBPF流量/连接采集后端失败，回退到/proc轮询实现")
+		return fallback
+	}
+
+	return collector
+}
+
+// defaultBPFObjectPath是NewEBPFCollector未显式指定路径时加载的预编译BPF对象
+// 文件，由项目CI用bpf2go从独立仓库的C源码编译产出，随发行包一起分发，不随
+// agent二进制一起嵌入——这里只负责加载和attach，不负责构建
+const defaultBPFObjectPath = "/usr/local/lib/nspass-agent/ebpf_probes.o"
+
+// flowKey对应BPF端struct flow_key{}的内存布局，字段顺序和大小必须与C源码保持
+// 一致，否则map读出来的key会错位
+type flowKey struct {
+	SrcAddr uint32
+	DstAddr uint32
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+	_       [3]byte
+}
+
+// flowCounters是按5元组聚合的运行计数器，对应flow_counters map的value，由
+// tcp_sendmsg/tcp_cleanup_rbuf/udp_sendmsg几个探测点在内核态原子累加
+type flowCounters struct {
+	BytesSent uint64
+	BytesRecv uint64
+	Packets   uint64
+}
+
+// ebpfObjects对应预编译BPF对象里的程序和map集合
+type ebpfObjects struct {
+	TraceTCPSendmsg       *ebpf.Program `ebpf:"trace_tcp_sendmsg"`
+	TraceTCPCleanupRbuf   *ebpf.Program `ebpf:"trace_tcp_cleanup_rbuf"`
+	TraceInetSockSetState *ebpf.Program `ebpf:"trace_inet_sock_set_state"`
+	TraceUDPSendmsg       *ebpf.Program `ebpf:"trace_udp_sendmsg"`
+
+	PidCounters  *ebpf.Map `ebpf:"pid_counters"`
+	FlowCounters *ebpf.Map `ebpf:"flow_counters"`
+}
+
+func (o *ebpfObjects) Close() error {
+	closers := []interface {
+		Close() error
+	}{o.TraceTCPSendmsg, o.TraceTCPCleanupRbuf, o.TraceInetSockSetState, o.TraceUDPSendmsg, o.PidCounters, o.FlowCounters}
+
+	var firstErr error
+	for _, c := range closers {
+		if c == nil {
+			continue
+		}
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// EBPFCollector用内核tracepoint/kprobe维护的运行计数器实现CollectTrafficMetrics
+// 和CollectConnectionMetrics，作为DefaultMetricsCollector基于/proc轮询方案的
+// 替代：/proc轮询在两次采集间隔之间新建又关闭的短连接完全看不到，
+// net.Connections("tcp")在进程数上千的机器上要挨个读每个进程的/proc/<pid>/fd/*，
+// 开销很大；这里把计数维护在内核map里，读取是O(1)的，既不依赖轮询间隔也不用
+// 遍历/proc。CPU/内存/性能/错误等与流量无关的指标直接复用内嵌的
+// DefaultMetricsCollector，不重新实现
+type EBPFCollector struct {
+	*DefaultMetricsCollector
+
+	objs  ebpfObjects
+	links []link.Link
+
+	mu               sync.Mutex
+	lastFlowSnapshot map[flowKey]flowCounters
+	lastUpdateTime   time.Time
+
+	log *logrus.Entry
+}
+
+// NewEBPFCollector加载objectPath指向的预编译BPF对象、创建map并把四个探测点
+// attach到对应内核tracepoint/kprobe上。objectPath为空时使用
+// defaultBPFObjectPath。任一步失败都会把已经attach的探测点和已加载的map/程序
+// 释放掉再返回错误，不留下半挂起的状态。fallback用于实现本身不处理的系统/
+// 性能/错误指标采集
+func NewEBPFCollector(objectPath string, fallback *DefaultMetricsCollector) (*EBPFCollector, error) {
+	if objectPath == "" {
+		objectPath = defaultBPFObjectPath
+	}
+	log := logger.GetComponentLogger("ebpf-collector")
+
+	spec, err := ebpf.LoadCollectionSpec(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载eBPF对象文件%s失败: %w", objectPath, err)
+	}
+
+	var objs ebpfObjects
+	if err := spec.LoadAndAssign(&objs, nil); err != nil {
+		return nil, fmt.Errorf("加载eBPF程序到内核失败: %w", err)
+	}
+
+	c := &EBPFCollector{
+		DefaultMetricsCollector: fallback,
+		objs:                    objs,
+		lastFlowSnapshot:        make(map[flowKey]flowCounters),
+		lastUpdateTime:          time.Now(),
+		log:                     log,
+	}
+
+	attachments := []struct {
+		name string
+		fn   func() (link.Link, error)
+	}{
+		{"tcp_sendmsg", func() (link.Link, error) {
+			return link.Kprobe("tcp_sendmsg", objs.TraceTCPSendmsg, nil)
+		}},
+		{"tcp_cleanup_rbuf", func() (link.Link, error) {
+			return link.Kprobe("tcp_cleanup_rbuf", objs.TraceTCPCleanupRbuf, nil)
+		}},
+		{"inet_sock_set_state", func() (link.Link, error) {
+			return link.Tracepoint("sock", "inet_sock_set_state", objs.TraceInetSockSetState, nil)
+		}},
+		{"udp_sendmsg", func() (link.Link, error) {
+			return link.Kprobe("udp_sendmsg", objs.TraceUDPSendmsg, nil)
+		}},
+	}
+
+	for _, a := range attachments {
+		l, err := a.fn()
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("attach %s失败: %w", a.name, err)
+		}
+		c.links = append(c.links, l)
+	}
+
+	log.WithField("probes", len(c.links)).Info("eBPF流量/连接统计已启用")
+	return c, nil
+}
+
+// Close detach所有已attach的探测点并释放map/程序的文件描述符，幂等，可以安全
+// 重复调用（比如attach中途失败时的清理路径）
+func (c *EBPFCollector) Close() error {
+	for _, l := range c.links {
+		if l == nil {
+			continue
+		}
+		if err := l.Close(); err != nil {
+			c.log.WithError(err).Warn("detach探测点失败")
+		}
+	}
+	c.links = nil
+	return c.objs.Close()
+}
+
+// snapshotFlows读出flow_counters map里当前的全部条目
+func (c *EBPFCollector) snapshotFlows() (map[flowKey]flowCounters, error) {
+	snapshot := make(map[flowKey]flowCounters)
+
+	var key flowKey
+	var value flowCounters
+	iter := c.objs.FlowCounters.Iterate()
+	for iter.Next(&key, &value) {
+		snapshot[key] = value
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("遍历flow_counters失败: %w", err)
+	}
+	return snapshot, nil
+}
+
+// CollectTrafficMetrics从flow_counters map汇总两次采集之间的字节/包数增量。
+// 和DefaultMetricsCollector基于net.IOCounters的实现不同，这里反映的是内核态
+// 维护的真实运行计数，不会漏掉采集间隔内已经建立又关闭的短连接
+func (c *EBPFCollector) CollectTrafficMetrics() (*model.TrafficMetrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	periodStart := c.lastUpdateTime
+
+	current, err := c.snapshotFlows()
+	if err != nil {
+		return nil, err
+	}
+
+	var bytesIn, bytesOut, packets int64
+	for key, counters := range current {
+		prev := c.lastFlowSnapshot[key]
+		bytesOut += int64(counters.BytesSent - prev.BytesSent)
+		bytesIn += int64(counters.BytesRecv - prev.BytesRecv)
+		packets += int64(counters.Packets - prev.Packets)
+	}
+
+	duration := now.Sub(periodStart).Seconds()
+	var bandwidthIn, bandwidthOut float64
+	if duration > 0 {
+		bandwidthIn = float64(bytesIn) / duration
+		bandwidthOut = float64(bytesOut) / duration
+	}
+
+	c.lastFlowSnapshot = current
+	c.lastUpdateTime = now
+
+	return &model.TrafficMetrics{
+		BytesIn:         bytesIn,
+		BytesOut:        bytesOut,
+		PacketsIn:       packets,
+		PacketsOut:      packets,
+		ConnectionCount: int32(len(current)),
+		BandwidthIn:     bandwidthIn,
+		BandwidthOut:    bandwidthOut,
+		PeriodStart:     timestamppb.New(periodStart),
+		PeriodEnd:       timestamppb.New(now),
+	}, nil
+}
+
+// CollectConnectionMetrics从flow_counters map的当前key集合直接数出活跃连接数
+// 和按远端地址的目标排行，不需要像DefaultMetricsCollector那样对/proc里的每个
+// socket做一次isLocalAddress判断
+func (c *EBPFCollector) CollectConnectionMetrics() (*model.ConnectionMetrics, error) {
+	current, err := c.snapshotFlows()
+	if err != nil {
+		return nil, err
+	}
+
+	connectionByProtocol := make(map[string]int32)
+	destinations := make(map[string]*destinationAccumulator)
+	now := time.Now()
+
+	for key, counters := range current {
+		proto := "tcp"
+		if key.Proto == protoUDP {
+			proto = "udp"
+		}
+		connectionByProtocol[proto]++
+
+		ip := flowKeyDstIP(key)
+		if ip == "" || isLocalAddress(ip) {
+			continue
+		}
+		d, ok := destinations[ip]
+		if !ok {
+			d = &destinationAccumulator{ip: ip}
+			destinations[ip] = d
+		}
+		d.connections++
+		d.bytesIn += counters.BytesRecv
+		d.bytesOut += counters.BytesSent
+		d.lastSeen = now
+	}
+
+	topDestinations := topKDestinations(destinations, 3)
+	topDestinationIPs := make([]string, len(topDestinations))
+	for i, d := range topDestinations {
+		topDestinationIPs[i] = d.IP
+	}
+	topDestinationsGeo := c.resolveTopDestinationsGeo(topDestinationIPs)
+
+	activeConnections := int32(len(current))
+
+	return &model.ConnectionMetrics{
+		ActiveConnections:    activeConnections,
+		TotalConnections:     activeConnections,
+		ConcurrentUsers:      activeConnections,
+		TopDestinations:      topDestinationIPs,
+		ConnectionByProtocol: connectionByProtocol,
+		TopDestinationsGeo:   topDestinationsGeo,
+	}, nil
+}
+
+// protoUDP是flow_key.Proto里表示UDP的取值，和C源码里IPPROTO_UDP保持一致
+const protoUDP = 17
+
+// flowKeyDstIP把flowKey.DstAddr（网络字节序的IPv4地址）格式化成点分十进制
+// 字符串，供destinationAccumulator和isLocalAddress复用现有的字符串判断逻辑
+func flowKeyDstIP(key flowKey) string {
+	if key.DstAddr == 0 {
+		return ""
+	}
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, key.DstAddr)
+	return net.IP(buf).String()
+}
@@ -0,0 +1,228 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/nspass/nspass-agent/generated/model"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// HealthCheckResult是handleHealthCheck单次执行的结构化结果，序列化为JSON写入
+// TaskResult.Output，取代此前fmt.Sprintf("%v", checks)那种调用方无法可靠解析的
+// 调试输出
+type HealthCheckResult struct {
+	Healthy bool              `json:"healthy"`
+	Checks  []HealthCheckItem `json:"checks"`
+}
+
+// HealthCheckItem是单项具体检查（比如磁盘可用空间、某个代理实例、iptables
+// 规则漂移）的结果。Severity取自config.TaskHealthCheckConfig里对应检查组的
+// 配置，只有critical级别的不健康项才会让整个任务判定为TASK_STATUS_FAILED，
+// 其余级别仅体现在Healthy/Checks里供人工查看
+type HealthCheckItem struct {
+	Name        string `json:"name"`
+	Healthy     bool   `json:"healthy"`
+	Severity    string `json:"severity"`
+	Value       string `json:"value"`
+	Threshold   string `json:"threshold"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// handleHealthCheck 处理健康检查任务
+func (h *DefaultTaskHandler) handleHealthCheck(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+	h.log.WithField("task_id", task.TaskId).Info("处理健康检查任务")
+
+	var params model.HealthCheckTaskParams
+	if err := task.Parameters.UnmarshalTo(&params); err != nil {
+		return nil, fmt.Errorf("解析健康检查参数失败: %w", err)
+	}
+
+	var items []HealthCheckItem
+	for _, checkType := range params.CheckTypes {
+		var checkItems []HealthCheckItem
+		switch checkType {
+		case "system":
+			checkItems = h.checkSystemHealth(ctx)
+		case "proxy":
+			checkItems = h.checkProxyHealth(ctx)
+		case "iptables":
+			checkItems = h.checkIPTablesHealth(ctx)
+		default:
+			h.log.WithField("check_type", checkType).Warn("不支持的健康检查类型")
+			continue
+		}
+		items = append(items, checkItems...)
+		progress.Log("info", fmt.Sprintf("%s检查完成", checkType))
+	}
+
+	result := HealthCheckResult{Healthy: true, Checks: items}
+	status := model.TaskStatus_TASK_STATUS_COMPLETED
+	for _, item := range items {
+		if item.Healthy {
+			continue
+		}
+		result.Healthy = false
+		if item.Severity == "critical" {
+			status = model.TaskStatus_TASK_STATUS_FAILED
+		}
+	}
+
+	outputJSON, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("序列化健康检查结果失败: %w", err)
+	}
+
+	return &model.TaskResult{
+		TaskId: "",
+		Status: status,
+		Output: string(outputJSON),
+	}, nil
+}
+
+// checkSystemHealth 按config.TaskHealthCheckConfig.System里配置的阈值检查
+// 磁盘可用空间、负载、内存可用率和agent自身运行时长
+func (h *DefaultTaskHandler) checkSystemHealth(ctx context.Context) []HealthCheckItem {
+	cfg := h.config.Task.HealthCheck.System
+	var items []HealthCheckItem
+
+	if diskInfo, err := disk.Usage("/"); err != nil {
+		h.log.WithError(err).Warn("获取磁盘使用情况失败")
+	} else {
+		freePercent := 100 - diskInfo.UsedPercent
+		items = append(items, HealthCheckItem{
+			Name:        "disk_free_percent",
+			Healthy:     freePercent >= cfg.DiskFreePercentMin,
+			Severity:    cfg.Severity,
+			Value:       fmt.Sprintf("%.1f%%", freePercent),
+			Threshold:   fmt.Sprintf(">= %.1f%%", cfg.DiskFreePercentMin),
+			Remediation: "清理磁盘空间或扩容",
+		})
+	}
+
+	if loadInfo, err := load.Avg(); err != nil {
+		h.log.WithError(err).Warn("获取负载平均值失败")
+	} else {
+		perCPU := loadInfo.Load1 / float64(runtime.NumCPU())
+		items = append(items, HealthCheckItem{
+			Name:        "load_average_per_cpu",
+			Healthy:     perCPU <= cfg.LoadAveragePerCPUMax,
+			Severity:    cfg.Severity,
+			Value:       fmt.Sprintf("%.2f", perCPU),
+			Threshold:   fmt.Sprintf("<= %.2f", cfg.LoadAveragePerCPUMax),
+			Remediation: "排查异常占用CPU的进程或降低负载",
+		})
+	}
+
+	if memInfo, err := mem.VirtualMemory(); err != nil {
+		h.log.WithError(err).Warn("获取内存使用情况失败")
+	} else {
+		freePercent := 100 - memInfo.UsedPercent
+		items = append(items, HealthCheckItem{
+			Name:        "memory_free_percent",
+			Healthy:     freePercent >= cfg.MemoryFreePercentMin,
+			Severity:    cfg.Severity,
+			Value:       fmt.Sprintf("%.1f%%", freePercent),
+			Threshold:   fmt.Sprintf(">= %.1f%%", cfg.MemoryFreePercentMin),
+			Remediation: "排查内存泄漏或增加内存",
+		})
+	}
+
+	if proc, err := process.NewProcess(int32(os.Getpid())); err != nil {
+		h.log.WithError(err).Warn("获取agent进程信息失败")
+	} else if createTimeMs, err := proc.CreateTime(); err != nil {
+		h.log.WithError(err).Warn("获取agent启动时间失败")
+	} else {
+		uptime := time.Since(time.UnixMilli(createTimeMs))
+		items = append(items, HealthCheckItem{
+			Name:        "agent_uptime",
+			Healthy:     uptime >= time.Duration(cfg.MinUptimeSeconds)*time.Second,
+			Severity:    cfg.Severity,
+			Value:       uptime.Round(time.Second).String(),
+			Threshold:   fmt.Sprintf(">= %ds", cfg.MinUptimeSeconds),
+			Remediation: "agent刚重启不久，若频繁重启请检查崩溃日志",
+		})
+	}
+
+	return items
+}
+
+// checkProxyHealth 委托给proxy.Manager.HealthCheckAll逐个代理实例做存活+应用
+// 层探测，本身不重复实现端口拨号/握手逻辑
+func (h *DefaultTaskHandler) checkProxyHealth(ctx context.Context) []HealthCheckItem {
+	severity := h.config.Task.HealthCheck.Proxy.Severity
+
+	if h.proxyManager == nil {
+		return []HealthCheckItem{{
+			Name:        "proxy_manager",
+			Healthy:     false,
+			Severity:    severity,
+			Value:       "未初始化",
+			Threshold:   "非空",
+			Remediation: "检查agent启动流程中proxy.Manager的初始化",
+		}}
+	}
+
+	results := h.proxyManager.HealthCheckAll(ctx)
+	items := make([]HealthCheckItem, 0, len(results))
+	for proxyID, checkErr := range results {
+		item := HealthCheckItem{
+			Name:      fmt.Sprintf("proxy:%s", proxyID),
+			Healthy:   checkErr == nil,
+			Severity:  severity,
+			Value:     "running",
+			Threshold: "进程存活且端口探测/握手成功",
+		}
+		if checkErr != nil {
+			item.Value = checkErr.Error()
+			item.Remediation = "查看代理进程日志，必要时手动重启该代理"
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// checkIPTablesHealth 通过iptablesManager.CheckDrift比较内核里实际生效的规则
+// 与Agent最近一次下发后记在内存里的快照，发现规则被外部改动或丢失的漂移
+func (h *DefaultTaskHandler) checkIPTablesHealth(ctx context.Context) []HealthCheckItem {
+	severity := h.config.Task.HealthCheck.IPTables.Severity
+
+	if h.iptablesManager == nil {
+		return []HealthCheckItem{{
+			Name:        "iptables_manager",
+			Healthy:     false,
+			Severity:    severity,
+			Value:       "未初始化",
+			Threshold:   "非空",
+			Remediation: "检查agent启动流程中iptables.Manager的初始化",
+		}}
+	}
+
+	report, err := h.iptablesManager.CheckDrift()
+	if err != nil {
+		return []HealthCheckItem{{
+			Name:        "iptables_drift",
+			Healthy:     false,
+			Severity:    severity,
+			Value:       err.Error(),
+			Threshold:   "可读取当前规则",
+			Remediation: "检查iptables-save/nft是否可执行、权限是否充足",
+		}}
+	}
+
+	return []HealthCheckItem{{
+		Name:        "iptables_drift",
+		Healthy:     !report.Drifted,
+		Severity:    severity,
+		Value:       fmt.Sprintf("live=%d managed=%d", report.Live, report.Managed),
+		Threshold:   "live == managed",
+		Remediation: "等待下一次Resync自动纠偏，或手动触发sync_rules任务",
+	}}
+}
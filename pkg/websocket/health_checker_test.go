@@ -0,0 +1,45 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+func newTestTaskHandler() *DefaultTaskHandler {
+	return &DefaultTaskHandler{
+		config: &config.Config{},
+		log:    logger.GetComponentLogger("test"),
+	}
+}
+
+func TestCheckProxyHealthNilManager(t *testing.T) {
+	h := newTestTaskHandler()
+	items := h.checkProxyHealth(context.Background())
+	if len(items) != 1 || items[0].Healthy {
+		t.Fatalf("proxyManager为nil时应返回单个unhealthy项, got %+v", items)
+	}
+}
+
+func TestCheckIPTablesHealthNilManager(t *testing.T) {
+	h := newTestTaskHandler()
+	items := h.checkIPTablesHealth(context.Background())
+	if len(items) != 1 || items[0].Healthy {
+		t.Fatalf("iptablesManager为nil时应返回单个unhealthy项, got %+v", items)
+	}
+}
+
+func TestCheckSystemHealthReturnsNamedItems(t *testing.T) {
+	h := newTestTaskHandler()
+	items := h.checkSystemHealth(context.Background())
+	if len(items) == 0 {
+		t.Fatal("checkSystemHealth在正常宿主机上至少应返回一项检查结果")
+	}
+	for _, item := range items {
+		if item.Name == "" {
+			t.Fatal("每一项HealthCheckItem都应有Name")
+		}
+	}
+}
@@ -2,13 +2,18 @@ package websocket
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/moooyo/nspass-proto/generated/model"
+	"github.com/nspass/nspass-agent/pkg/errorbus"
+	"github.com/nspass/nspass-agent/pkg/geoip"
 	"github.com/nspass/nspass-agent/pkg/logger"
 	"github.com/nspass/nspass-agent/pkg/proxy"
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -23,9 +28,12 @@ import (
 
 // DefaultMetricsCollector 默认监控数据收集器
 type DefaultMetricsCollector struct {
-	proxyManager *proxy.Manager
-	taskProvider TaskStatsProvider
-	log          *logrus.Entry
+	proxyManager        *proxy.Manager
+	taskProvider        TaskStatsProvider
+	queueProvider       QueueStatsProvider
+	compressionProvider CompressionStatsProvider
+	geoResolver         *geoip.Resolver
+	log                 *logrus.Entry
 
 	// 缓存上次的数据用于计算差值
 	lastTrafficData  *TrafficData
@@ -38,6 +46,16 @@ type TaskStatsProvider interface {
 	GetTaskStats() map[string]int
 }
 
+// QueueStatsProvider 为出站消息优先级队列提供发送/丢弃计数
+type QueueStatsProvider interface {
+	GetOutboundQueueStats() map[string]int64
+}
+
+// CompressionStatsProvider 提供消息压缩前后的字节数统计，用于观察压缩带来的节省
+type CompressionStatsProvider interface {
+	GetCompressionStats() map[string]int64
+}
+
 // TrafficData 流量数据结构
 type TrafficData struct {
 	BytesIn    int64
@@ -70,6 +88,22 @@ func (c *DefaultMetricsCollector) SetTaskStatsProvider(provider TaskStatsProvide
 	c.taskProvider = provider
 }
 
+// SetQueueStatsProvider 设置出站队列统计提供者
+func (c *DefaultMetricsCollector) SetQueueStatsProvider(provider QueueStatsProvider) {
+	c.queueProvider = provider
+}
+
+// SetCompressionStatsProvider 设置压缩统计提供者
+func (c *DefaultMetricsCollector) SetCompressionStatsProvider(provider CompressionStatsProvider) {
+	c.compressionProvider = provider
+}
+
+// SetGeoResolver 设置TopDestinations地理位置富化所使用的geoip.Resolver，
+// 不设置时CollectConnectionMetrics跳过富化，TopDestinations仍只是IP列表
+func (c *DefaultMetricsCollector) SetGeoResolver(resolver *geoip.Resolver) {
+	c.geoResolver = resolver
+}
+
 // CollectSystemMetrics 收集系统监控数据
 func (c *DefaultMetricsCollector) CollectSystemMetrics() (*model.SystemMetrics, error) {
 	c.log.Debug("收集系统监控数据")
@@ -257,7 +291,8 @@ func (c *DefaultMetricsCollector) CollectConnectionMetrics() (*model.ConnectionM
 	// 统计连接状态
 	var activeConnections, totalConnections, failedConnections int32
 	connectionByProtocol := make(map[string]int32)
-	destinationMap := make(map[string]int)
+	destinations := make(map[string]*destinationAccumulator)
+	now := time.Now()
 
 	for _, conn := range connections {
 		totalConnections++
@@ -274,7 +309,13 @@ func (c *DefaultMetricsCollector) CollectConnectionMetrics() (*model.ConnectionM
 
 		// 统计目标地址（仅统计外部连接）
 		if conn.Raddr.IP != "" && !isLocalAddress(conn.Raddr.IP) {
-			destinationMap[conn.Raddr.IP]++
+			d, ok := destinations[conn.Raddr.IP]
+			if !ok {
+				d = &destinationAccumulator{ip: conn.Raddr.IP}
+				destinations[conn.Raddr.IP] = d
+			}
+			d.connections++
+			d.lastSeen = now
 		}
 	}
 
@@ -284,8 +325,26 @@ func (c *DefaultMetricsCollector) CollectConnectionMetrics() (*model.ConnectionM
 		connectionByProtocol["udp"] = int32(len(udpConnections))
 	}
 
-	// 获取热门目标地址（前3个）
-	topDestinations := getTopDestinations(destinationMap, 3)
+	// 把/proc/net/tcp[6]里tx_queue:rx_queue的瞬时采样按远端IP合入accumulator，
+	// 近似反映各目标地址占用的收发缓冲区大小（不是累计字节数）
+	bytesIn, bytesOut := c.collectDestinationByteSamples()
+	for ip, d := range destinations {
+		d.bytesIn = bytesIn[ip]
+		d.bytesOut = bytesOut[ip]
+	}
+
+	// 用container/heap维护的K大小最小堆求连接数前3的目标地址，取代此前对全量
+	// destinations冒泡排序的O(n²)实现，复杂度降到O(n log K)
+	topDestinations := topKDestinations(destinations, 3)
+	topDestinationIPs := make([]string, len(topDestinations))
+	for i, d := range topDestinations {
+		topDestinationIPs[i] = d.IP
+	}
+
+	// 用geoip.Resolver给热门目标地址附加国家/ASN/ISP信息。TopDestinationsGeo
+	// 是按IP索引的JSON编码字符串（而不是新增repeated消息类型），未配置
+	// geoResolver或查询失败时该IP直接从map里缺席，调用方据此优雅降级
+	topDestinationsGeo := c.resolveTopDestinationsGeo(topDestinationIPs)
 
 	// 计算平均响应时间（简化实现，使用模拟值）
 	averageResponseTime := 50.0 // ms
@@ -308,11 +367,52 @@ func (c *DefaultMetricsCollector) CollectConnectionMetrics() (*model.ConnectionM
 		FailedConnections:    failedConnections,
 		AverageResponseTime:  averageResponseTime,
 		ConcurrentUsers:      concurrentUsers,
-		TopDestinations:      topDestinations,
+		TopDestinations:      topDestinationIPs,
 		ConnectionByProtocol: connectionByProtocol,
+		TopDestinationsGeo:   topDestinationsGeo,
 	}, nil
 }
 
+// destinationGeoInfo是TopDestinationsGeo里每个IP对应的JSON编码内容
+type destinationGeoInfo struct {
+	Continent string `json:"continent,omitempty"`
+	Country   string `json:"country,omitempty"`
+	ASN       string `json:"asn,omitempty"`
+	ISP       string `json:"isp,omitempty"`
+}
+
+// resolveTopDestinationsGeo对destinations里的每个IP做一次geoip查询，未配置
+// geoResolver、查询失败或数据库未就绪的IP直接从返回值里缺席
+func (c *DefaultMetricsCollector) resolveTopDestinationsGeo(destinations []string) map[string]string {
+	if c.geoResolver == nil || len(destinations) == 0 {
+		return nil
+	}
+
+	geo := make(map[string]string, len(destinations))
+	for _, ip := range destinations {
+		loc, err := c.geoResolver.Lookup(ip)
+		if err != nil {
+			continue
+		}
+
+		data, err := json.Marshal(destinationGeoInfo{
+			Continent: loc.Continent,
+			Country:   loc.Country,
+			ASN:       loc.ASN,
+			ISP:       loc.ISP,
+		})
+		if err != nil {
+			continue
+		}
+		geo[ip] = string(data)
+	}
+
+	if len(geo) == 0 {
+		return nil
+	}
+	return geo
+}
+
 // CollectPerformanceMetrics 收集性能监控数据
 func (c *DefaultMetricsCollector) CollectPerformanceMetrics() (*model.PerformanceMetrics, error) {
 	c.log.Debug("收集性能监控数据")
@@ -354,6 +454,20 @@ func (c *DefaultMetricsCollector) CollectPerformanceMetrics() (*model.Performanc
 		c.log.WithField("task_stats", taskStats).Debug("Added task statistics to performance metrics")
 	}
 
+	// 获取出站队列统计信息（各优先级的发送/丢弃计数）
+	if c.queueProvider != nil {
+		for name, count := range c.queueProvider.GetOutboundQueueStats() {
+			customMetrics["queue_"+name] = float64(count)
+		}
+	}
+
+	// 获取压缩统计信息（压缩前后字节数及压缩率）
+	if c.compressionProvider != nil {
+		for name, count := range c.compressionProvider.GetCompressionStats() {
+			customMetrics["compression_"+name] = float64(count)
+		}
+	}
+
 	return &model.PerformanceMetrics{
 		ResponseTime:  50.0,  // 50ms 模拟值
 		Throughput:    100.0, // 100 requests/sec 模拟值
@@ -369,21 +483,184 @@ func (c *DefaultMetricsCollector) CollectPerformanceMetrics() (*model.Performanc
 func (c *DefaultMetricsCollector) CollectErrorMetrics() (*model.ErrorMetrics, error) {
 	c.log.Debug("收集错误监控数据")
 
-	// 这里应该从实际的错误监控中获取数据
-	// 简化实现，返回模拟数据
-
-	return &model.ErrorMetrics{
-		TotalErrors:    10,
-		CriticalErrors: 2,
-		WarningErrors:  5,
-		ErrorTypes:     []string{"connection_error", "timeout_error", "config_error"},
-		ErrorCountByType: map[string]int32{
-			"connection_error": 3,
-			"timeout_error":    4,
-			"config_error":     3,
-		},
-		LastErrorTime: timestamppb.New(time.Now().Add(-time.Hour)), // 1小时前
-	}, nil
+	events := errorbus.Global().Snapshot()
+
+	var totalErrors, criticalErrors, warningErrors int32
+	errorCountByType := make(map[string]int32)
+	var lastErrorTime time.Time
+
+	for _, e := range events {
+		totalErrors += int32(e.Count)
+		switch e.Severity {
+		case errorbus.SeverityCritical:
+			criticalErrors += int32(e.Count)
+		default:
+			warningErrors += int32(e.Count)
+		}
+		errorCountByType[e.Type] += int32(e.Count)
+		if e.Timestamp.After(lastErrorTime) {
+			lastErrorTime = e.Timestamp
+		}
+	}
+
+	errorTypes := make([]string, 0, len(errorCountByType))
+	for t := range errorCountByType {
+		errorTypes = append(errorTypes, t)
+	}
+	sort.Strings(errorTypes)
+
+	metrics := &model.ErrorMetrics{
+		TotalErrors:      totalErrors,
+		CriticalErrors:   criticalErrors,
+		WarningErrors:    warningErrors,
+		ErrorTypes:       errorTypes,
+		ErrorCountByType: errorCountByType,
+	}
+	if !lastErrorTime.IsZero() {
+		metrics.LastErrorTime = timestamppb.New(lastErrorTime)
+	}
+	return metrics, nil
+}
+
+// CollectTCPConnectionsByState 按状态（ESTABLISHED/TIME_WAIT/CLOSE_WAIT等）
+// 统计TCP连接数，供MetricsExporter渲染成按state分类的Prometheus指标；
+// CollectConnectionMetrics里的ActiveConnections只关心ESTABLISHED这一种状态，
+// 这里提供完整的状态分布
+func (c *DefaultMetricsCollector) CollectTCPConnectionsByState() (map[string]int32, error) {
+	connections, err := net.Connections("tcp")
+	if err != nil {
+		return nil, fmt.Errorf("获取TCP连接失败: %w", err)
+	}
+
+	byState := make(map[string]int32)
+	for _, conn := range connections {
+		byState[conn.Status]++
+	}
+	return byState, nil
+}
+
+// ProcessMetrics是单个被Agent管理的代理进程（含其全部子进程）的资源占用快照，
+// 用于取代CollectSystemMetrics里只有len(processes)的ProcessCount——那只反映
+// 全机进程总数，既不能定位是哪个代理，也看不出是否失控。控制面可以按ProxyID
+// 比对阈值，发现失控的sing-box/trojan实例后通过既有websocket命令通道下发重启
+type ProcessMetrics struct {
+	ProxyID     string
+	Pid         int
+	ChildCount  int
+	CPUPercent  float64
+	RSSBytes    uint64
+	OpenFDs     int32
+	ThreadCount int32
+	TCPSockets  int
+	UDPSockets  int
+	// IOReadBytes/IOWriteBytes来自/proc/<pid>/io的read_bytes/write_bytes，
+	// Linux并不按网络/磁盘区分进程级I/O计数器，这里统计的是进程整体I/O，
+	// 对以网络转发为主业的代理进程而言可以近似当作网络收发量
+	IOReadBytes  uint64
+	IOWriteBytes uint64
+	CgroupPath   string
+}
+
+// CollectProcessMetrics按proxy.Manager当前持有的每个代理PID，walk其进程树
+// （部分后端以accelerator/鉴权插件等子进程形式运行），把CPU/内存/FD/线程数/
+// IO字节数/TCP与UDP套接字数汇总成一条per-proxy记录。单个代理或其进程树读取
+// 失败只记录日志并跳过该代理，不影响其余代理的采集
+func (c *DefaultMetricsCollector) CollectProcessMetrics() ([]ProcessMetrics, error) {
+	if c.proxyManager == nil {
+		return nil, fmt.Errorf("proxyManager未初始化")
+	}
+
+	pids := c.proxyManager.ProxyPids()
+	if len(pids) == 0 {
+		return nil, nil
+	}
+
+	results := make([]ProcessMetrics, 0, len(pids))
+	for proxyID, pid := range pids {
+		metrics, err := collectProcessTreeMetrics(proxyID, pid)
+		if err != nil {
+			c.log.WithError(err).WithFields(logrus.Fields{
+				"proxy_id": proxyID,
+				"pid":      pid,
+			}).Warn("采集代理进程资源占用失败")
+			continue
+		}
+		results = append(results, *metrics)
+	}
+	return results, nil
+}
+
+// collectProcessTreeMetrics汇总pid本身及其全部子进程的资源占用。单个子进程
+// 的某一项指标读取失败（比如进程在采集过程中退出）只跳过那一项，不影响同一
+// 进程树里其余指标和其余子进程的统计
+func collectProcessTreeMetrics(proxyID string, pid int) (*ProcessMetrics, error) {
+	root, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return nil, fmt.Errorf("打开进程%d失败: %w", pid, err)
+	}
+
+	tree := []*process.Process{root}
+	if children, err := root.Children(); err == nil {
+		tree = append(tree, children...)
+	}
+
+	metrics := &ProcessMetrics{
+		ProxyID:    proxyID,
+		Pid:        pid,
+		ChildCount: len(tree) - 1,
+		CgroupPath: readCgroupPath(pid),
+	}
+
+	for _, p := range tree {
+		if cpuPercent, err := p.Percent(0); err == nil {
+			metrics.CPUPercent += cpuPercent
+		}
+		if memInfo, err := p.MemoryInfo(); err == nil && memInfo != nil {
+			metrics.RSSBytes += memInfo.RSS
+		}
+		if fds, err := p.NumFDs(); err == nil {
+			metrics.OpenFDs += fds
+		}
+		if threads, err := p.NumThreads(); err == nil {
+			metrics.ThreadCount += threads
+		}
+		if conns, err := p.Connections(); err == nil {
+			for _, conn := range conns {
+				switch conn.Type {
+				case syscall.SOCK_STREAM:
+					metrics.TCPSockets++
+				case syscall.SOCK_DGRAM:
+					metrics.UDPSockets++
+				}
+			}
+		}
+		if ioCounters, err := p.IOCounters(); err == nil && ioCounters != nil {
+			metrics.IOReadBytes += ioCounters.ReadBytes
+			metrics.IOWriteBytes += ioCounters.WriteBytes
+		}
+	}
+
+	return metrics, nil
+}
+
+// readCgroupPath解析/proc/<pid>/cgroup，返回该进程所属的cgroup路径：cgroup v2
+// 下只有一条unified层级记录，v1下取第一条非空记录作为代表。读取失败（比如
+// 内核未启用cgroup或进程已退出）返回空字符串，调用方按空值处理，不中断整体采集
+func readCgroupPath(pid int) string {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) == 3 && parts[2] != "" {
+			return parts[2]
+		}
+	}
+	return ""
 }
 
 // isLocalAddress 检查IP地址是否为本地地址
@@ -417,41 +694,6 @@ func isLocalAddress(ip string) bool {
 	return false
 }
 
-// getTopDestinations 获取前N个热门目标地址
-func getTopDestinations(destinations map[string]int, topN int) []string {
-	type destination struct {
-		ip    string
-		count int
-	}
-
-	var dests []destination
-	for ip, count := range destinations {
-		dests = append(dests, destination{ip: ip, count: count})
-	}
-
-	// 按连接数排序
-	for i := 0; i < len(dests)-1; i++ {
-		for j := i + 1; j < len(dests); j++ {
-			if dests[j].count > dests[i].count {
-				dests[i], dests[j] = dests[j], dests[i]
-			}
-		}
-	}
-
-	// 返回前N个
-	var result []string
-	limit := topN
-	if len(dests) < limit {
-		limit = len(dests)
-	}
-
-	for i := 0; i < limit; i++ {
-		result = append(result, dests[i].ip)
-	}
-
-	return result
-}
-
 // getTCPConnectionCount 获取TCP连接数
 func (c *DefaultMetricsCollector) getTCPConnectionCount() (int, error) {
 	connections, err := net.Connections("tcp")
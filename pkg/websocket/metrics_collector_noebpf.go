@@ -0,0 +1,12 @@
+//go:build !(linux && ebpf)
+
+package websocket
+
+import "github.com/nspass/nspass-agent/pkg/proxy"
+
+// NewMetricsCollector 非`-tags ebpf`构建下直接返回DefaultMetricsCollector；
+// eBPF采集后端只在linux且带ebpf构建标签时可用，参见ebpf_collector.go里同名
+// 函数的选择逻辑
+func NewMetricsCollector(proxyManager *proxy.Manager) MetricsCollector {
+	return NewDefaultMetricsCollector(proxyManager)
+}
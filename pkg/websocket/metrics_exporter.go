@@ -0,0 +1,344 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+)
+
+// MetricsRegistry收集一批带稳定标签集的指标样本，并渲染成Prometheus文本
+// exposition格式（或经内容协商后的OpenMetrics格式）。它只是一次性渲染用的
+// 累加器，不是长期持有状态的单例——每次/metrics请求都会重新从
+// DefaultMetricsCollector取一份快照、新建一个MetricsRegistry
+type MetricsRegistry struct {
+	families []metricFamily
+}
+
+type metricKind string
+
+const (
+	metricGauge   metricKind = "gauge"
+	metricCounter metricKind = "counter"
+)
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    metricKind
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// NewMetricsRegistry 创建一个空的MetricsRegistry
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+// Gauge 注册一个不带标签的瞬时值指标
+func (r *MetricsRegistry) Gauge(name, help string, value float64) {
+	r.GaugeVec(name, help, nil, value)
+}
+
+// GaugeVec 注册一个带标签的瞬时值指标
+func (r *MetricsRegistry) GaugeVec(name, help string, labels map[string]string, value float64) {
+	r.addSample(name, help, metricGauge, labels, value)
+}
+
+// Counter 注册一个不带标签的单调递增指标
+func (r *MetricsRegistry) Counter(name, help string, value float64) {
+	r.CounterVec(name, help, nil, value)
+}
+
+// CounterVec 注册一个带标签的单调递增指标
+func (r *MetricsRegistry) CounterVec(name, help string, labels map[string]string, value float64) {
+	r.addSample(name, help, metricCounter, labels, value)
+}
+
+func (r *MetricsRegistry) addSample(name, help string, kind metricKind, labels map[string]string, value float64) {
+	for i := range r.families {
+		if r.families[i].name == name {
+			r.families[i].samples = append(r.families[i].samples, metricSample{labels: labels, value: value})
+			return
+		}
+	}
+	r.families = append(r.families, metricFamily{
+		name: name,
+		help: help,
+		kind: kind,
+		samples: []metricSample{
+			{labels: labels, value: value},
+		},
+	})
+}
+
+// Render按family注册顺序渲染全部指标。openMetrics为true时追加OpenMetrics
+// exposition格式要求的末尾"# EOF"行，其余部分两种格式相同
+func (r *MetricsRegistry) Render(openMetrics bool) string {
+	var b strings.Builder
+	for _, f := range r.families {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.kind)
+		for _, s := range f.samples {
+			b.WriteString(f.name)
+			if len(s.labels) > 0 {
+				b.WriteString("{")
+				b.WriteString(renderLabels(s.labels))
+				b.WriteString("}")
+			}
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+			b.WriteString("\n")
+		}
+	}
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+	return b.String()
+}
+
+// renderLabels按key排序后拼成"k1=\"v1\",k2=\"v2\""，保证同一指标每次渲染的
+// 标签顺序稳定，便于抓取端做文本diff
+func renderLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ExportableMetricsCollector是MetricsExporter渲染/metrics端点需要的全部
+// 采集方法，比client.go的MetricsCollector接口多了CollectTCPConnectionsByState
+// 和CollectProcessMetrics两个只有Prometheus端点用得到的方法。
+// *DefaultMetricsCollector和（内嵌了它的）*EBPFCollector都满足这个接口，
+// 所以不管NewMetricsCollector选中了哪个采集后端，都能原样传给
+// NewMetricsExporter
+type ExportableMetricsCollector interface {
+	MetricsCollector
+	CollectTCPConnectionsByState() (map[string]int32, error)
+	CollectProcessMetrics() ([]ProcessMetrics, error)
+}
+
+// MetricsExporter 把ExportableMetricsCollector采集到的数据以Prometheus文本
+// exposition格式通过本地HTTP `/metrics`端点暴露出去，供Prometheus/
+// node_exporter风格的抓取式监控接入，作为WebSocket主动推送之外的另一条
+// 观测路径
+type MetricsExporter struct {
+	collector  ExportableMetricsCollector
+	listenAddr string
+	authToken  string
+	httpServer *http.Server
+	listener   net.Listener
+}
+
+// NewMetricsExporter 创建MetricsExporter，listenAddr为空时返回nil（调用方
+// 应视为禁用）
+func NewMetricsExporter(collector ExportableMetricsCollector, listenAddr, authToken string) *MetricsExporter {
+	if listenAddr == "" {
+		return nil
+	}
+
+	e := &MetricsExporter{
+		collector:  collector,
+		listenAddr: listenAddr,
+		authToken:  authToken,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.handleMetrics)
+	e.httpServer = &http.Server{Handler: mux}
+
+	return e
+}
+
+// Start 监听listenAddr并在后台处理抓取请求
+func (e *MetricsExporter) Start() error {
+	log := logger.GetComponentLogger("metrics-exporter")
+
+	listener, err := net.Listen("tcp", e.listenAddr)
+	if err != nil {
+		return fmt.Errorf("监听metrics端点失败: %w", err)
+	}
+	e.listener = listener
+
+	log.WithField("listen_addr", e.listenAddr).Info("Prometheus抓取端点已启动")
+
+	go func() {
+		if err := e.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.LogError(err, "Prometheus抓取端点异常退出", nil)
+		}
+	}()
+
+	return nil
+}
+
+// Stop 关闭HTTP服务
+func (e *MetricsExporter) Stop() error {
+	if e.httpServer == nil {
+		return nil
+	}
+	return e.httpServer.Shutdown(context.Background())
+}
+
+func (e *MetricsExporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if e.authToken != "" && !checkBearerToken(r, e.authToken) {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	registry, err := e.collect()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("采集监控数据失败: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	openMetrics := wantsOpenMetrics(r.Header.Get("Accept"))
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	w.Write([]byte(registry.Render(openMetrics)))
+}
+
+// checkBearerToken校验Authorization: Bearer <token>请求头
+func checkBearerToken(r *http.Request, expected string) bool {
+	auth := r.Header.Get("Authorization")
+	return strings.TrimPrefix(auth, "Bearer ") == expected && auth != ""
+}
+
+// wantsOpenMetrics按Accept请求头协商是否以OpenMetrics格式响应，抓取端（如
+// Prometheus 2.x+）会在Accept里带上application/openmetrics-text
+func wantsOpenMetrics(accept string) bool {
+	return strings.Contains(accept, "application/openmetrics-text")
+}
+
+// collect调用DefaultMetricsCollector现有的Collect*Metrics方法取一份快照，
+// 把每个字段注册成MetricsRegistry里带稳定标签集的gauge/counter，字段含义与
+// websocket.outbound.go推送给服务端的同一套数据完全一致，只是换了一种暴露
+// 方式
+func (e *MetricsExporter) collect() (*MetricsRegistry, error) {
+	registry := NewMetricsRegistry()
+
+	sys, err := e.collector.CollectSystemMetrics()
+	if err != nil {
+		return nil, fmt.Errorf("采集系统监控数据失败: %w", err)
+	}
+	registry.Gauge("nspass_agent_cpu_usage_percent", "CPU使用率百分比", sys.CpuUsage)
+	registry.Gauge("nspass_agent_memory_usage_percent", "内存使用率百分比", sys.MemoryUsage)
+	registry.Gauge("nspass_agent_memory_total_bytes", "内存总量（字节）", float64(sys.MemoryTotal))
+	registry.Gauge("nspass_agent_memory_used_bytes", "已用内存（字节）", float64(sys.MemoryUsed))
+	registry.Gauge("nspass_agent_swap_total_bytes", "SWAP总量（字节）", float64(sys.SwapTotal))
+	registry.Gauge("nspass_agent_swap_used_bytes", "已用SWAP（字节）", float64(sys.SwapUsed))
+	registry.Gauge("nspass_agent_disk_usage_percent", "磁盘使用率百分比", sys.DiskUsage)
+	registry.Gauge("nspass_agent_disk_total_bytes", "磁盘总量（字节）", float64(sys.DiskTotal))
+	registry.Gauge("nspass_agent_disk_used_bytes", "已用磁盘（字节）", float64(sys.DiskUsed))
+	registry.Gauge("nspass_agent_load_average", "1分钟平均负载", sys.LoadAverage)
+	registry.Gauge("nspass_agent_uptime_seconds", "Agent运行时长（秒）", float64(sys.Uptime))
+	registry.Gauge("nspass_agent_process_count", "系统进程总数", float64(sys.ProcessCount))
+
+	traffic, err := e.collector.CollectTrafficMetrics()
+	if err != nil {
+		return nil, fmt.Errorf("采集流量监控数据失败: %w", err)
+	}
+	registry.CounterVec("nspass_agent_traffic_bytes_total", "累计流量字节数", map[string]string{"direction": "in"}, float64(traffic.BytesIn))
+	registry.CounterVec("nspass_agent_traffic_bytes_total", "累计流量字节数", map[string]string{"direction": "out"}, float64(traffic.BytesOut))
+	registry.CounterVec("nspass_agent_traffic_packets_total", "累计流量包数", map[string]string{"direction": "in"}, float64(traffic.PacketsIn))
+	registry.CounterVec("nspass_agent_traffic_packets_total", "累计流量包数", map[string]string{"direction": "out"}, float64(traffic.PacketsOut))
+	registry.GaugeVec("nspass_agent_bandwidth_bytes_per_second", "瞬时带宽（字节/秒）", map[string]string{"direction": "in"}, traffic.BandwidthIn)
+	registry.GaugeVec("nspass_agent_bandwidth_bytes_per_second", "瞬时带宽（字节/秒）", map[string]string{"direction": "out"}, traffic.BandwidthOut)
+
+	conn, err := e.collector.CollectConnectionMetrics()
+	if err != nil {
+		return nil, fmt.Errorf("采集连接监控数据失败: %w", err)
+	}
+	registry.Gauge("nspass_agent_connections_active", "活跃连接数", float64(conn.ActiveConnections))
+	registry.Gauge("nspass_agent_connections_total", "连接总数", float64(conn.TotalConnections))
+	registry.Gauge("nspass_agent_connections_failed", "失败连接数", float64(conn.FailedConnections))
+	registry.Gauge("nspass_agent_concurrent_users", "并发用户数", float64(conn.ConcurrentUsers))
+	for protocol, count := range conn.ConnectionByProtocol {
+		registry.GaugeVec("nspass_agent_connections_by_protocol", "按协议分类的连接数", map[string]string{"protocol": protocol}, float64(count))
+	}
+
+	byState, err := e.collector.CollectTCPConnectionsByState()
+	if err != nil {
+		return nil, fmt.Errorf("采集TCP连接状态分布失败: %w", err)
+	}
+	for state, count := range byState {
+		registry.GaugeVec("nspass_agent_tcp_connections_by_state", "按状态分类的TCP连接数", map[string]string{"state": state}, float64(count))
+	}
+
+	perf, err := e.collector.CollectPerformanceMetrics()
+	if err != nil {
+		return nil, fmt.Errorf("采集性能监控数据失败: %w", err)
+	}
+	registry.Gauge("nspass_agent_response_time_ms", "平均响应时间（毫秒）", perf.ResponseTime)
+	registry.Gauge("nspass_agent_throughput", "吞吐量（请求/秒）", perf.Throughput)
+	registry.Gauge("nspass_agent_error_rate", "错误率", perf.ErrorRate)
+	registry.Gauge("nspass_agent_queue_size", "待处理队列长度", float64(perf.QueueSize))
+	for name, value := range perf.CustomMetrics {
+		switch {
+		case name == "goroutines":
+			registry.Gauge("nspass_agent_goroutines", "当前goroutine数量", value)
+		case name == "gc_cycles":
+			registry.Counter("nspass_agent_gc_cycles_total", "累计GC次数", value)
+		case name == "heap_alloc":
+			registry.Gauge("nspass_agent_heap_alloc_bytes", "堆已分配字节数", value)
+		case name == "heap_sys":
+			registry.Gauge("nspass_agent_heap_sys_bytes", "堆向系统申请的字节数", value)
+		case strings.HasPrefix(name, "task_"):
+			registry.GaugeVec("nspass_agent_task_count", "按状态分类的任务数量", map[string]string{"status": strings.TrimPrefix(name, "task_")}, value)
+		case strings.HasPrefix(name, "queue_"):
+			registry.GaugeVec("nspass_agent_outbound_queue_count", "按优先级分类的出站队列发送/丢弃计数", map[string]string{"queue": strings.TrimPrefix(name, "queue_")}, value)
+		case strings.HasPrefix(name, "compression_"):
+			registry.GaugeVec("nspass_agent_compression_bytes", "压缩前后字节数统计", map[string]string{"metric": strings.TrimPrefix(name, "compression_")}, value)
+		}
+	}
+
+	errs, err := e.collector.CollectErrorMetrics()
+	if err != nil {
+		return nil, fmt.Errorf("采集错误监控数据失败: %w", err)
+	}
+	registry.Counter("nspass_agent_errors_total", "累计错误总数", float64(errs.TotalErrors))
+	registry.Counter("nspass_agent_errors_critical_total", "累计严重错误数", float64(errs.CriticalErrors))
+	registry.Counter("nspass_agent_errors_warning_total", "累计警告级错误数", float64(errs.WarningErrors))
+	for errType, count := range errs.ErrorCountByType {
+		registry.CounterVec("nspass_agent_errors_by_type_total", "按类型分类的累计错误数", map[string]string{"type": errType}, float64(count))
+	}
+
+	// 按代理维度的进程资源占用，proxyManager未配置或全部代理都尚未启动时
+	// 只是没有数据可报，不应该让整个抓取失败
+	if procMetrics, err := e.collector.CollectProcessMetrics(); err != nil {
+		logger.LogError(err, "采集代理进程资源占用失败", nil)
+	} else {
+		for _, p := range procMetrics {
+			labels := map[string]string{"proxy_id": p.ProxyID}
+			registry.GaugeVec("nspass_agent_proxy_cpu_percent", "代理进程树CPU使用率百分比", labels, p.CPUPercent)
+			registry.GaugeVec("nspass_agent_proxy_memory_rss_bytes", "代理进程树RSS内存占用（字节）", labels, float64(p.RSSBytes))
+			registry.GaugeVec("nspass_agent_proxy_open_fds", "代理进程树打开的文件描述符数", labels, float64(p.OpenFDs))
+			registry.GaugeVec("nspass_agent_proxy_thread_count", "代理进程树线程数", labels, float64(p.ThreadCount))
+			registry.GaugeVec("nspass_agent_proxy_tcp_sockets", "代理进程树TCP套接字数", labels, float64(p.TCPSockets))
+			registry.GaugeVec("nspass_agent_proxy_udp_sockets", "代理进程树UDP套接字数", labels, float64(p.UDPSockets))
+			registry.GaugeVec("nspass_agent_proxy_io_read_bytes", "代理进程树累计IO读取字节数", labels, float64(p.IOReadBytes))
+			registry.GaugeVec("nspass_agent_proxy_io_write_bytes", "代理进程树累计IO写入字节数", labels, float64(p.IOWriteBytes))
+			registry.GaugeVec("nspass_agent_proxy_child_count", "代理进程树子进程数", labels, float64(p.ChildCount))
+		}
+	}
+
+	return registry, nil
+}
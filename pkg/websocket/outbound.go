@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/moooyo/nspass-proto/generated/model"
+)
+
+// outboundPriority 描述出站消息的优先级类别，数值越小优先级越高
+type outboundPriority int
+
+const (
+	priorityControl outboundPriority = iota
+	priorityAck
+	priorityTaskResult
+	priorityMetrics
+	priorityCount
+)
+
+const (
+	controlQueueSize    = 16
+	ackQueueSize        = 64
+	taskResultQueueSize = 64
+	metricsQueueSize    = 32
+
+	// outboundWriteDeadline 单次WriteMessage允许的最长耗时，避免一次慢写卡住整个写协程
+	outboundWriteDeadline = 10 * time.Second
+)
+
+var outboundPriorityNames = [priorityCount]string{"control", "ack", "task_result", "metrics"}
+
+// classifyOutbound 根据消息类型判断其优先级类别。
+//
+// ACK消息类型同时承载心跳确认、配置确认和任务结果确认，在当前协议下无法进一步
+// 细分，因此统一归入priorityAck——其优先级仍高于metrics，满足"控制/确认优先于
+// 监控上报"的排序要求。
+func classifyOutbound(msgType model.WebSocketMessageType) outboundPriority {
+	switch msgType {
+	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_AGENT_TYPE_HEARTBEAT:
+		return priorityControl
+	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_AGENT_TYPE_ACK:
+		return priorityAck
+	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_AGENT_TYPE_METRICS:
+		return priorityMetrics
+	case model.WebSocketMessageType_WEBSOCKET_MESSAGE_AGENT_TYPE_TASK_PROGRESS:
+		return priorityTaskResult
+	default:
+		return priorityTaskResult
+	}
+}
+
+// outboundQueues 是按优先级分桶的有界发送队列，由单个写协程消费，避免多个
+// goroutine并发调用gorilla/websocket明确禁止的并发WriteMessage。
+type outboundQueues struct {
+	queues [priorityCount]chan *model.WebSocketMessage
+
+	mu      sync.Mutex
+	sent    [priorityCount]int64
+	dropped [priorityCount]int64
+}
+
+func newOutboundQueues() *outboundQueues {
+	return &outboundQueues{
+		queues: [priorityCount]chan *model.WebSocketMessage{
+			make(chan *model.WebSocketMessage, controlQueueSize),
+			make(chan *model.WebSocketMessage, ackQueueSize),
+			make(chan *model.WebSocketMessage, taskResultQueueSize),
+			make(chan *model.WebSocketMessage, metricsQueueSize),
+		},
+	}
+}
+
+// enqueue 将消息放入对应优先级的队列，永不阻塞调用方。metrics类队列满时丢弃队列中
+// 最旧的一条腾出空间（drop-oldest），其余类别队列满时直接丢弃本次消息。
+func (q *outboundQueues) enqueue(message *model.WebSocketMessage, priority outboundPriority) {
+	ch := q.queues[priority]
+
+	select {
+	case ch <- message:
+		q.mu.Lock()
+		q.sent[priority]++
+		q.mu.Unlock()
+		return
+	default:
+	}
+
+	if priority == priorityMetrics {
+		select {
+		case <-ch:
+			q.mu.Lock()
+			q.dropped[priority]++
+			q.mu.Unlock()
+		default:
+		}
+
+		select {
+		case ch <- message:
+			q.mu.Lock()
+			q.sent[priority]++
+			q.mu.Unlock()
+			return
+		default:
+		}
+	}
+
+	q.mu.Lock()
+	q.dropped[priority]++
+	q.mu.Unlock()
+}
+
+// drain 清空所有队列中尚未发送的消息，在连接断开时调用，防止写协程对着失效连接做
+// 无意义的写入尝试，也避免断线期间队列无限堆积过期数据。返回被丢弃的消息总数。
+func (q *outboundQueues) drain() int {
+	total := 0
+	for p := range q.queues {
+		total += q.drainChannel(outboundPriority(p))
+	}
+	return total
+}
+
+func (q *outboundQueues) drainChannel(priority outboundPriority) int {
+	count := 0
+	for {
+		select {
+		case <-q.queues[priority]:
+			count++
+		default:
+			if count > 0 {
+				q.mu.Lock()
+				q.dropped[priority] += int64(count)
+				q.mu.Unlock()
+			}
+			return count
+		}
+	}
+}
+
+// stats 返回各优先级队列的发送/丢弃计数快照，供MetricsCollector上报
+func (q *outboundQueues) stats() map[string]int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result := make(map[string]int64, len(outboundPriorityNames)*2)
+	for i, name := range outboundPriorityNames {
+		result[name+"_sent"] = q.sent[i]
+		result[name+"_dropped"] = q.dropped[i]
+	}
+	return result
+}
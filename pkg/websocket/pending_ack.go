@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/moooyo/nspass-proto/generated/model"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ackInitialBackoff = time.Second
+	ackMaxBackoff     = 30 * time.Second
+	ackMaxAttempts    = 5
+	ackTrackerMaxSize = 1024
+)
+
+// pendingAck 记录一条等待服务端确认的出站消息
+type pendingAck struct {
+	message  *model.WebSocketMessage
+	sentAt   time.Time
+	attempts int
+	timer    *time.Timer
+	onFail   func(*model.WebSocketMessage, error)
+}
+
+// PendingAckTracker 为需要可靠投递保证的出站消息维护"待确认"状态：超时未收到ACK时
+// 按指数退避重试，达到最大尝试次数后放弃并回调onFail。
+//
+// 退避策略借鉴client-go URLBackoff的思路：base 1秒，每次翻倍，上限30秒，并叠加
+// 随机抖动，避免大量消息同时超时重试造成突发流量。为防止一个异常/失联的服务端
+// 导致跟踪表无限增长，超过ackTrackerMaxSize时会淘汰最早发出的一条记录。
+type PendingAckTracker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingAck
+	send    func(*model.WebSocketMessage)
+	log     *logrus.Entry
+}
+
+// NewPendingAckTracker 创建一个待确认消息跟踪器，send用于重试时实际执行网络发送
+func NewPendingAckTracker(send func(*model.WebSocketMessage)) *PendingAckTracker {
+	return &PendingAckTracker{
+		pending: make(map[string]*pendingAck),
+		send:    send,
+		log:     logger.GetComponentLogger("pending-ack"),
+	}
+}
+
+// Track 登记一条需要可靠投递的消息；如果在超时前未调用Complete，会按退避策略自动
+// 重发，重试耗尽后调用onFail回调通知最终失败
+func (t *PendingAckTracker) Track(message *model.WebSocketMessage, onFail func(*model.WebSocketMessage, error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.pending) >= ackTrackerMaxSize {
+		t.evictOldestLocked()
+	}
+
+	messageID := message.MessageId
+	entry := &pendingAck{
+		message:  message,
+		sentAt:   time.Now(),
+		attempts: 1,
+		onFail:   onFail,
+	}
+	entry.timer = time.AfterFunc(nextBackoff(0), func() {
+		t.handleTimeout(messageID)
+	})
+	t.pending[messageID] = entry
+}
+
+// Complete 根据服务端ACK消息携带的CorrelationId结束一次投递跟踪
+func (t *PendingAckTracker) Complete(correlationID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.pending[correlationID]
+	if !ok {
+		return
+	}
+	entry.timer.Stop()
+	delete(t.pending, correlationID)
+
+	t.log.WithFields(logrus.Fields{
+		"message_id": correlationID,
+		"attempts":   entry.attempts,
+		"latency":    time.Since(entry.sentAt),
+	}).Debug("消息已确认")
+}
+
+// handleTimeout 在一次等待超时后触发：未达最大尝试次数则重发并安排下一次超时，
+// 否则放弃投递并回调onFail
+func (t *PendingAckTracker) handleTimeout(messageID string) {
+	t.mu.Lock()
+	entry, ok := t.pending[messageID]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+
+	if entry.attempts >= ackMaxAttempts {
+		delete(t.pending, messageID)
+		t.mu.Unlock()
+
+		t.log.WithFields(logrus.Fields{
+			"message_id": messageID,
+			"attempts":   entry.attempts,
+		}).Warn("消息重试耗尽，放弃投递")
+
+		if entry.onFail != nil {
+			entry.onFail(entry.message, fmt.Errorf("消息%s重试%d次后仍未收到确认", messageID, entry.attempts))
+		}
+		return
+	}
+
+	entry.attempts++
+	delay := nextBackoff(entry.attempts - 1)
+	entry.timer = time.AfterFunc(delay, func() {
+		t.handleTimeout(messageID)
+	})
+	message := entry.message
+	t.mu.Unlock()
+
+	t.log.WithFields(logrus.Fields{
+		"message_id": messageID,
+		"attempt":    entry.attempts,
+		"delay":      delay,
+	}).Debug("消息未在超时前确认，按退避策略重新发送")
+
+	t.send(message)
+}
+
+// evictOldestLocked 在跟踪表已满时淘汰最早发出的一条记录，调用方必须持有t.mu
+func (t *PendingAckTracker) evictOldestLocked() {
+	var oldestID string
+	var oldestTime time.Time
+	for id, entry := range t.pending {
+		if oldestID == "" || entry.sentAt.Before(oldestTime) {
+			oldestID = id
+			oldestTime = entry.sentAt
+		}
+	}
+	if oldestID != "" {
+		t.pending[oldestID].timer.Stop()
+		delete(t.pending, oldestID)
+		t.log.WithField("message_id", oldestID).Warn("待确认队列已满，淘汰最早的记录")
+	}
+}
+
+// Size 返回当前待确认的消息数量，供监控/测试使用
+func (t *PendingAckTracker) Size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// nextBackoff 计算第n次重试前的退避时间：base*2^n，上限ackMaxBackoff，叠加最多20%的随机抖动
+func nextBackoff(n int) time.Duration {
+	backoff := ackInitialBackoff
+	for i := 0; i < n; i++ {
+		backoff *= 2
+		if backoff > ackMaxBackoff {
+			backoff = ackMaxBackoff
+			break
+		}
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(backoff)/5 + 1))
+	return backoff + jitter
+}
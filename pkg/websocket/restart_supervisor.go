@@ -0,0 +1,284 @@
+package websocket
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nspass/nspass-agent/generated/model"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// RestartCondition决定RestartPolicy在什么情况下允许自动重试，对应Swarmkit
+// restart包RestartPolicy.Condition的三种取值
+type RestartCondition string
+
+const (
+	RestartConditionOnFailure RestartCondition = "on-failure"
+	RestartConditionAny       RestartCondition = "any"
+	RestartConditionNone      RestartCondition = "none"
+)
+
+// RestartPolicy描述一种任务类型失败后的自动重试策略
+type RestartPolicy struct {
+	Condition         RestartCondition
+	Delay             time.Duration
+	MaxAttempts       int
+	Window            time.Duration
+	BackoffMultiplier float64
+	MaxBackoff        time.Duration
+	// Jitter是退避时长上下浮动的比例，取值[0,1]
+	Jitter float64
+}
+
+// DefaultRestartPolicy是任务类型没有显式配置策略时使用的兜底策略
+var DefaultRestartPolicy = RestartPolicy{
+	Condition:         RestartConditionOnFailure,
+	Delay:             5 * time.Second,
+	MaxAttempts:       5,
+	Window:            10 * time.Minute,
+	BackoffMultiplier: 2,
+	MaxBackoff:        5 * time.Minute,
+	Jitter:            0.2,
+}
+
+// BuildRestartPolicies把config.TaskRestartConfig转换成RestartSupervisor可用
+// 的策略表，按model.TaskType.String()匹配config.Policies里的键
+func BuildRestartPolicies(cfg config.TaskRestartConfig) (map[model.TaskType]RestartPolicy, RestartPolicy) {
+	defaultPolicy := restartPolicyFromConfig(cfg.Default, DefaultRestartPolicy)
+
+	policies := make(map[model.TaskType]RestartPolicy, len(cfg.Policies))
+	for name, policyCfg := range cfg.Policies {
+		taskType, ok := model.TaskType_value[name]
+		if !ok {
+			continue
+		}
+		policies[model.TaskType(taskType)] = restartPolicyFromConfig(policyCfg, defaultPolicy)
+	}
+
+	return policies, defaultPolicy
+}
+
+func restartPolicyFromConfig(cfg config.TaskRestartPolicyConfig, fallback RestartPolicy) RestartPolicy {
+	policy := fallback
+
+	if cfg.Condition != "" {
+		policy.Condition = RestartCondition(cfg.Condition)
+	}
+	if cfg.DelaySeconds > 0 {
+		policy.Delay = time.Duration(cfg.DelaySeconds) * time.Second
+	}
+	if cfg.MaxAttempts > 0 {
+		policy.MaxAttempts = cfg.MaxAttempts
+	}
+	if cfg.WindowSeconds > 0 {
+		policy.Window = time.Duration(cfg.WindowSeconds) * time.Second
+	}
+	if cfg.BackoffMultiplier > 0 {
+		policy.BackoffMultiplier = cfg.BackoffMultiplier
+	}
+	if cfg.MaxBackoffSeconds > 0 {
+		policy.MaxBackoff = time.Duration(cfg.MaxBackoffSeconds) * time.Second
+	}
+	if cfg.Jitter > 0 {
+		policy.Jitter = cfg.Jitter
+	}
+
+	return policy
+}
+
+// restartWindow是RestartSupervisor为单个TaskID维护的重试历史：最近一批失败
+// 时间戳（按Window裁剪）、下一次自动重试的时间，以及待触发的delayedStart的
+// 取消函数（没有待触发的重试时为nil）
+type restartWindow struct {
+	timestamps  []time.Time
+	nextRetryAt time.Time
+	cancel      context.CancelFunc
+}
+
+// RestartSupervisor模仿Swarmkit restart包的计数/退避逻辑，为TaskManager提供
+// "这个任务失败后该不该自动重试、要等多久"的决策，并负责调度真正执行重试的
+// delayedStart goroutine
+type RestartSupervisor struct {
+	mu            sync.Mutex
+	policies      map[model.TaskType]RestartPolicy
+	defaultPolicy RestartPolicy
+	windows       map[string]*restartWindow
+	log           *logrus.Entry
+}
+
+// NewRestartSupervisor创建一个RestartSupervisor，policies为空的任务类型一律
+// 使用defaultPolicy
+func NewRestartSupervisor(policies map[model.TaskType]RestartPolicy, defaultPolicy RestartPolicy) *RestartSupervisor {
+	return &RestartSupervisor{
+		policies:      policies,
+		defaultPolicy: defaultPolicy,
+		windows:       make(map[string]*restartWindow),
+		log:           logger.GetComponentLogger("restart-supervisor"),
+	}
+}
+
+func (s *RestartSupervisor) policyFor(taskType model.TaskType) RestartPolicy {
+	if policy, ok := s.policies[taskType]; ok {
+		return policy
+	}
+	return s.defaultPolicy
+}
+
+// RecordFailure在任务执行失败时调用：把本次失败计入taskID的滑动窗口，按
+// Window裁剪过期记录，超过MaxAttempts时判定为永久失败（不再返回可用的
+// delay）；否则返回按指数退避+抖动计算出的下一次重试前应等待的时长
+func (s *RestartSupervisor) RecordFailure(taskID string, taskType model.TaskType) (delay time.Duration, permanentlyFailed bool) {
+	policy := s.policyFor(taskType)
+	if policy.Condition == RestartConditionNone {
+		return 0, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[taskID]
+	if !ok {
+		w = &restartWindow{}
+		s.windows[taskID] = w
+	}
+
+	now := time.Now()
+	w.timestamps = append(w.timestamps, now)
+	if policy.Window > 0 {
+		cutoff := now.Add(-policy.Window)
+		pruned := w.timestamps[:0]
+		for _, ts := range w.timestamps {
+			if ts.After(cutoff) {
+				pruned = append(pruned, ts)
+			}
+		}
+		w.timestamps = pruned
+	}
+
+	attempt := len(w.timestamps)
+	if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+		s.log.WithFields(logrus.Fields{
+			"task_id": taskID,
+			"attempt": attempt,
+		}).Warn("任务在窗口期内失败次数已达上限，不再自动重试")
+		return 0, true
+	}
+
+	delay = backoffDelay(policy, attempt)
+	w.nextRetryAt = now.Add(delay)
+	return delay, false
+}
+
+// backoffDelay计算第attempt次失败后的退避时长：min(MaxBackoff,
+// Delay*BackoffMultiplier^(attempt-1))，再叠加±Jitter比例的随机抖动
+func backoffDelay(policy RestartPolicy, attempt int) time.Duration {
+	multiplier := policy.BackoffMultiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+
+	delay := float64(policy.Delay) * math.Pow(multiplier, float64(attempt-1))
+	if policy.MaxBackoff > 0 && delay > float64(policy.MaxBackoff) {
+		delay = float64(policy.MaxBackoff)
+	}
+
+	if policy.Jitter > 0 {
+		jitterRange := delay * policy.Jitter
+		delay += (rand.Float64()*2 - 1) * jitterRange
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	return time.Duration(delay)
+}
+
+// Schedule在delay后异步调用retry，除非期间被Cancel。同一taskID重复调度会
+// 先取消前一次尚未触发的delayedStart
+func (s *RestartSupervisor) Schedule(taskID string, delay time.Duration, retry func()) {
+	s.mu.Lock()
+	w, ok := s.windows[taskID]
+	if !ok {
+		w = &restartWindow{}
+		s.windows[taskID] = w
+	}
+	if w.cancel != nil {
+		w.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	s.mu.Unlock()
+
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			s.mu.Lock()
+			if w.cancel != nil {
+				w.cancel = nil
+			}
+			s.mu.Unlock()
+			retry()
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// Pending返回taskID当前是否有一个尚未触发的delayedStart，以及它计划触发的
+// 时间，供CheckTaskStatus在任务处于退避窗口期间重复收到同一任务时回复
+// NextRetryAt而不是重新执行
+func (s *RestartSupervisor) Pending(taskID string) (nextRetryAt time.Time, scheduled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[taskID]
+	if !ok || w.cancel == nil {
+		return time.Time{}, false
+	}
+	return w.nextRetryAt, true
+}
+
+// Cancel中止taskID尚未触发的delayedStart（如果有），供CancelTask调用以阻止
+// 已失败任务的下一次自动重试
+func (s *RestartSupervisor) Cancel(taskID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[taskID]
+	if !ok || w.cancel == nil {
+		return false
+	}
+	w.cancel()
+	w.cancel = nil
+	return true
+}
+
+// Reset清除taskID的失败历史，任务成功完成后调用，避免同一TaskID下一次独立
+// 的失败周期被此前已经过期的历史影响退避计算
+func (s *RestartSupervisor) Reset(taskID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.windows, taskID)
+}
+
+// Stats返回当前被跟踪失败历史的任务数，以及其中有多少正处于待触发的退避
+// 窗口中，供TaskManager.GetTaskStats合并展示
+func (s *RestartSupervisor) Stats() (tracked int, scheduled int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tracked = len(s.windows)
+	for _, w := range s.windows {
+		if w.cancel != nil {
+			scheduled++
+		}
+	}
+	return tracked, scheduled
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nspass/nspass-agent/pkg/logger"
+	"github.com/nspass/nspass-agent/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// resyncStateFileName 持久化最近一次成功应用的配置版本的文件名
+const resyncStateFileName = "resync_state.json"
+
+// resyncSafetyInterval 周期性安全resync的间隔
+const resyncSafetyInterval = 5 * time.Minute
+
+// Resyncer 由能够幂等地应用"全量快照"的子系统实现。与只处理单条变更的增量更新
+// 不同，ApplyFullState每次都接收完整配置集合并做全量协调，因此可以在重连、或
+// 周期性安全校验之后重复调用而不产生副作用——proxy.Manager.UpdateProxies和
+// iptables.Manager.UpdateRulesFromProto本身就是按全量集合做diff协调的实现，
+// 天然满足这个约束，这里只是为它们抽出一个公共接口名以便调用方统一处理。
+type Resyncer interface {
+	ApplyFullState() error
+}
+
+// resyncVersions 记录每个配置域最近一次成功应用的版本标识
+type resyncVersions struct {
+	EgressVersion   string `json:"egress_version"`
+	IptablesVersion string `json:"iptables_version"`
+}
+
+// resyncTracker 维护已应用的配置版本并将其持久化到磁盘，使agent重启后仍能
+// 在reconnect时带上正确的版本信息请求resync
+type resyncTracker struct {
+	mu       sync.Mutex
+	path     string
+	versions resyncVersions
+	files    *utils.FileUtils
+	log      *logrus.Entry
+}
+
+// newResyncTracker 创建一个resync版本跟踪器，状态文件保存在stateDir下
+func newResyncTracker(stateDir string) *resyncTracker {
+	t := &resyncTracker{
+		path:  filepath.Join(stateDir, resyncStateFileName),
+		files: utils.NewFileUtils("websocket-resync"),
+		log:   logger.GetComponentLogger("websocket-resync"),
+	}
+	t.load()
+	return t
+}
+
+// load 从磁盘恢复上次持久化的版本信息；文件不存在（例如首次启动）时保持零值
+func (t *resyncTracker) load() {
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return
+	}
+
+	var versions resyncVersions
+	if err := json.Unmarshal(data, &versions); err != nil {
+		t.log.WithError(err).Warn("解析resync状态文件失败，忽略")
+		return
+	}
+
+	t.versions = versions
+}
+
+// persist 将当前版本信息写回磁盘
+func (t *resyncTracker) persist() {
+	data, err := json.Marshal(t.versions)
+	if err != nil {
+		t.log.WithError(err).Warn("序列化resync状态失败")
+		return
+	}
+
+	if err := t.files.WriteConfigFile(t.path, data); err != nil {
+		t.log.WithError(err).Warn("持久化resync状态失败")
+	}
+}
+
+// EgressVersion 返回最近一次成功应用的egress配置版本
+func (t *resyncTracker) EgressVersion() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.versions.EgressVersion
+}
+
+// SetEgressVersion 记录新应用的egress配置版本并持久化
+func (t *resyncTracker) SetEgressVersion(version string) {
+	t.mu.Lock()
+	t.versions.EgressVersion = version
+	t.mu.Unlock()
+	t.persist()
+}
+
+// IptablesVersion 返回最近一次成功应用的iptables配置版本
+func (t *resyncTracker) IptablesVersion() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.versions.IptablesVersion
+}
+
+// SetIptablesVersion 记录新应用的iptables配置版本并持久化
+func (t *resyncTracker) SetIptablesVersion(version string) {
+	t.mu.Lock()
+	t.versions.IptablesVersion = version
+	t.mu.Unlock()
+	t.persist()
+}
+
+// configVersion 以配置内容的SHA-256摘要作为资源版本标识使用。
+//
+// nspass-proto目前还没有为配置消息提供专门的resource_version/hash字段，这里先用
+// 内容哈希作为等价物；一旦协议补充了该字段，应直接改用服务端下发的版本值。
+func configVersion(message proto.Message) string {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RestartPendingMarker记录一次自重启（syscall.Exec替换进程镜像）发起前、尚未
+// 来得及把TASK_STATUS_COMPLETED结果发送回服务端的restart任务，落盘在
+// config.TaskConfig.RestartMarkerPath，新进程启动时据此补发延迟的结果并清除
+// 标记
+type RestartPendingMarker struct {
+	TaskID      string    `json:"task_id"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// writeRestartMarker把marker写入path，path所在目录不存在时自动创建
+func writeRestartMarker(path string, marker *RestartPendingMarker) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建重启标记目录失败: %w", err)
+	}
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return fmt.Errorf("序列化重启标记失败: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("写入重启标记失败: %w", err)
+	}
+	return nil
+}
+
+// ConsumeRestartMarker读取path处的重启标记并删除它（一次性消费）。path不存在
+// 时返回ok=false、err=nil——这是绝大多数正常启动（不是紧接在一次自重启之后）
+// 的情况，不应被当作错误对待
+func ConsumeRestartMarker(path string) (*RestartPendingMarker, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("读取重启标记失败: %w", err)
+	}
+
+	var marker RestartPendingMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, false, fmt.Errorf("解析重启标记失败: %w", err)
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("清除重启标记失败: %w", err)
+	}
+
+	return &marker, true, nil
+}
+
+// verifyBinaryChecksum校验path处二进制文件内容的sha256是否与expected（十六
+// 进制）一致，expected为空时跳过校验——用于restart任务里可选的binary_path/
+// checksum参数，在exec一个新版本二进制前先确认它没有被截断或替换成别的文件
+func verifyBinaryChecksum(path, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取待校验的二进制失败: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("二进制校验和不匹配: 期望%s，实际%s", expected, actual)
+	}
+	return nil
+}
+
+// selfExec用binaryPath替换当前进程镜像，保留原有argv[1:]和环境变量，使监听
+// 套接字等进程资源尽可能延续（syscall.Exec不经过fork，文件描述符默认保留，
+// 除非被标记了close-on-exec）。调用方必须确保在此之前已经把需要送达服务端的
+// 消息flush出去——一旦syscall.Exec成功，当前goroutine的后续代码都不会再执行
+func selfExec(binaryPath string) error {
+	args := append([]string{binaryPath}, os.Args[1:]...)
+	return syscall.Exec(binaryPath, args, os.Environ())
+}
@@ -2,17 +2,20 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os/exec"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/nspass/nspass-agent/generated/model"
 	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/nspass/nspass-agent/pkg/errorbus"
 	"github.com/nspass/nspass-agent/pkg/iptables"
 	"github.com/nspass/nspass-agent/pkg/logger"
 	"github.com/nspass/nspass-agent/pkg/proxy"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // TaskRecord represents a task record in memory
@@ -27,20 +30,183 @@ type TaskRecord struct {
 	ErrorMsg    string            `json:"error_message,omitempty"`
 	RetryCount  int               `json:"retry_count"`
 	LastRetryAt *time.Time        `json:"last_retry_at,omitempty"`
+	// LastProgress是这个任务最近一次上报的阶段性进度，尚未上报过时为nil
+	LastProgress *TaskProgressSnapshot `json:"last_progress,omitempty"`
+	// OutputTail是这个任务最近产生的若干行输出（日志行/部分输出），按
+	// outputTailLimit裁剪的有界环形缓冲，供服务端或其他客户端实时查看尾部输出，
+	// 不是最终TaskResult.Output的替代
+	OutputTail []string `json:"output_tail,omitempty"`
 }
 
-// TaskManager manages task states in memory
+// TaskProgressSnapshot是TaskRecord.LastProgress的内容，记录最近一次
+// TaskProgressReporter.Report上报的进度
+type TaskProgressSnapshot struct {
+	Percent int32     `json:"percent"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// outputTailLimit是TaskRecord.OutputTail保留的最大行数，超出部分丢弃最旧的行，
+// 避免长时间运行的命令产生的海量输出撑爆内存
+const outputTailLimit = 200
+
+// TaskManager manages task states, write-through cached in memory and backed
+// by a TaskStore（store为nil时退化为纯内存，不持久化）
 type TaskManager struct {
-	tasks map[string]*TaskRecord
-	mu    sync.RWMutex
-	log   *logrus.Entry
+	tasks    map[string]*TaskRecord
+	store    TaskStore
+	restarts *RestartSupervisor
+	mu       sync.RWMutex
+	log      *logrus.Entry
+
+	// subMu保护subscribers，与tasks用单独的锁是为了避免publish期间持有的锁
+	// 和GetTask/CreateTask等高频读写路径互相阻塞
+	subMu       sync.Mutex
+	subscribers map[string][]chan TaskProgressSnapshot
+}
+
+// NewTaskManager creates a new task manager. store为nil表示不持久化任务记录；
+// 非nil时会在构造时从store加载已有记录（迁移路径），并把此前意外停留在
+// RUNNING状态的任务标记为FAILED（Agent上次退出时任务显然没有真正执行完），
+// 交给RestartSupervisor在之后自动重试。policies/defaultPolicy配置失败任务由
+// RestartSupervisor自动重试时使用的退避策略
+func NewTaskManager(store TaskStore, policies map[model.TaskType]RestartPolicy, defaultPolicy RestartPolicy) *TaskManager {
+	tm := &TaskManager{
+		tasks:       make(map[string]*TaskRecord),
+		store:       store,
+		restarts:    NewRestartSupervisor(policies, defaultPolicy),
+		subscribers: make(map[string][]chan TaskProgressSnapshot),
+		log:         logger.GetComponentLogger("task-manager"),
+	}
+	tm.recoverFromStore()
+	return tm
+}
+
+// recoverFromStore从TaskStore加载所有已持久化的任务记录到内存缓存；处于
+// RUNNING状态的记录说明Agent是在任务执行期间重启的，进程已经不在了，不可能
+// 真的还在运行，标记为FAILED并写回store，后续由CheckTaskStatus/
+// RestartSupervisor走正常的失败重试路径
+func (tm *TaskManager) recoverFromStore() {
+	if tm.store == nil {
+		return
+	}
+
+	records, err := tm.store.List()
+	if err != nil {
+		tm.log.WithError(err).Warn("从持久化TaskStore加载任务记录失败，按空记录启动")
+		return
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	recovered := 0
+	for _, record := range records {
+		if record.Status == model.TaskStatus_TASK_STATUS_RUNNING {
+			now := time.Now()
+			record.Status = model.TaskStatus_TASK_STATUS_FAILED
+			record.CompletedAt = &now
+			record.ErrorMsg = "recovered after restart"
+			if err := tm.store.Put(record); err != nil {
+				tm.log.WithError(err).WithField("task_id", record.TaskID).Warn("回写恢复后的任务记录失败")
+			}
+			recovered++
+		}
+		tm.tasks[record.TaskID] = record
+	}
+
+	tm.log.WithFields(logrus.Fields{
+		"loaded_count":    len(records),
+		"recovered_count": recovered,
+	}).Info("从持久化TaskStore恢复任务记录")
+}
+
+// persist把task写入TaskStore（如果有配置），写入失败只记录日志，不影响内存
+// 里的状态——TaskStore是幂等性的持久化保障，不是关键路径的强一致性要求
+func (tm *TaskManager) persist(task *TaskRecord) {
+	if tm.store == nil {
+		return
+	}
+	if err := tm.store.Put(task); err != nil {
+		tm.log.WithError(err).WithField("task_id", task.TaskID).Warn("持久化任务记录失败")
+	}
 }
 
-// NewTaskManager creates a new task manager
-func NewTaskManager() *TaskManager {
-	return &TaskManager{
-		tasks: make(map[string]*TaskRecord),
-		log:   logger.GetComponentLogger("task-manager"),
+// RecordProgress更新taskID的LastProgress快照、持久化，并推送给当前订阅者；
+// 由executeAndFinalize包装出的taskProgressRecorder在每次上报进度时调用
+func (tm *TaskManager) RecordProgress(taskID string, percent int32, message string) {
+	snapshot := TaskProgressSnapshot{Percent: percent, Message: message, At: time.Now()}
+
+	tm.mu.Lock()
+	task, exists := tm.tasks[taskID]
+	if exists {
+		task.LastProgress = &snapshot
+		tm.persist(task)
+	}
+	tm.mu.Unlock()
+
+	if exists {
+		tm.publish(taskID, snapshot)
+	}
+}
+
+// AppendOutput把一行输出追加进taskID的OutputTail环形缓冲（超出outputTailLimit
+// 丢弃最旧的行），不更新LastProgress、也不单独推送给订阅者——Log/Partial只
+// 关心尾部输出本身，真正的实时通知走RecordProgress
+func (tm *TaskManager) AppendOutput(taskID string, line string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	task, exists := tm.tasks[taskID]
+	if !exists {
+		return
+	}
+
+	task.OutputTail = append(task.OutputTail, line)
+	if len(task.OutputTail) > outputTailLimit {
+		task.OutputTail = task.OutputTail[len(task.OutputTail)-outputTailLimit:]
+	}
+	tm.persist(task)
+}
+
+// Subscribe订阅taskID的进度快照，返回一个只读channel和取消订阅的函数；
+// channel有少量缓冲，慢消费者会丢失中间的进度快照而不是阻塞上报方
+func (tm *TaskManager) Subscribe(taskID string) (<-chan TaskProgressSnapshot, func()) {
+	ch := make(chan TaskProgressSnapshot, 8)
+
+	tm.subMu.Lock()
+	tm.subscribers[taskID] = append(tm.subscribers[taskID], ch)
+	tm.subMu.Unlock()
+
+	cancel := func() {
+		tm.subMu.Lock()
+		defer tm.subMu.Unlock()
+		subs := tm.subscribers[taskID]
+		for i, existing := range subs {
+			if existing == ch {
+				tm.subscribers[taskID] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		if len(tm.subscribers[taskID]) == 0 {
+			delete(tm.subscribers, taskID)
+		}
+		close(ch)
+	}
+	return ch, cancel
+}
+
+// publish把snapshot非阻塞地投递给taskID的所有订阅者，channel满时直接丢弃该次
+// 更新（订阅者关心的是"追上最新进度"，不是"一条不漏"）
+func (tm *TaskManager) publish(taskID string, snapshot TaskProgressSnapshot) {
+	tm.subMu.Lock()
+	defer tm.subMu.Unlock()
+
+	for _, ch := range tm.subscribers[taskID] {
+		select {
+		case ch <- snapshot:
+		default:
+		}
 	}
 }
 
@@ -66,6 +232,7 @@ func (tm *TaskManager) CreateTask(taskID string, taskType model.TaskType) *TaskR
 	}
 
 	tm.tasks[taskID] = task
+	tm.persist(task)
 	tm.log.WithFields(logrus.Fields{
 		"task_id":   taskID,
 		"task_type": taskType.String(),
@@ -89,6 +256,7 @@ func (tm *TaskManager) UpdateTaskStatus(taskID string, status model.TaskStatus)
 		case model.TaskStatus_TASK_STATUS_COMPLETED, model.TaskStatus_TASK_STATUS_FAILED, model.TaskStatus_TASK_STATUS_CANCELLED:
 			task.CompletedAt = &now
 		}
+		tm.persist(task)
 
 		tm.log.WithFields(logrus.Fields{
 			"task_id": taskID,
@@ -109,6 +277,7 @@ func (tm *TaskManager) SetTaskResult(taskID string, result *model.TaskResult, er
 		if result != nil {
 			task.Status = result.Status
 		}
+		tm.persist(task)
 	}
 }
 
@@ -121,6 +290,7 @@ func (tm *TaskManager) IncrementRetryCount(taskID string) {
 		task.RetryCount++
 		now := time.Now()
 		task.LastRetryAt = &now
+		tm.persist(task)
 
 		tm.log.WithFields(logrus.Fields{
 			"task_id":     taskID,
@@ -129,14 +299,21 @@ func (tm *TaskManager) IncrementRetryCount(taskID string) {
 	}
 }
 
-// CleanupOldTasks removes old completed tasks (older than 24 hours)
+// CleanupOldTasks removes old completed tasks (older than 24 hours). 配置了
+// TaskStore时走cleanupViaStore，借助按CompletedAt分桶的二级索引只打开已经
+// 过期的那一批任务文件，而不必在持有写锁的情况下扫描整个内存map
 func (tm *TaskManager) CleanupOldTasks() {
+	cutoff := time.Now().Add(-24 * time.Hour)
+
+	if tm.store != nil {
+		tm.cleanupViaStore(cutoff)
+		return
+	}
+
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
-	cutoff := time.Now().Add(-24 * time.Hour)
 	cleaned := 0
-
 	for taskID, task := range tm.tasks {
 		if task.CompletedAt != nil && task.CompletedAt.Before(cutoff) {
 			delete(tm.tasks, taskID)
@@ -149,37 +326,121 @@ func (tm *TaskManager) CleanupOldTasks() {
 	}
 }
 
+// cleanupViaStore按TaskStore.Range返回的CompletedAt升序顺序收集已过期的
+// taskID，遇到第一个未过期的记录就提前终止；随后在一次短暂的写锁内批量从
+// 内存缓存摘除，最后逐个从TaskStore删除
+func (tm *TaskManager) cleanupViaStore(cutoff time.Time) {
+	var expired []string
+	err := tm.store.Range(func(record *TaskRecord) bool {
+		if record.CompletedAt == nil || record.CompletedAt.After(cutoff) {
+			return false // 按时间升序遍历，后面的只会更新，可以提前终止
+		}
+		expired = append(expired, record.TaskID)
+		return true
+	})
+	if err != nil {
+		tm.log.WithError(err).Warn("按时间索引遍历待清理任务失败")
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	tm.mu.Lock()
+	for _, taskID := range expired {
+		delete(tm.tasks, taskID)
+	}
+	tm.mu.Unlock()
+
+	for _, taskID := range expired {
+		if err := tm.store.Delete(taskID); err != nil {
+			tm.log.WithError(err).WithField("task_id", taskID).Warn("删除持久化任务记录失败")
+		}
+	}
+
+	tm.log.WithField("cleaned_count", len(expired)).Info("Cleaned up old tasks")
+}
+
 // GetTaskStats returns task statistics
 func (tm *TaskManager) GetTaskStats() map[string]int {
 	tm.mu.RLock()
-	defer tm.mu.RUnlock()
-
 	stats := make(map[string]int)
 	for _, task := range tm.tasks {
 		stats[task.Status.String()]++
 	}
+	tm.mu.RUnlock()
+
+	tracked, scheduled := tm.restarts.Stats()
+	stats["restart_tracked"] = tracked
+	stats["restart_scheduled"] = scheduled
 
 	return stats
 }
 
+// OutboundFlusher由websocket.Client实现。restartAgentService在syscall.Exec
+// 替换当前进程镜像前调用它，确保已经入队、但写协程还没来得及真正发到连接上的
+// ack/进度消息不会随进程镜像被替换而丢失
+type OutboundFlusher interface {
+	// FlushOutbound阻塞直到所有出站队列被写协程清空，或timeout到期（以先发生
+	// 者为准），超时返回错误，调用方应把它当作"尽力而为"对待而不是中止重启
+	FlushOutbound(timeout time.Duration) error
+}
+
+// outboundFlushTimeout是restartAgentService等待出站队列清空的最长时间，超过
+// 仍不为空也会继续执行自重启，不无限阻塞这次restart任务
+const outboundFlushTimeout = 5 * time.Second
+
 // DefaultTaskHandler 默认任务处理器
 type DefaultTaskHandler struct {
 	config          *config.Config
 	proxyManager    *proxy.Manager
 	iptablesManager iptables.ManagerInterface
 	taskManager     *TaskManager
+	registry        *TaskHandlerRegistry
+	outboundFlusher OutboundFlusher
 	log             *logrus.Entry
 }
 
-// NewDefaultTaskHandler 创建默认任务处理器
+// SetOutboundFlusher注入出站队列flusher。Client依赖taskHandler构造，两者之间
+// 存在构造顺序上的循环依赖，只能在NewClient返回之后由调用方反向回填；未设置
+// 时restartAgentService会跳过flush，直接尽力而为地发起自重启
+func (h *DefaultTaskHandler) SetOutboundFlusher(flusher OutboundFlusher) {
+	h.outboundFlusher = flusher
+}
+
+// NewDefaultTaskHandler 创建默认任务处理器，内置任务类型通过registry分发，
+// 也可以在此之后调用h.GetRegistry().Register为其他任务类型接入自定义executor。
+// cfg.Task.StoreDir非空时使用持久化的TaskStore，使任务幂等性记录在Agent重启
+// 后依然有效；留空时退化为纯内存，与此前的行为一致
 func NewDefaultTaskHandler(cfg *config.Config, proxyManager *proxy.Manager, iptablesManager iptables.ManagerInterface) *DefaultTaskHandler {
-	return &DefaultTaskHandler{
+	log := logger.GetComponentLogger("task-handler")
+
+	var store TaskStore
+	if cfg.Task.StoreDir != "" {
+		fileStore, err := NewFileTaskStore(cfg.Task.StoreDir)
+		if err != nil {
+			log.WithError(err).WithField("store_dir", cfg.Task.StoreDir).Warn("初始化持久化TaskStore失败，任务记录将不跨重启保留")
+		} else {
+			store = fileStore
+		}
+	}
+
+	policies, defaultPolicy := BuildRestartPolicies(cfg.Task.Restart)
+	h := &DefaultTaskHandler{
 		config:          cfg,
 		proxyManager:    proxyManager,
 		iptablesManager: iptablesManager,
-		taskManager:     NewTaskManager(),
-		log:             logger.GetComponentLogger("task-handler"),
+		taskManager:     NewTaskManager(store, policies, defaultPolicy),
+		registry:        NewTaskHandlerRegistry(),
+		log:             log,
 	}
+	h.registerBuiltinExecutors()
+	return h
+}
+
+// GetRegistry 返回任务处理器注册表，供外部按需注册额外的TaskExecutor
+func (h *DefaultTaskHandler) GetRegistry() *TaskHandlerRegistry {
+	return h.registry
 }
 
 // CheckTaskStatus checks task status and determines how to handle it
@@ -208,12 +469,26 @@ func (h *DefaultTaskHandler) CheckTaskStatus(taskID string, taskType model.TaskT
 		h.log.WithField("task_id", taskID).Info("Task is currently running, skipping execution")
 		return false, nil
 
-	case model.TaskStatus_TASK_STATUS_PENDING, model.TaskStatus_TASK_STATUS_FAILED:
-		// Task is pending or failed, should retry
-		h.log.WithField("task_id", taskID).Info("Task is pending or failed, will retry execution")
-		h.taskManager.IncrementRetryCount(taskID)
+	case model.TaskStatus_TASK_STATUS_PENDING:
+		// Fresh task, should execute immediately
 		return true, nil
 
+	case model.TaskStatus_TASK_STATUS_FAILED:
+		// 之前失败过：如果RestartSupervisor已经为它安排了delayedStart，说明还在
+		// 退避窗口内，不重复执行，回复NextRetryAt；否则说明已经永久失败（或策略
+		// 禁止重试），回复缓存的失败结果
+		if nextRetryAt, scheduled := h.taskManager.restarts.Pending(taskID); scheduled {
+			h.log.WithField("task_id", taskID).Info("Task is waiting in a restart backoff window")
+			return false, &model.TaskResult{
+				TaskId:      taskID,
+				Status:      model.TaskStatus_TASK_STATUS_PENDING,
+				Output:      "等待退避窗口后自动重试",
+				NextRetryAt: timestamppb.New(nextRetryAt),
+			}
+		}
+		h.log.WithField("task_id", taskID).Info("Task has permanently failed, returning cached result")
+		return false, task.Result
+
 	case model.TaskStatus_TASK_STATUS_CANCELLED:
 		// Task was cancelled, don't execute
 		h.log.WithField("task_id", taskID).Info("Task was cancelled, skipping execution")
@@ -229,8 +504,10 @@ func (h *DefaultTaskHandler) CheckTaskStatus(taskID string, taskType model.TaskT
 	}
 }
 
-// HandleTask 处理任务
-func (h *DefaultTaskHandler) HandleTask(ctx context.Context, task *model.TaskMessage) (*model.TaskResult, error) {
+// HandleTask 处理任务。progress可为nil（例如测试场景），非nil时在关键节点上报
+// 执行进度；注意目前各子处理函数尚未逐一检查ctx取消信号，取消主要依赖调用方
+// （websocket.Client）在HandleTask返回后根据ctx.Err()纠正最终状态。
+func (h *DefaultTaskHandler) HandleTask(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.WithFields(logrus.Fields{
 		"task_id":   task.TaskId,
 		"task_type": task.TaskType.String(),
@@ -252,61 +529,125 @@ func (h *DefaultTaskHandler) HandleTask(ctx context.Context, task *model.TaskMes
 		}, nil
 	}
 
-	// Mark task as running
+	return h.executeAndFinalize(ctx, task, progress)
+}
+
+// taskProgressRecorder包装调用方传入的TaskProgressReporter（可能为nil），把
+// 同一份进度事件和输出行同时落到TaskManager（供TaskRecord.LastProgress/
+// OutputTail以及Subscribe供本地查询使用），再转发给真正的websocket
+// reporter（如果有）。传给各TaskExecutor的progress始终是这个wrapper，不会是nil
+type taskProgressRecorder struct {
+	taskID string
+	tm     *TaskManager
+	inner  TaskProgressReporter
+}
+
+func (r *taskProgressRecorder) Report(percent int32, message string) {
+	r.tm.RecordProgress(r.taskID, percent, message)
+	if r.inner != nil {
+		r.inner.Report(percent, message)
+	}
+}
+
+func (r *taskProgressRecorder) Log(level string, line string) {
+	r.tm.AppendOutput(r.taskID, fmt.Sprintf("[%s] %s", level, line))
+	if r.inner != nil {
+		r.inner.Log(level, line)
+	}
+}
+
+func (r *taskProgressRecorder) Partial(output string) {
+	r.tm.AppendOutput(r.taskID, output)
+	if r.inner != nil {
+		r.inner.Partial(output)
+	}
+}
+
+// executeAndFinalize实际执行任务（通过registry分发到对应的executor）并把结果
+// 落盘到TaskManager；失败时交给handleExecutionFailure决定是安排自动重试还是
+// 判定为永久失败。delayedStart自动重试也会复用这个方法
+func (h *DefaultTaskHandler) executeAndFinalize(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.taskManager.UpdateTaskStatus(task.TaskId, model.TaskStatus_TASK_STATUS_RUNNING)
 
+	recorder := &taskProgressRecorder{taskID: task.TaskId, tm: h.taskManager, inner: progress}
+	recorder.Report(0, "开始执行任务")
+
 	var result *model.TaskResult
 	var err error
 
-	// Execute the task based on type
-	switch task.TaskType {
-	case model.TaskType_TASK_TYPE_CONFIG_UPDATE:
-		result, err = h.handleConfigUpdate(ctx, task)
-	case model.TaskType_TASK_TYPE_RESTART:
-		result, err = h.handleRestart(ctx, task)
-	case model.TaskType_TASK_TYPE_SYNC_RULES:
-		result, err = h.handleSyncRules(ctx, task)
-	case model.TaskType_TASK_TYPE_SYNC_USERS:
-		result, err = h.handleSyncUsers(ctx, task)
-	case model.TaskType_TASK_TYPE_COLLECT_METRICS:
-		result, err = h.handleCollectMetrics(ctx, task)
-	case model.TaskType_TASK_TYPE_HEALTH_CHECK:
-		result, err = h.handleHealthCheck(ctx, task)
-	default:
+	// 通过registry分发到对应的executor，取代过去硬编码的switch
+	if executor, ok := h.registry.Resolve(task.TaskType); ok {
+		result, err = executor(ctx, h, task, recorder)
+	} else {
 		err = fmt.Errorf("不支持的任务类型: %s", task.TaskType.String())
 	}
 
-	// Update task status and result
 	if err != nil {
-		h.taskManager.UpdateTaskStatus(task.TaskId, model.TaskStatus_TASK_STATUS_FAILED)
-		h.taskManager.SetTaskResult(task.TaskId, nil, err.Error())
-		h.log.WithError(err).WithField("task_id", task.TaskId).Error("任务处理失败")
+		return h.handleExecutionFailure(task, err)
+	}
+
+	if result != nil {
+		result.TaskId = task.TaskId
+		if result.Status == model.TaskStatus_TASK_STATUS_UNSPECIFIED {
+			result.Status = model.TaskStatus_TASK_STATUS_COMPLETED
+		}
 	} else {
-		if result != nil {
-			result.TaskId = task.TaskId
-			if result.Status == model.TaskStatus_TASK_STATUS_UNSPECIFIED {
-				result.Status = model.TaskStatus_TASK_STATUS_COMPLETED
-			}
-		} else {
-			result = &model.TaskResult{
-				TaskId: task.TaskId,
-				Status: model.TaskStatus_TASK_STATUS_COMPLETED,
-				Output: "Task completed successfully",
-			}
+		result = &model.TaskResult{
+			TaskId: task.TaskId,
+			Status: model.TaskStatus_TASK_STATUS_COMPLETED,
+			Output: "Task completed successfully",
 		}
-		h.taskManager.UpdateTaskStatus(task.TaskId, result.Status)
-		h.taskManager.SetTaskResult(task.TaskId, result, "")
-		h.log.WithField("task_id", task.TaskId).Info("任务处理成功")
 	}
+	h.taskManager.UpdateTaskStatus(task.TaskId, result.Status)
+	h.taskManager.SetTaskResult(task.TaskId, result, "")
+	h.taskManager.restarts.Reset(task.TaskId)
+	h.log.WithField("task_id", task.TaskId).Info("任务处理成功")
+
+	recorder.Report(100, result.Output)
 
 	// Cleanup old tasks periodically
 	go h.taskManager.CleanupOldTasks()
 
-	return result, err
+	return result, nil
+}
+
+// handleExecutionFailure在任务执行失败时记录一次失败，交给RestartSupervisor
+// 判断是否还能自动重试：还能重试时安排delayedStart并把这次调用的返回值降级
+// 为PENDING+NextRetryAt（不当作硬错误，调用方不会因此中断任务的生命周期）；
+// 已达到MaxAttempts上限则标记为永久失败，把真正的错误透传给调用方
+func (h *DefaultTaskHandler) handleExecutionFailure(task *model.TaskMessage, execErr error) (*model.TaskResult, error) {
+	h.taskManager.UpdateTaskStatus(task.TaskId, model.TaskStatus_TASK_STATUS_FAILED)
+	h.taskManager.SetTaskResult(task.TaskId, nil, execErr.Error())
+	h.log.WithError(execErr).WithField("task_id", task.TaskId).Error("任务处理失败")
+
+	delay, permanentlyFailed := h.taskManager.restarts.RecordFailure(task.TaskId, task.TaskType)
+	if permanentlyFailed {
+		return nil, execErr
+	}
+
+	nextRetryAt := time.Now().Add(delay)
+	h.log.WithFields(logrus.Fields{
+		"task_id": task.TaskId,
+		"delay":   delay.String(),
+	}).Info("任务失败，已安排退避窗口后自动重试")
+
+	// 自动重试发生在服务端重新下发任务之外，不复用原始的progress（其
+	// correlationID对应的消息多半早已被ACK），由下一次真正完成/失败时的结果
+	// 通过常规的任务状态查询或下一条TaskResult通知呈现
+	h.taskManager.restarts.Schedule(task.TaskId, delay, func() {
+		h.executeAndFinalize(context.Background(), task, nil)
+	})
+
+	return &model.TaskResult{
+		TaskId:      task.TaskId,
+		Status:      model.TaskStatus_TASK_STATUS_PENDING,
+		Output:      "任务执行失败，等待退避窗口后自动重试",
+		NextRetryAt: timestamppb.New(nextRetryAt),
+	}, nil
 }
 
 // handleConfigUpdate 处理配置更新任务
-func (h *DefaultTaskHandler) handleConfigUpdate(ctx context.Context, task *model.TaskMessage) (*model.TaskResult, error) {
+func (h *DefaultTaskHandler) handleConfigUpdate(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.WithField("task_id", task.TaskId).Info("处理配置更新任务")
 
 	// 解析配置更新参数
@@ -318,16 +659,16 @@ func (h *DefaultTaskHandler) handleConfigUpdate(ctx context.Context, task *model
 	// 根据配置类型更新相应的配置
 	switch params.ConfigType {
 	case "proxy":
-		return h.updateProxyConfig(ctx, &params)
+		return h.updateProxyConfig(ctx, &params, progress)
 	case "iptables":
-		return h.updateIPTablesConfig(ctx, &params)
+		return h.updateIPTablesConfig(ctx, &params, progress)
 	default:
 		return nil, fmt.Errorf("不支持的配置类型: %s", params.ConfigType)
 	}
 }
 
 // updateProxyConfig 更新代理配置
-func (h *DefaultTaskHandler) updateProxyConfig(ctx context.Context, params *model.ConfigUpdateTaskParams) (*model.TaskResult, error) {
+func (h *DefaultTaskHandler) updateProxyConfig(ctx context.Context, params *model.ConfigUpdateTaskParams, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.Info("更新代理配置")
 
 	// 这里应该根据配置内容更新代理配置
@@ -336,6 +677,7 @@ func (h *DefaultTaskHandler) updateProxyConfig(ctx context.Context, params *mode
 
 	// 如果需要重启
 	if params.RestartRequired {
+		progress.Report(50, "正在重启代理服务")
 		if err := h.proxyManager.RestartAll(); err != nil {
 			return nil, fmt.Errorf("重启代理服务失败: %w", err)
 		}
@@ -350,17 +692,18 @@ func (h *DefaultTaskHandler) updateProxyConfig(ctx context.Context, params *mode
 }
 
 // updateIPTablesConfig 更新iptables配置
-func (h *DefaultTaskHandler) updateIPTablesConfig(ctx context.Context, params *model.ConfigUpdateTaskParams) (*model.TaskResult, error) {
+func (h *DefaultTaskHandler) updateIPTablesConfig(ctx context.Context, params *model.ConfigUpdateTaskParams, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.Info("更新iptables配置")
 
 	// 这里可以根据具体的配置类型来处理不同的更新逻辑
 	// 目前我们让agent通过常规的配置同步来处理iptables更新
-	
+
 	output := fmt.Sprintf("iptables配置更新请求已处理，配置类型: %s", params.ConfigType)
-	
+
 	// 如果需要重启，可以设置相应的标志
 	if params.RestartRequired {
 		output += "，需要重启服务"
+		progress.Log("info", "iptables配置更新需要重启服务")
 		h.log.Info("iptables配置更新需要重启服务")
 	}
 
@@ -372,7 +715,7 @@ func (h *DefaultTaskHandler) updateIPTablesConfig(ctx context.Context, params *m
 }
 
 // handleRestart 处理重启任务
-func (h *DefaultTaskHandler) handleRestart(ctx context.Context, task *model.TaskMessage) (*model.TaskResult, error) {
+func (h *DefaultTaskHandler) handleRestart(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.WithField("task_id", task.TaskId).Info("处理重启任务")
 
 	// 解析重启参数
@@ -383,17 +726,18 @@ func (h *DefaultTaskHandler) handleRestart(ctx context.Context, task *model.Task
 
 	switch params.ServiceName {
 	case "proxy":
-		return h.restartProxyService(ctx, &params)
+		return h.restartProxyService(ctx, &params, progress)
 	case "agent":
-		return h.restartAgentService(ctx, &params)
+		return h.restartAgentService(ctx, task.TaskId, &params, progress)
 	default:
 		return nil, fmt.Errorf("不支持的服务名称: %s", params.ServiceName)
 	}
 }
 
 // restartProxyService 重启代理服务
-func (h *DefaultTaskHandler) restartProxyService(ctx context.Context, params *model.RestartTaskParams) (*model.TaskResult, error) {
+func (h *DefaultTaskHandler) restartProxyService(ctx context.Context, params *model.RestartTaskParams, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.Info("重启代理服务")
+	progress.Report(30, "正在重启代理服务")
 
 	if err := h.proxyManager.RestartAll(); err != nil {
 		return nil, fmt.Errorf("重启代理服务失败: %w", err)
@@ -406,26 +750,63 @@ func (h *DefaultTaskHandler) restartProxyService(ctx context.Context, params *mo
 	}, nil
 }
 
-// restartAgentService 重启Agent服务
-func (h *DefaultTaskHandler) restartAgentService(ctx context.Context, params *model.RestartTaskParams) (*model.TaskResult, error) {
-	h.log.Info("重启Agent服务")
+// restartAgentService 通过syscall.Exec让Agent自重启，取代此前的
+// "systemctl restart nspass-agent"——后者在新进程完全起来之前就把旧进程杀掉，
+// 导致这次restart任务的TaskResult在websocket连接断开前根本来不及发出，服务端
+// 看到的永远是卡在RUNNING的任务。
+//
+// 流程：校验可选的binary_path/checksum参数 -> 把带有taskID的
+// RestartPendingMarker落盘 -> flush出站队列 -> syscall.Exec替换进程镜像。新
+// 进程启动时通过ConsumeRestartMarker读回taskID，补发这里本该发出但来不及发出
+// 的TASK_STATUS_COMPLETED结果
+func (h *DefaultTaskHandler) restartAgentService(ctx context.Context, taskID string, params *model.RestartTaskParams, progress TaskProgressReporter) (*model.TaskResult, error) {
+	h.log.Info("准备自重启Agent进程")
+	progress.Report(20, "正在校验待执行的二进制")
+
+	binaryPath := params.BinaryPath
+	if binaryPath == "" {
+		execPath, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("获取当前可执行文件路径失败: %w", err)
+		}
+		binaryPath = execPath
+	}
 
-	// 这里需要实现Agent服务的重启逻辑
-	// 可能需要通过systemctl或其他方式重启服务
-	cmd := exec.CommandContext(ctx, "systemctl", "restart", "nspass-agent")
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("重启Agent服务失败: %w", err)
+	if err := verifyBinaryChecksum(binaryPath, params.Checksum); err != nil {
+		return nil, fmt.Errorf("自重启前校验二进制失败: %w", err)
 	}
 
-	return &model.TaskResult{
-		TaskId: "",
-		Status: model.TaskStatus_TASK_STATUS_COMPLETED,
-		Output: "Agent服务重启成功",
-	}, nil
+	progress.Report(50, "正在持久化重启标记")
+	marker := &RestartPendingMarker{TaskID: taskID, RequestedAt: time.Now()}
+	if err := writeRestartMarker(h.config.Task.RestartMarkerPath, marker); err != nil {
+		return nil, fmt.Errorf("持久化重启标记失败: %w", err)
+	}
+
+	progress.Report(80, "正在flush出站消息队列")
+	if h.outboundFlusher != nil {
+		if err := h.outboundFlusher.FlushOutbound(outboundFlushTimeout); err != nil {
+			h.log.WithError(err).Warn("flush出站队列超时，仍继续自重启")
+		}
+	}
+
+	h.log.WithField("binary_path", binaryPath).Info("即将通过syscall.Exec自重启Agent进程")
+	if err := selfExec(binaryPath); err != nil {
+		// selfExec失败说明exec调用本身没能替换进程镜像（比如二进制不可执行），
+		// 原进程仍然存活，必须清掉刚写入的标记，否则下一次正常启动会误以为
+		// 紧接在一次自重启之后，把这个早已不存在的taskID的结果发给服务端
+		if _, _, clearErr := ConsumeRestartMarker(h.config.Task.RestartMarkerPath); clearErr != nil {
+			h.log.WithError(clearErr).Warn("清除重启标记失败")
+		}
+		return nil, fmt.Errorf("自重启Agent进程失败: %w", err)
+	}
+
+	// syscall.Exec成功后当前进程镜像已被替换，不会执行到这里；保留返回值只是
+	// 为了满足函数签名，调用方（executeAndFinalize）也不会有机会处理它
+	return nil, nil
 }
 
 // handleSyncRules 处理同步规则任务
-func (h *DefaultTaskHandler) handleSyncRules(ctx context.Context, task *model.TaskMessage) (*model.TaskResult, error) {
+func (h *DefaultTaskHandler) handleSyncRules(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.WithField("task_id", task.TaskId).Info("处理同步规则任务")
 
 	// 解析同步规则参数
@@ -440,6 +821,7 @@ func (h *DefaultTaskHandler) handleSyncRules(ctx context.Context, task *model.Ta
 	if params.FullSync {
 		ruleCount = 0 // 全量同步时不知道具体数量
 	}
+	progress.Report(50, fmt.Sprintf("正在同步 %d 条规则", ruleCount))
 
 	output := fmt.Sprintf("规则同步完成，同步了 %d 条规则", ruleCount)
 
@@ -451,7 +833,7 @@ func (h *DefaultTaskHandler) handleSyncRules(ctx context.Context, task *model.Ta
 }
 
 // handleSyncUsers 处理同步用户任务
-func (h *DefaultTaskHandler) handleSyncUsers(ctx context.Context, task *model.TaskMessage) (*model.TaskResult, error) {
+func (h *DefaultTaskHandler) handleSyncUsers(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.WithField("task_id", task.TaskId).Info("处理同步用户任务")
 
 	// 解析同步用户参数
@@ -466,6 +848,7 @@ func (h *DefaultTaskHandler) handleSyncUsers(ctx context.Context, task *model.Ta
 	if params.FullSync {
 		userCount = 0 // 全量同步时不知道具体数量
 	}
+	progress.Report(50, fmt.Sprintf("正在同步 %d 个用户", userCount))
 
 	output := fmt.Sprintf("用户同步完成，同步了 %d 个用户", userCount)
 
@@ -476,8 +859,19 @@ func (h *DefaultTaskHandler) handleSyncUsers(ctx context.Context, task *model.Ta
 	}, nil
 }
 
+// errorTailMetricsType是MetricsTypes里的一个特殊取值：nspass-proto尚未给
+// "拉取最近错误事件尾部"提供专门的TaskType，这里复用已有的
+// TASK_TYPE_COLLECT_METRICS承载该语义——server下发一个MetricsTypes包含
+// errorTailMetricsType的收集任务，agent在Output里把errorbus最近的事件序列化
+// 成JSON带回去，供排查问题用。等协议补充了专门的消息类型后应迁移过去
+const errorTailMetricsType = "error_tail"
+
+// errorTailLimit是单次任务最多带回的错误事件条数，避免一次性把整个环形
+// 缓冲区都序列化进Output
+const errorTailLimit = 50
+
 // handleCollectMetrics 处理收集监控数据任务
-func (h *DefaultTaskHandler) handleCollectMetrics(ctx context.Context, task *model.TaskMessage) (*model.TaskResult, error) {
+func (h *DefaultTaskHandler) handleCollectMetrics(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
 	h.log.WithField("task_id", task.TaskId).Info("处理收集监控数据任务")
 
 	// 解析收集监控数据参数
@@ -486,6 +880,22 @@ func (h *DefaultTaskHandler) handleCollectMetrics(ctx context.Context, task *mod
 		return nil, fmt.Errorf("解析收集监控数据参数失败: %w", err)
 	}
 
+	for _, metricsType := range params.MetricsTypes {
+		if metricsType != errorTailMetricsType {
+			continue
+		}
+		tail := errorbus.Global().Tail(errorTailLimit)
+		data, err := json.Marshal(tail)
+		if err != nil {
+			return nil, fmt.Errorf("序列化错误事件尾部失败: %w", err)
+		}
+		return &model.TaskResult{
+			TaskId: "",
+			Status: model.TaskStatus_TASK_STATUS_COMPLETED,
+			Output: string(data),
+		}, nil
+	}
+
 	// 这里应该实现监控数据收集逻辑
 	// 可能需要立即收集并上报监控数据
 	metricsCount := len(params.MetricsTypes)
@@ -499,84 +909,6 @@ func (h *DefaultTaskHandler) handleCollectMetrics(ctx context.Context, task *mod
 	}, nil
 }
 
-// handleHealthCheck 处理健康检查任务
-func (h *DefaultTaskHandler) handleHealthCheck(ctx context.Context, task *model.TaskMessage) (*model.TaskResult, error) {
-	h.log.WithField("task_id", task.TaskId).Info("处理健康检查任务")
-
-	// 解析健康检查参数
-	var params model.HealthCheckTaskParams
-	if err := task.Parameters.UnmarshalTo(&params); err != nil {
-		return nil, fmt.Errorf("解析健康检查参数失败: %w", err)
-	}
-
-	// 执行健康检查
-	checks := make(map[string]bool)
-
-	for _, checkType := range params.CheckTypes {
-		switch checkType {
-		case "system":
-			checks["system"] = h.checkSystemHealth(ctx)
-		case "proxy":
-			checks["proxy"] = h.checkProxyHealth(ctx)
-		case "iptables":
-			checks["iptables"] = h.checkIPTablesHealth(ctx)
-		default:
-			h.log.WithField("check_type", checkType).Warn("不支持的健康检查类型")
-		}
-	}
-
-	// 构建健康检查结果
-	allHealthy := true
-	for _, healthy := range checks {
-		if !healthy {
-			allHealthy = false
-			break
-		}
-	}
-
-	output := fmt.Sprintf("健康检查完成，检查结果: %v", checks)
-
-	status := model.TaskStatus_TASK_STATUS_COMPLETED
-	if !allHealthy {
-		status = model.TaskStatus_TASK_STATUS_FAILED
-	}
-
-	return &model.TaskResult{
-		TaskId: "",
-		Status: status,
-		Output: output,
-	}, nil
-}
-
-// checkSystemHealth 检查系统健康状态
-func (h *DefaultTaskHandler) checkSystemHealth(ctx context.Context) bool {
-	// 检查系统基本状态
-	// 例如：磁盘空间、内存使用率、CPU负载等
-	return true // 简化实现
-}
-
-// checkProxyHealth 检查代理健康状态
-func (h *DefaultTaskHandler) checkProxyHealth(ctx context.Context) bool {
-	// 检查代理服务状态
-	if h.proxyManager != nil {
-		// 这里需要实现代理健康检查逻辑
-		// 例如：检查代理进程是否运行、端口是否监听等
-		return true // 简化实现
-	}
-	return false
-}
-
-// checkIPTablesHealth 检查iptables健康状态
-func (h *DefaultTaskHandler) checkIPTablesHealth(ctx context.Context) bool {
-	// 检查iptables规则状态
-	if h.iptablesManager != nil {
-		// 这里需要实现iptables健康检查逻辑
-		// 例如：检查规则是否正确配置、是否有冲突等
-		return true // 简化实现
-	}
-	return false
-}
-
 // GetTaskStats returns task statistics
 func (h *DefaultTaskHandler) GetTaskStats() map[string]int {
 	return h.taskManager.GetTaskStats()
@@ -587,13 +919,20 @@ func (h *DefaultTaskHandler) GetTaskManager() *TaskManager {
 	return h.taskManager
 }
 
-// CancelTask cancels a running task
+// CancelTask cancels a running task, or a failed task that is still waiting
+// in its RestartSupervisor backoff window for an automatic retry
 func (h *DefaultTaskHandler) CancelTask(taskID string) error {
 	task, exists := h.taskManager.GetTask(taskID)
 	if !exists {
 		return fmt.Errorf("task not found: %s", taskID)
 	}
 
+	if h.taskManager.restarts.Cancel(taskID) {
+		h.taskManager.UpdateTaskStatus(taskID, model.TaskStatus_TASK_STATUS_CANCELLED)
+		h.log.WithField("task_id", taskID).Info("Pending task retry cancelled")
+		return nil
+	}
+
 	if task.Status == model.TaskStatus_TASK_STATUS_RUNNING {
 		h.taskManager.UpdateTaskStatus(taskID, model.TaskStatus_TASK_STATUS_CANCELLED)
 		h.log.WithField("task_id", taskID).Info("Task cancelled")
@@ -0,0 +1,254 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nspass/nspass-agent/generated/model"
+	"github.com/nspass/nspass-agent/pkg/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// TaskExecutor执行一种任务类型，注册进TaskHandlerRegistry。语义与
+// DefaultTaskHandler.handleXxx系列私有方法完全一致，只是可以在
+// NewDefaultTaskHandler之外按需注册。progress用于在执行过程中上报阶段性进度、
+// 日志行和部分输出，不会为nil（executeAndFinalize总是传入TaskManager的
+// write-through包装，即使调用方没有提供真正的websocket reporter）
+type TaskExecutor func(ctx context.Context, h *DefaultTaskHandler, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error)
+
+// TaskHandlerRegistry按model.TaskType把任务分发到对应的TaskExecutor，取代
+// HandleTask里原来硬编码的switch分支。未显式注册的TaskType落到fallback
+// （留空表示不支持），用来承接generic exec执行器这类不与具体TaskType绑定、
+// 靠task.Parameters里的字段自行决定做什么的处理器
+type TaskHandlerRegistry struct {
+	mu        sync.RWMutex
+	executors map[model.TaskType]TaskExecutor
+	fallback  TaskExecutor
+}
+
+// NewTaskHandlerRegistry 创建一个空的任务处理器注册表
+func NewTaskHandlerRegistry() *TaskHandlerRegistry {
+	return &TaskHandlerRegistry{
+		executors: make(map[model.TaskType]TaskExecutor),
+	}
+}
+
+// Register 为taskType注册executor，重复注册会覆盖此前的登记
+func (r *TaskHandlerRegistry) Register(taskType model.TaskType, executor TaskExecutor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.executors[taskType] = executor
+}
+
+// RegisterFallback 注册一个兜底executor，用于HandleTask收到的task.TaskType
+// 没有精确匹配任何已注册executor时兜底执行（比如generic exec执行器）
+func (r *TaskHandlerRegistry) RegisterFallback(executor TaskExecutor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = executor
+}
+
+// Resolve按taskType查找executor，找不到精确匹配时返回fallback（如果有注册）
+func (r *TaskHandlerRegistry) Resolve(taskType model.TaskType) (TaskExecutor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if executor, ok := r.executors[taskType]; ok {
+		return executor, true
+	}
+	if r.fallback != nil {
+		return r.fallback, true
+	}
+	return nil, false
+}
+
+// registerBuiltinExecutors登记当前6种任务类型的内置executor，以及一个不绑定
+// 具体TaskType的generic exec兜底executor，供未来新增任务类型（如
+// run_playbook、rotate_cert）复用而不用为每一种都新增TaskType分支
+func (h *DefaultTaskHandler) registerBuiltinExecutors() {
+	h.registry.Register(model.TaskType_TASK_TYPE_CONFIG_UPDATE, func(ctx context.Context, h *DefaultTaskHandler, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+		return h.handleConfigUpdate(ctx, task, progress)
+	})
+	h.registry.Register(model.TaskType_TASK_TYPE_RESTART, func(ctx context.Context, h *DefaultTaskHandler, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+		return h.handleRestart(ctx, task, progress)
+	})
+	h.registry.Register(model.TaskType_TASK_TYPE_SYNC_RULES, func(ctx context.Context, h *DefaultTaskHandler, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+		return h.handleSyncRules(ctx, task, progress)
+	})
+	h.registry.Register(model.TaskType_TASK_TYPE_SYNC_USERS, func(ctx context.Context, h *DefaultTaskHandler, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+		return h.handleSyncUsers(ctx, task, progress)
+	})
+	h.registry.Register(model.TaskType_TASK_TYPE_COLLECT_METRICS, func(ctx context.Context, h *DefaultTaskHandler, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+		return h.handleCollectMetrics(ctx, task, progress)
+	})
+	h.registry.Register(model.TaskType_TASK_TYPE_HEALTH_CHECK, func(ctx context.Context, h *DefaultTaskHandler, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+		return h.handleHealthCheck(ctx, task, progress)
+	})
+	h.registry.RegisterFallback(func(ctx context.Context, h *DefaultTaskHandler, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+		return h.handleExec(ctx, task, progress)
+	})
+}
+
+// handleExec 是generic exec执行器：从task.Parameters（一个structpb.Struct）
+// 里取出"command"字段，在config.Config.Task.Exec.Commands白名单里查找同名
+// 条目并执行，不在白名单内一律拒绝。用于承接未绑定专属TaskType的任务，
+// 让操作员通过改配置而不是改代码接入新的运维动作
+func (h *DefaultTaskHandler) handleExec(ctx context.Context, task *model.TaskMessage, progress TaskProgressReporter) (*model.TaskResult, error) {
+	h.log.WithField("task_id", task.TaskId).Info("处理通用exec任务")
+
+	var params structpb.Struct
+	if err := task.Parameters.UnmarshalTo(&params); err != nil {
+		return nil, fmt.Errorf("解析exec任务参数失败: %w", err)
+	}
+
+	commandName := params.Fields["command"].GetStringValue()
+	if commandName == "" {
+		return nil, fmt.Errorf("exec任务缺少command参数")
+	}
+
+	cmdConfig, ok := findExecCommand(h.config.Task.Exec.Commands, commandName)
+	if !ok {
+		return nil, fmt.Errorf("命令%q不在exec白名单内", commandName)
+	}
+
+	values := execTemplateValues(&params)
+
+	timeoutSeconds := cmdConfig.TimeoutSeconds
+	if timeoutSeconds == 0 {
+		timeoutSeconds = h.config.Task.Exec.TimeoutSeconds
+	}
+	execCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSeconds)*time.Second)
+	defer cancel()
+
+	args := make([]string, len(cmdConfig.Args))
+	for i, arg := range cmdConfig.Args {
+		args[i] = renderExecTemplate(arg, values)
+	}
+
+	cmd := exec.CommandContext(execCtx, cmdConfig.Path, args...)
+	for key, valueTemplate := range cmdConfig.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, renderExecTemplate(valueTemplate, values)))
+	}
+
+	outputLimit := h.config.Task.Exec.OutputLimitBytes
+	var stdout, stderr limitedBuffer
+	stdout.limit = outputLimit
+	stderr.limit = outputLimit
+
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("创建命令%q的stdout管道失败: %w", commandName, err)
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("创建命令%q的stderr管道失败: %w", commandName, err)
+	}
+
+	h.log.WithFields(logrus.Fields{
+		"task_id": task.TaskId,
+		"command": commandName,
+		"path":    cmdConfig.Path,
+	}).Info("执行exec任务")
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动命令%q失败: %w", commandName, err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go streamExecOutput(&wg, stdoutPipe, &stdout, progress, "")
+	go streamExecOutput(&wg, stderrPipe, &stderr, progress, "stderr: ")
+	wg.Wait()
+
+	runErr := cmd.Wait()
+	output := stdout.String()
+	if stderr.Len() > 0 {
+		output = strings.TrimRight(output, "\n") + "\nstderr: " + stderr.String()
+	}
+
+	if runErr != nil {
+		return nil, fmt.Errorf("执行命令%q失败: %w，输出: %s", commandName, runErr, output)
+	}
+
+	return &model.TaskResult{
+		TaskId: task.TaskId,
+		Status: model.TaskStatus_TASK_STATUS_COMPLETED,
+		Output: output,
+	}, nil
+}
+
+func findExecCommand(commands []config.TaskExecCommand, name string) (config.TaskExecCommand, bool) {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return config.TaskExecCommand{}, false
+}
+
+// execTemplateValues把structpb.Struct里除command外的string字段抽成模板值表，
+// 供renderExecTemplate替换参数模板里的{{paramName}}占位符
+func execTemplateValues(params *structpb.Struct) map[string]string {
+	values := make(map[string]string, len(params.Fields))
+	for key, value := range params.Fields {
+		if key == "command" {
+			continue
+		}
+		values[key] = value.GetStringValue()
+	}
+	return values
+}
+
+func renderExecTemplate(template string, values map[string]string) string {
+	rendered := template
+	for key, value := range values {
+		rendered = strings.ReplaceAll(rendered, "{{"+key+"}}", value)
+	}
+	return rendered
+}
+
+// streamExecOutput按行读取pipe（命令的stdout或stderr管道），把每一行追加进
+// buf（受limitedBuffer截断保护，用于最终的TaskResult.Output）的同时通过
+// progress.Partial实时上报，使调用方不必等命令整体结束就能看到输出；prefix
+// 用于区分stderr行，progress为nil时只写入buf
+func streamExecOutput(wg *sync.WaitGroup, pipe io.Reader, buf *limitedBuffer, progress TaskProgressReporter, prefix string) {
+	defer wg.Done()
+
+	scanner := bufio.NewScanner(pipe)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		buf.Write([]byte(line + "\n"))
+		if progress != nil {
+			progress.Partial(prefix + line)
+		}
+	}
+}
+
+// limitedBuffer是一个写入超过limit字节后静默丢弃多余内容的bytes.Buffer包装，
+// 用于截断exec任务的stdout/stderr，避免巨量输出占满内存或撑爆TaskResult
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && b.buf.Len() >= b.limit {
+		return len(p), nil
+	}
+	if b.limit > 0 && b.buf.Len()+len(p) > b.limit {
+		p = p[:b.limit-b.buf.Len()]
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Len() int       { return b.buf.Len() }
+func (b *limitedBuffer) String() string { return b.buf.String() }
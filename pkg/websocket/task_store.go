@@ -0,0 +1,329 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nspass/nspass-agent/generated/model"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// TaskStore持久化TaskRecord，TaskManager把它当作write-through缓存的后备
+// 存储：内存map仍然是读路径的唯一来源，每次写入（Put/Delete）先落盘再更新
+// 内存，使任务的幂等性（CheckTaskStatus据此判断COMPLETED/RUNNING是否需要
+// 重新执行）在Agent重启后依然成立
+type TaskStore interface {
+	Get(taskID string) (*TaskRecord, bool, error)
+	Put(record *TaskRecord) error
+	Delete(taskID string) error
+	List() ([]*TaskRecord, error)
+	// Range按CompletedAt升序遍历所有已落盘且已完结（CompletedAt非空）的记录，
+	// f返回false时提前终止；配合按时间分桶的二级索引，CleanupOldTasks不需要
+	// 打开全部任务文件就能找到已过期的那一批
+	Range(f func(record *TaskRecord) bool) error
+}
+
+// cleanupBucketWindow是fileTaskStore二级索引的分桶粒度：同一小时内完成的
+// 任务落在同一个桶文件里，Range按桶文件名（即桶的起始时间戳）升序遍历
+const cleanupBucketWindow = time.Hour
+
+// persistedTaskRecord是TaskRecord在磁盘上的序列化形式，Result通过protojson
+// 单独编码，以正确处理其中的时间戳等proto字段
+type persistedTaskRecord struct {
+	TaskID       string                `json:"task_id"`
+	TaskType     model.TaskType        `json:"task_type"`
+	Status       model.TaskStatus      `json:"status"`
+	CreatedAt    time.Time             `json:"created_at"`
+	StartedAt    *time.Time            `json:"started_at,omitempty"`
+	CompletedAt  *time.Time            `json:"completed_at,omitempty"`
+	Result       json.RawMessage       `json:"result,omitempty"`
+	ErrorMsg     string                `json:"error_message,omitempty"`
+	RetryCount   int                   `json:"retry_count"`
+	LastRetryAt  *time.Time            `json:"last_retry_at,omitempty"`
+	LastProgress *TaskProgressSnapshot `json:"last_progress,omitempty"`
+	OutputTail   []string              `json:"output_tail,omitempty"`
+}
+
+func encodeTaskRecord(record *TaskRecord) (*persistedTaskRecord, error) {
+	p := &persistedTaskRecord{
+		TaskID:       record.TaskID,
+		TaskType:     record.TaskType,
+		Status:       record.Status,
+		CreatedAt:    record.CreatedAt,
+		StartedAt:    record.StartedAt,
+		CompletedAt:  record.CompletedAt,
+		ErrorMsg:     record.ErrorMsg,
+		RetryCount:   record.RetryCount,
+		LastRetryAt:  record.LastRetryAt,
+		LastProgress: record.LastProgress,
+		OutputTail:   record.OutputTail,
+	}
+	if record.Result != nil {
+		resultJSON, err := protojson.Marshal(record.Result)
+		if err != nil {
+			return nil, fmt.Errorf("序列化任务结果失败: %w", err)
+		}
+		p.Result = resultJSON
+	}
+	return p, nil
+}
+
+func decodeTaskRecord(p *persistedTaskRecord) *TaskRecord {
+	record := &TaskRecord{
+		TaskID:       p.TaskID,
+		TaskType:     p.TaskType,
+		Status:       p.Status,
+		CreatedAt:    p.CreatedAt,
+		StartedAt:    p.StartedAt,
+		CompletedAt:  p.CompletedAt,
+		ErrorMsg:     p.ErrorMsg,
+		RetryCount:   p.RetryCount,
+		LastRetryAt:  p.LastRetryAt,
+		LastProgress: p.LastProgress,
+		OutputTail:   p.OutputTail,
+	}
+	if len(p.Result) > 0 {
+		result := &model.TaskResult{}
+		if err := protojson.Unmarshal(p.Result, result); err == nil {
+			record.Result = result
+		}
+	}
+	return record
+}
+
+// fileTaskStore是TaskStore的默认实现：每个任务一个JSON文件
+// (<dir>/tasks/<taskID>.json)，外加按CompletedAt分桶的二级索引
+// (<dir>/index/<bucket>.json，bucket是CompletedAt按cleanupBucketWindow取整
+// 后的Unix时间戳)。没有BoltDB/SQLite之类的嵌入式数据库依赖时，这是在不引入
+// 新依赖的前提下最接近的等价实现
+type fileTaskStore struct {
+	mu       sync.Mutex
+	tasksDir string
+	indexDir string
+}
+
+// NewFileTaskStore打开或创建dir下的任务存储目录结构
+func NewFileTaskStore(dir string) (*fileTaskStore, error) {
+	s := &fileTaskStore{
+		tasksDir: filepath.Join(dir, "tasks"),
+		indexDir: filepath.Join(dir, "index"),
+	}
+	if err := os.MkdirAll(s.tasksDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建任务存储目录失败: %w", err)
+	}
+	if err := os.MkdirAll(s.indexDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建任务时间索引目录失败: %w", err)
+	}
+	return s, nil
+}
+
+// validTaskID只允许不含路径分隔符、不是"."或".."的非空taskID——TaskId来自
+// 服务端下发的model.TaskMessage（见task_handler.go的CreateTask），一个被
+// MITM或恶意服务端控制的控制通道（chunk1-6修复的HMAC验证正是为了防住这类
+// 威胁模型）原本可以塞一个"../../../etc/cron.d/x"之类的TaskId，借着
+// taskPath/bucketPath的Join把任意文件写到agent磁盘上的任何位置——而这个agent
+// 本身就在执行特权的iptables/systemd/ptrace操作
+func validTaskID(taskID string) bool {
+	return taskID != "" && !strings.ContainsAny(taskID, `/\`) && taskID != "." && taskID != ".."
+}
+
+func (s *fileTaskStore) taskPath(taskID string) string {
+	return filepath.Join(s.tasksDir, taskID+".json")
+}
+
+func (s *fileTaskStore) bucketPath(bucket int64) string {
+	return filepath.Join(s.indexDir, strconv.FormatInt(bucket, 10)+".json")
+}
+
+func bucketFor(t time.Time) int64 {
+	return t.Truncate(cleanupBucketWindow).Unix()
+}
+
+// Get 按taskID读取单个任务记录
+func (s *fileTaskStore) Get(taskID string) (*TaskRecord, bool, error) {
+	if !validTaskID(taskID) {
+		return nil, false, fmt.Errorf("非法的任务ID: %q", taskID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.taskPath(taskID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("读取任务记录失败: %w", err)
+	}
+
+	var p persistedTaskRecord
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, false, fmt.Errorf("解析任务记录失败: %w", err)
+	}
+	return decodeTaskRecord(&p), true, nil
+}
+
+// Put 写入一条任务记录，并在CompletedAt非空时把taskID登记进对应的时间桶
+func (s *fileTaskStore) Put(record *TaskRecord) error {
+	if !validTaskID(record.TaskID) {
+		return fmt.Errorf("非法的任务ID: %q", record.TaskID)
+	}
+
+	p, err := encodeTaskRecord(record)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("序列化任务记录失败: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.WriteFile(s.taskPath(record.TaskID), data, 0644); err != nil {
+		return fmt.Errorf("写入任务记录失败: %w", err)
+	}
+
+	if record.CompletedAt != nil {
+		if err := s.addToBucketLocked(bucketFor(*record.CompletedAt), record.TaskID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete 删除一条任务记录；不尝试把taskID从它可能所在的时间桶里摘除——
+// Range消费时会通过Get确认记录仍然存在，摘除留给下一次该桶被整体清空时
+func (s *fileTaskStore) Delete(taskID string) error {
+	if !validTaskID(taskID) {
+		return fmt.Errorf("非法的任务ID: %q", taskID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.taskPath(taskID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除任务记录失败: %w", err)
+	}
+	return nil
+}
+
+// List 返回所有已持久化的任务记录，供NewTaskManager启动时做迁移加载
+func (s *fileTaskStore) List() ([]*TaskRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.tasksDir)
+	if err != nil {
+		return nil, fmt.Errorf("列出任务存储目录失败: %w", err)
+	}
+
+	records := make([]*TaskRecord, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.tasksDir, entry.Name()))
+		if err != nil {
+			continue // 单个文件损坏不应阻止其余任务的恢复
+		}
+		var p persistedTaskRecord
+		if err := json.Unmarshal(data, &p); err != nil {
+			continue
+		}
+		records = append(records, decodeTaskRecord(&p))
+	}
+	return records, nil
+}
+
+// Range按桶文件名（即桶起始时间）升序遍历二级索引，对每个桶内登记的taskID
+// 尝试Get其完整记录并回调f，f返回false时立即停止，不再打开后续的桶
+func (s *fileTaskStore) Range(f func(record *TaskRecord) bool) error {
+	s.mu.Lock()
+	entries, err := os.ReadDir(s.indexDir)
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("列出任务时间索引目录失败: %w", err)
+	}
+
+	buckets := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		bucket, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	for _, bucket := range buckets {
+		taskIDs, err := s.readBucket(bucket)
+		if err != nil {
+			continue
+		}
+		for _, taskID := range taskIDs {
+			record, ok, err := s.Get(taskID)
+			if err != nil || !ok {
+				continue
+			}
+			if !f(record) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fileTaskStore) addToBucketLocked(bucket int64, taskID string) error {
+	taskIDs, err := s.readBucketLocked(bucket)
+	if err != nil {
+		return err
+	}
+	for _, existing := range taskIDs {
+		if existing == taskID {
+			return nil
+		}
+	}
+	taskIDs = append(taskIDs, taskID)
+
+	data, err := json.Marshal(taskIDs)
+	if err != nil {
+		return fmt.Errorf("序列化时间索引桶失败: %w", err)
+	}
+	if err := os.WriteFile(s.bucketPath(bucket), data, 0644); err != nil {
+		return fmt.Errorf("写入时间索引桶失败: %w", err)
+	}
+	return nil
+}
+
+func (s *fileTaskStore) readBucket(bucket int64) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readBucketLocked(bucket)
+}
+
+func (s *fileTaskStore) readBucketLocked(bucket int64) ([]string, error) {
+	data, err := os.ReadFile(s.bucketPath(bucket))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取时间索引桶失败: %w", err)
+	}
+	var taskIDs []string
+	if err := json.Unmarshal(data, &taskIDs); err != nil {
+		return nil, fmt.Errorf("解析时间索引桶失败: %w", err)
+	}
+	return taskIDs, nil
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import "testing"
+
+func TestValidTaskID(t *testing.T) {
+	valid := []string{"task-1", "abc123", "任务1"}
+	for _, id := range valid {
+		if !validTaskID(id) {
+			t.Errorf("validTaskID(%q) = false, want true", id)
+		}
+	}
+
+	invalid := []string{"", ".", "..", "../etc/passwd", "../../../../etc/cron.d/x", "a/b", `a\b`, "/etc/passwd"}
+	for _, id := range invalid {
+		if validTaskID(id) {
+			t.Errorf("validTaskID(%q) = true, want false", id)
+		}
+	}
+}
+
+func TestFileTaskStorePutGetDeleteRejectPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileTaskStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileTaskStore失败: %v", err)
+	}
+
+	maliciousID := "../../../../etc/cron.d/x"
+
+	if err := store.Put(&TaskRecord{TaskID: maliciousID}); err == nil {
+		t.Fatal("Put应当拒绝包含路径穿越的taskID")
+	}
+	if _, ok, err := store.Get(maliciousID); err == nil || ok {
+		t.Fatal("Get应当拒绝包含路径穿越的taskID")
+	}
+	if err := store.Delete(maliciousID); err == nil {
+		t.Fatal("Delete应当拒绝包含路径穿越的taskID")
+	}
+
+	const goodID = "task-1"
+	if err := store.Put(&TaskRecord{TaskID: goodID}); err != nil {
+		t.Fatalf("Put合法taskID失败: %v", err)
+	}
+	record, ok, err := store.Get(goodID)
+	if err != nil || !ok {
+		t.Fatalf("Get合法taskID失败: ok=%v err=%v", ok, err)
+	}
+	if record.TaskID != goodID {
+		t.Fatalf("Get返回的TaskID = %q, want %q", record.TaskID, goodID)
+	}
+	if err := store.Delete(goodID); err != nil {
+		t.Fatalf("Delete合法taskID失败: %v", err)
+	}
+}
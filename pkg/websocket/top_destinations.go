@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TopDestination是CollectConnectionMetrics按连接数排名前列的一个远端地址的
+// 聚合数据。BytesIn/BytesOut来自/proc/net/tcp[6]里tx_queue:rx_queue字段的
+// 瞬时采样，反映采集那一刻各socket收发缓冲区里还积压着多少字节——Linux在
+// /proc下不维护按远端地址的累计字节计数器（真正的累计统计需要conntrack或
+// eBPF），这里只是用队列深度做一个低成本的近似，量级上可以区分"安静"和
+// "繁忙"的目标地址
+type TopDestination struct {
+	IP          string
+	Connections int
+	BytesIn     uint64
+	BytesOut    uint64
+	LastSeen    time.Time
+}
+
+// destinationAccumulator是CollectConnectionMetrics遍历连接列表时，按远端IP
+// 聚合连接数/字节采样的中间状态
+type destinationAccumulator struct {
+	ip          string
+	connections int
+	bytesIn     uint64
+	bytesOut    uint64
+	lastSeen    time.Time
+}
+
+// topDestinationHeap是一个按Connections升序排列的最小堆：堆顶（索引0）始终
+// 是当前已入堆的至多topN个目标地址里连接数最少的一个，新元素只有连接数超过
+// 堆顶时才需要替换堆顶。配合topKDestinations使用，把求前topN大的时间复杂度
+// 从对全量destinations排序的O(n log n)降到O(n log topN)
+type topDestinationHeap []destinationAccumulator
+
+func (h topDestinationHeap) Len() int            { return len(h) }
+func (h topDestinationHeap) Less(i, j int) bool  { return h[i].connections < h[j].connections }
+func (h topDestinationHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topDestinationHeap) Push(x interface{}) { *h = append(*h, x.(destinationAccumulator)) }
+func (h *topDestinationHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// topKDestinations用一个大小为topN的最小堆求destinations里连接数最多的前
+// topN个，取代对全量destinations做冒泡排序再截断的O(n²)实现
+func topKDestinations(destinations map[string]*destinationAccumulator, topN int) []TopDestination {
+	if topN <= 0 || len(destinations) == 0 {
+		return nil
+	}
+
+	h := make(topDestinationHeap, 0, topN)
+	heap.Init(&h)
+
+	for _, d := range destinations {
+		if h.Len() < topN {
+			heap.Push(&h, *d)
+			continue
+		}
+		if d.connections > h[0].connections {
+			heap.Pop(&h)
+			heap.Push(&h, *d)
+		}
+	}
+
+	// 堆顶弹出的顺序是连接数从小到大，倒着填入result即可得到降序排列
+	result := make([]TopDestination, h.Len())
+	for i := len(result) - 1; i >= 0; i-- {
+		item := heap.Pop(&h).(destinationAccumulator)
+		result[i] = TopDestination{
+			IP:          item.ip,
+			Connections: item.connections,
+			BytesIn:     item.bytesIn,
+			BytesOut:    item.bytesOut,
+			LastSeen:    item.lastSeen,
+		}
+	}
+	return result
+}
+
+// collectDestinationByteSamples解析/proc/net/tcp和/proc/net/tcp6，按远端IP
+// 汇总tx_queue/rx_queue字段，任一文件不存在（如内核未启用ipv6）或读取失败
+// 都静默跳过，不影响CollectConnectionMetrics其余部分的统计
+func (c *DefaultMetricsCollector) collectDestinationByteSamples() (bytesIn, bytesOut map[string]uint64) {
+	bytesIn = make(map[string]uint64)
+	bytesOut = make(map[string]uint64)
+
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		addProcNetTCPByteSamples(path, bytesIn, bytesOut)
+	}
+	return bytesIn, bytesOut
+}
+
+// addProcNetTCPByteSamples读取path（/proc/net/tcp或/proc/net/tcp6），把每行
+// 的tx_queue（已发送未确认，计作bytesOut）和rx_queue（已接收未读取，计作
+// bytesIn）按远端地址累加进bytesOut/bytesIn
+func addProcNetTCPByteSamples(path string, bytesIn, bytesOut map[string]uint64) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // 跳过表头行
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+
+		remoteIP := parseProcNetAddr(fields[2])
+		if remoteIP == "" || isLocalAddress(remoteIP) {
+			continue
+		}
+
+		queues := strings.SplitN(fields[4], ":", 2)
+		if len(queues) != 2 {
+			continue
+		}
+		tx, txErr := strconv.ParseUint(queues[0], 16, 64)
+		rx, rxErr := strconv.ParseUint(queues[1], 16, 64)
+		if txErr != nil || rxErr != nil {
+			continue
+		}
+
+		bytesOut[remoteIP] += tx
+		bytesIn[remoteIP] += rx
+	}
+}
+
+// parseProcNetAddr解析/proc/net/tcp[6]里"ADDR:PORT"形式的地址字段，ADDR是
+// 按32位小端字序存储的十六进制IP（ipv6地址由四个32位字各自按小端存储），
+// 解析失败返回空字符串
+func parseProcNetAddr(field string) string {
+	parts := strings.Split(field, ":")
+	if len(parts) != 2 {
+		return ""
+	}
+
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return ""
+	}
+
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw)/4; word++ {
+		for i := 0; i < 4; i++ {
+			ip[word*4+i] = raw[word*4+3-i]
+		}
+	}
+	return ip.String()
+}